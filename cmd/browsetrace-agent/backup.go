@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/backup"
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+)
+
+// backupDirectory returns BROWSETRACE_BACKUP_DIR, or backups in the
+// application directory.
+func backupDirectory(directory string) string {
+	if path := os.Getenv("BROWSETRACE_BACKUP_DIR"); path != "" {
+		return path
+	}
+	return filepath.Join(directory, "backups")
+}
+
+// backupManager configures backups of db into backupDirectory from
+// BROWSETRACE_BACKUP_INTERVAL (a duration; scheduled backups are off without
+// it), BROWSETRACE_BACKUP_KEEP_DAILY and BROWSETRACE_BACKUP_KEEP_WEEKLY.
+func backupManager(db *database.Database, directory string) (*backup.Manager, error) {
+	var interval time.Duration
+	if value := os.Getenv("BROWSETRACE_BACKUP_INTERVAL"); value != "" {
+		var err error
+		if interval, err = time.ParseDuration(value); err != nil || interval < 0 {
+			return nil, fmt.Errorf("invalid BROWSETRACE_BACKUP_INTERVAL: %s", value)
+		}
+	}
+	policy := backup.DefaultPolicy
+	for name, target := range map[string]*int{
+		"BROWSETRACE_BACKUP_KEEP_DAILY":  &policy.Daily,
+		"BROWSETRACE_BACKUP_KEEP_WEEKLY": &policy.Weekly,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("invalid %s: %s", name, value)
+		}
+		*target = count
+	}
+	return backup.NewManager(db, backupDirectory(directory), policy, interval), nil
+}
+
+// runBackup takes a backup while the agent may be running. It opens the
+// database directly rather than through openAgent: copying it needs neither
+// the encryption key nor the derived tables.
+func runBackup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	output := flags.String("o", "", "write the backup to this file instead of the backup directory, without rotation")
+	list := flags.Bool("list", false, "list backups instead of taking one")
+	flags.Parse(args)
+
+	directory, err := applicationDirectory()
+	if err != nil {
+		return err
+	}
+	db, err := database.NewDatabase(filepath.Join(directory, "events.db"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	manager, err := backupManager(db, directory)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *list:
+		backups, err := manager.List()
+		if err != nil {
+			return err
+		}
+		for _, b := range backups {
+			fmt.Printf("%s\t%s\t%d bytes\n", b.Path, time.UnixMilli(b.TakenTSUTC).UTC().Format(time.RFC3339), b.Size)
+		}
+		return nil
+	case *output != "":
+		if err := backup.Create(context.Background(), db, *output); err != nil {
+			return err
+		}
+		log.Printf("Backed up database to %s", *output)
+		return nil
+	}
+	b, err := manager.Backup(context.Background())
+	if err != nil {
+		return err
+	}
+	log.Printf("Backed up database to %s (%d bytes)", b.Path, b.Size)
+	return nil
+}
+
+// runRestore replaces the database with a backup, verifying it before and
+// after. The agent must be stopped first.
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	from := flags.String("from", "", "backup file to restore")
+	latest := flags.Bool("latest", false, "restore the newest backup in the backup directory")
+	flags.Parse(args)
+	if (*from == "") == !*latest {
+		return errors.New("restore needs exactly one of -from or -latest")
+	}
+
+	if address := agentAddress(); agentRunning(address) {
+		return fmt.Errorf("the agent is running at %s; stop it before restoring", address)
+	}
+	directory, err := applicationDirectory()
+	if err != nil {
+		return err
+	}
+	path := *from
+	if *latest {
+		backups, err := backup.List(backupDirectory(directory))
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			return fmt.Errorf("no backups in %s", backupDirectory(directory))
+		}
+		path = backups[0].Path
+	}
+
+	databasePath := filepath.Join(directory, "events.db")
+	if err := backup.Restore(context.Background(), path, databasePath); err != nil {
+		return err
+	}
+	log.Printf("Restored %s; the previous database is kept as %s.pre-restore", path, databasePath)
+	return nil
+}
+
+// agentAddress is the address runServe listens on.
+func agentAddress() string {
+	if address := os.Getenv("BROWSETRACE_ADDRESS"); address != "" {
+		return address
+	}
+	return "127.0.0.1:8123"
+}
+
+// agentRunning reports whether an agent answers health checks at address.
+func agentRunning(address string) bool {
+	client := http.Client{Timeout: time.Second}
+	response, err := client.Get("http://" + address + "/healthz")
+	if err != nil {
+		return false
+	}
+	response.Body.Close()
+	return response.StatusCode == http.StatusOK
+}
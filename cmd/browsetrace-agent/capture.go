@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/capture"
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// runCapture shows or changes whether the agent records events. Like backup,
+// it opens the database directly: a running server reads the state from there
+// on every batch, so the change applies at once.
+func runCapture(args []string) error {
+	flags := flag.NewFlagSet("capture", flag.ExitOnError)
+	pause := flags.Bool("pause", false, "stop recording until resumed, or for -for or until -until")
+	pauseFor := flags.Duration("for", 0, "with -pause, how long to pause")
+	until := flags.String("until", "", "with -pause, when to start recording again (milliseconds, RFC 3339 or YYYY-MM-DD)")
+	resume := flags.Bool("resume", false, "start recording again")
+	private := flags.Int64("private", -1, "mark this browser window ID private, so none of its events are recorded")
+	public := flags.Int64("public", -1, "record this browser window ID again")
+	client := flags.String("client", "", "with -private or -public, the client ID the window belongs to")
+	flags.Parse(args)
+
+	actions := 0
+	for _, set := range []bool{*pause, *resume, *private >= 0, *public >= 0} {
+		if set {
+			actions++
+		}
+	}
+	if actions > 1 {
+		return errors.New("capture takes one of -pause, -resume, -private or -public")
+	}
+	if (*pauseFor != 0 || *until != "") && !*pause {
+		return errors.New("-for and -until go with -pause")
+	}
+
+	directory, err := applicationDirectory()
+	if err != nil {
+		return err
+	}
+	db, err := database.NewDatabase(filepath.Join(directory, "events.db"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	sw, err := capture.New(db)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var state capture.State
+	switch {
+	case *pause:
+		var end time.Time
+		switch {
+		case *pauseFor != 0 && *until != "":
+			return errors.New("give -for or -until, not both")
+		case *pauseFor != 0:
+			end = time.Now().Add(*pauseFor)
+		case *until != "":
+			milliseconds, err := models.ParseTimestamp(*until)
+			if err != nil {
+				return err
+			}
+			end = time.UnixMilli(milliseconds)
+		}
+		state, err = sw.Pause(ctx, end)
+	case *resume:
+		state, err = sw.Resume(ctx)
+	case *private >= 0:
+		state, err = sw.SetPrivate(ctx, *client, *private, true)
+	case *public >= 0:
+		state, err = sw.SetPrivate(ctx, *client, *public, false)
+	default:
+		state, err = sw.State(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case state.PausedUntilTSUTC != nil:
+		fmt.Printf("Paused until %s\n", time.UnixMilli(*state.PausedUntilTSUTC).Format(time.RFC3339))
+	case state.State == capture.Paused:
+		fmt.Println("Paused until resumed")
+	default:
+		fmt.Println("Recording")
+	}
+	for _, window := range state.PrivateWindows {
+		if window.ClientID == "" {
+			fmt.Printf("Private window %d\n", window.WindowID)
+		} else {
+			fmt.Printf("Private window %d of client %s\n", window.WindowID, window.ClientID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/clockskew"
+)
+
+// clockSkewChecker reads BROWSETRACE_CLOCK_SKEW (detect, correct or reject;
+// detect by default) and BROWSETRACE_CLOCK_TOLERANCE (a duration such as
+// "2m") into a checker for posted events.
+func clockSkewChecker() (*clockskew.Checker, error) {
+	config := clockskew.Config{Mode: clockskew.ModeDetect}
+	if value := os.Getenv("BROWSETRACE_CLOCK_SKEW"); value != "" {
+		mode, err := clockskew.ParseMode(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BROWSETRACE_CLOCK_SKEW: %w", err)
+		}
+		config.Mode = mode
+	}
+	if value := os.Getenv("BROWSETRACE_CLOCK_TOLERANCE"); value != "" {
+		tolerance, err := time.ParseDuration(value)
+		if err != nil || tolerance <= 0 {
+			return nil, fmt.Errorf("invalid BROWSETRACE_CLOCK_TOLERANCE: %s", value)
+		}
+		config.Tolerance = tolerance
+	}
+	return clockskew.New(config)
+}
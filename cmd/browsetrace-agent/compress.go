@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+)
+
+// configureCompression applies BROWSETRACE_COMPRESSION (none, gzip or zstd;
+// zstd by default) and BROWSETRACE_COMPRESSION_THRESHOLD (bytes) to db, and
+// compresses text stored uncompressed before.
+func configureCompression(db *database.Database) error {
+	compression := database.Compression{Codec: database.CodecZstd}
+	if value := os.Getenv("BROWSETRACE_COMPRESSION"); value != "" {
+		codec, err := database.ParseCodec(value)
+		if err != nil {
+			return fmt.Errorf("invalid BROWSETRACE_COMPRESSION: %w", err)
+		}
+		compression.Codec = codec
+	}
+	if value := os.Getenv("BROWSETRACE_COMPRESSION_THRESHOLD"); value != "" {
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold <= 0 {
+			return fmt.Errorf("invalid BROWSETRACE_COMPRESSION_THRESHOLD: %s", value)
+		}
+		compression.Threshold = threshold
+	}
+	db.SetCompression(compression)
+
+	result, err := db.Recompress(context.Background(), false)
+	if err != nil {
+		return err
+	}
+	if result.Blobs > 0 {
+		log.Printf("Compressed %d stored texts from %d to %d bytes", result.Blobs, result.BytesBefore, result.BytesAfter)
+	}
+	return nil
+}
+
+// runCompress re-encodes all stored text with the configured codec,
+// optionally training a zstd dictionary on it first.
+func runCompress(args []string) error {
+	flags := flag.NewFlagSet("compress", flag.ExitOnError)
+	train := flags.Bool("train", false, "train a zstd dictionary on recent page text before recompressing")
+	flags.Parse(args)
+
+	a, err := openAgent()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if *train {
+		hash, err := a.db.TrainDictionary(context.Background())
+		if err != nil {
+			return err
+		}
+		log.Printf("Trained compression dictionary %s", hash)
+	}
+	result, err := a.db.Recompress(context.Background(), true)
+	if err != nil {
+		return err
+	}
+	log.Printf("Recompressed %d stored texts from %d to %d bytes", result.Blobs, result.BytesBefore, result.BytesAfter)
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/llmcontext"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func runContext(args []string) error {
+	flags := flag.NewFlagSet("context", flag.ExitOnError)
+	minutes := flags.Int("minutes", 30, "compile the last N minutes of activity")
+	since := flags.String("since", "", "compile activity from this time instead (ms, RFC 3339 or YYYY-MM-DD)")
+	until := flags.String("until", "", "with -since, stop at this time")
+	sessionID := flags.Int64("session", 0, "compile this session instead of a time window")
+	budget := flags.Int("budget", llmcontext.DefaultBudget, "token budget")
+	strategyName := flags.String("strategy", "recent", "what to cut when over budget: recent (drop oldest pages) or even (share between pages)")
+	asJSON := flags.Bool("json", false, "print the result with its token estimate as JSON")
+	output := flags.String("o", "-", "output file, - for stdout")
+	flags.Parse(args)
+
+	strategy, err := llmcontext.ParseStrategy(*strategyName)
+	if err != nil {
+		return err
+	}
+	opts := llmcontext.Options{Budget: *budget, Strategy: strategy}
+
+	a, err := openAgent()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	blocklist, err := loadBlocklist()
+	if err != nil {
+		return err
+	}
+	compiler := llmcontext.NewCompiler(a.db, a.sessions, blocklist)
+
+	var result llmcontext.Result
+	if *sessionID > 0 {
+		result, err = compiler.Session(context.Background(), *sessionID, opts)
+	} else {
+		start := time.Now().Add(-time.Duration(*minutes) * time.Minute).UnixMilli()
+		var end int64
+		if *since != "" {
+			if start, err = models.ParseTimestamp(*since); err != nil {
+				return err
+			}
+		}
+		if *until != "" {
+			if end, err = models.ParseTimestamp(*until); err != nil {
+				return err
+			}
+		}
+		result, err = compiler.Window(context.Background(), start, end, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	var writer io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		writer = file
+	}
+	if *asJSON {
+		return json.NewEncoder(writer).Encode(result)
+	}
+	if _, err := io.WriteString(writer, result.Text); err != nil {
+		return fmt.Errorf("failed to write context: %w", err)
+	}
+	log.Printf("Compiled %d pages, about %d tokens", result.Pages, result.EstimatedTokens)
+	return nil
+}
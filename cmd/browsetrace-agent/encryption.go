@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/encryption"
+)
+
+// keyringService names the agent's entries in the OS keyring.
+const keyringService = "browsetrace-agent"
+
+// keySource is where the database encryption key comes from: a passphrase
+// from BROWSETRACE_PASSPHRASE, or a random secret kept in the OS keyring or a
+// key file as selected by BROWSETRACE_ENCRYPTION (keyring, file or off, the
+// default). The keyring falls back to the key file where there is none.
+type keySource struct {
+	passphrase string
+	store      encryption.Store
+}
+
+func loadKeySource(directory string) (*keySource, error) {
+	if passphrase := os.Getenv("BROWSETRACE_PASSPHRASE"); passphrase != "" {
+		return &keySource{passphrase: passphrase}, nil
+	}
+	file := encryption.FileStore{Path: filepath.Join(directory, "encryption.key")}
+	switch mode := os.Getenv("BROWSETRACE_ENCRYPTION"); mode {
+	case "", "off":
+		return &keySource{}, nil
+	case "file":
+		return &keySource{store: file}, nil
+	case "keyring":
+		keyring, err := encryption.NewKeyringStore(keyringService)
+		if errors.Is(err, encryption.ErrNoKeyring) {
+			log.Printf("No OS keyring available; keeping the encryption key in %s", file.Path)
+			return &keySource{store: file}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &keySource{store: keyring}, nil
+	default:
+		return nil, fmt.Errorf("invalid BROWSETRACE_ENCRYPTION %q (want keyring, file or off)", mode)
+	}
+}
+
+func (s *keySource) enabled() bool {
+	return s.passphrase != "" || s.store != nil
+}
+
+// currentKey returns the configured key, or nil when encryption is off. A
+// key store without a key gets a new random one, unless the database is
+// already encrypted with a key that must have been lost.
+func (s *keySource) currentKey(ctx context.Context, db *database.Database) (*encryption.Key, error) {
+	if s.passphrase != "" {
+		return passphraseKey(ctx, db, s.passphrase)
+	}
+	if s.store == nil {
+		return nil, nil
+	}
+	secret, err := s.store.Get("current")
+	if errors.Is(err, encryption.ErrNoSecret) {
+		keyID, err := db.EncryptionKeyID(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if keyID != "" {
+			return nil, fmt.Errorf("events.db is encrypted but %s holds no key", s.store.Name())
+		}
+		if secret, err = encryption.GenerateSecret(); err != nil {
+			return nil, err
+		}
+		if err := s.store.Set("current", secret); err != nil {
+			return nil, err
+		}
+		log.Printf("Generated a new encryption key in %s", s.store.Name())
+	} else if err != nil {
+		return nil, err
+	}
+	return encryption.NewKey(secret)
+}
+
+func passphraseKey(ctx context.Context, db *database.Database, passphrase string) (*encryption.Key, error) {
+	salt, err := db.EncryptionSalt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := encryption.DeriveSecret(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.NewKey(secret)
+}
+
+// configureEncryption gives db its key. It returns a key to encrypt the
+// database with once every subsystem has registered its hooks, when
+// encryption was just turned on for a plaintext database.
+func configureEncryption(ctx context.Context, db *database.Database, keys *keySource) (*encryption.Key, error) {
+	key, err := keys.currentKey(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	err = db.SetEncryptionKey(ctx, key)
+	switch {
+	case err == nil:
+		if keys.store != nil {
+			// Left over from a rotation that failed before re-encrypting.
+			return nil, keys.store.Delete("next")
+		}
+		return nil, nil
+	case errors.Is(err, database.ErrNotEncrypted):
+		return key, nil
+	case errors.Is(err, database.ErrEncrypted):
+		return nil, errors.New("events.db is encrypted; set BROWSETRACE_PASSPHRASE or BROWSETRACE_ENCRYPTION")
+	case errors.Is(err, encryption.ErrWrongKey) && keys.store != nil:
+		// A rotation may have re-encrypted the database but stopped before
+		// promoting the new key.
+		if next, nextErr := keys.store.Get("next"); nextErr == nil {
+			nextKey, keyErr := encryption.NewKey(next)
+			if keyErr == nil && db.SetEncryptionKey(ctx, nextKey) == nil {
+				log.Printf("Completing an interrupted key rotation")
+				if err := keys.store.Set("current", next); err != nil {
+					return nil, err
+				}
+				return nil, keys.store.Delete("next")
+			}
+		}
+	}
+	return nil, err
+}
+
+// runRekey re-encrypts the database with a new key, or decrypts it. The
+// agent must be stopped first: it would go on sealing new events with the
+// old key.
+func runRekey(args []string) error {
+	flags := flag.NewFlagSet("rekey", flag.ExitOnError)
+	decrypt := flags.Bool("decrypt", false, "decrypt the database instead of rotating its key")
+	flags.Parse(args)
+
+	if address := agentAddress(); agentRunning(address) {
+		return fmt.Errorf("the agent is running at %s; stop it before rekeying", address)
+	}
+
+	a, err := openAgent()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	if !a.keys.enabled() {
+		return errors.New("encryption is not enabled; set BROWSETRACE_PASSPHRASE or BROWSETRACE_ENCRYPTION")
+	}
+	ctx := context.Background()
+
+	if *decrypt {
+		count, err := a.db.Reencrypt(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if a.keys.store != nil {
+			if err := a.keys.store.Delete("current"); err != nil {
+				return err
+			}
+		}
+		log.Printf("Decrypted %d events; unset BROWSETRACE_PASSPHRASE and BROWSETRACE_ENCRYPTION to keep it that way", count)
+		return nil
+	}
+
+	if a.keys.passphrase != "" {
+		passphrase := os.Getenv("BROWSETRACE_NEW_PASSPHRASE")
+		if passphrase == "" {
+			return errors.New("set BROWSETRACE_NEW_PASSPHRASE to the new passphrase")
+		}
+		key, err := passphraseKey(ctx, a.db, passphrase)
+		if err != nil {
+			return err
+		}
+		count, err := a.db.Reencrypt(ctx, key)
+		if err != nil {
+			return err
+		}
+		log.Printf("Re-encrypted %d events with key %s; use the new passphrase from now on", count, key.ID())
+		return nil
+	}
+
+	// Save the new secret before using it, so that a crash cannot leave the
+	// database encrypted with a key that exists nowhere else.
+	secret, err := encryption.GenerateSecret()
+	if err != nil {
+		return err
+	}
+	key, err := encryption.NewKey(secret)
+	if err != nil {
+		return err
+	}
+	if err := a.keys.store.Set("next", secret); err != nil {
+		return err
+	}
+	count, err := a.db.Reencrypt(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := a.keys.store.Set("current", secret); err != nil {
+		return err
+	}
+	if err := a.keys.store.Delete("next"); err != nil {
+		return err
+	}
+	log.Printf("Re-encrypted %d events with key %s in %s", count, key.ID(), a.keys.store.Name())
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/export"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	formatName := flags.String("format", "jsonl", "output format: jsonl, csv, parquet or har")
+	output := flags.String("o", "-", "output file, - for stdout")
+	since := flags.String("since", "", "only events at or after this time (ms, RFC 3339 or YYYY-MM-DD)")
+	until := flags.String("until", "", "only events before this time (ms, RFC 3339 or YYYY-MM-DD)")
+	types := flags.String("type", "", "comma-separated event types")
+	urlPrefix := flags.String("url-prefix", "", "only URLs starting with this prefix")
+	tabID := flags.Int64("tab", -1, "only events from this tab ID")
+	windowID := flags.Int64("window", -1, "only events from this window ID")
+	profile := flags.String("profile", "", "only events from this browser profile")
+	clientID := flags.String("client", "", "only events from this client/device ID")
+	limit := flags.Int("limit", 0, "maximum number of events, 0 for all")
+	fields := flags.String("fields", "", "comma-separated data key paths to flatten into CSV columns, e.g. target.selector")
+	flags.Parse(args)
+
+	format, err := export.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	filter := database.EventFilter{URLPrefix: *urlPrefix, Profile: *profile, ClientID: *clientID, Limit: *limit}
+	if *tabID >= 0 {
+		filter.TabID = tabID
+	}
+	if *windowID >= 0 {
+		filter.WindowID = windowID
+	}
+	if *since != "" {
+		if filter.Since, err = models.ParseTimestamp(*since); err != nil {
+			return err
+		}
+	}
+	if *until != "" {
+		if filter.Until, err = models.ParseTimestamp(*until); err != nil {
+			return err
+		}
+	}
+	if *types != "" {
+		filter.Types = strings.Split(*types, ",")
+	}
+	opts := export.Options{Format: format, Filter: filter}
+	if *fields != "" {
+		opts.CSVFields = strings.Split(*fields, ",")
+	}
+
+	a, err := openAgent()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	var writer io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		writer = file
+	}
+
+	count, err := export.Export(context.Background(), a.db, writer, opts)
+	if err != nil {
+		return err
+	}
+	log.Printf("Exported %d events", count)
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/forward"
+)
+
+// configureForwarding queues the events db stores for the collector at
+// BROWSETRACE_FORWARD_URL, if set. BROWSETRACE_FORWARD_TOKEN is sent as a
+// bearer token and BROWSETRACE_FORWARD_BATCH_SIZE sets how many events go in
+// one request. Blocklisted sites are never forwarded. Every command that
+// writes events queues them; the server sends them.
+func configureForwarding(db *database.Database) (*forward.Forwarder, error) {
+	url := os.Getenv("BROWSETRACE_FORWARD_URL")
+	if url == "" {
+		return nil, nil
+	}
+	blocklist, err := loadBlocklist()
+	if err != nil {
+		return nil, err
+	}
+	config := forward.Config{URL: url, Token: os.Getenv("BROWSETRACE_FORWARD_TOKEN"), Blocklist: blocklist}
+	if value := os.Getenv("BROWSETRACE_FORWARD_BATCH_SIZE"); value != "" {
+		if config.BatchSize, err = strconv.Atoi(value); err != nil || config.BatchSize <= 0 {
+			return nil, fmt.Errorf("invalid BROWSETRACE_FORWARD_BATCH_SIZE: %s", value)
+		}
+	}
+	return forward.New(db, config)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/vincentbai/browsetrace-agent/internal/importer"
+)
+
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	browser := flags.String("browser", "chrome", "browser history to import: chrome or firefox")
+	path := flags.String("path", "", "history database (defaults to the browser's default profile)")
+	harPath := flags.String("har", "", "import navigations from this HAR file instead of a browser")
+	flags.Parse(args)
+
+	a, err := openAgent()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if *harPath != "" {
+		file, err := os.Open(*harPath)
+		if err != nil {
+			return fmt.Errorf("failed to open HAR file: %w", err)
+		}
+		defer file.Close()
+
+		result, err := importer.ImportHAR(context.Background(), a.db, file)
+		if err != nil {
+			return err
+		}
+		log.Printf("Imported %d of %d pages from %s", result.Imported, result.Read, *harPath)
+		return a.analytics.Rebuild(context.Background())
+	}
+
+	historyPath := *path
+	if historyPath == "" {
+		switch *browser {
+		case "chrome":
+			historyPath, err = importer.DefaultChromePath()
+		case "firefox":
+			historyPath, err = importer.DefaultFirefoxPath()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var result importer.Result
+	switch *browser {
+	case "chrome":
+		result, err = importer.ImportChrome(context.Background(), a.db, historyPath)
+	case "firefox":
+		result, err = importer.ImportFirefox(context.Background(), a.db, historyPath)
+	default:
+		return fmt.Errorf("unsupported browser %q (want chrome or firefox)", *browser)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Imported %d of %d visits from %s", result.Imported, result.Read, historyPath)
+	if result.Skipped > 0 {
+		log.Printf("Skipped %d visits without a usable time", result.Skipped)
+	}
+	// Imported history predates live events, which the incremental dwell
+	// tracker skips; recompute the rollups from scratch.
+	return a.analytics.Rebuild(context.Background())
+}
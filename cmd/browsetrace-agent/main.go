@@ -1,52 +1,282 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // clients name their time zones; not every host has a zone database
 
+	"github.com/vincentbai/browsetrace-agent/internal/analytics"
+	"github.com/vincentbai/browsetrace-agent/internal/capture"
 	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/devicesync"
+	"github.com/vincentbai/browsetrace-agent/internal/forward"
+	"github.com/vincentbai/browsetrace-agent/internal/insights"
+	"github.com/vincentbai/browsetrace-agent/internal/llmcontext"
+	"github.com/vincentbai/browsetrace-agent/internal/mcp"
+	"github.com/vincentbai/browsetrace-agent/internal/report"
 	"github.com/vincentbai/browsetrace-agent/internal/server"
+	"github.com/vincentbai/browsetrace-agent/internal/sessions"
 )
 
 func main() {
-	// app data dir: platform-specific
+	// First non-flag argument selects a subcommand; the default is to serve.
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "serve":
+		err = runServe()
+	case "export":
+		err = runExport(args)
+	case "import":
+		err = runImport(args)
+	case "report":
+		err = runReport(args)
+	case "mcp":
+		err = runMCP(args)
+	case "context":
+		err = runContext(args)
+	case "script":
+		err = runScript(args)
+	case "normalize":
+		err = runNormalize(args)
+	case "compress":
+		err = runCompress(args)
+	case "rekey":
+		err = runRekey(args)
+	case "backup":
+		err = runBackup(args)
+	case "restore":
+		err = runRestore(args)
+	case "sync":
+		err = runSync(args)
+	case "capture":
+		err = runCapture(args)
+	default:
+		err = fmt.Errorf("unknown command %q (want serve, export, import, report, mcp, context, script, normalize, compress, rekey, backup, restore, sync or capture)", command)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// applicationDirectory returns the platform-specific app data dir, creating it if needed.
+func applicationDirectory() (string, error) {
 	homeDirectory, err := os.UserHomeDir()
 	if err != nil {
-		log.Fatal("Failed to get user home directory:", err)
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
 	}
 
-	var applicationDirectory string
+	var directory string
 	switch runtime.GOOS {
 	case "darwin":
-		applicationDirectory = filepath.Join(homeDirectory, "Library", "Application Support", "BrowserTrace")
+		directory = filepath.Join(homeDirectory, "Library", "Application Support", "BrowserTrace")
 	case "windows":
-		applicationDirectory = filepath.Join(homeDirectory, "AppData", "Roaming", "BrowserTrace")
+		directory = filepath.Join(homeDirectory, "AppData", "Roaming", "BrowserTrace")
 	default: // linux and others
-		applicationDirectory = filepath.Join(homeDirectory, ".local", "share", "BrowserTrace")
+		directory = filepath.Join(homeDirectory, ".local", "share", "BrowserTrace")
+	}
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create application directory: %w", err)
+	}
+	return directory, nil
+}
+
+// agent bundles the database with the subsystems that derive data from every
+// insert, so that commands writing events keep derived tables current.
+type agent struct {
+	db        *database.Database
+	sessions  *sessions.Sessionizer
+	analytics *analytics.Tracker
+	keys      *keySource
+	forwarder *forward.Forwarder
+}
+
+func openAgent() (*agent, error) {
+	directory, err := applicationDirectory()
+	if err != nil {
+		return nil, err
+	}
+	normalizer, err := loadURLNormalizer()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := loadKeySource(directory)
+	if err != nil {
+		return nil, err
+	}
+	db, err := database.NewDatabase(filepath.Join(directory, "events.db"))
+	if err != nil {
+		return nil, err
+	}
+	encryptWith, err := configureEncryption(context.Background(), db, keys)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	// Rows stored before canonical URLs existed get theirs on first start.
+	db.SetURLNormalizer(normalizer.Normalize)
+	backfilled, err := db.CanonicalizeURLs(context.Background(), false)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if value := os.Getenv("BROWSETRACE_TEXT_DIFFS"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("invalid BROWSETRACE_TEXT_DIFFS: %w", err)
+		}
+		db.SetTextDiffs(enabled)
+	}
+	if err := configureCompression(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	// Likewise, page text recorded before text blobs existed moves into them.
+	if _, err := db.CompactText(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	idleGap := sessions.DefaultIdleGap
+	if value := os.Getenv("BROWSETRACE_SESSION_IDLE_GAP"); value != "" {
+		if idleGap, err = time.ParseDuration(value); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("invalid BROWSETRACE_SESSION_IDLE_GAP: %w", err)
+		}
+	}
+	sessionizer, err := sessions.New(db, idleGap)
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
-	if err := os.MkdirAll(applicationDirectory, 0o755); err != nil {
-		log.Fatal("Failed to create application directory:", err)
+
+	idleCutoff := analytics.DefaultIdleCutoff
+	if value := os.Getenv("BROWSETRACE_IDLE_CUTOFF"); value != "" {
+		if idleCutoff, err = time.ParseDuration(value); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("invalid BROWSETRACE_IDLE_CUTOFF: %w", err)
+		}
+	}
+	tracker, err := analytics.New(db, idleCutoff)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if backfilled > 0 {
+		// Rollups built before the backfill are keyed by raw URL.
+		if err := tracker.Rebuild(context.Background()); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if encryptWith != nil {
+		// Encryption was just turned on; the rollups are resealed with the rest.
+		count, err := db.Reencrypt(context.Background(), encryptWith)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		log.Printf("Encrypted %d stored events with key %s", count, encryptWith.ID())
+	}
+	forwarder, err := configureForwarding(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &agent{db: db, sessions: sessionizer, analytics: tracker, keys: keys, forwarder: forwarder}, nil
+}
+
+func (a *agent) Close() error {
+	return a.db.Close()
+}
+
+func runServe() error {
+	dsn, err := postgresDSN()
+	if err != nil {
+		return err
+	}
+	if dsn != "" {
+		return runServePostgres(dsn)
 	}
-	databasePath := filepath.Join(applicationDirectory, "events.db")
 
 	// Initialize database
-	db, err := database.NewDatabase(databasePath)
+	a, err := openAgent()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer db.Close()
+	defer a.Close()
 
 	// Get server address from environment or use default
-	serverAddress := os.Getenv("BROWSETRACE_ADDRESS")
-	if serverAddress == "" {
-		serverAddress = "127.0.0.1:8123"
+	serverAddress := agentAddress()
+
+	blocklist, err := loadBlocklist()
+	if err != nil {
+		return err
+	}
+	directory, err := applicationDirectory()
+	if err != nil {
+		return err
+	}
+	backups, err := backupManager(a.db, directory)
+	if err != nil {
+		return err
+	}
+	engine, err := loadRules(a)
+	if err != nil {
+		return err
+	}
+	stages, err := loadPipeline()
+	if err != nil {
+		return err
+	}
+	defer stages.Close()
+	sw, err := capture.New(a.db)
+	if err != nil {
+		return err
+	}
+	clock, err := clockSkewChecker()
+	if err != nil {
+		return err
 	}
 
 	// Initialize and start server
-	srv := server.NewServer(db, serverAddress)
-	if err := srv.Start(); err != nil {
-		log.Fatal(err)
+	reports := report.New(a.db, a.analytics)
+	options := []server.Option{
+		server.WithSessions(a.sessions),
+		server.WithAnalytics(a.analytics),
+		server.WithReports(reports),
+		server.WithMCP(mcp.New(a.db, a.sessions, reports, blocklist)),
+		server.WithContext(llmcontext.NewCompiler(a.db, a.sessions, blocklist)),
+		server.WithInsights(insights.NewMiner(a.sessions)),
+		server.WithBackups(backups),
+		server.WithRules(engine),
+		server.WithPipeline(stages),
+		server.WithCapture(sw),
+		server.WithClockSkew(clock),
+	}
+	if a.forwarder != nil {
+		options = append(options, server.WithForwarding(a.forwarder))
+	}
+	// Other agents may sync with this one once they share a secret.
+	if secret := os.Getenv("BROWSETRACE_SYNC_SECRET"); secret != "" {
+		peer, err := devicesync.NewPeer(a.db, []byte(secret))
+		if err != nil {
+			return err
+		}
+		options = append(options, server.WithSync(peer))
 	}
+	srv := server.NewServer(a.db, serverAddress, options...)
+	return srv.Start()
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/vincentbai/browsetrace-agent/internal/mcp"
+	"github.com/vincentbai/browsetrace-agent/internal/privacy"
+	"github.com/vincentbai/browsetrace-agent/internal/report"
+)
+
+// loadBlocklist reads the privacy blocklist from BROWSETRACE_BLOCKLIST, or
+// blocklist.txt in the application directory. A missing file blocks nothing.
+func loadBlocklist() (*privacy.Blocklist, error) {
+	path := os.Getenv("BROWSETRACE_BLOCKLIST")
+	if path == "" {
+		directory, err := applicationDirectory()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(directory, "blocklist.txt")
+	}
+	return privacy.Load(path)
+}
+
+// runMCP serves the Model Context Protocol over stdin and stdout, for
+// assistants that launch the agent as a subprocess.
+func runMCP(args []string) error {
+	flags := flag.NewFlagSet("mcp", flag.ExitOnError)
+	flags.Parse(args)
+
+	a, err := openAgent()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	blocklist, err := loadBlocklist()
+	if err != nil {
+		return err
+	}
+	server := mcp.New(a.db, a.sessions, report.New(a.db, a.analytics), blocklist)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	return server.ServeStdio(ctx, os.Stdin, os.Stdout)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/vincentbai/browsetrace-agent/internal/urlnorm"
+)
+
+// loadURLNormalizer reads URL normalization rules from BROWSETRACE_URL_RULES,
+// or url-rules.txt in the application directory. A missing file means the
+// built-in rules.
+func loadURLNormalizer() (*urlnorm.Normalizer, error) {
+	path := os.Getenv("BROWSETRACE_URL_RULES")
+	if path == "" {
+		directory, err := applicationDirectory()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(directory, "url-rules.txt")
+	}
+	rules, err := urlnorm.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return urlnorm.New(rules)
+}
+
+// runNormalize recomputes canonical_url for every stored event, for use after
+// editing the rules file.
+func runNormalize(args []string) error {
+	flags := flag.NewFlagSet("normalize", flag.ExitOnError)
+	flags.Parse(args)
+
+	a, err := openAgent()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	changed, err := a.db.CanonicalizeURLs(context.Background(), true)
+	if err != nil {
+		return err
+	}
+	log.Printf("Updated the canonical URL of %d events", changed)
+	if changed == 0 {
+		return nil
+	}
+	return a.analytics.Rebuild(context.Background())
+}
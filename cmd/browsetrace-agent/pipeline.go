@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/vincentbai/browsetrace-agent/internal/pipeline"
+)
+
+// loadPipeline reads the ingestion pipeline from BROWSETRACE_PIPELINE, or
+// pipeline.json in the application directory. Without the file, posted events
+// are stored as they arrive.
+func loadPipeline() (*pipeline.Pipeline, error) {
+	path := os.Getenv("BROWSETRACE_PIPELINE")
+	if path == "" {
+		directory, err := applicationDirectory()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(directory, "pipeline.json")
+	}
+	stages, err := pipeline.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if stages.Len() > 0 {
+		log.Printf("Processing posted events through %d pipeline stages from %s", stages.Len(), path)
+	}
+	return stages, nil
+}
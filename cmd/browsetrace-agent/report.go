@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/report"
+)
+
+func runReport(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	periodName := flags.String("period", "day", "digest period: day or week")
+	date := flags.String("date", "", "any day within the period (YYYY-MM-DD, RFC 3339 or ms); defaults to today")
+	formatName := flags.String("format", "markdown", "output format: markdown, html or json")
+	output := flags.String("o", "-", "output file, - for stdout")
+	limit := flags.Int("limit", report.DefaultLimit, "entries per section")
+	timezone := flags.String("tz", "", "IANA time zone whose days the report covers; defaults to the local one")
+	flags.Parse(args)
+
+	period, err := report.ParsePeriod(*periodName)
+	if err != nil {
+		return err
+	}
+	format, err := report.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	opts := report.Options{Period: period, Limit: *limit, Location: models.DefaultLocation}
+	if *timezone != "" {
+		if opts.Location, err = time.LoadLocation(*timezone); err != nil {
+			return fmt.Errorf("invalid time zone %q: %w", *timezone, err)
+		}
+	}
+	if day, err := time.ParseInLocation(time.DateOnly, *date, opts.Location); err == nil {
+		// A plain date names that day in the report's time zone, not UTC.
+		opts.Date = day
+	} else if *date != "" {
+		ms, err := models.ParseTimestamp(*date)
+		if err != nil {
+			return err
+		}
+		opts.Date = time.UnixMilli(ms)
+	}
+
+	a, err := openAgent()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	digest, err := report.New(a.db, a.analytics).Generate(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+
+	var writer io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		writer = file
+	}
+	return report.Render(writer, digest, format)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/vincentbai/browsetrace-agent/internal/rules"
+)
+
+// loadRules starts the rules engine on BROWSETRACE_RULES, or rules.json in
+// the application directory. The file need not exist yet: the server picks
+// it up, and any later edit, while it runs.
+func loadRules(a *agent) (*rules.Engine, error) {
+	path := os.Getenv("BROWSETRACE_RULES")
+	if path == "" {
+		directory, err := applicationDirectory()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(directory, "rules.json")
+	}
+	return rules.New(a.db, a.analytics, path)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/vincentbai/browsetrace-agent/internal/automation"
+)
+
+func runScript(args []string) error {
+	flags := flag.NewFlagSet("script", flag.ExitOnError)
+	sessionID := flags.Int64("session", 0, "session to replay (see GET /sessions)")
+	targetName := flags.String("target", "playwright", "script flavour: playwright (JavaScript) or chromedp (Go)")
+	parameterize := flags.Bool("parameterize", false, "read typed values from environment variables, defaulting to the recorded ones")
+	output := flags.String("o", "-", "output file, - for stdout")
+	flags.Parse(args)
+
+	if *sessionID <= 0 {
+		return fmt.Errorf("-session is required")
+	}
+	target, err := automation.ParseTarget(*targetName)
+	if err != nil {
+		return err
+	}
+
+	a, err := openAgent()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	detail, err := a.sessions.Get(context.Background(), *sessionID)
+	if err != nil {
+		return err
+	}
+	script, err := automation.Generate(detail.Events, automation.Options{
+		Target:       target,
+		Parameterize: *parameterize,
+		Description:  fmt.Sprintf("session %d", *sessionID),
+	})
+	if err != nil {
+		return err
+	}
+
+	var writer io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		writer = file
+	}
+	if _, err := io.WriteString(writer, script); err != nil {
+		return fmt.Errorf("failed to write script: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/vincentbai/browsetrace-agent/internal/server"
+	"github.com/vincentbai/browsetrace-agent/internal/storage"
+)
+
+// postgresDSN returns the PostgreSQL URL BROWSETRACE_STORAGE names, or "" for
+// the default local SQLite database.
+func postgresDSN() (string, error) {
+	value := os.Getenv("BROWSETRACE_STORAGE")
+	switch {
+	case value == "" || value == "sqlite":
+		return "", nil
+	case strings.HasPrefix(value, "postgres://") || strings.HasPrefix(value, "postgresql://"):
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid BROWSETRACE_STORAGE (want sqlite or a postgres:// URL)")
+	}
+}
+
+// runServePostgres serves events stored in PostgreSQL. Sessions, time stats,
+// reports, MCP, context, insights, backups and the capture switch are built
+// on the local SQLite database and are not available.
+func runServePostgres(dsn string) error {
+	normalizer, err := loadURLNormalizer()
+	if err != nil {
+		return err
+	}
+	stages, err := loadPipeline()
+	if err != nil {
+		return err
+	}
+	defer stages.Close()
+	clock, err := clockSkewChecker()
+	if err != nil {
+		return err
+	}
+	store, err := storage.NewPostgres(context.Background(), dsn)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	store.SetURLNormalizer(normalizer.Normalize)
+
+	log.Printf("Storing events in PostgreSQL; sessions, time stats, reports, backups and pausing capture need the SQLite backend")
+	return server.NewServer(store, agentAddress(), server.WithPipeline(stages), server.WithClockSkew(clock)).Start()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/vincentbai/browsetrace-agent/internal/devicesync"
+)
+
+// syncSecret returns BROWSETRACE_SYNC_SECRET, which signs and verifies sync
+// bundles and must be the same on every device.
+func syncSecret() ([]byte, error) {
+	secret := os.Getenv("BROWSETRACE_SYNC_SECRET")
+	if secret == "" {
+		return nil, errors.New("set BROWSETRACE_SYNC_SECRET to the secret shared by your devices")
+	}
+	return []byte(secret), nil
+}
+
+// runSync exchanges changes with other devices, directly with a running agent
+// or through bundle files:
+//
+//	sync -peer URL [-page N]
+//	sync export -since WATERMARK -o FILE
+//	sync import FILE...
+func runSync(args []string) error {
+	if len(args) == 0 {
+		return errors.New("sync needs -peer or a subcommand: export or import")
+	}
+	secret, err := syncSecret()
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(args[0], "-") {
+		return runSyncPeer(args, secret)
+	}
+	switch args[0] {
+	case "export":
+		return runSyncExport(args[1:], secret)
+	case "import":
+		return runSyncImport(args[1:], secret)
+	default:
+		return fmt.Errorf("unknown sync subcommand %q (want export or import)", args[0])
+	}
+}
+
+func runSyncPeer(args []string, secret []byte) error {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	peerURL := flags.String("peer", "", "base URL of the agent to sync with, such as http://desktop.local:8123")
+	pageSize := flags.Int("page", devicesync.DefaultPageSize, "events and deletions per request")
+	flags.Parse(args)
+	if *peerURL == "" {
+		return errors.New("sync needs -peer")
+	}
+	if *pageSize <= 0 {
+		return fmt.Errorf("invalid -page: %d", *pageSize)
+	}
+
+	a, err := openAgent()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	peer, err := devicesync.NewPeer(a.db, secret)
+	if err != nil {
+		return err
+	}
+	peer.PageSize = *pageSize
+	result, err := peer.Sync(context.Background(), *peerURL)
+	if err != nil {
+		return err
+	}
+	log.Printf("Synced with %s: received %d new events and %d deletions, sent %d new events and %d deletions",
+		*peerURL, result.Received.Inserted, result.Received.Deleted, result.Sent.Inserted, result.Sent.Deleted)
+	if result.Received.Inserted == 0 {
+		return nil
+	}
+	// As with bundles, the rollups are recomputed around the merged events.
+	return a.analytics.Rebuild(context.Background())
+}
+
+func runSyncExport(args []string, secret []byte) error {
+	flags := flag.NewFlagSet("sync export", flag.ExitOnError)
+	since := flags.Int64("since", 0, "watermark printed by the previous export; 0 exports everything")
+	output := flags.String("o", "", "bundle file to write")
+	flags.Parse(args)
+	if *output == "" {
+		return errors.New("sync export needs -o")
+	}
+
+	a, err := openAgent()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	bundle, err := devicesync.Export(context.Background(), a.db, *since)
+	if err != nil {
+		return err
+	}
+	// Bundles hold history in the clear.
+	file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	if err := devicesync.Write(file, bundle, secret); err != nil {
+		file.Close()
+		os.Remove(*output)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	log.Printf("Wrote %d events and %d deletions from device %s to %s; next time pass -since %d",
+		len(bundle.Events), len(bundle.Tombstones), bundle.DeviceID, *output, bundle.Watermark)
+	return nil
+}
+
+func runSyncImport(paths []string, secret []byte) error {
+	if len(paths) == 0 {
+		return errors.New("sync import needs at least one bundle file")
+	}
+	a, err := openAgent()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	inserted := 0
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open bundle: %w", err)
+		}
+		bundle, err := devicesync.Read(file, secret)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		result, err := devicesync.Import(context.Background(), a.db, bundle)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		log.Printf("Merged %s from device %s: %d new events, %d deleted, %d already known",
+			path, bundle.DeviceID, result.Inserted, result.Deleted, result.Skipped)
+		inserted += result.Inserted
+	}
+	if inserted == 0 {
+		return nil
+	}
+	// Merged events interleave with local ones, which the incremental dwell
+	// tracker skips; recompute the rollups from scratch.
+	return a.analytics.Rebuild(context.Background())
+}
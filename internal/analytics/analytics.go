@@ -0,0 +1,326 @@
+// Package analytics estimates time spent per canonical URL and per registrable
+// domain.
+//
+// Every activity event (navigate, focus, scroll, click, input) marks the user
+// as present on that event's page. The time until the next activity event from
+// the same client and profile is credited to the earlier page, capped at the
+// idle cutoff so that walking away from the browser does not count. Credits
+// are rolled up per day as events are inserted, on the local day of the
+// earlier event: the user's day, in the time zone their client reported.
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/urlnorm"
+)
+
+// DefaultIdleCutoff caps how long a single gap between activity events counts.
+const DefaultIdleCutoff = 5 * time.Minute
+
+const dayLayout = time.DateOnly
+
+// activityTypes are the events that show the user is looking at a page.
+// visible_text is sent by the extension on its own and does not count.
+var activityTypes = map[string]bool{
+	"navigate": true,
+	"focus":    true,
+	"scroll":   true,
+	"click":    true,
+	"input":    true,
+}
+
+type Tracker struct {
+	db         *database.Database
+	idleCutoff int64 // milliseconds
+}
+
+// New creates the rollup tables and registers the tracker as an insert and
+// delete hook.
+// Like the sessionizer, it backfills from existing events on first use.
+func New(db *database.Database, idleCutoff time.Duration) (*Tracker, error) {
+	t := &Tracker{db: db, idleCutoff: idleCutoff.Milliseconds()}
+	_, err := db.DB().Exec(`
+	CREATE TABLE IF NOT EXISTS dwell_daily_url(
+	  day    TEXT    NOT NULL,
+	  url    TEXT    NOT NULL,
+	  domain TEXT    NOT NULL,
+	  ms     INTEGER NOT NULL,
+	  PRIMARY KEY (day, url)
+	);
+	CREATE TABLE IF NOT EXISTS dwell_daily_domain(
+	  day    TEXT    NOT NULL,
+	  domain TEXT    NOT NULL,
+	  ms     INTEGER NOT NULL,
+	  PRIMARY KEY (day, domain)
+	);
+	CREATE TABLE IF NOT EXISTS dwell_state(
+	  client_id TEXT    NOT NULL,
+	  profile   TEXT    NOT NULL,
+	  last_ts   INTEGER NOT NULL,
+	  last_url  TEXT    NOT NULL,
+	  last_day  TEXT    NOT NULL DEFAULT '',
+	  PRIMARY KEY (client_id, profile)
+	);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create analytics tables: %w", err)
+	}
+
+	var hasState, hasEvents, hasDays bool
+	err = db.DB().QueryRow(`SELECT EXISTS(SELECT 1 FROM dwell_state), EXISTS(SELECT 1 FROM events),
+	EXISTS(SELECT 1 FROM pragma_table_info('dwell_state') WHERE name = 'last_day')`).Scan(&hasState, &hasEvents, &hasDays)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect analytics tables: %w", err)
+	}
+	// Rollups made before local days were kept are keyed by UTC day; the
+	// rebuild moves them onto local days.
+	if !hasDays {
+		if _, err := db.DB().Exec(`ALTER TABLE dwell_state ADD COLUMN last_day TEXT NOT NULL DEFAULT ''`); err != nil {
+			return nil, fmt.Errorf("failed to update analytics tables: %w", err)
+		}
+	}
+	if (!hasState || !hasDays) && hasEvents {
+		if err := t.Rebuild(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+
+	db.AddInsertHook(t.observe)
+	db.AddDeleteHook(t.rebuild)
+	db.AddReencryptHook(reencrypt)
+	return t, nil
+}
+
+type source struct {
+	clientID string
+	profile  string
+}
+
+type presence struct {
+	ts  int64
+	url string
+	day string // local day of ts
+}
+
+type urlDay struct {
+	day string
+	url string
+}
+
+// accumulator credits gaps between activity events. It is shared by the
+// incremental hook and the full rebuild.
+type accumulator struct {
+	idleCutoff int64
+	states     map[source]presence
+	dirty      map[source]bool
+	credits    map[urlDay]int64
+}
+
+func newAccumulator(idleCutoff int64) *accumulator {
+	return &accumulator{
+		idleCutoff: idleCutoff,
+		states:     make(map[source]presence),
+		dirty:      make(map[source]bool),
+		credits:    make(map[urlDay]int64),
+	}
+}
+
+// add records activity at ts, on local day day. Events older than the last
+// one seen for the source are ignored; Rebuild accounts for them.
+func (a *accumulator) add(key source, ts int64, url, day string) {
+	last, ok := a.states[key]
+	if ok && ts < last.ts {
+		return
+	}
+	if ok {
+		if gap := min(ts-last.ts, a.idleCutoff); gap > 0 {
+			a.credits[urlDay{day: last.day, url: last.url}] += gap
+		}
+	}
+	a.states[key] = presence{ts: ts, url: url, day: day}
+	a.dirty[key] = true
+}
+
+// flush writes the credits and presence to the rollup tables, sealing URLs
+// and domains when the database is encrypted.
+func (a *accumulator) flush(db *database.Database, transaction *sql.Tx) error {
+	for key, ms := range a.credits {
+		domain := db.Seal(urlnorm.RegistrableDomain(key.url))
+		_, err := transaction.Exec(`
+		INSERT INTO dwell_daily_url(day, url, domain, ms) VALUES(?,?,?,?)
+		ON CONFLICT(day, url) DO UPDATE SET ms = ms + excluded.ms`, key.day, db.Seal(key.url), domain, ms)
+		if err != nil {
+			return fmt.Errorf("failed to update URL rollup: %w", err)
+		}
+		_, err = transaction.Exec(`
+		INSERT INTO dwell_daily_domain(day, domain, ms) VALUES(?,?,?)
+		ON CONFLICT(day, domain) DO UPDATE SET ms = ms + excluded.ms`, key.day, domain, ms)
+		if err != nil {
+			return fmt.Errorf("failed to update domain rollup: %w", err)
+		}
+	}
+	for key := range a.dirty {
+		state := a.states[key]
+		_, err := transaction.Exec(`
+		INSERT INTO dwell_state(client_id, profile, last_ts, last_url, last_day) VALUES(?,?,?,?,?)
+		ON CONFLICT(client_id, profile) DO UPDATE SET last_ts = excluded.last_ts, last_url = excluded.last_url, last_day = excluded.last_day`,
+			key.clientID, key.profile, state.ts, db.Seal(state.url), state.day)
+		if err != nil {
+			return fmt.Errorf("failed to update presence: %w", err)
+		}
+	}
+	return nil
+}
+
+func (t *Tracker) observe(transaction *sql.Tx, events []models.StoredEvent) error {
+	sorted := make([]models.StoredEvent, 0, len(events))
+	for _, event := range events {
+		if activityTypes[event.Type] {
+			sorted = append(sorted, event)
+		}
+	}
+	if len(sorted) == 0 {
+		return nil
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].TSUTC < sorted[j].TSUTC })
+
+	acc := newAccumulator(t.idleCutoff)
+	for _, event := range sorted {
+		key := source{clientID: stringValue(event.ClientID), profile: stringValue(event.Profile)}
+		if _, loaded := acc.states[key]; !loaded {
+			var last presence
+			err := transaction.QueryRow(`SELECT last_ts, last_url, last_day FROM dwell_state WHERE client_id = ? AND profile = ?`,
+				key.clientID, key.profile).Scan(&last.ts, &last.url, &last.day)
+			if err == nil {
+				if last.url, err = t.db.Unseal(last.url); err != nil {
+					return fmt.Errorf("failed to decrypt presence: %w", err)
+				}
+				acc.states[key] = last
+			} else if err != sql.ErrNoRows {
+				return fmt.Errorf("failed to load presence: %w", err)
+			}
+		}
+		url := event.CanonicalURL
+		if url == "" {
+			url = event.URL
+		}
+		acc.add(key, event.TSUTC, url, localDay(event.TSUTC, event.LocalDate))
+	}
+	return acc.flush(t.db, transaction)
+}
+
+// Rebuild recomputes all rollups from the events table, including events that
+// arrived out of order (e.g. from a history import).
+func (t *Tracker) Rebuild(ctx context.Context) error {
+	transaction, err := t.db.DB().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+	if err := t.rebuild(ctx, transaction); err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// rebuild recomputes the rollups within transaction. It is also the delete
+// hook: time credited to deleted events must go with them.
+func (t *Tracker) rebuild(ctx context.Context, transaction *sql.Tx) error {
+	rows, err := transaction.QueryContext(ctx, `
+	SELECT ts_utc, COALESCE(canonical_url, url), COALESCE(client_id, ''), COALESCE(profile, ''), COALESCE(local_date, '') FROM events
+	WHERE type IN ('navigate','focus','scroll','click','input')
+	ORDER BY ts_utc, id`)
+	if err != nil {
+		return fmt.Errorf("failed to query events: %w", err)
+	}
+	acc := newAccumulator(t.idleCutoff)
+	for rows.Next() {
+		var ts int64
+		var url, day string
+		var key source
+		if err := rows.Scan(&ts, &url, &key.clientID, &key.profile, &day); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan event: %w", err)
+		}
+		if url, err = t.db.Unseal(url); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to decrypt event: %w", err)
+		}
+		acc.add(key, ts, url, localDay(ts, day))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read events: %w", err)
+	}
+
+	if _, err := transaction.ExecContext(ctx, `DELETE FROM dwell_daily_url; DELETE FROM dwell_daily_domain; DELETE FROM dwell_state;`); err != nil {
+		return fmt.Errorf("failed to clear rollups: %w", err)
+	}
+	return acc.flush(t.db, transaction)
+}
+
+// reencrypt reseals the URLs and domains in the rollup tables when the
+// database key changes.
+func reencrypt(transaction *sql.Tx, reseal func(string) (string, error)) error {
+	for _, column := range []struct{ table, name string }{
+		{"dwell_daily_url", "url"},
+		{"dwell_daily_url", "domain"},
+		{"dwell_daily_domain", "domain"},
+		{"dwell_state", "last_url"},
+	} {
+		rows, err := transaction.Query(`SELECT DISTINCT ` + column.name + ` FROM ` + column.table)
+		if err != nil {
+			return fmt.Errorf("failed to query %s: %w", column.table, err)
+		}
+		var values []string
+		for rows.Next() {
+			var value string
+			if err := rows.Scan(&value); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan %s: %w", column.table, err)
+			}
+			values = append(values, value)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to read %s: %w", column.table, err)
+		}
+		for _, value := range values {
+			resealed, err := reseal(value)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt %s: %w", column.table, err)
+			}
+			_, err = transaction.Exec(`UPDATE `+column.table+` SET `+column.name+` = ? WHERE `+column.name+` = ?`, resealed, value)
+			if err != nil {
+				return fmt.Errorf("failed to update %s: %w", column.table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// localDay returns an event's stored local date, or for an event without
+// one, its day in the agent's time zone.
+func localDay(ts int64, localDate string) string {
+	if localDate != "" {
+		return localDate
+	}
+	return time.UnixMilli(ts).Format(dayLayout)
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -0,0 +1,400 @@
+package analytics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/encryption"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/urlnorm"
+)
+
+const (
+	second = int64(1000)
+	minute = 60 * second
+	// 2021-01-01T00:00:00Z, a Friday.
+	base = int64(1609459200000)
+)
+
+func setupTestDB(t *testing.T) (*database.Database, func()) {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "browsetrace-analytics-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	db, err := database.NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+	return db, cleanup
+}
+
+// event returns an activity event from a client in UTC, so that its day does
+// not depend on the time zone the tests run in.
+func event(ts int64, url, eventType string) models.Event {
+	utc := "UTC"
+	return models.Event{TSUTC: ts, TSISO: models.FormatTimestamp(ts), URL: url, Type: eventType, Data: map[string]any{}, Timezone: &utc}
+}
+
+func byKey(items []Item) map[string]int64 {
+	result := make(map[string]int64)
+	for _, item := range items {
+		result[item.Key] = item.MS
+	}
+	return result
+}
+
+func TestDwellTimeWithIdleCutoff(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tracker, err := New(db, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	events := []models.Event{
+		event(base, "https://news.bbc.co.uk/a", "navigate"),
+		event(base+30*second, "https://news.bbc.co.uk/a", "scroll"),
+		// visible_text is not activity and must not split the gap.
+		event(base+40*second, "https://news.bbc.co.uk/a", "visible_text"),
+		event(base+60*second, "https://www.bbc.co.uk/sport", "navigate"),
+		// 20 minutes idle: only the 5 minute cutoff counts.
+		event(base+21*minute, "https://go.dev/", "navigate"),
+		event(base+22*minute, "https://go.dev/", "click"),
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	domains, err := tracker.TimeSpent(context.Background(), Query{Group: GroupDay, By: ByDomain})
+	if err != nil {
+		t.Fatalf("TimeSpent() error = %v", err)
+	}
+	if len(domains) != 1 || domains[0].Period != "2021-01-01" {
+		t.Fatalf("Expected one day bucket, got %+v", domains)
+	}
+	got := byKey(domains[0].Items)
+	if got["bbc.co.uk"] != 60*second+5*minute || got["go.dev"] != minute {
+		t.Errorf("Unexpected domain totals: %v", got)
+	}
+	if domains[0].TotalMS != 60*second+5*minute+minute {
+		t.Errorf("Unexpected total: %d", domains[0].TotalMS)
+	}
+	if domains[0].Items[0].Key != "bbc.co.uk" {
+		t.Errorf("Expected items sorted by time, got %+v", domains[0].Items)
+	}
+
+	urls, err := tracker.TimeSpent(context.Background(), Query{Group: GroupDay, By: ByURL})
+	if err != nil {
+		t.Fatalf("TimeSpent() error = %v", err)
+	}
+	gotURLs := byKey(urls[0].Items)
+	if gotURLs["https://news.bbc.co.uk/a"] != 60*second || gotURLs["https://www.bbc.co.uk/sport"] != 5*minute {
+		t.Errorf("Unexpected URL totals: %v", gotURLs)
+	}
+}
+
+func TestDwellTimeAcrossBatchesAndClients(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tracker, err := New(db, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	laptop := "laptop"
+	other := event(base+30*second, "https://other.example/", "navigate")
+	other.ClientID = &laptop
+	batches := [][]models.Event{
+		{event(base, "https://a.example/", "navigate")},
+		// Interleaved activity from another device must not cut a.example short.
+		{other},
+		{event(base+2*minute, "https://a.example/", "scroll")},
+	}
+	for _, batch := range batches {
+		if err := db.InsertEvents(batch); err != nil {
+			t.Fatalf("InsertEvents() error = %v", err)
+		}
+	}
+
+	buckets, err := tracker.TimeSpent(context.Background(), Query{Group: GroupDay, By: ByDomain})
+	if err != nil {
+		t.Fatalf("TimeSpent() error = %v", err)
+	}
+	got := byKey(buckets[0].Items)
+	if got["a.example"] != 2*minute {
+		t.Errorf("Expected 2 minutes on a.example, got %v", got)
+	}
+	if _, ok := got["other.example"]; ok {
+		t.Errorf("Expected no time for the open-ended laptop visit, got %v", got)
+	}
+}
+
+func TestRebuildMatchesIncremental(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tracker, err := New(db, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// The second batch arrives late and is skipped incrementally.
+	if err := db.InsertEvents([]models.Event{event(base, "https://a.example/", "navigate"), event(base+4*minute, "https://b.example/", "navigate")}); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+	if err := db.InsertEvents([]models.Event{event(base+2*minute, "https://c.example/", "navigate")}); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	if err := tracker.Rebuild(context.Background()); err != nil {
+		t.Fatalf("Rebuild() error = %v", err)
+	}
+	buckets, err := tracker.TimeSpent(context.Background(), Query{Group: GroupDay, By: ByDomain})
+	if err != nil {
+		t.Fatalf("TimeSpent() error = %v", err)
+	}
+	got := byKey(buckets[0].Items)
+	if got["a.example"] != 2*minute || got["c.example"] != 2*minute {
+		t.Errorf("Expected late event accounted for after rebuild, got %v", got)
+	}
+}
+
+func TestDwellTimeByCanonicalURL(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	normalizer, err := urlnorm.New(urlnorm.DefaultRules())
+	if err != nil {
+		t.Fatalf("urlnorm.New() error = %v", err)
+	}
+	db.SetURLNormalizer(normalizer.Normalize)
+	tracker, err := New(db, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	events := []models.Event{
+		event(base, "https://example.com/post?utm_source=mail", "navigate"),
+		event(base+minute, "https://example.com/post?fbclid=abc", "navigate"),
+		event(base+2*minute, "https://go.dev/", "navigate"),
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	for _, rebuild := range []bool{false, true} {
+		if rebuild {
+			if err := tracker.Rebuild(context.Background()); err != nil {
+				t.Fatalf("Rebuild() error = %v", err)
+			}
+		}
+		urls, err := tracker.TimeSpent(context.Background(), Query{Group: GroupDay, By: ByURL})
+		if err != nil {
+			t.Fatalf("TimeSpent() error = %v", err)
+		}
+		got := byKey(urls[0].Items)
+		if len(got) != 1 || got["https://example.com/post"] != 2*minute {
+			t.Errorf("Rebuild %v: expected variants merged under the canonical URL, got %v", rebuild, got)
+		}
+	}
+}
+
+func TestEncryptedRollups(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	tracker, err := New(db, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	// Rollups written before encryption is turned on are resealed with it.
+	if err := db.InsertEvents([]models.Event{
+		event(base, "https://go.dev/", "navigate"),
+		event(base+minute, "https://go.dev/doc", "navigate"),
+	}); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+	key, err := encryption.NewKey(make([]byte, encryption.SecretSize))
+	if err != nil {
+		t.Fatalf("NewKey() error = %v", err)
+	}
+	if _, err := db.Reencrypt(ctx, key); err != nil {
+		t.Fatalf("Reencrypt() error = %v", err)
+	}
+	if err := db.InsertEvents([]models.Event{event(base+3*minute, "https://go.dev/", "navigate")}); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	rows, err := db.DB().Query(`SELECT url, domain FROM dwell_daily_url UNION ALL SELECT last_url, '' FROM dwell_state`)
+	if err != nil {
+		t.Fatalf("Failed to query rollups: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var url, domain string
+		if err := rows.Scan(&url, &domain); err != nil {
+			t.Fatalf("Failed to scan rollup: %v", err)
+		}
+		if strings.Contains(url, "go.dev") || strings.Contains(domain, "go.dev") {
+			t.Errorf("Expected sealed rollup values, got %q and %q", url, domain)
+		}
+	}
+
+	urls, err := tracker.TimeSpent(ctx, Query{Group: GroupDay, By: ByURL})
+	if err != nil {
+		t.Fatalf("TimeSpent() error = %v", err)
+	}
+	got := byKey(urls[0].Items)
+	if got["https://go.dev/"] != minute || got["https://go.dev/doc"] != 2*minute {
+		t.Errorf("Expected decrypted per-URL time, got %v", got)
+	}
+
+	// A rebuild from encrypted events agrees.
+	if err := tracker.Rebuild(ctx); err != nil {
+		t.Fatalf("Rebuild() error = %v", err)
+	}
+	domains, err := tracker.TimeSpent(ctx, Query{Group: GroupDay, By: ByDomain})
+	if err != nil {
+		t.Fatalf("TimeSpent() error = %v", err)
+	}
+	if got := byKey(domains[0].Items); got["go.dev"] != 3*minute {
+		t.Errorf("Expected 3 minutes on go.dev, got %v", got)
+	}
+}
+
+func TestDeleteRemovesDwellTime(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tracker, err := New(db, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	events := []models.Event{
+		event(base, "https://go.dev/", "navigate"),
+		event(base+minute, "https://private.example/", "navigate"),
+		event(base+3*minute, "https://go.dev/", "navigate"),
+		event(base+4*minute, "https://go.dev/", "click"),
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	if _, err := db.DeleteEvents(context.Background(), database.EventFilter{URL: "https://private.example/"}); err != nil {
+		t.Fatalf("DeleteEvents() error = %v", err)
+	}
+	domains, err := tracker.TimeSpent(context.Background(), Query{Group: GroupDay, By: ByDomain})
+	if err != nil {
+		t.Fatalf("TimeSpent() error = %v", err)
+	}
+	got := byKey(domains[0].Items)
+	if _, ok := got["private.example"]; ok || got["go.dev"] != 4*minute {
+		t.Errorf("Expected the deleted page's time to go to go.dev, got %v", got)
+	}
+}
+
+func TestDwellTimeByLocalDay(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tracker, err := New(db, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// New Year's Eve in New York runs until 05:00 UTC.
+	newYork := "America/New_York"
+	late := event(base+4*time.Hour.Milliseconds(), "https://go.dev/", "navigate")
+	late.Timezone = &newYork
+	next := event(late.TSUTC+time.Minute.Milliseconds(), "https://go.dev/", "click")
+	next.Timezone = &newYork
+	if err := db.InsertEvents([]models.Event{late, next}); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	location, err := time.LoadLocation(newYork)
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+	eve := time.Date(2020, 12, 31, 0, 0, 0, 0, location)
+	tests := []struct {
+		name  string
+		query Query
+		want  int64
+	}{
+		{"local day", Query{Since: eve.UnixMilli(), Until: eve.AddDate(0, 0, 1).UnixMilli(), Location: location}, minute},
+		{"UTC day of the event", Query{Since: base, Until: base + 24*time.Hour.Milliseconds(), Location: time.UTC}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Group, tt.query.By = GroupDay, ByDomain
+			buckets, err := tracker.TimeSpent(context.Background(), tt.query)
+			if err != nil {
+				t.Fatalf("TimeSpent() error = %v", err)
+			}
+			var total int64
+			for _, bucket := range buckets {
+				total += bucket.TotalMS
+				if bucket.Period != "2020-12-31" {
+					t.Errorf("Expected the time on 2020-12-31, got %s", bucket.Period)
+				}
+			}
+			if total != tt.want {
+				t.Errorf("Expected %d ms, got %d", tt.want, total)
+			}
+		})
+	}
+}
+
+func TestRollupsMoveToLocalDays(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	newYork := "America/New_York"
+	events := []models.Event{event(base+time.Hour.Milliseconds(), "https://go.dev/", "navigate"), event(base+time.Hour.Milliseconds()+minute, "https://go.dev/", "click")}
+	for i := range events {
+		events[i].Timezone = &newYork
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+	// Rollups as kept before local days: by UTC day, without last_day.
+	_, err := db.DB().Exec(`
+	CREATE TABLE dwell_daily_url(day TEXT NOT NULL, url TEXT NOT NULL, domain TEXT NOT NULL, ms INTEGER NOT NULL, PRIMARY KEY (day, url));
+	CREATE TABLE dwell_daily_domain(day TEXT NOT NULL, domain TEXT NOT NULL, ms INTEGER NOT NULL, PRIMARY KEY (day, domain));
+	CREATE TABLE dwell_state(client_id TEXT NOT NULL, profile TEXT NOT NULL, last_ts INTEGER NOT NULL, last_url TEXT NOT NULL, PRIMARY KEY (client_id, profile));
+	INSERT INTO dwell_daily_domain VALUES('2021-01-01', 'go.dev', 60000);
+	INSERT INTO dwell_state VALUES('', '', 1609463280000, 'https://go.dev/');`)
+	if err != nil {
+		t.Fatalf("Failed to create old rollups: %v", err)
+	}
+
+	tracker, err := New(db, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	buckets, err := tracker.TimeSpent(context.Background(), Query{Group: GroupDay, By: ByDomain})
+	if err != nil {
+		t.Fatalf("TimeSpent() error = %v", err)
+	}
+	if len(buckets) != 1 || buckets[0].Period != "2020-12-31" || buckets[0].TotalMS != minute {
+		t.Errorf("Expected a minute on 2020-12-31, got %+v", buckets)
+	}
+}
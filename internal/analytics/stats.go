@@ -0,0 +1,160 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+type Grouping string
+
+const (
+	GroupDay   Grouping = "day"
+	GroupWeek  Grouping = "week" // ISO weeks, keyed by their Monday
+	GroupMonth Grouping = "month"
+)
+
+type Dimension string
+
+const (
+	ByDomain Dimension = "domain"
+	ByURL    Dimension = "url"
+)
+
+// DefaultLimit is how many items each bucket lists when Query.Limit is zero.
+const DefaultLimit = 10
+
+type Query struct {
+	Group Grouping
+	By    Dimension
+	Since int64 // with Until, selects the local days overlapping [Since, Until) (ms)
+	Until int64
+	Limit int // items per bucket
+	// Location is the time zone Since and Until are turned into days in;
+	// models.DefaultLocation when nil. Rollups are kept per local day of the user, so a range from
+	// local midnight to local midnight selects exactly those days.
+	Location *time.Location
+}
+
+type Bucket struct {
+	Period  string `json:"period"`
+	TotalMS int64  `json:"total_ms"`
+	Items   []Item `json:"items"`
+}
+
+type Item struct {
+	Key string `json:"key"`
+	MS  int64  `json:"ms"`
+}
+
+func ParseGrouping(value string) (Grouping, error) {
+	switch Grouping(value) {
+	case "", GroupDay:
+		return GroupDay, nil
+	case GroupWeek, GroupMonth:
+		return Grouping(value), nil
+	}
+	return "", fmt.Errorf("invalid grouping %q (want day, week or month)", value)
+}
+
+func ParseDimension(value string) (Dimension, error) {
+	switch Dimension(value) {
+	case "", ByDomain:
+		return ByDomain, nil
+	case ByURL:
+		return ByURL, nil
+	}
+	return "", fmt.Errorf("invalid dimension %q (want domain or url)", value)
+}
+
+// period maps a rollup day to the bucket it belongs to.
+func (g Grouping) period(day time.Time) string {
+	switch g {
+	case GroupWeek:
+		offset := (int(day.Weekday()) + 6) % 7 // days since Monday
+		return day.AddDate(0, 0, -offset).Format(dayLayout)
+	case GroupMonth:
+		return day.Format("2006-01")
+	}
+	return day.Format(dayLayout)
+}
+
+// TimeSpent returns time spent per period, with the top items of each.
+func (t *Tracker) TimeSpent(ctx context.Context, q Query) ([]Bucket, error) {
+	table, column := "dwell_daily_domain", "domain"
+	if q.By == ByURL {
+		table, column = "dwell_daily_url", "url"
+	}
+	query := `SELECT day, ` + column + `, ms FROM ` + table + ` WHERE 1 = 1`
+	var args []any
+	location := q.Location
+	if location == nil {
+		location = models.DefaultLocation
+	}
+	if q.Since > 0 {
+		query += ` AND day >= ?`
+		args = append(args, time.UnixMilli(q.Since).In(location).Format(dayLayout))
+	}
+	if q.Until > 0 {
+		query += ` AND day < ?`
+		args = append(args, time.UnixMilli(q.Until-1).In(location).AddDate(0, 0, 1).Format(dayLayout))
+	}
+
+	rows, err := t.db.DB().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rollups: %w", err)
+	}
+	defer rows.Close()
+
+	totals := make(map[string]map[string]int64)
+	for rows.Next() {
+		var day, key string
+		var ms int64
+		if err := rows.Scan(&day, &key, &ms); err != nil {
+			return nil, fmt.Errorf("failed to scan rollup: %w", err)
+		}
+		if key, err = t.db.Unseal(key); err != nil {
+			return nil, fmt.Errorf("failed to decrypt rollup: %w", err)
+		}
+		parsed, err := time.Parse(dayLayout, day)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rollup day %q: %w", day, err)
+		}
+		period := q.Group.period(parsed)
+		if totals[period] == nil {
+			totals[period] = make(map[string]int64)
+		}
+		totals[period][key] += ms
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rollups: %w", err)
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	buckets := make([]Bucket, 0, len(totals))
+	for period, byKey := range totals {
+		bucket := Bucket{Period: period, Items: make([]Item, 0, len(byKey))}
+		for key, ms := range byKey {
+			bucket.TotalMS += ms
+			bucket.Items = append(bucket.Items, Item{Key: key, MS: ms})
+		}
+		sort.Slice(bucket.Items, func(i, j int) bool {
+			if bucket.Items[i].MS != bucket.Items[j].MS {
+				return bucket.Items[i].MS > bucket.Items[j].MS
+			}
+			return bucket.Items[i].Key < bucket.Items[j].Key
+		})
+		if len(bucket.Items) > limit {
+			bucket.Items = bucket.Items[:limit]
+		}
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Period < buckets[j].Period })
+	return buckets, nil
+}
@@ -0,0 +1,77 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func TestTimeSpentGrouping(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tracker, err := New(db, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	day := 24 * 60 * minute
+	var events []models.Event
+	// One minute on a.example on Fri 1 Jan, Mon 4 Jan and Mon 1 Feb 2021.
+	for _, start := range []int64{base, base + 3*day, base + 31*day} {
+		events = append(events, event(start, "https://a.example/", "navigate"), event(start+minute, "https://a.example/", "click"))
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	tests := []struct {
+		group   Grouping
+		periods []string
+	}{
+		{GroupDay, []string{"2021-01-01", "2021-01-04", "2021-02-01"}},
+		{GroupWeek, []string{"2020-12-28", "2021-01-04", "2021-02-01"}},
+		{GroupMonth, []string{"2021-01", "2021-02"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.group), func(t *testing.T) {
+			buckets, err := tracker.TimeSpent(context.Background(), Query{Group: tt.group, By: ByDomain})
+			if err != nil {
+				t.Fatalf("TimeSpent() error = %v", err)
+			}
+			if len(buckets) != len(tt.periods) {
+				t.Fatalf("Expected %d buckets, got %+v", len(tt.periods), buckets)
+			}
+			for i, bucket := range buckets {
+				if bucket.Period != tt.periods[i] {
+					t.Errorf("Bucket %d: expected %s, got %s", i, tt.periods[i], bucket.Period)
+				}
+			}
+		})
+	}
+
+	ranged, err := tracker.TimeSpent(context.Background(), Query{Group: GroupDay, Since: base + 3*day, Until: base + 4*day})
+	if err != nil {
+		t.Fatalf("TimeSpent() error = %v", err)
+	}
+	if len(ranged) != 1 || ranged[0].Period != "2021-01-04" {
+		t.Errorf("Expected only 2021-01-04, got %+v", ranged)
+	}
+}
+
+func TestParseGroupingAndDimension(t *testing.T) {
+	if group, err := ParseGrouping(""); err != nil || group != GroupDay {
+		t.Errorf("Expected default day grouping, got %q, %v", group, err)
+	}
+	if _, err := ParseGrouping("year"); err == nil {
+		t.Error("Expected error for unsupported grouping")
+	}
+	if by, err := ParseDimension("url"); err != nil || by != ByURL {
+		t.Errorf("Expected url dimension, got %q, %v", by, err)
+	}
+	if _, err := ParseDimension("title"); err == nil {
+		t.Error("Expected error for unsupported dimension")
+	}
+}
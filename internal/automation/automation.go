@@ -0,0 +1,253 @@
+// Package automation turns recorded interactions back into browser automation
+// scripts. Navigations, clicks, focus changes and inputs become Playwright
+// (JavaScript) or chromedp (Go) steps, addressed by the CSS selectors the
+// extension records in Event.Data.
+package automation
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+type Target string
+
+const (
+	TargetPlaywright Target = "playwright"
+	TargetChromedp   Target = "chromedp"
+)
+
+func ParseTarget(value string) (Target, error) {
+	switch Target(strings.ToLower(value)) {
+	case "", TargetPlaywright, "js":
+		return TargetPlaywright, nil
+	case TargetChromedp, "go":
+		return TargetChromedp, nil
+	}
+	return "", fmt.Errorf("unsupported script target: %s", value)
+}
+
+// Extension is the file extension of scripts for the target.
+func (t Target) Extension() string {
+	if t == TargetChromedp {
+		return "go"
+	}
+	return "js"
+}
+
+type Options struct {
+	Target Target
+	// Parameterize turns every typed value into a parameter read from the
+	// environment, defaulting to the recorded value. Redacted values are
+	// always parameters, with a placeholder default.
+	Parameterize bool
+	// Description is written into the script's header comment.
+	Description string
+}
+
+// Parameter is a value the script reads from an environment variable.
+type Parameter struct {
+	Name     string // environment variable, e.g. EMAIL
+	Default  string
+	Redacted bool
+}
+
+type stepKind int
+
+const (
+	stepNavigate stepKind = iota
+	stepWaitForURL
+	stepClick
+	stepFocus
+	stepFill
+	stepComment
+)
+
+type step struct {
+	kind     stepKind
+	url      string
+	selector string
+	value    string     // literal value for stepFill, or the comment text
+	param    *Parameter // value source for stepFill, when parameterized
+}
+
+type script struct {
+	steps  []step
+	params []*Parameter
+}
+
+// navigationWindow is how soon after a click or input a navigation is taken
+// to be its result rather than a separate visit.
+const navigationWindow = 5000 // ms
+
+// Generate converts timestamp-ordered events into a script for opts.Target.
+func Generate(events []models.StoredEvent, opts Options) (string, error) {
+	s := buildScript(events, opts.Parameterize)
+	if len(s.steps) == 0 {
+		return "", fmt.Errorf("no replayable events (navigate, click, focus or input)")
+	}
+	if opts.Target == TargetChromedp {
+		return renderChromedp(s, opts.Description)
+	}
+	return renderPlaywright(s, opts.Description), nil
+}
+
+func buildScript(events []models.StoredEvent, parameterize bool) script {
+	var s script
+	names := make(map[string]int)
+	var currentURL string
+	var lastAction int64 = -navigationWindow - 1
+	var currentTab *int64
+
+	for _, event := range events {
+		if event.TabID != nil && currentTab != nil && *event.TabID != *currentTab {
+			s.steps = append(s.steps, step{kind: stepComment, value: fmt.Sprintf("recorded in another tab (%d)", *event.TabID)})
+		}
+		if event.TabID != nil {
+			currentTab = event.TabID
+		}
+
+		switch event.Type {
+		case "navigate":
+			if event.URL == currentURL {
+				continue
+			}
+			if currentURL != "" && event.TSUTC-lastAction <= navigationWindow {
+				s.steps = append(s.steps, step{kind: stepWaitForURL, url: event.URL})
+			} else {
+				s.steps = append(s.steps, step{kind: stepNavigate, url: event.URL})
+			}
+			currentURL = event.URL
+
+		case "click", "focus", "input":
+			if currentURL == "" {
+				// The recording starts mid-page; open it first.
+				s.steps = append(s.steps, step{kind: stepNavigate, url: event.URL})
+				currentURL = event.URL
+			}
+			selector := lookup(event.Data, "selector")
+			if selector == "" {
+				s.steps = append(s.steps, step{kind: stepComment, value: event.Type + " without a recorded selector"})
+				continue
+			}
+			lastAction = event.TSUTC
+			switch event.Type {
+			case "click":
+				s.steps = append(s.steps, step{kind: stepClick, selector: selector})
+			case "focus":
+				s.steps = append(s.steps, step{kind: stepFocus, selector: selector})
+			case "input":
+				s.addInput(event, selector, parameterize, names)
+			}
+		}
+	}
+	s.dropRedundantFocus()
+	return s
+}
+
+// addInput records a fill step. Inputs arrive per keystroke, so consecutive
+// inputs into the same field collapse into one step with the final value.
+func (s *script) addInput(event models.StoredEvent, selector string, parameterize bool, names map[string]int) {
+	value := lookup(event.Data, "value")
+	redacted, _ := event.Data["redacted"].(bool)
+
+	if last := len(s.steps) - 1; last >= 0 && s.steps[last].kind == stepFill && s.steps[last].selector == selector {
+		previous := &s.steps[last]
+		if previous.param != nil {
+			if redacted {
+				previous.param.Redacted = true
+				previous.param.Default = placeholder(previous.param.Name)
+			} else if !previous.param.Redacted {
+				previous.param.Default = value
+			}
+		} else {
+			previous.value = value
+		}
+		return
+	}
+
+	fill := step{kind: stepFill, selector: selector, value: value}
+	if parameterize || redacted {
+		name := parameterName(firstNonEmpty(lookup(event.Data, "field"), lookup(event.Data, "name"), selector), names)
+		param := &Parameter{Name: name, Default: value, Redacted: redacted}
+		if redacted {
+			param.Default = placeholder(name)
+		}
+		fill.param = param
+		s.params = append(s.params, param)
+	}
+	s.steps = append(s.steps, fill)
+}
+
+// dropRedundantFocus removes focus steps directly followed by a click or
+// fill on the same element, which focus it anyway.
+func (s *script) dropRedundantFocus() {
+	steps := s.steps[:0]
+	for i, current := range s.steps {
+		if current.kind == stepFocus && i+1 < len(s.steps) {
+			next := s.steps[i+1]
+			if (next.kind == stepClick || next.kind == stepFill) && next.selector == current.selector {
+				continue
+			}
+		}
+		steps = append(steps, current)
+	}
+	s.steps = steps
+}
+
+// lookup reads a string from data or from its "target" object, the two
+// shapes the extension uses.
+func lookup(data map[string]any, key string) string {
+	if value, ok := data[key].(string); ok && value != "" {
+		return value
+	}
+	if target, ok := data["target"].(map[string]any); ok {
+		if value, ok := target[key].(string); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// parameterName derives an environment variable name from a field name or
+// selector ("input[name=email]" → INPUT_NAME_EMAIL), unique within a script.
+func parameterName(source string, names map[string]int) string {
+	var builder strings.Builder
+	underscore := false
+	for _, r := range source {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if underscore && builder.Len() > 0 {
+				builder.WriteByte('_')
+			}
+			builder.WriteRune(unicode.ToUpper(r))
+			underscore = false
+		} else {
+			underscore = true
+		}
+	}
+	name := builder.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "VALUE_" + name
+		name = strings.TrimSuffix(name, "_")
+	}
+	names[name]++
+	if count := names[name]; count > 1 {
+		name = fmt.Sprintf("%s_%d", name, count)
+	}
+	return name
+}
+
+func placeholder(name string) string {
+	return "<" + name + ">"
+}
@@ -0,0 +1,121 @@
+package automation
+
+import (
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// 2021-01-01T00:00:00Z
+const base = int64(1609459200000)
+
+func stored(seconds int64, url, eventType string, data map[string]any) models.StoredEvent {
+	if data == nil {
+		data = map[string]any{}
+	}
+	ts := base + seconds*1000
+	return models.StoredEvent{ID: seconds, Event: models.Event{TSUTC: ts, TSISO: models.FormatTimestamp(ts), URL: url, Type: eventType, Data: data}}
+}
+
+func loginEvents() []models.StoredEvent {
+	return []models.StoredEvent{
+		stored(0, "https://app.example/login", "navigate", nil),
+		stored(1, "https://app.example/login", "scroll", nil),
+		stored(2, "https://app.example/login", "focus", map[string]any{"selector": "#email"}),
+		stored(3, "https://app.example/login", "input", map[string]any{"selector": "#email", "field": "email", "value": "m"}),
+		stored(4, "https://app.example/login", "input", map[string]any{"selector": "#email", "field": "email", "value": "me@example.com"}),
+		stored(5, "https://app.example/login", "input", map[string]any{"target": map[string]any{"selector": "#password", "name": "password"}, "redacted": true}),
+		stored(6, "https://app.example/login", "click", map[string]any{"target": map[string]any{"selector": "button[type=submit]"}}),
+		stored(7, "https://app.example/home", "navigate", nil),
+		stored(60, "https://app.example/settings", "navigate", nil),
+		stored(61, "https://app.example/settings", "click", nil),
+	}
+}
+
+func TestBuildScriptSteps(t *testing.T) {
+	s := buildScript(loginEvents(), false)
+
+	want := []struct {
+		kind     stepKind
+		target   string
+		value    string
+		hasParam bool
+	}{
+		{stepNavigate, "https://app.example/login", "", false},
+		{stepFill, "#email", "me@example.com", false},
+		{stepFill, "#password", "", true},
+		{stepClick, "button[type=submit]", "", false},
+		{stepWaitForURL, "https://app.example/home", "", false},
+		{stepNavigate, "https://app.example/settings", "", false},
+		{stepComment, "", "click without a recorded selector", false},
+	}
+	if len(s.steps) != len(want) {
+		t.Fatalf("Expected %d steps, got %d: %+v", len(want), len(s.steps), s.steps)
+	}
+	for i, w := range want {
+		got := s.steps[i]
+		target := got.selector
+		if got.kind == stepNavigate || got.kind == stepWaitForURL {
+			target = got.url
+		}
+		if got.kind != w.kind || target != w.target || got.value != w.value || (got.param != nil) != w.hasParam {
+			t.Errorf("Step %d: expected %+v, got %+v", i, w, got)
+		}
+	}
+
+	// Only the redacted password is a parameter without -parameterize.
+	if len(s.params) != 1 || s.params[0].Name != "PASSWORD" || !s.params[0].Redacted || s.params[0].Default != "<PASSWORD>" {
+		t.Errorf("Unexpected parameters: %+v", s.params)
+	}
+}
+
+func TestBuildScriptParameterized(t *testing.T) {
+	s := buildScript(loginEvents(), true)
+	if len(s.params) != 2 {
+		t.Fatalf("Expected 2 parameters, got %+v", s.params)
+	}
+	if s.params[0].Name != "EMAIL" || s.params[0].Default != "me@example.com" || s.params[0].Redacted {
+		t.Errorf("Expected the final typed email as default, got %+v", s.params[0])
+	}
+}
+
+func TestBuildScriptStartsMidPage(t *testing.T) {
+	s := buildScript([]models.StoredEvent{stored(0, "https://a.example/", "click", map[string]any{"selector": "a"})}, false)
+	if len(s.steps) != 2 || s.steps[0].kind != stepNavigate || s.steps[0].url != "https://a.example/" {
+		t.Errorf("Expected the page opened before the click, got %+v", s.steps)
+	}
+}
+
+func TestParameterName(t *testing.T) {
+	names := make(map[string]int)
+	tests := []struct{ source, want string }{
+		{"email", "EMAIL"},
+		{"input[name=email]", "INPUT_NAME_EMAIL"},
+		{"email", "EMAIL_2"},
+		{"#1st-line", "VALUE_1ST_LINE"},
+		{"###", "VALUE"},
+	}
+	for _, tt := range tests {
+		if got := parameterName(tt.source, names); got != tt.want {
+			t.Errorf("parameterName(%q) = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateWithoutReplayableEvents(t *testing.T) {
+	events := []models.StoredEvent{stored(0, "https://a.example/", "visible_text", map[string]any{"text": "hi"})}
+	if _, err := Generate(events, Options{}); err == nil {
+		t.Error("Expected an error without replayable events")
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	for input, want := range map[string]Target{"": TargetPlaywright, "js": TargetPlaywright, "chromedp": TargetChromedp, "Go": TargetChromedp} {
+		if got, err := ParseTarget(input); err != nil || got != want {
+			t.Errorf("ParseTarget(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseTarget("selenium"); err == nil {
+		t.Error("Expected error for unsupported target")
+	}
+}
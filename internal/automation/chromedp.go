@@ -0,0 +1,102 @@
+package automation
+
+import (
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+)
+
+// reservedIdentifiers would clash with Go keywords or the script's own names.
+var reservedIdentifiers = map[string]bool{
+	"ctx": true, "cancel": true, "err": true, "param": true,
+	"break": true, "case": true, "chan": true, "const": true, "continue": true,
+	"default": true, "defer": true, "else": true, "fallthrough": true, "for": true,
+	"func": true, "go": true, "goto": true, "if": true, "import": true,
+	"interface": true, "map": true, "package": true, "range": true, "return": true,
+	"select": true, "struct": true, "switch": true, "type": true, "var": true,
+}
+
+// goIdentifier turns a parameter name into a local variable name
+// (INPUT_NAME_EMAIL → inputNameEmail).
+func goIdentifier(name string) string {
+	var b strings.Builder
+	for i, part := range strings.Split(strings.ToLower(name), "_") {
+		if part == "" {
+			continue
+		}
+		if i > 0 {
+			part = strings.ToUpper(part[:1]) + part[1:]
+		}
+		b.WriteString(part)
+	}
+	if identifier := b.String(); !reservedIdentifiers[identifier] {
+		return identifier
+	}
+	return b.String() + "Value"
+}
+
+func renderChromedp(s script, description string) (string, error) {
+	var b strings.Builder
+	b.WriteString("// Generated by browsetrace-agent")
+	if description != "" {
+		b.WriteString(" from " + description)
+	}
+	b.WriteString(".\n// Run with: go run script.go (requires github.com/chromedp/chromedp)\n")
+	b.WriteString("package main\n\nimport (\n\t\"context\"\n\t\"log\"\n")
+	if len(s.params) > 0 {
+		b.WriteString("\t\"os\"\n")
+	}
+	b.WriteString("\n\t\"github.com/chromedp/chromedp\"\n)\n\n")
+
+	if len(s.params) > 0 {
+		b.WriteString("// param reads an environment variable, falling back to the recorded value.\n")
+		b.WriteString("func param(name, fallback string) string {\n")
+		b.WriteString("\tif value, ok := os.LookupEnv(name); ok {\n\t\treturn value\n\t}\n\treturn fallback\n}\n\n")
+	}
+
+	b.WriteString("func main() {\n")
+	b.WriteString("\tctx, cancel := chromedp.NewContext(context.Background())\n\tdefer cancel()\n\n")
+	for _, param := range s.params {
+		fmt.Fprintf(&b, "\t%s := param(%s, %s)", goIdentifier(param.Name), strconv.Quote(param.Name), strconv.Quote(param.Default))
+		if param.Redacted {
+			b.WriteString(" // redacted when recorded; must be supplied")
+		}
+		b.WriteString("\n")
+	}
+	if len(s.params) > 0 {
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\terr := chromedp.Run(ctx,\n")
+	for _, st := range s.steps {
+		b.WriteString("\t\t")
+		switch st.kind {
+		case stepNavigate:
+			fmt.Fprintf(&b, "chromedp.Navigate(%s),", strconv.Quote(st.url))
+		case stepWaitForURL:
+			fmt.Fprintf(&b, "// The previous action navigates to %s.", strconv.Quote(st.url))
+		case stepClick:
+			fmt.Fprintf(&b, "chromedp.Click(%s, chromedp.ByQuery),", strconv.Quote(st.selector))
+		case stepFocus:
+			fmt.Fprintf(&b, "chromedp.Focus(%s, chromedp.ByQuery),", strconv.Quote(st.selector))
+		case stepFill:
+			value := strconv.Quote(st.value)
+			if st.param != nil {
+				value = goIdentifier(st.param.Name)
+			}
+			selector := strconv.Quote(st.selector)
+			fmt.Fprintf(&b, "chromedp.Clear(%s, chromedp.ByQuery),\n\t\tchromedp.SendKeys(%s, %s, chromedp.ByQuery),", selector, selector, value)
+		case stepComment:
+			b.WriteString("// " + st.value)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\t)\n\tif err != nil {\n\t\tlog.Fatal(err)\n\t}\n}\n")
+
+	source, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return "", fmt.Errorf("failed to format chromedp script: %w", err)
+	}
+	return string(source), nil
+}
@@ -0,0 +1,57 @@
+package automation
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func TestGenerateChromedp(t *testing.T) {
+	script, err := Generate(loginEvents(), Options{Target: TargetChromedp})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "script.go", script, 0); err != nil {
+		t.Fatalf("Generated script does not parse: %v\n%s", err, script)
+	}
+	for _, want := range []string{
+		"package main",
+		`"os"`,
+		`password := param("PASSWORD", "<PASSWORD>") // redacted when recorded; must be supplied`,
+		`chromedp.Navigate("https://app.example/login"),`,
+		`chromedp.Clear("#email", chromedp.ByQuery),`,
+		`chromedp.SendKeys("#email", "me@example.com", chromedp.ByQuery),`,
+		`chromedp.SendKeys("#password", password, chromedp.ByQuery),`,
+		`chromedp.Click("button[type=submit]", chromedp.ByQuery),`,
+		`// The previous action navigates to "https://app.example/home".`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected %q in script:\n%s", want, script)
+		}
+	}
+}
+
+func TestGenerateChromedpWithoutParameters(t *testing.T) {
+	events := []models.StoredEvent{
+		stored(0, "https://a.example/", "navigate", nil),
+		stored(1, "https://a.example/", "input", map[string]any{"selector": "#err", "field": "err", "value": "x"}),
+	}
+	script, err := Generate(events, Options{Target: TargetChromedp})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if strings.Contains(script, `"os"`) || strings.Contains(script, "func param") {
+		t.Errorf("Expected no parameter helper:\n%s", script)
+	}
+
+	parameterized, err := Generate(events, Options{Target: TargetChromedp, Parameterize: true})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if !strings.Contains(parameterized, `errValue := param("ERR", "x")`) {
+		t.Errorf("Expected reserved names to be avoided:\n%s", parameterized)
+	}
+}
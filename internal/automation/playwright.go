@@ -0,0 +1,68 @@
+package automation
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// jsString quotes a value as a JavaScript string literal. JSON string syntax
+// is a subset of it, and encoding/json escapes U+2028 and U+2029.
+func jsString(value string) string {
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func renderPlaywright(s script, description string) string {
+	var b strings.Builder
+	b.WriteString("// Generated by browsetrace-agent")
+	if description != "" {
+		b.WriteString(" from " + description)
+	}
+	b.WriteString(".\n// Run with: node script.js (requires `npm install playwright`)\n")
+	b.WriteString("const { chromium } = require('playwright');\n\n")
+
+	if len(s.params) > 0 {
+		b.WriteString("// Override with environment variables.\nconst params = {\n")
+		for _, param := range s.params {
+			fmt.Fprintf(&b, "  %s: process.env.%s ?? %s,", param.Name, param.Name, jsString(param.Default))
+			if param.Redacted {
+				b.WriteString(" // redacted when recorded; must be supplied")
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("};\n\n")
+	}
+
+	b.WriteString("(async () => {\n")
+	b.WriteString("  const browser = await chromium.launch({ headless: false });\n")
+	b.WriteString("  const page = await browser.newPage();\n")
+	for _, st := range s.steps {
+		b.WriteString("  ")
+		switch st.kind {
+		case stepNavigate:
+			fmt.Fprintf(&b, "await page.goto(%s);", jsString(st.url))
+		case stepWaitForURL:
+			fmt.Fprintf(&b, "await page.waitForURL(%s);", jsString(st.url))
+		case stepClick:
+			fmt.Fprintf(&b, "await page.click(%s);", jsString(st.selector))
+		case stepFocus:
+			fmt.Fprintf(&b, "await page.focus(%s);", jsString(st.selector))
+		case stepFill:
+			value := jsString(st.value)
+			if st.param != nil {
+				value = "params." + st.param.Name
+			}
+			fmt.Fprintf(&b, "await page.fill(%s, %s);", jsString(st.selector), value)
+		case stepComment:
+			b.WriteString("// " + st.value)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("  await browser.close();\n")
+	b.WriteString("})();\n")
+	return b.String()
+}
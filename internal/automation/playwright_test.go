@@ -0,0 +1,45 @@
+package automation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratePlaywright(t *testing.T) {
+	script, err := Generate(loginEvents(), Options{Target: TargetPlaywright, Parameterize: true, Description: "session 4"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	for _, want := range []string{
+		"// Generated by browsetrace-agent from session 4.",
+		"const { chromium } = require('playwright');",
+		`  EMAIL: process.env.EMAIL ?? "me@example.com",`,
+		`  PASSWORD: process.env.PASSWORD ?? "<PASSWORD>", // redacted when recorded; must be supplied`,
+		`  await page.goto("https://app.example/login");`,
+		`  await page.fill("#email", params.EMAIL);`,
+		`  await page.fill("#password", params.PASSWORD);`,
+		`  await page.click("button[type=submit]");`,
+		`  await page.waitForURL("https://app.example/home");`,
+		`  // click without a recorded selector`,
+		"  await browser.close();",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected %q in script:\n%s", want, script)
+		}
+	}
+}
+
+func TestGeneratePlaywrightQuotesValues(t *testing.T) {
+	events := loginEvents()[:1]
+	events = append(events, stored(1, "https://app.example/login", "input", map[string]any{"selector": `input[name="q"]`, "value": "a\"b\u2028c"}))
+	script, err := Generate(events, Options{Target: TargetPlaywright})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if !strings.Contains(script, `await page.fill("input[name=\"q\"]", "a\"b\u2028c");`) {
+		t.Errorf("Expected escaped literals:\n%s", script)
+	}
+	if strings.Contains(script, "params") {
+		t.Errorf("Expected no params block without parameters:\n%s", script)
+	}
+}
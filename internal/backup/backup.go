@@ -0,0 +1,164 @@
+// Package backup takes consistent snapshots of the events database while the
+// agent keeps writing to it, rotates them, and restores them.
+//
+// Snapshots are made with VACUUM INTO, which reads the database in a single
+// transaction and writes a compact copy, so a backup never sees half a batch
+// and never blocks inserts for longer than SQLite's own page reads. An
+// encrypted database stays encrypted in its backups and needs the same key
+// after a restore.
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+)
+
+// Create writes a snapshot of db to path, which must not exist yet. The
+// snapshot is written under a temporary name and verified before it is
+// renamed into place, so path only ever holds a complete backup.
+func Create(ctx context.Context, db *database.Database, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup %s already exists", path)
+	}
+	temporary := path + ".partial"
+	if err := os.Remove(temporary); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale partial backup: %w", err)
+	}
+	if _, err := db.DB().ExecContext(ctx, `VACUUM INTO ?`, temporary); err != nil {
+		os.Remove(temporary)
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	if err := Verify(ctx, temporary); err != nil {
+		os.Remove(temporary)
+		return err
+	}
+	if err := os.Rename(temporary, path); err != nil {
+		os.Remove(temporary)
+		return fmt.Errorf("failed to move backup into place: %w", err)
+	}
+	return nil
+}
+
+// Verify checks that path is an intact SQLite database holding an events
+// table, using PRAGMA integrity_check.
+func Verify(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	// mode=ro keeps a damaged file from being "repaired" or a missing one
+	// from being created.
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, `PRAGMA integrity_check`)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", path, err)
+	}
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to check %s: %w", path, err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check %s: %w", path, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s failed the integrity check: %s", path, strings.Join(problems, "; "))
+	}
+	var events int64
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM events`).Scan(&events); err != nil {
+		return fmt.Errorf("%s is not an events database: %w", path, err)
+	}
+	return nil
+}
+
+// walFiles are the files SQLite keeps next to a database in WAL mode. They
+// belong to that exact database file and must move with it.
+var walFiles = []string{"", "-wal", "-shm"}
+
+// Restore replaces the database at databasePath with the backup at
+// backupPath. The agent must not be running. The backup is verified before
+// anything is touched and the restored database after; the database it
+// replaces is kept as databasePath.pre-restore, and put back if the restored
+// copy fails verification.
+func Restore(ctx context.Context, backupPath, databasePath string) error {
+	if err := Verify(ctx, backupPath); err != nil {
+		return err
+	}
+	temporary := databasePath + ".restoring"
+	if err := copyFile(backupPath, temporary); err != nil {
+		return err
+	}
+	defer os.Remove(temporary)
+	if err := Verify(ctx, temporary); err != nil {
+		return err
+	}
+
+	previous := databasePath + ".pre-restore"
+	for _, suffix := range walFiles {
+		if err := os.Remove(previous + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove old pre-restore copy: %w", err)
+		}
+		if err := os.Rename(databasePath+suffix, previous+suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to set the current database aside: %w", err)
+		}
+	}
+	if err := os.Rename(temporary, databasePath); err != nil {
+		putBack(previous, databasePath)
+		return fmt.Errorf("failed to move restored database into place: %w", err)
+	}
+	if err := Verify(ctx, databasePath); err != nil {
+		putBack(previous, databasePath)
+		return fmt.Errorf("restored database failed verification, kept the previous one: %w", err)
+	}
+	return nil
+}
+
+// putBack undoes setting the previous database aside.
+func putBack(previous, databasePath string) {
+	for _, suffix := range walFiles {
+		os.Rename(previous+suffix, databasePath+suffix)
+	}
+}
+
+func copyFile(source, destination string) error {
+	input, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	defer input.Close()
+	output, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+	if _, err := io.Copy(output, input); err != nil {
+		output.Close()
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+	// The copy must be on disk before it replaces the live database.
+	if err := output.Sync(); err != nil {
+		output.Close()
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+	if err := output.Close(); err != nil {
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func setupTestDB(t *testing.T) (*database.Database, string, func()) {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "browsetrace-backup-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	db, err := database.NewDatabase(filepath.Join(tmpDir, "events.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+	return db, tmpDir, cleanup
+}
+
+func navigation(ts int64) models.Event {
+	return models.Event{TSUTC: ts, TSISO: models.FormatTimestamp(ts), URL: "https://example.com/", Type: "navigate", Data: map[string]any{"n": ts}}
+}
+
+// countEvents returns the number of events in a backup and the highest ID.
+func countEvents(t *testing.T, path string) (int64, int64) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	defer db.Close()
+	var count, maxID int64
+	if err := db.QueryRow(`SELECT COUNT(*), COALESCE(MAX(id), 0) FROM events`).Scan(&count, &maxID); err != nil {
+		t.Fatalf("Failed to count events: %v", err)
+	}
+	return count, maxID
+}
+
+func TestCreateDuringWrites(t *testing.T) {
+	db, directory, cleanup := setupTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Batches of ten events, so that a torn snapshot would show up as a
+	// count that is not a multiple of ten.
+	var written atomic.Int64
+	stop := make(chan struct{})
+	var writer sync.WaitGroup
+	writer.Add(1)
+	go func() {
+		defer writer.Done()
+		for batch := int64(1); ; batch++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			events := make([]models.Event, 10)
+			for i := range events {
+				events[i] = navigation(batch*100 + int64(i))
+			}
+			if err := db.InsertEvents(events); err != nil {
+				t.Errorf("InsertEvents() error = %v", err)
+				return
+			}
+			written.Add(10)
+		}
+	}()
+
+	var previous int64
+	for i := 0; i < 5; i++ {
+		path := filepath.Join(directory, FileName(timeAt(int64(i))))
+		before := written.Load()
+		if err := Create(ctx, db, path); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		after := written.Load()
+
+		count, maxID := countEvents(t, path)
+		if count%10 != 0 || count != maxID {
+			t.Errorf("Backup %d is not a consistent snapshot: %d events, max id %d", i, count, maxID)
+		}
+		if count < before || count > after+10 || count < previous {
+			t.Errorf("Backup %d has %d events, expected between %d and %d", i, count, before, after+10)
+		}
+		previous = count
+		if _, err := os.Stat(path + ".partial"); !os.IsNotExist(err) {
+			t.Errorf("Expected no partial file to be left behind, got %v", err)
+		}
+	}
+	close(stop)
+	writer.Wait()
+
+	if err := Create(ctx, db, filepath.Join(directory, FileName(timeAt(0)))); err == nil {
+		t.Error("Expected an error when the backup already exists")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	db, directory, cleanup := setupTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	if err := db.InsertEvents([]models.Event{navigation(1000)}); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+	path := filepath.Join(directory, "backup.db")
+	if err := Create(ctx, db, path); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := Verify(ctx, path); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+
+	if err := Verify(ctx, filepath.Join(directory, "missing.db")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+	if _, err := os.Stat(filepath.Join(directory, "missing.db")); !os.IsNotExist(err) {
+		t.Error("Expected Verify not to create a missing file")
+	}
+
+	garbage := filepath.Join(directory, "garbage.db")
+	if err := os.WriteFile(garbage, []byte("not a database at all, just some text padding it out"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := Verify(ctx, garbage); err == nil {
+		t.Error("Expected an error for a file that is not a database")
+	}
+
+	// Keep the first page, with the header and schema, and wreck the rest.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read backup: %v", err)
+	}
+	for i := 4096; i < len(data); i++ {
+		data[i] = 0xff
+	}
+	corrupt := filepath.Join(directory, "corrupt.db")
+	if err := os.WriteFile(corrupt, data, 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := Verify(ctx, corrupt); err == nil {
+		t.Error("Expected an error for a corrupted database")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	directory, err := os.MkdirTemp("", "browsetrace-backup-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(directory)
+	ctx := context.Background()
+	databasePath := filepath.Join(directory, "events.db")
+	backupPath := filepath.Join(directory, "backup.db")
+
+	db, err := database.NewDatabase(databasePath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if err := db.InsertEvents([]models.Event{navigation(1000), navigation(2000)}); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+	if err := Create(ctx, db, backupPath); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := db.InsertEvents([]models.Event{navigation(3000)}); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+	db.Close()
+
+	if err := Restore(ctx, backupPath, databasePath); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if count, _ := countEvents(t, databasePath); count != 2 {
+		t.Errorf("Expected 2 events after restore, got %d", count)
+	}
+	if count, _ := countEvents(t, databasePath+".pre-restore"); count != 3 {
+		t.Errorf("Expected the replaced database to be kept with 3 events, got %d", count)
+	}
+
+	// The restored file opens normally.
+	db, err = database.NewDatabase(databasePath)
+	if err != nil {
+		t.Fatalf("Failed to open restored database: %v", err)
+	}
+	events, err := db.QueryEvents(ctx, database.EventFilter{})
+	db.Close()
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("Expected 2 events, got %d", len(events))
+	}
+
+	// A bad backup leaves the database alone.
+	if err := os.WriteFile(backupPath, []byte("corrupt"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := Restore(ctx, backupPath, databasePath); err == nil {
+		t.Error("Expected an error restoring a corrupt backup")
+	}
+	if count, _ := countEvents(t, databasePath); count != 2 {
+		t.Errorf("Expected the database to be untouched, got %d events", count)
+	}
+}
@@ -0,0 +1,202 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+)
+
+// Backups in a directory are named after the UTC time they were taken, which
+// is all rotation needs to know about them.
+const (
+	namePrefix = "events-"
+	nameLayout = "20060102T150405.000Z"
+	nameSuffix = ".db"
+)
+
+// Policy says which backups rotation keeps: the newest backup of each of the
+// last Daily days that have one, and likewise for the last Weekly ISO weeks.
+// The newest backup is always kept.
+type Policy struct {
+	Daily  int
+	Weekly int
+}
+
+var DefaultPolicy = Policy{Daily: 7, Weekly: 4}
+
+type Backup struct {
+	Name       string `json:"name"`
+	Path       string `json:"-"`
+	TakenTSUTC int64  `json:"taken_ts_utc"`
+	Size       int64  `json:"size"`
+}
+
+// FileName returns the name of a backup taken at at.
+func FileName(at time.Time) string {
+	return namePrefix + at.UTC().Format(nameLayout) + nameSuffix
+}
+
+// List returns the backups in directory, newest first. Other files are
+// ignored.
+func List(directory string) ([]Backup, error) {
+	entries, err := os.ReadDir(directory)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Backup{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	backups := []Backup{}
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), namePrefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		stamp, ok = strings.CutSuffix(stamp, nameSuffix)
+		taken, err := time.Parse(nameLayout, stamp)
+		if !ok || err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue // rotated away meanwhile
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat backup: %w", err)
+		}
+		backups = append(backups, Backup{
+			Name:       entry.Name(),
+			Path:       filepath.Join(directory, entry.Name()),
+			TakenTSUTC: taken.UnixMilli(),
+			Size:       info.Size(),
+		})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].TakenTSUTC > backups[j].TakenTSUTC })
+	return backups, nil
+}
+
+// Rotate deletes the backups in directory that policy does not keep and
+// returns them.
+func Rotate(directory string, policy Policy) ([]Backup, error) {
+	backups, err := List(directory)
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[string]bool)
+	if len(backups) > 0 {
+		keep[backups[0].Name] = true
+	}
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	for _, backup := range backups { // newest first
+		taken := time.UnixMilli(backup.TakenTSUTC).UTC()
+		day := taken.Format(time.DateOnly)
+		if !days[day] && len(days) < policy.Daily {
+			days[day] = true
+			keep[backup.Name] = true
+		}
+		year, number := taken.ISOWeek()
+		week := fmt.Sprintf("%d-W%02d", year, number)
+		if !weeks[week] && len(weeks) < policy.Weekly {
+			weeks[week] = true
+			keep[backup.Name] = true
+		}
+	}
+
+	removed := []Backup{}
+	for _, backup := range backups {
+		if keep[backup.Name] {
+			continue
+		}
+		if err := os.Remove(backup.Path); err != nil {
+			return removed, fmt.Errorf("failed to remove backup %s: %w", backup.Name, err)
+		}
+		removed = append(removed, backup)
+	}
+	return removed, nil
+}
+
+// Manager takes rotated backups of a database into a directory, on demand
+// and on a schedule.
+type Manager struct {
+	db        *database.Database
+	directory string
+	policy    Policy
+	interval  time.Duration
+	mu        sync.Mutex // one backup at a time
+	now       func() time.Time
+}
+
+// NewManager returns a manager backing db up into directory. Run takes a
+// backup every interval; zero disables the schedule.
+func NewManager(db *database.Database, directory string, policy Policy, interval time.Duration) *Manager {
+	return &Manager{db: db, directory: directory, policy: policy, interval: interval, now: time.Now}
+}
+
+// Backup takes a backup now and rotates old ones.
+func (m *Manager) Backup(ctx context.Context) (Backup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(m.directory, 0o700); err != nil {
+		return Backup{}, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	taken := m.now().UTC().Truncate(time.Millisecond)
+	path := filepath.Join(m.directory, FileName(taken))
+	if err := Create(ctx, m.db, path); err != nil {
+		return Backup{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Backup{}, fmt.Errorf("failed to stat backup: %w", err)
+	}
+	if _, err := Rotate(m.directory, m.policy); err != nil {
+		return Backup{}, err
+	}
+	return Backup{Name: filepath.Base(path), Path: path, TakenTSUTC: taken.UnixMilli(), Size: info.Size()}, nil
+}
+
+// List returns the manager's backups, newest first.
+func (m *Manager) List() ([]Backup, error) {
+	return List(m.directory)
+}
+
+// Run takes a backup every interval until ctx is done. The first one is taken
+// right away if the newest existing backup is older than the interval.
+func (m *Manager) Run(ctx context.Context) {
+	if m.interval <= 0 {
+		return
+	}
+	wait := time.Duration(0)
+	if backups, err := m.List(); err == nil && len(backups) > 0 {
+		age := m.now().Sub(time.UnixMilli(backups[0].TakenTSUTC))
+		wait = max(m.interval-age, 0)
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		backup, err := m.Backup(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Scheduled backup failed: %v", err)
+			}
+		} else {
+			log.Printf("Backed up database to %s (%d bytes)", backup.Path, backup.Size)
+		}
+		timer.Reset(m.interval)
+	}
+}
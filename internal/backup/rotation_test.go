@@ -0,0 +1,161 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 2021-01-04T12:00:00Z, a Monday.
+var start = time.Date(2021, 1, 4, 12, 0, 0, 0, time.UTC)
+
+func timeAt(hours int64) time.Time {
+	return start.Add(time.Duration(hours) * time.Hour)
+}
+
+func TestListIgnoresOtherFiles(t *testing.T) {
+	directory, err := os.MkdirTemp("", "browsetrace-backup-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(directory)
+
+	for _, name := range []string{FileName(timeAt(0)), FileName(timeAt(5)), "events-garbage.db", "notes.txt", FileName(timeAt(9)) + ".partial"} {
+		if err := os.WriteFile(filepath.Join(directory, name), []byte("x"), 0o600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	backups, err := List(directory)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups, got %d", len(backups))
+	}
+	if backups[0].TakenTSUTC != timeAt(5).UnixMilli() {
+		t.Errorf("Expected newest first, got %+v", backups)
+	}
+
+	if backups, err := List(filepath.Join(directory, "missing")); err != nil || len(backups) != 0 {
+		t.Errorf("Expected no backups in a missing directory, got %v, %v", backups, err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	directory, err := os.MkdirTemp("", "browsetrace-backup-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(directory)
+
+	// Two backups a day for three weeks, up to a Sunday noon.
+	for hour := int64(0); hour <= 20*24; hour += 12 {
+		if err := os.WriteFile(filepath.Join(directory, FileName(timeAt(hour))), []byte("x"), 0o600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	removed, err := Rotate(directory, Policy{Daily: 3, Weekly: 2})
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	backups, err := List(directory)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(removed)+len(backups) != 41 {
+		t.Errorf("Expected removed and kept to add up to 41, got %d and %d", len(removed), len(backups))
+	}
+
+	var kept []string
+	for _, backup := range backups {
+		kept = append(kept, time.UnixMilli(backup.TakenTSUTC).UTC().Format("2006-01-02T15"))
+	}
+	// The last three days, plus the newest backup of the week before (that
+	// of the last week is already kept as a daily one).
+	want := []string{"2021-01-24T12", "2021-01-23T12", "2021-01-22T12", "2021-01-17T12"}
+	if len(kept) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, kept)
+	}
+	for i := range want {
+		if kept[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, kept)
+			break
+		}
+	}
+
+	// A policy keeping nothing still keeps the newest backup.
+	if _, err := Rotate(directory, Policy{}); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if backups, _ := List(directory); len(backups) != 1 || backups[0].Name != FileName(timeAt(20*24)) {
+		t.Errorf("Expected only the newest backup to remain, got %+v", backups)
+	}
+}
+
+func TestManager(t *testing.T) {
+	db, directory, cleanup := setupTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	backups := filepath.Join(directory, "backups")
+	manager := NewManager(db, backups, Policy{Daily: 2}, 0)
+	hours := int64(0)
+	manager.now = func() time.Time {
+		hours += 24
+		return timeAt(hours)
+	}
+	for i := 0; i < 4; i++ {
+		backup, err := manager.Backup(ctx)
+		if err != nil {
+			t.Fatalf("Backup() error = %v", err)
+		}
+		if err := Verify(ctx, backup.Path); err != nil {
+			t.Errorf("Verify() error = %v", err)
+		}
+	}
+	list, err := manager.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(list) != 2 || list[0].TakenTSUTC != timeAt(96).UnixMilli() {
+		t.Errorf("Expected the two newest daily backups, got %+v", list)
+	}
+}
+
+func TestManagerRun(t *testing.T) {
+	db, directory, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager := NewManager(db, filepath.Join(directory, "backups"), DefaultPolicy, 50*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		manager.Run(ctx)
+		close(done)
+	}()
+
+	// Rotation keeps one backup per day, so watch the newest one change.
+	deadline := time.Now().Add(5 * time.Second)
+	seen := make(map[string]bool)
+	for len(seen) < 2 {
+		backups, err := manager.List()
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if len(backups) > 0 {
+			seen[backups[0].Name] = true
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected two scheduled backups, saw %d", len(seen))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Run to return after cancellation")
+	}
+}
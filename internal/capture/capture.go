@@ -0,0 +1,254 @@
+// Package capture holds the user's switch for stopping recording. While
+// capture is paused the agent drops every event posted to it, whatever the
+// extension does, and it always drops events from windows the user has marked
+// private, like an incognito window.
+//
+// The state lives in the database rather than in the server, so that it
+// survives restarts and the CLI can flip it while the server runs.
+package capture
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// Capture states.
+const (
+	Recording = "recording"
+	Paused    = "paused"
+)
+
+// pollInterval is how often Wait looks for changes made by other processes,
+// such as the CLI.
+const pollInterval = time.Second
+
+// State is what the extension shows the user.
+type State struct {
+	State string `json:"state"`
+	// PausedUntilTSUTC is when a timed pause ends; nil while recording or
+	// paused until resumed.
+	PausedUntilTSUTC *int64          `json:"paused_until_ts_utc"`
+	PrivateWindows   []PrivateWindow `json:"private_windows"`
+	ChangedTSUTC     int64           `json:"changed_ts_utc"`
+	// Version goes up with every change, for pollers to wait on.
+	Version int64 `json:"version"`
+}
+
+// Recording reports whether events are being recorded outside private
+// windows.
+func (s State) Recording() bool {
+	return s.State == Recording
+}
+
+// PrivateWindow is a browser window whose events are never recorded. Window
+// IDs are only unique within a browser, so the client ID is part of the key.
+type PrivateWindow struct {
+	ClientID    string `json:"client_id"`
+	WindowID    int64  `json:"window_id"`
+	MarkedTSUTC int64  `json:"marked_ts_utc"`
+}
+
+// Switch reads and changes the capture state stored in a database.
+type Switch struct {
+	db  *database.Database
+	now func() time.Time
+
+	mu      sync.Mutex
+	changed chan struct{} // closed and replaced on every change made here
+}
+
+// New returns the switch for db, creating its tables if needed. A new
+// database starts out recording.
+func New(db *database.Database) (*Switch, error) {
+	_, err := db.DB().Exec(`
+	CREATE TABLE IF NOT EXISTS capture_state(
+		id                  INTEGER PRIMARY KEY CHECK (id = 1),
+		paused              INTEGER NOT NULL,
+		paused_until_ts_utc INTEGER,
+		changed_ts_utc      INTEGER NOT NULL,
+		version             INTEGER NOT NULL
+	);
+	INSERT OR IGNORE INTO capture_state(id, paused, changed_ts_utc, version) VALUES(1, 0, 0, 1);
+	CREATE TABLE IF NOT EXISTS private_windows(
+		client_id     TEXT NOT NULL,
+		window_id     INTEGER NOT NULL,
+		marked_ts_utc INTEGER NOT NULL,
+		PRIMARY KEY (client_id, window_id)
+	);`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture tables: %w", err)
+	}
+	return &Switch{db: db, now: time.Now, changed: make(chan struct{})}, nil
+}
+
+// State returns the current capture state. A timed pause that has run out
+// reads as recording.
+func (s *Switch) State(ctx context.Context) (State, error) {
+	var state State
+	var paused bool
+	var until sql.NullInt64
+	err := s.db.DB().QueryRowContext(ctx,
+		`SELECT paused, paused_until_ts_utc, changed_ts_utc, version FROM capture_state WHERE id = 1`,
+	).Scan(&paused, &until, &state.ChangedTSUTC, &state.Version)
+	if err != nil {
+		return state, fmt.Errorf("failed to read capture state: %w", err)
+	}
+	state.State = Recording
+	if paused {
+		if !until.Valid {
+			state.State = Paused
+		} else if now := s.now().UnixMilli(); until.Int64 > now {
+			state.State = Paused
+			state.PausedUntilTSUTC = &until.Int64
+		} else if until.Int64 > state.ChangedTSUTC {
+			// The pause ran out: that is a change too.
+			state.ChangedTSUTC = until.Int64
+		}
+	}
+
+	rows, err := s.db.DB().QueryContext(ctx,
+		`SELECT client_id, window_id, marked_ts_utc FROM private_windows ORDER BY client_id, window_id`)
+	if err != nil {
+		return state, fmt.Errorf("failed to read private windows: %w", err)
+	}
+	defer rows.Close()
+	state.PrivateWindows = []PrivateWindow{}
+	for rows.Next() {
+		var window PrivateWindow
+		if err := rows.Scan(&window.ClientID, &window.WindowID, &window.MarkedTSUTC); err != nil {
+			return state, fmt.Errorf("failed to scan private window: %w", err)
+		}
+		state.PrivateWindows = append(state.PrivateWindows, window)
+	}
+	if err := rows.Err(); err != nil {
+		return state, fmt.Errorf("failed to read private windows: %w", err)
+	}
+	return state, nil
+}
+
+// Pause stops recording until resumed, or until until if it is not zero.
+func (s *Switch) Pause(ctx context.Context, until time.Time) (State, error) {
+	if !until.IsZero() && !until.After(s.now()) {
+		return State{}, errors.New("a pause must end in the future")
+	}
+	var untilTS any
+	if !until.IsZero() {
+		untilTS = until.UnixMilli()
+	}
+	return s.update(ctx, `UPDATE capture_state SET paused = 1, paused_until_ts_utc = ? WHERE id = 1`, untilTS)
+}
+
+// Resume starts recording again.
+func (s *Switch) Resume(ctx context.Context) (State, error) {
+	return s.update(ctx, `UPDATE capture_state SET paused = 0, paused_until_ts_utc = NULL WHERE id = 1`)
+}
+
+// SetPrivate marks a window private, or records it again.
+func (s *Switch) SetPrivate(ctx context.Context, clientID string, windowID int64, private bool) (State, error) {
+	if private {
+		return s.update(ctx,
+			`INSERT OR IGNORE INTO private_windows(client_id, window_id, marked_ts_utc) VALUES(?, ?, ?)`,
+			clientID, windowID, s.now().UnixMilli())
+	}
+	return s.update(ctx, `DELETE FROM private_windows WHERE client_id = ? AND window_id = ?`, clientID, windowID)
+}
+
+// update applies a change and moves the state to a new version.
+func (s *Switch) update(ctx context.Context, query string, args ...any) (State, error) {
+	tx, err := s.db.DB().BeginTx(ctx, nil)
+	if err != nil {
+		return State{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return State{}, fmt.Errorf("failed to update capture state: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE capture_state SET changed_ts_utc = ?, version = version + 1 WHERE id = 1`, s.now().UnixMilli())
+	if err != nil {
+		return State{}, fmt.Errorf("failed to update capture state: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return State{}, fmt.Errorf("failed to commit capture state: %w", err)
+	}
+
+	s.mu.Lock()
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mu.Unlock()
+	return s.State(ctx)
+}
+
+// Filter returns the events that may be recorded now and how many were
+// dropped.
+func (s *Switch) Filter(ctx context.Context, events []models.Event) ([]models.Event, int, error) {
+	state, err := s.State(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !state.Recording() {
+		return nil, len(events), nil
+	}
+	if len(state.PrivateWindows) == 0 {
+		return events, 0, nil
+	}
+	private := make(map[PrivateWindow]bool, len(state.PrivateWindows))
+	for _, window := range state.PrivateWindows {
+		private[PrivateWindow{ClientID: window.ClientID, WindowID: window.WindowID}] = true
+	}
+	kept := make([]models.Event, 0, len(events))
+	for _, event := range events {
+		if event.WindowID != nil {
+			key := PrivateWindow{WindowID: *event.WindowID}
+			if event.ClientID != nil {
+				key.ClientID = *event.ClientID
+			}
+			if private[key] {
+				continue
+			}
+		}
+		kept = append(kept, event)
+	}
+	return kept, len(events) - len(kept), nil
+}
+
+// Wait returns the state once it differs from version, as soon as it changes
+// here or within a second of another process changing it, or when a timed
+// pause runs out. When ctx ends first, it returns the unchanged state.
+func (s *Switch) Wait(ctx context.Context, version int64) (State, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		changed := s.changed
+		s.mu.Unlock()
+		state, err := s.State(ctx)
+		if err != nil || state.Version != version {
+			return state, err
+		}
+		var expired <-chan time.Time
+		var timer *time.Timer
+		if state.PausedUntilTSUTC != nil {
+			timer = time.NewTimer(time.UnixMilli(*state.PausedUntilTSUTC).Sub(s.now()))
+			expired = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			return state, nil
+		case <-expired:
+			return s.State(ctx)
+		case <-changed:
+		case <-ticker.C:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package capture
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func setupTestSwitch(t *testing.T) (*database.Database, *Switch, func()) {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "browsetrace-capture-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	db, err := database.NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+	capture, err := New(db)
+	if err != nil {
+		cleanup()
+		t.Fatalf("New() error = %v", err)
+	}
+	return db, capture, cleanup
+}
+
+func TestPauseAndResume(t *testing.T) {
+	db, capture, cleanup := setupTestSwitch(t)
+	defer cleanup()
+	ctx := context.Background()
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	capture.now = func() time.Time { return now }
+
+	state, err := capture.State(ctx)
+	if err != nil || !state.Recording() || state.Version != 1 {
+		t.Fatalf("Expected a new database to be recording at version 1, got %+v, %v", state, err)
+	}
+
+	if state, err = capture.Pause(ctx, time.Time{}); err != nil || state.State != Paused || state.PausedUntilTSUTC != nil {
+		t.Fatalf("Expected an indefinite pause, got %+v, %v", state, err)
+	}
+	// The state is in the database: a second switch, as the CLI would open,
+	// sees it.
+	other, err := New(db)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if state, err := other.State(ctx); err != nil || state.State != Paused || state.Version != 2 {
+		t.Errorf("Expected the pause to persist, got %+v, %v", state, err)
+	}
+
+	if state, err = capture.Resume(ctx); err != nil || !state.Recording() || state.Version != 3 {
+		t.Fatalf("Expected recording after resume, got %+v, %v", state, err)
+	}
+
+	until := now.Add(30 * time.Minute)
+	if state, err = capture.Pause(ctx, until); err != nil || state.State != Paused ||
+		state.PausedUntilTSUTC == nil || *state.PausedUntilTSUTC != until.UnixMilli() {
+		t.Fatalf("Expected a pause for 30 minutes, got %+v, %v", state, err)
+	}
+	now = until
+	if state, err = capture.State(ctx); err != nil || !state.Recording() || state.ChangedTSUTC != until.UnixMilli() {
+		t.Errorf("Expected recording once the pause ran out, got %+v, %v", state, err)
+	}
+
+	if _, err := capture.Pause(ctx, now.Add(-time.Minute)); err == nil {
+		t.Error("Expected a pause ending in the past to be refused")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	_, capture, cleanup := setupTestSwitch(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	window := func(id int64) *int64 { return &id }
+	client := func(id string) *string { return &id }
+	events := []models.Event{
+		{TSUTC: 1000, Type: "navigate", WindowID: window(1), ClientID: client("laptop")},
+		{TSUTC: 2000, Type: "navigate", WindowID: window(2), ClientID: client("laptop")},
+		{TSUTC: 3000, Type: "navigate", WindowID: window(2), ClientID: client("desktop")},
+		{TSUTC: 4000, Type: "navigate", WindowID: window(2)},
+		{TSUTC: 5000, Type: "navigate"},
+	}
+	keptTimes := func(kept []models.Event) []int64 {
+		var times []int64
+		for _, event := range kept {
+			times = append(times, event.TSUTC)
+		}
+		return times
+	}
+
+	kept, dropped, err := capture.Filter(ctx, events)
+	if err != nil || dropped != 0 || len(kept) != 5 {
+		t.Fatalf("Expected everything kept while recording, got %v, %d, %v", keptTimes(kept), dropped, err)
+	}
+
+	if _, err := capture.SetPrivate(ctx, "laptop", 2, true); err != nil {
+		t.Fatalf("SetPrivate() error = %v", err)
+	}
+	if _, err := capture.SetPrivate(ctx, "", 2, true); err != nil {
+		t.Fatalf("SetPrivate() error = %v", err)
+	}
+	kept, dropped, err = capture.Filter(ctx, events)
+	if got := keptTimes(kept); err != nil || dropped != 2 || len(got) != 3 || got[0] != 1000 || got[1] != 3000 || got[2] != 5000 {
+		t.Errorf("Expected the private windows dropped, got %v, %d, %v", got, dropped, err)
+	}
+
+	if _, err := capture.Pause(ctx, time.Time{}); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	if kept, dropped, err = capture.Filter(ctx, events); err != nil || dropped != 5 || len(kept) != 0 {
+		t.Errorf("Expected everything dropped while paused, got %v, %d, %v", keptTimes(kept), dropped, err)
+	}
+
+	if _, err := capture.Resume(ctx); err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	state, err := capture.SetPrivate(ctx, "laptop", 2, false)
+	if err != nil || len(state.PrivateWindows) != 1 {
+		t.Fatalf("Expected one private window left, got %+v, %v", state, err)
+	}
+	if kept, dropped, err = capture.Filter(ctx, events); err != nil || dropped != 1 || len(kept) != 4 {
+		t.Errorf("Expected the window recorded again, got %v, %d, %v", keptTimes(kept), dropped, err)
+	}
+}
+
+func TestWait(t *testing.T) {
+	db, capture, cleanup := setupTestSwitch(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// A version the caller has not seen is answered at once.
+	if state, err := capture.Wait(ctx, 0); err != nil || state.Version != 1 {
+		t.Errorf("Expected the current state at once, got %+v, %v", state, err)
+	}
+
+	// Nothing changes: the state comes back when ctx ends.
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	state, err := capture.Wait(short, 1)
+	cancel()
+	if err != nil || state.Version != 1 {
+		t.Errorf("Expected the unchanged state, got %+v, %v", state, err)
+	}
+
+	// A change made here wakes the waiter.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		capture.Pause(ctx, time.Now().Add(200*time.Millisecond))
+	}()
+	if state, err = capture.Wait(ctx, 1); err != nil || state.State != Paused || state.Version != 2 {
+		t.Fatalf("Expected to wake on the pause, got %+v, %v", state, err)
+	}
+	// The end of a timed pause does too.
+	if state, err = capture.Wait(ctx, 2); err != nil || !state.Recording() {
+		t.Errorf("Expected to wake when the pause ran out, got %+v, %v", state, err)
+	}
+
+	// So does a change made by another process, once it is polled.
+	other, err := New(db)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		other.Resume(ctx)
+	}()
+	if state, err = capture.Wait(ctx, 2); err != nil || state.Version != 3 {
+		t.Errorf("Expected to see the other switch's change, got %+v, %v", state, err)
+	}
+}
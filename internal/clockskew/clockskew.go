@@ -0,0 +1,217 @@
+// Package clockskew checks the timestamps clients put on events against the
+// time the agent receives them, to catch browsers whose clocks are wrong.
+//
+// An event cannot happen after the agent receives it, so a batch whose newest
+// event is stamped later than that shows the client's clock running ahead by
+// at least the difference. A clock running behind cannot be told apart from
+// a batch that was queued for a while, so it is reported but never corrected.
+package clockskew
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// Mode decides what happens to events from a client whose clock runs ahead.
+type Mode string
+
+const (
+	// ModeDetect stores them as sent and counts them. It also counts any
+	// ts_iso that does not match its ts_utc but leaves it as sent, so that the
+	// server refuses the batch as invalid.
+	ModeDetect Mode = "detect"
+	// ModeCorrect moves their timestamps back onto the agent's clock, and
+	// rewrites any ts_iso that does not match its ts_utc.
+	ModeCorrect Mode = "correct"
+	// ModeReject refuses the batch, along with any ts_iso that does not match
+	// its ts_utc.
+	ModeReject Mode = "reject"
+)
+
+func ParseMode(value string) (Mode, error) {
+	switch Mode(strings.ToLower(value)) {
+	case ModeDetect:
+		return ModeDetect, nil
+	case ModeCorrect:
+		return ModeCorrect, nil
+	case ModeReject:
+		return ModeReject, nil
+	}
+	return "", fmt.Errorf("invalid clock skew mode %q (want detect, correct or reject)", value)
+}
+
+// DefaultTolerance is how far ahead a client's clock may be before it counts
+// as skewed.
+const DefaultTolerance = time.Minute
+
+// Config says how to treat skewed clients.
+type Config struct {
+	Mode      Mode
+	Tolerance time.Duration
+}
+
+// Counters sum up what the checker has seen since the agent started.
+type Counters struct {
+	SinceTSUTC int64 `json:"since_ts_utc"`
+	Events     int64 `json:"events"`
+	// ISOMismatched counts events whose ts_iso disagreed with ts_utc, and
+	// ISOReconciled those of them whose ts_iso was rewritten from it.
+	ISOMismatched int64 `json:"iso_mismatched"`
+	ISOReconciled int64 `json:"iso_reconciled"`
+	// Skewed counts events from clients whose clocks ran ahead, and Corrected
+	// those of them whose timestamps were moved.
+	Skewed    int64    `json:"skewed"`
+	Corrected int64    `json:"corrected"`
+	Rejected  int64    `json:"rejected"`
+	Clients   []Client `json:"clients"`
+}
+
+// Client is the latest reading of one client's clock.
+type Client struct {
+	ClientID string `json:"client_id"`
+	// OffsetMS is how far the newest event of the client's last batch was
+	// stamped after the agent received it: positive when its clock runs
+	// ahead, negative for delivery delay or a clock running behind.
+	OffsetMS      int64 `json:"offset_ms"`
+	Skewed        bool  `json:"skewed"`
+	Batches       int64 `json:"batches"`
+	LastSeenTSUTC int64 `json:"last_seen_ts_utc"`
+}
+
+// RejectedError is returned for a batch ModeReject refuses.
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return "rejected timestamps: " + e.Reason
+}
+
+// Checker checks and, if configured to, corrects the timestamps of incoming
+// batches. It is safe for concurrent use.
+type Checker struct {
+	config Config
+	now    func() time.Time
+
+	mu       sync.Mutex
+	counters Counters
+	clients  map[string]*Client
+}
+
+func New(config Config) (*Checker, error) {
+	if config.Mode == "" {
+		config.Mode = ModeDetect
+	}
+	if _, err := ParseMode(string(config.Mode)); err != nil {
+		return nil, err
+	}
+	if config.Tolerance == 0 {
+		config.Tolerance = DefaultTolerance
+	}
+	if config.Tolerance < 0 {
+		return nil, fmt.Errorf("invalid clock skew tolerance %s", config.Tolerance)
+	}
+	c := &Checker{config: config, now: time.Now, clients: make(map[string]*Client)}
+	c.counters.SinceTSUTC = c.now().UnixMilli()
+	return c, nil
+}
+
+// Check returns events as ModeCorrect fixes them, leaving the given slice
+// alone; ModeDetect returns them unchanged. In ModeReject it returns a
+// *RejectedError instead of changing anything.
+func (c *Checker) Check(events []models.Event) ([]models.Event, error) {
+	received := c.now().UnixMilli()
+	newest := make(map[string]int64)
+	for _, event := range events {
+		client := clientID(event)
+		newest[client] = max(newest[client], event.TSUTC)
+	}
+	offsets := make(map[string]int64, len(newest))
+	for client, ts := range newest {
+		offsets[client] = ts - received
+	}
+	skewed := func(client string) bool {
+		return offsets[client] > c.config.Tolerance.Milliseconds()
+	}
+
+	checked := make([]models.Event, len(events))
+	var mismatched, reconciled, skewedEvents, corrected int64
+	var rejection string
+	for i, event := range events {
+		client := clientID(event)
+		if skewed(client) {
+			skewedEvents++
+			switch c.config.Mode {
+			case ModeCorrect:
+				// Keep the batch's spacing, with its newest event at receipt.
+				event.TSUTC -= offsets[client]
+				if event.TSISO != "" {
+					event.TSISO = models.FormatTimestamp(event.TSUTC)
+				}
+				corrected++
+			case ModeReject:
+				rejection = fmt.Sprintf("client clock is %s ahead", time.Duration(offsets[client])*time.Millisecond)
+			}
+		}
+		if err := models.CheckISOTimestamp(event.TSUTC, event.TSISO); err != nil {
+			mismatched++
+			switch c.config.Mode {
+			case ModeCorrect:
+				event.TSISO = models.FormatTimestamp(event.TSUTC)
+				reconciled++
+			case ModeReject:
+				rejection = err.Error()
+			}
+		}
+		checked[i] = event
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for client, offset := range offsets {
+		reading := c.clients[client]
+		if reading == nil {
+			reading = &Client{ClientID: client}
+			c.clients[client] = reading
+		}
+		reading.OffsetMS, reading.Skewed, reading.LastSeenTSUTC = offset, skewed(client), received
+		reading.Batches++
+	}
+	c.counters.Events += int64(len(events))
+	c.counters.Skewed += skewedEvents
+	c.counters.ISOMismatched += mismatched
+	if rejection != "" {
+		c.counters.Rejected += int64(len(events))
+		return nil, &RejectedError{Reason: rejection}
+	}
+	c.counters.ISOReconciled += reconciled
+	c.counters.Corrected += corrected
+	return checked, nil
+}
+
+// Counters returns a snapshot of the counters, with clients in ID order.
+func (c *Checker) Counters() Counters {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counters := c.counters
+	counters.Clients = make([]Client, 0, len(c.clients))
+	for _, client := range c.clients {
+		counters.Clients = append(counters.Clients, *client)
+	}
+	sort.Slice(counters.Clients, func(i, j int) bool {
+		return counters.Clients[i].ClientID < counters.Clients[j].ClientID
+	})
+	return counters
+}
+
+func clientID(event models.Event) string {
+	if event.ClientID == nil {
+		return ""
+	}
+	return *event.ClientID
+}
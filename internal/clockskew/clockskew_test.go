@@ -0,0 +1,127 @@
+package clockskew
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Mode
+		wantErr bool
+	}{
+		{"detect", ModeDetect, false},
+		{"Correct", ModeCorrect, false},
+		{"reject", ModeReject, false},
+		{"fix", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMode(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseMode(%q) = %q, %v; want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	received := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC).UnixMilli()
+	laptop, phone := "laptop", "phone"
+	event := func(client *string, offset time.Duration, iso bool) models.Event {
+		ts := received + offset.Milliseconds()
+		event := models.Event{TSUTC: ts, URL: "https://example.com/", Type: "click", ClientID: client}
+		if iso {
+			event.TSISO = models.FormatTimestamp(ts)
+		}
+		return event
+	}
+	// The laptop's clock runs ten minutes ahead; the phone's batch was queued
+	// for five minutes, which is not skew.
+	batch := []models.Event{
+		event(&laptop, 9*time.Minute, true),
+		event(&laptop, 10*time.Minute, false),
+		event(&phone, -5*time.Minute, true),
+		event(nil, 0, false),
+	}
+	batch[3].TSISO = "2001-01-01T00:00:00Z"
+
+	tests := []struct {
+		mode       Mode
+		wantTS     []int64
+		wantErr    bool
+		wantCounts Counters
+	}{
+		{
+			mode:       ModeDetect,
+			wantCounts: Counters{Events: 4, Skewed: 2, ISOMismatched: 1},
+		},
+		{
+			mode:       ModeCorrect,
+			wantTS:     []int64{received - time.Minute.Milliseconds(), received, batch[2].TSUTC, batch[3].TSUTC},
+			wantCounts: Counters{Events: 4, Skewed: 2, Corrected: 2, ISOMismatched: 1, ISOReconciled: 1},
+		},
+		{
+			mode:       ModeReject,
+			wantErr:    true,
+			wantCounts: Counters{Events: 4, Skewed: 2, ISOMismatched: 1, Rejected: 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			checker, err := New(Config{Mode: tt.mode})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			checker.now = func() time.Time { return time.UnixMilli(received) }
+
+			checked, err := checker.Check(batch)
+			var rejected *RejectedError
+			if tt.wantErr != errors.As(err, &rejected) {
+				t.Fatalf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for i, want := range tt.wantTS {
+				if checked[i].TSUTC != want {
+					t.Errorf("Event %d: Expected ts_utc %d, got %d", i, want, checked[i].TSUTC)
+				}
+				if err := models.CheckISOTimestamp(checked[i].TSUTC, checked[i].TSISO); err != nil {
+					t.Errorf("Event %d: Expected a consistent ts_iso, got %v", i, err)
+				}
+			}
+			if tt.mode == ModeDetect && !reflect.DeepEqual(checked, batch) {
+				t.Errorf("Expected detect mode to leave the events as sent, got %+v", checked)
+			}
+			if !tt.wantErr && checked[1].TSISO != "" {
+				t.Errorf("Expected an empty ts_iso to stay empty, got %q", checked[1].TSISO)
+			}
+
+			counters := checker.Counters()
+			if counters.Events != tt.wantCounts.Events || counters.Skewed != tt.wantCounts.Skewed ||
+				counters.Corrected != tt.wantCounts.Corrected || counters.ISOMismatched != tt.wantCounts.ISOMismatched ||
+				counters.ISOReconciled != tt.wantCounts.ISOReconciled ||
+				counters.Rejected != tt.wantCounts.Rejected {
+				t.Errorf("Expected counters %+v, got %+v", tt.wantCounts, counters)
+			}
+			if len(counters.Clients) != 3 || counters.Clients[0].ClientID != "" ||
+				!counters.Clients[1].Skewed || counters.Clients[1].OffsetMS != (10*time.Minute).Milliseconds() ||
+				counters.Clients[2].Skewed || counters.Clients[2].OffsetMS != (-5*time.Minute).Milliseconds() {
+				t.Errorf("Expected readings for three clients, got %+v", counters.Clients)
+			}
+		})
+	}
+	if batch[1].TSUTC != received+(10*time.Minute).Milliseconds() || batch[3].TSISO != "2001-01-01T00:00:00Z" {
+		t.Errorf("Expected the input batch to be left alone, got %+v", batch)
+	}
+}
+
+func TestNewRejectsBadConfig(t *testing.T) {
+	if _, err := New(Config{Mode: "fix"}); err == nil {
+		t.Error("Expected an unknown mode to be refused")
+	}
+	if _, err := New(Config{Tolerance: -time.Second}); err == nil {
+		t.Error("Expected a negative tolerance to be refused")
+	}
+}
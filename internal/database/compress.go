@@ -0,0 +1,401 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+	"github.com/vincentbai/browsetrace-agent/internal/encryption"
+	sqlite "modernc.org/sqlite"
+)
+
+// Text blobs above a size threshold can be compressed at rest. The codec
+// column of text_blobs records how each blob's content is encoded:
+//
+//	""             plain text
+//	"gzip"         gzip
+//	"zstd"         zstd
+//	"zstd:<hash>"  zstd with the trained dictionary of that hash
+//
+// Page text is where the bulk of the database lives; data_json stays
+// uncompressed so that it remains queryable with SQLite's JSON functions.
+// Text search decompresses through the browsetrace_text SQL function, except
+// on an encrypted database, where it runs in Go (see encrypt.go).
+
+// Codec selects how new text blobs are compressed.
+type Codec string
+
+const (
+	CodecNone Codec = "none"
+	CodecGzip Codec = "gzip"
+	CodecZstd Codec = "zstd"
+)
+
+func ParseCodec(value string) (Codec, error) {
+	switch Codec(strings.ToLower(value)) {
+	case "", CodecNone:
+		return CodecNone, nil
+	case CodecGzip:
+		return CodecGzip, nil
+	case CodecZstd:
+		return CodecZstd, nil
+	}
+	return "", fmt.Errorf("unsupported codec %q (want none, gzip or zstd)", value)
+}
+
+// DefaultCompressionThreshold is the smallest text, in bytes, worth
+// compressing; below it the codec overhead eats most of the gain.
+const DefaultCompressionThreshold = 4096
+
+type Compression struct {
+	Codec     Codec
+	Threshold int // bytes; 0 means DefaultCompressionThreshold
+}
+
+// SetCompression sets how text stored from now on is compressed. Existing
+// blobs keep their codec until Recompress rewrites them.
+func (d *Database) SetCompression(compression Compression) {
+	if compression.Threshold <= 0 {
+		compression.Threshold = DefaultCompressionThreshold
+	}
+	d.compression = compression
+}
+
+func init() {
+	// Lets SQL see through compression: browsetrace_text(content, codec).
+	err := sqlite.RegisterDeterministicScalarFunction("browsetrace_text", 2, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		codec, _ := args[1].(string)
+		switch content := args[0].(type) {
+		case string:
+			return decompressText([]byte(content), codec)
+		case []byte:
+			return decompressText(content, codec)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+var plainZstd = sync.OnceValues(func() (*zstdCodec, error) {
+	return newZstdCodec(nil)
+})
+
+type zstdCodec struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newZstdCodec(dictionary []byte) (*zstdCodec, error) {
+	var encoderOptions []zstd.EOption
+	var decoderOptions []zstd.DOption
+	if dictionary != nil {
+		encoderOptions = append(encoderOptions, zstd.WithEncoderDict(dictionary))
+		decoderOptions = append(decoderOptions, zstd.WithDecoderDicts(dictionary))
+	}
+	encoder, err := zstd.NewWriter(nil, encoderOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+	}
+	decoder, err := zstd.NewReader(nil, append(decoderOptions, zstd.WithDecoderConcurrency(0))...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+	}
+	return &zstdCodec{encoder: encoder, decoder: decoder}, nil
+}
+
+// dictionaries holds the zstd dictionaries of every open database, keyed by
+// content hash so that databases cannot confuse each other's.
+var dictionaries = struct {
+	sync.RWMutex
+	byHash map[string]*zstdCodec
+}{byHash: make(map[string]*zstdCodec)}
+
+func registerDictionary(dictionary []byte) (string, error) {
+	hash := hashText(string(dictionary))[:16]
+	dictionaries.Lock()
+	defer dictionaries.Unlock()
+	if dictionaries.byHash[hash] != nil {
+		return hash, nil
+	}
+	codec, err := newZstdCodec(dictionary)
+	if err != nil {
+		return "", err
+	}
+	dictionaries.byHash[hash] = codec
+	return hash, nil
+}
+
+func zstdCodecFor(codec string) (*zstdCodec, error) {
+	hash, withDictionary := strings.CutPrefix(codec, "zstd:")
+	if !withDictionary {
+		return plainZstd()
+	}
+	dictionaries.RLock()
+	defer dictionaries.RUnlock()
+	if found := dictionaries.byHash[hash]; found != nil {
+		return found, nil
+	}
+	return nil, fmt.Errorf("unknown compression dictionary %s", hash)
+}
+
+// loadDictionaries registers the database's trained dictionaries and makes
+// the newest one current.
+func (d *Database) loadDictionaries() error {
+	rows, err := d.db.Query(`SELECT dictionary FROM compression_dictionaries ORDER BY created_ts, rowid`)
+	if err != nil {
+		return fmt.Errorf("failed to query compression dictionaries: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var dictionary []byte
+		if err := rows.Scan(&dictionary); err != nil {
+			return fmt.Errorf("failed to scan compression dictionary: %w", err)
+		}
+		if encryption.IsSealed(dictionary) && d.key == nil {
+			// Loaded again once SetEncryptionKey provides the key.
+			continue
+		}
+		if dictionary, err = d.key.OpenBytes(dictionary); err != nil {
+			return fmt.Errorf("failed to decrypt compression dictionary: %w", err)
+		}
+		if d.dictionary, err = registerDictionary(dictionary); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read compression dictionaries: %w", err)
+	}
+	return nil
+}
+
+// compressText encodes text for storage, returning the value for the content
+// column and its codec. Text below the threshold, or that does not shrink,
+// is stored as-is.
+func (d *Database) compressText(text string) (any, string, error) {
+	if d.compression.Codec == "" || d.compression.Codec == CodecNone || len(text) < d.compression.Threshold {
+		return text, "", nil
+	}
+	var compressed []byte
+	var codec string
+	switch d.compression.Codec {
+	case CodecGzip:
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write([]byte(text)); err != nil {
+			return nil, "", fmt.Errorf("failed to compress text: %w", err)
+		}
+		if err := writer.Close(); err != nil {
+			return nil, "", fmt.Errorf("failed to compress text: %w", err)
+		}
+		compressed, codec = buffer.Bytes(), string(CodecGzip)
+	case CodecZstd:
+		codec = string(CodecZstd)
+		if d.dictionary != "" {
+			codec += ":" + d.dictionary
+		}
+		zstdCodec, err := zstdCodecFor(codec)
+		if err != nil {
+			return nil, "", err
+		}
+		compressed = zstdCodec.encoder.EncodeAll([]byte(text), nil)
+	default:
+		return nil, "", fmt.Errorf("unsupported codec %q", d.compression.Codec)
+	}
+	if len(compressed) >= len(text) {
+		return text, "", nil
+	}
+	return compressed, codec, nil
+}
+
+// openText decrypts and decompresses a blob's stored content.
+func (d *Database) openText(stored []byte, codec string) (string, error) {
+	content, err := d.key.OpenBytes(stored)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt text: %w", err)
+	}
+	return decompressText(content, codec)
+}
+
+func decompressText(content []byte, codec string) (string, error) {
+	switch {
+	case codec == "":
+		return string(content), nil
+	case codec == string(CodecGzip):
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress text: %w", err)
+		}
+		text, err := io.ReadAll(reader)
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress text: %w", err)
+		}
+		return string(text), nil
+	case strings.HasPrefix(codec, string(CodecZstd)):
+		zstdCodec, err := zstdCodecFor(codec)
+		if err != nil {
+			return "", err
+		}
+		text, err := zstdCodec.decoder.DecodeAll(content, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress text: %w", err)
+		}
+		return string(text), nil
+	}
+	return "", fmt.Errorf("unknown codec %q", codec)
+}
+
+// dictionarySamples bounds the text a dictionary is trained on.
+const (
+	dictionarySamples     = 2000
+	dictionarySampleBytes = 64 << 10
+	dictionarySize        = 64 << 10
+	minimumSamples        = 16
+)
+
+// TrainDictionary builds a zstd dictionary from the most recent page text
+// snapshots and makes it current, so that text compressed with CodecZstd from
+// now on uses it. It returns the dictionary's hash.
+func (d *Database) TrainDictionary(ctx context.Context) (string, error) {
+	rows, err := d.db.QueryContext(ctx, `
+	SELECT content, codec FROM text_blobs WHERE base_hash IS NULL ORDER BY rowid DESC LIMIT ?`, dictionarySamples)
+	if err != nil {
+		return "", fmt.Errorf("failed to query text samples: %w", err)
+	}
+	var samples [][]byte
+	for rows.Next() {
+		var content []byte
+		var codec string
+		if err := rows.Scan(&content, &codec); err != nil {
+			rows.Close()
+			return "", fmt.Errorf("failed to scan text sample: %w", err)
+		}
+		text, err := d.openText(content, codec)
+		if err != nil {
+			rows.Close()
+			return "", err
+		}
+		samples = append(samples, []byte(text[:min(len(text), dictionarySampleBytes)]))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("failed to read text samples: %w", err)
+	}
+	if len(samples) < minimumSamples {
+		return "", fmt.Errorf("not enough stored text to train a dictionary (%d snapshots, want %d)", len(samples), minimumSamples)
+	}
+
+	dictionary, err := dict.BuildZstdDict(samples, dict.Options{MaxDictSize: dictionarySize, HashBytes: 6})
+	if err != nil {
+		return "", fmt.Errorf("failed to train dictionary: %w", err)
+	}
+	hash, err := registerDictionary(dictionary)
+	if err != nil {
+		return "", err
+	}
+	_, err = d.db.ExecContext(ctx, `INSERT OR IGNORE INTO compression_dictionaries(hash, dictionary, created_ts) VALUES(?,?,?)`,
+		hash, sealBytes(d.key, dictionary), time.Now().UnixMilli())
+	if err != nil {
+		return "", fmt.Errorf("failed to store dictionary: %w", err)
+	}
+	d.dictionary = hash
+	return hash, nil
+}
+
+type RecompressResult struct {
+	Blobs       int   `json:"blobs"`        // blobs rewritten
+	BytesBefore int64 `json:"bytes_before"` // stored size of those blobs before
+	BytesAfter  int64 `json:"bytes_after"`  // and after
+}
+
+// Recompress rewrites stored text with the current compression settings.
+// By default only blobs stored uncompressed above the threshold are touched,
+// which is cheap once done; with all set every blob is re-encoded, e.g. after
+// switching codecs or training a dictionary.
+func (d *Database) Recompress(ctx context.Context, all bool) (RecompressResult, error) {
+	var result RecompressResult
+	transaction, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	query := `SELECT hash, content, codec FROM text_blobs`
+	var args []any
+	if !all {
+		if d.compression.Codec == "" || d.compression.Codec == CodecNone {
+			return result, nil
+		}
+		// Sealed content is slightly longer than the text, which is harmless.
+		query += ` WHERE codec = '' AND LENGTH(CAST(content AS BLOB)) >= ?`
+		args = append(args, d.compression.Threshold)
+	}
+	rows, err := transaction.QueryContext(ctx, query, args...)
+	if err != nil {
+		return result, fmt.Errorf("failed to query text: %w", err)
+	}
+	type blob struct {
+		hash    string
+		content []byte
+		codec   string
+	}
+	var blobs []blob
+	for rows.Next() {
+		var b blob
+		if err := rows.Scan(&b.hash, &b.content, &b.codec); err != nil {
+			rows.Close()
+			return result, fmt.Errorf("failed to scan text: %w", err)
+		}
+		blobs = append(blobs, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, fmt.Errorf("failed to read text: %w", err)
+	}
+
+	statement, err := transaction.PrepareContext(ctx, `UPDATE text_blobs SET content = ?, codec = ? WHERE hash = ?`)
+	if err != nil {
+		return result, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer statement.Close()
+	for _, b := range blobs {
+		text, err := d.openText(b.content, b.codec)
+		if err != nil {
+			return result, fmt.Errorf("failed to read text %s: %w", b.hash, err)
+		}
+		content, codec, err := d.compressText(text)
+		if err != nil {
+			return result, err
+		}
+		if codec == b.codec && codec == "" {
+			continue
+		}
+		content = d.sealContent(content)
+		if _, err := statement.ExecContext(ctx, content, codec, b.hash); err != nil {
+			return result, fmt.Errorf("failed to update text %s: %w", b.hash, err)
+		}
+		result.Blobs++
+		result.BytesBefore += int64(len(b.content))
+		result.BytesAfter += int64(storedSize(content))
+	}
+	if err := transaction.Commit(); err != nil {
+		return result, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return result, nil
+}
+
+func storedSize(content any) int {
+	if compressed, ok := content.([]byte); ok {
+		return len(compressed)
+	}
+	return len(content.(string))
+}
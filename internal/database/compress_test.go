@@ -0,0 +1,250 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+var proseWords = strings.Fields(`the a of to and in is for on that with as by this from at be are was it or
+an which browser page article agent recorded history session user text content window scroll search results
+privacy settings account dashboard report weekly daily time spent domain navigation click input focus event
+server database storage compression dictionary snapshot version network request response cache update`)
+
+// prose returns deterministic pseudo-text of about size bytes.
+func prose(seed int64, size int) string {
+	random := rand.New(rand.NewSource(seed))
+	var builder strings.Builder
+	for builder.Len() < size {
+		for i := 0; i < 12; i++ {
+			builder.WriteString(proseWords[random.Intn(len(proseWords))])
+			builder.WriteByte(' ')
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+func storedBlob(t testing.TB, db *Database) (codec string, size int) {
+	t.Helper()
+
+	err := db.db.QueryRow(`SELECT codec, LENGTH(CAST(content AS BLOB)) FROM text_blobs ORDER BY rowid DESC LIMIT 1`).Scan(&codec, &size)
+	if err != nil {
+		t.Fatalf("Failed to read blob: %v", err)
+	}
+	return codec, size
+}
+
+func TestParseCodec(t *testing.T) {
+	tests := map[string]Codec{"": CodecNone, "none": CodecNone, "GZIP": CodecGzip, "zstd": CodecZstd}
+	for input, want := range tests {
+		if got, err := ParseCodec(input); err != nil || got != want {
+			t.Errorf("ParseCodec(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseCodec("brotli"); err == nil {
+		t.Error("Expected an error for an unsupported codec")
+	}
+}
+
+func TestCompressedText(t *testing.T) {
+	tests := []struct {
+		codec Codec
+		text  string
+		want  string
+	}{
+		{CodecNone, prose(1, 20000), ""},
+		{CodecGzip, prose(2, 20000), "gzip"},
+		{CodecZstd, prose(3, 20000), "zstd"},
+		{CodecZstd, "short text\nbelow the threshold", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.codec, len(tt.text)), func(t *testing.T) {
+			db, cleanup := setupTestDB(t)
+			defer cleanup()
+			db.SetCompression(Compression{Codec: tt.codec})
+
+			text := tt.text + "needle"
+			if err := db.InsertEvents([]models.Event{visibleText(1000, "https://example.com", text)}); err != nil {
+				t.Fatalf("Failed to insert event: %v", err)
+			}
+			codec, size := storedBlob(t, db)
+			if codec != tt.want {
+				t.Errorf("Expected codec %q, got %q", tt.want, codec)
+			}
+			if tt.want != "" && size >= len(text)/2 {
+				t.Errorf("Expected compression to at least halve %d bytes, got %d", len(text), size)
+			}
+
+			stored, err := db.QueryEvents(context.Background(), EventFilter{Text: "needle"})
+			if err != nil {
+				t.Fatalf("Failed to search events: %v", err)
+			}
+			if len(stored) != 1 || stored[0].Data["text"] != text {
+				t.Errorf("Expected the text to be searchable and read back unchanged")
+			}
+		})
+	}
+}
+
+func TestCompressedDiffs(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	db.SetTextDiffs(true)
+	db.SetCompression(Compression{Codec: CodecZstd, Threshold: 64})
+
+	base := prose(4, 20000)
+	events := []models.Event{
+		visibleText(1000, "https://example.com", base),
+		visibleText(2000, "https://example.com", base+prose(5, 2000)),
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	stored, err := db.QueryEvents(context.Background(), EventFilter{})
+	if err != nil {
+		t.Fatalf("Failed to query events: %v", err)
+	}
+	for i, event := range stored {
+		if event.Data["text"] != events[i].Data["text"] {
+			t.Errorf("Event %d: text not reassembled exactly", i)
+		}
+	}
+}
+
+func TestRecompress(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	text := prose(6, 20000)
+	if err := db.InsertEvents([]models.Event{visibleText(1000, "https://example.com", text)}); err != nil {
+		t.Fatalf("Failed to insert event: %v", err)
+	}
+	if codec, _ := storedBlob(t, db); codec != "" {
+		t.Fatalf("Expected uncompressed text by default, got %q", codec)
+	}
+
+	db.SetCompression(Compression{Codec: CodecZstd})
+	result, err := db.Recompress(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Recompress() error = %v", err)
+	}
+	if result.Blobs != 1 || result.BytesBefore != int64(len(text)) || result.BytesAfter >= result.BytesBefore {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if result, err := db.Recompress(context.Background(), false); err != nil || result.Blobs != 0 {
+		t.Errorf("Expected nothing left to compress, got %+v, %v", result, err)
+	}
+
+	db.SetCompression(Compression{Codec: CodecGzip})
+	if result, err := db.Recompress(context.Background(), true); err != nil || result.Blobs != 1 {
+		t.Errorf("Expected the blob re-encoded, got %+v, %v", result, err)
+	}
+	if codec, _ := storedBlob(t, db); codec != "gzip" {
+		t.Errorf("Expected gzip after recompressing, got %q", codec)
+	}
+
+	stored, err := db.QueryEvents(context.Background(), EventFilter{})
+	if err != nil {
+		t.Fatalf("Failed to query events: %v", err)
+	}
+	if stored[0].Data["text"] != text {
+		t.Error("Expected text unchanged after recompression")
+	}
+}
+
+func TestTrainDictionary(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "browsetrace-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	db, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer func() { db.Close() }()
+
+	if _, err := db.TrainDictionary(context.Background()); err == nil {
+		t.Error("Expected an error without enough text to train on")
+	}
+
+	var events []models.Event
+	for i := 0; i < 2*minimumSamples; i++ {
+		events = append(events, visibleText(int64(1000+i), fmt.Sprintf("https://example.com/%d", i), prose(int64(i), 8000)))
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	hash, err := db.TrainDictionary(context.Background())
+	if err != nil {
+		t.Fatalf("TrainDictionary() error = %v", err)
+	}
+
+	// A reopened database picks the dictionary up again.
+	db.Close()
+	if db, err = NewDatabase(dbPath); err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	db.SetCompression(Compression{Codec: CodecZstd})
+	text := prose(1000, 8000)
+	if err := db.InsertEvents([]models.Event{visibleText(5000, "https://example.com/new", text)}); err != nil {
+		t.Fatalf("Failed to insert event: %v", err)
+	}
+	if codec, _ := storedBlob(t, db); codec != "zstd:"+hash {
+		t.Errorf("Expected the trained dictionary to be used, got codec %q", codec)
+	}
+	stored, err := db.QueryEvents(context.Background(), EventFilter{URL: "https://example.com/new"})
+	if err != nil {
+		t.Fatalf("Failed to query events: %v", err)
+	}
+	if len(stored) != 1 || stored[0].Data["text"] != text {
+		t.Error("Expected dictionary-compressed text to read back unchanged")
+	}
+}
+
+// BenchmarkTextCompression inserts and reads back visible_text snapshots with
+// each codec. stored-bytes/op is what one snapshot costs on disk.
+func BenchmarkTextCompression(b *testing.B) {
+	const pageSize = 50000
+	for _, codec := range []Codec{CodecNone, CodecGzip, CodecZstd} {
+		b.Run(string(codec), func(b *testing.B) {
+			db, cleanup := setupTestDB(b)
+			defer cleanup()
+			db.SetCompression(Compression{Codec: codec})
+
+			pages := make([]string, 16)
+			for i := range pages {
+				pages[i] = prose(int64(i), pageSize)
+			}
+			b.SetBytes(pageSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// A fresh suffix keeps every snapshot distinct.
+				text := pages[i%len(pages)] + fmt.Sprint(i)
+				if err := db.InsertEvents([]models.Event{visibleText(int64(i+1), "https://example.com", text)}); err != nil {
+					b.Fatalf("Failed to insert event: %v", err)
+				}
+			}
+			if _, err := db.QueryEvents(context.Background(), EventFilter{}); err != nil {
+				b.Fatalf("Failed to read events: %v", err)
+			}
+			b.StopTimer()
+
+			var stored int64
+			if err := db.db.QueryRow(`SELECT SUM(LENGTH(CAST(content AS BLOB))) FROM text_blobs`).Scan(&stored); err != nil {
+				b.Fatalf("Failed to measure storage: %v", err)
+			}
+			b.ReportMetric(float64(stored)/float64(b.N), "stored-bytes/op")
+		})
+	}
+}
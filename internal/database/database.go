@@ -1,22 +1,46 @@
 package database
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/vincentbai/browsetrace-agent/internal/encryption"
 	"github.com/vincentbai/browsetrace-agent/internal/models"
 	_ "modernc.org/sqlite" // CGO-free SQLite
 )
 
 type Database struct {
-	db              *sql.DB
-	validEventTypes map[string]bool
+	db             *sql.DB
+	insertHooks    []InsertHook
+	commitHooks    []CommitHook
+	deleteHooks    []DeleteHook
+	normalizeURL   URLNormalizer
+	textDiffs      bool
+	compression    Compression
+	dictionary     string // hash of the zstd dictionary for new text, if any
+	key            *encryption.Key
+	reencryptHooks []ReencryptHook
+	deviceID       string
 }
 
+// URLNormalizer maps a raw URL to the canonical form stored in canonical_url.
+type URLNormalizer func(rawURL string) string
+
+// InsertHook maintains data derived from newly stored events. It runs inside
+// the insert transaction, so an error rolls back the whole batch.
+type InsertHook func(transaction *sql.Tx, events []models.StoredEvent) error
+
+// CommitHook reacts to newly recorded events once they are committed. It runs
+// for InsertEvents only: imports and merges bring in history, not activity.
+type CommitHook func(events []models.StoredEvent)
+
 func NewDatabase(databasePath string) (*Database, error) {
-	// WAL + busy timeout to avoid "database is locked"
-	db, err := sql.Open("sqlite", databasePath+"?_journal_mode=WAL&_busy_timeout=5000")
+	// WAL + busy timeout to avoid "database is locked", so that readers such
+	// as backups run alongside inserts. The driver only applies settings
+	// given as _pragma parameters, on every new connection.
+	db, err := sql.Open("sqlite", databasePath+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -26,17 +50,20 @@ func NewDatabase(databasePath string) (*Database, error) {
 		return nil, err
 	}
 
-	return &Database{
-		db: db,
-		validEventTypes: map[string]bool{
-			"navigate":     true,
-			"visible_text": true,
-			"click":        true,
-			"input":        true,
-			"scroll":       true,
-			"focus":        true,
-		},
-	}, nil
+	d := &Database{db: db}
+	if err := d.loadDictionaries(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := db.QueryRow(`SELECT value FROM sync_meta WHERE name = 'device_id'`).Scan(&d.deviceID); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read device ID: %w", err)
+	}
+	if err := d.backfillLocalDates(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
 }
 
 func createTables(db *sql.DB) error {
@@ -57,26 +84,373 @@ func createTables(db *sql.DB) error {
 	if err != nil {
 		return fmt.Errorf("failed to create database tables: %w", err)
 	}
+	return migrate(db)
+}
+
+// migrations evolve the schema created by createTables. Entry i brings the
+// database from user_version i to i+1; append new steps, never edit old ones.
+var migrations = []string{
+	// 1: tab, window, frame, profile and client identity
+	`
+	ALTER TABLE events ADD COLUMN tab_id    INTEGER;
+	ALTER TABLE events ADD COLUMN window_id INTEGER;
+	ALTER TABLE events ADD COLUMN frame_id  INTEGER;
+	ALTER TABLE events ADD COLUMN profile   TEXT;
+	ALTER TABLE events ADD COLUMN client_id TEXT;
+	CREATE INDEX IF NOT EXISTS idx_events_tab     ON events(tab_id, ts_utc);
+	CREATE INDEX IF NOT EXISTS idx_events_window  ON events(window_id, ts_utc);
+	CREATE INDEX IF NOT EXISTS idx_events_profile ON events(profile);
+	CREATE INDEX IF NOT EXISTS idx_events_client  ON events(client_id);
+	`,
+	// 2: canonical URL alongside the raw one; NULL until backfilled
+	`
+	ALTER TABLE events ADD COLUMN canonical_url TEXT;
+	CREATE INDEX IF NOT EXISTS idx_events_canonical_url ON events(canonical_url);
+	`,
+	// 3: visible_text page text in content-addressed blobs (see text.go)
+	`
+	CREATE TABLE IF NOT EXISTS text_blobs(
+	  hash      TEXT    PRIMARY KEY,
+	  base_hash TEXT,
+	  content   TEXT    NOT NULL,
+	  length    INTEGER NOT NULL,
+	  depth     INTEGER NOT NULL
+	);
+	ALTER TABLE events ADD COLUMN text_hash TEXT;
+	CREATE INDEX IF NOT EXISTS idx_events_text_hash ON events(text_hash);
+	`,
+	// 4: compressed text blobs (see compress.go)
+	`
+	ALTER TABLE text_blobs ADD COLUMN codec TEXT NOT NULL DEFAULT '';
+	CREATE TABLE IF NOT EXISTS compression_dictionaries(
+	  hash       TEXT    PRIMARY KEY,
+	  dictionary BLOB    NOT NULL,
+	  created_ts INTEGER NOT NULL
+	);
+	`,
+	// 5: encryption key ID and passphrase salt (see encrypt.go)
+	`
+	CREATE TABLE IF NOT EXISTS encryption_meta(
+	  name  TEXT PRIMARY KEY,
+	  value BLOB NOT NULL
+	);
+	`,
+	// 6: globally unique event IDs, change sequence and tombstones for
+	// multi-device sync (see sync.go)
+	`
+	CREATE TABLE IF NOT EXISTS sync_meta(
+	  name  TEXT PRIMARY KEY,
+	  value NOT NULL
+	);
+	INSERT INTO sync_meta(name, value) VALUES('device_id', lower(hex(randomblob(8)))), ('seq', 1);
+	ALTER TABLE events ADD COLUMN uid       TEXT;
+	ALTER TABLE events ADD COLUMN device_id TEXT;
+	ALTER TABLE events ADD COLUMN seq       INTEGER NOT NULL DEFAULT 0;
+	UPDATE events SET uid = lower(hex(randomblob(16))), device_id = (SELECT value FROM sync_meta WHERE name = 'device_id'), seq = 1;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_events_uid ON events(uid);
+	CREATE INDEX IF NOT EXISTS idx_events_seq ON events(seq);
+	CREATE TABLE IF NOT EXISTS tombstones(
+	  uid        TEXT    PRIMARY KEY,
+	  device_id  TEXT    NOT NULL,
+	  deleted_ts INTEGER NOT NULL,
+	  seq        INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_tombstones_seq ON tombstones(seq);
+	`,
+	// 7: change numbers on the originating device and version vectors for
+	// peer-to-peer sync. Events merged from bundles before this migration
+	// have no origin number and are only sent again by bundles.
+	`
+	ALTER TABLE events     ADD COLUMN origin_seq INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE tombstones ADD COLUMN origin_seq INTEGER NOT NULL DEFAULT 0;
+	UPDATE events     SET origin_seq = seq WHERE device_id = (SELECT value FROM sync_meta WHERE name = 'device_id');
+	UPDATE tombstones SET origin_seq = seq WHERE device_id = (SELECT value FROM sync_meta WHERE name = 'device_id');
+	CREATE INDEX IF NOT EXISTS idx_events_origin     ON events(device_id, origin_seq);
+	CREATE INDEX IF NOT EXISTS idx_tombstones_origin ON tombstones(device_id, origin_seq);
+	CREATE TABLE IF NOT EXISTS sync_vector(
+	  device_id TEXT    PRIMARY KEY,
+	  seq       INTEGER NOT NULL
+	);
+	`,
+	// 8: when the agent received each event, to tell client clock skew from
+	// delivery delay. Unknown for events stored before.
+	`
+	ALTER TABLE events ADD COLUMN received_ts_utc INTEGER;
+	`,
+	// 9: the client's time zone and the local day each event happened on;
+	// NULL until backfilled (see localdate.go)
+	`
+	ALTER TABLE events ADD COLUMN timezone           TEXT;
+	ALTER TABLE events ADD COLUMN utc_offset_minutes INTEGER;
+	ALTER TABLE events ADD COLUMN local_date         TEXT;
+	CREATE INDEX IF NOT EXISTS idx_events_local_date ON events(local_date);
+	`,
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	for ; version < len(migrations); version++ {
+		transaction, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration: %w", err)
+		}
+		if _, err := transaction.Exec(migrations[version]); err != nil {
+			_ = transaction.Rollback()
+			return fmt.Errorf("failed to apply migration %d: %w", version+1, err)
+		}
+		if _, err := transaction.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			_ = transaction.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", version+1, err)
+		}
+		if err := transaction.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", version+1, err)
+		}
+	}
+	return nil
+}
+
+// maxIdentityLength bounds the free-form profile and client_id fields.
+const maxIdentityLength = 256
+
+// UTC offsets in use run from -12:00 to +14:00.
+const (
+	minUTCOffsetMinutes = -12 * 60
+	maxUTCOffsetMinutes = 14 * 60
+)
+
+const insertEventSQL = `INSERT INTO events(ts_utc, ts_iso, url, title, type, data_json, tab_id, window_id, frame_id, profile, client_id, canonical_url, text_hash, ` +
+	`uid, device_id, seq, origin_seq, received_ts_utc, timezone, utc_offset_minutes, local_date) VALUES(?,?,?,?,?,json(?),?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`
+
+// insertEvent stores event as part of change seq, deriving ts_iso if it has
+// none. A new event gets a fresh UID, this device's ID, seq as its origin
+// number, the current time as its receive time and its local date; one merged
+// from another device keeps its own, passed in origin.
+func (d *Database) insertEvent(transaction *sql.Tx, statement *sql.Stmt, event models.Event, origin models.StoredEvent, seq int64) (models.StoredEvent, error) {
+	event.TSISO = event.ISOTimestamp()
+	stored := models.StoredEvent{Event: event, CanonicalURL: d.canonicalURL(event.URL),
+		UID: origin.UID, DeviceID: origin.DeviceID, OriginSeq: origin.OriginSeq, ReceivedTSUTC: origin.ReceivedTSUTC,
+		LocalDate: origin.LocalDate}
+	if stored.LocalDate == "" {
+		stored.LocalDate = event.LocalDate(models.DefaultLocation)
+	}
+	if stored.UID == "" {
+		uid, err := newUID()
+		if err != nil {
+			return stored, err
+		}
+		stored.UID, stored.DeviceID, stored.OriginSeq = uid, d.deviceID, seq
+		stored.ReceivedTSUTC = time.Now().UnixMilli()
+	}
+	var received *int64
+	if stored.ReceivedTSUTC != 0 {
+		received = &stored.ReceivedTSUTC
+	}
+	jsonData, text, err := prepareData(event.Type, event.Data)
+	if err != nil {
+		return stored, fmt.Errorf("failed to marshal event data: %w", err)
+	}
+	var textHash *string
+	if text != nil {
+		hash, err := d.storeText(context.Background(), transaction, d.Seal(event.URL), *text)
+		if err != nil {
+			return stored, err
+		}
+		textHash = &hash
+	}
+	result, err := statement.Exec(event.TSUTC, event.TSISO, d.Seal(event.URL), d.sealTitle(event.Title), event.Type, d.sealData(jsonData),
+		event.TabID, event.WindowID, event.FrameID, event.Profile, event.ClientID, d.Seal(stored.CanonicalURL), textHash,
+		stored.UID, stored.DeviceID, seq, stored.OriginSeq, received, event.Timezone, event.UTCOffsetMinutes, stored.LocalDate)
+	if err != nil {
+		return stored, fmt.Errorf("failed to execute statement: %w", err)
+	}
+	if stored.ID, err = result.LastInsertId(); err != nil {
+		return stored, fmt.Errorf("failed to read event ID: %w", err)
+	}
+	return stored, nil
+}
+
+func (d *Database) runInsertHooks(transaction *sql.Tx, events []models.StoredEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	for _, hook := range d.insertHooks {
+		if err := hook(transaction, events); err != nil {
+			return fmt.Errorf("insert hook failed: %w", err)
+		}
+	}
 	return nil
 }
 
+// AddInsertHook registers hook to run for every successful insert. Register
+// hooks before the database is shared between goroutines.
+func (d *Database) AddInsertHook(hook InsertHook) {
+	d.insertHooks = append(d.insertHooks, hook)
+}
+
+// AddCommitHook registers hook to run after every committed InsertEvents.
+// Register hooks before the database is shared between goroutines.
+func (d *Database) AddCommitHook(hook CommitHook) {
+	d.commitHooks = append(d.commitHooks, hook)
+}
+
+// SetURLNormalizer sets how canonical_url is derived for new events. Without
+// one, canonical_url is a copy of url. Call CanonicalizeURLs afterwards to
+// bring existing rows in line.
+func (d *Database) SetURLNormalizer(normalize URLNormalizer) {
+	d.normalizeURL = normalize
+}
+
+func (d *Database) canonicalURL(rawURL string) string {
+	if d.normalizeURL == nil {
+		return rawURL
+	}
+	return d.normalizeURL(rawURL)
+}
+
+// CanonicalizeURLs fills in canonical_url for rows that lack one, such as rows
+// stored before the column existed. With all set it recomputes every row,
+// e.g. after the normalization rules changed. It returns how many rows changed.
+func (d *Database) CanonicalizeURLs(ctx context.Context, all bool) (int64, error) {
+	transaction, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	query := `SELECT DISTINCT url FROM events WHERE canonical_url IS NULL`
+	if all {
+		query = `SELECT DISTINCT url FROM events`
+	}
+	rows, err := transaction.QueryContext(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query URLs: %w", err)
+	}
+	var urls []string // as stored, so sealed when encrypted
+	for rows.Next() {
+		var storedURL string
+		if err := rows.Scan(&storedURL); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan URL: %w", err)
+		}
+		urls = append(urls, storedURL)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read URLs: %w", err)
+	}
+
+	statement, err := transaction.PrepareContext(ctx, `UPDATE events SET canonical_url = ? WHERE url = ? AND canonical_url IS NOT ?`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer statement.Close()
+	var changed int64
+	for _, storedURL := range urls {
+		rawURL, err := d.Unseal(storedURL)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt URL: %w", err)
+		}
+		canonical := d.Seal(d.canonicalURL(rawURL))
+		result, err := statement.ExecContext(ctx, canonical, storedURL, canonical)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update canonical URL: %w", err)
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to count updated rows: %w", err)
+		}
+		changed += count
+	}
+	if err := transaction.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return changed, nil
+}
+
+// DB exposes the underlying connection pool to packages that keep their own
+// tables alongside events.
+func (d *Database) DB() *sql.DB {
+	return d.db
+}
+
 func (d *Database) Close() error {
 	return d.db.Close()
 }
 
+// validEventTypes are the event types the extension sends.
+var validEventTypes = map[string]bool{
+	"navigate":     true,
+	"visible_text": true,
+	"click":        true,
+	"input":        true,
+	"scroll":       true,
+	"focus":        true,
+}
+
+// ReconcileISOTimestamp returns iso, an event's ts_iso, or if it does not
+// agree with milliseconds, its ts_utc, the ISO form of ts_utc. ts_utc is what
+// every query orders and filters by, so it is taken to be right. Imports and
+// merges bring in history recorded before ts_iso was checked, which is
+// repaired rather than refused.
+func ReconcileISOTimestamp(milliseconds int64, iso string) string {
+	if models.CheckISOTimestamp(milliseconds, iso) == nil {
+		return iso
+	}
+	return models.FormatTimestamp(milliseconds)
+}
+
 func (d *Database) ValidateEvent(event models.Event) error {
+	return ValidateEvent(event)
+}
+
+// ValidateEvent checks an incoming event. Every storage backend applies the
+// same rules.
+func ValidateEvent(event models.Event) error {
 	if event.URL == "" {
 		return fmt.Errorf("URL cannot be empty")
 	}
 	if event.Type == "" {
 		return fmt.Errorf("Type cannot be empty")
 	}
-	if !d.validEventTypes[event.Type] {
+	if !validEventTypes[event.Type] {
 		return fmt.Errorf("invalid event type: %s", event.Type)
 	}
 	if event.TSUTC <= 0 {
 		return fmt.Errorf("timestamp must be positive")
 	}
+	if err := models.CheckISOTimestamp(event.TSUTC, event.TSISO); err != nil {
+		return err
+	}
+	if event.TabID != nil && *event.TabID < 0 {
+		return fmt.Errorf("tab_id must be non-negative")
+	}
+	if event.WindowID != nil && *event.WindowID < 0 {
+		return fmt.Errorf("window_id must be non-negative")
+	}
+	if event.FrameID != nil && *event.FrameID < 0 {
+		return fmt.Errorf("frame_id must be non-negative")
+	}
+	if event.Profile != nil && (*event.Profile == "" || len(*event.Profile) > maxIdentityLength) {
+		return fmt.Errorf("profile must be between 1 and %d bytes", maxIdentityLength)
+	}
+	if event.ClientID != nil && (*event.ClientID == "" || len(*event.ClientID) > maxIdentityLength) {
+		return fmt.Errorf("client_id must be between 1 and %d bytes", maxIdentityLength)
+	}
+	if event.Timezone != nil {
+		// LoadLocation takes "" and "Local" to mean UTC and the agent's zone.
+		if *event.Timezone == "" || *event.Timezone == "Local" || len(*event.Timezone) > maxIdentityLength {
+			return fmt.Errorf("invalid timezone %q", *event.Timezone)
+		}
+		if _, err := time.LoadLocation(*event.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q", *event.Timezone)
+		}
+	}
+	if event.UTCOffsetMinutes != nil && (*event.UTCOffsetMinutes < minUTCOffsetMinutes || *event.UTCOffsetMinutes > maxUTCOffsetMinutes) {
+		return fmt.Errorf("utc_offset_minutes must be between %d and %d", minUTCOffsetMinutes, maxUTCOffsetMinutes)
+	}
 	return nil
 }
 
@@ -85,31 +459,99 @@ func (d *Database) InsertEvents(events []models.Event) error {
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	statement, err := transaction.Prepare(`INSERT INTO events(ts_utc, ts_iso, url, title, type, data_json) VALUES(?,?,?,?,?,json(?))`)
+	statement, err := transaction.Prepare(insertEventSQL)
 	if err != nil {
 		_ = transaction.Rollback()
 		return fmt.Errorf("failed to prepare statement: %w", err)
 	}
 	defer statement.Close()
+	seq, err := nextSeq(transaction)
+	if err != nil {
+		_ = transaction.Rollback()
+		return err
+	}
 
+	stored := make([]models.StoredEvent, 0, len(events))
 	for _, event := range events {
 		if err := d.ValidateEvent(event); err != nil {
 			_ = transaction.Rollback()
 			return fmt.Errorf("invalid event: %w", err)
 		}
-
-		jsonData, err := json.Marshal(event.Data)
+		storedEvent, err := d.insertEvent(transaction, statement, event, models.StoredEvent{}, seq)
 		if err != nil {
 			_ = transaction.Rollback()
-			return fmt.Errorf("failed to marshal event data: %w", err)
-		}
-		if _, err := statement.Exec(event.TSUTC, event.TSISO, event.URL, event.Title, event.Type, string(jsonData)); err != nil {
-			_ = transaction.Rollback()
-			return fmt.Errorf("failed to execute statement: %w", err)
+			return err
 		}
+		stored = append(stored, storedEvent)
+	}
+	if err := d.runInsertHooks(transaction, stored); err != nil {
+		_ = transaction.Rollback()
+		return err
 	}
 	if err := transaction.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	for _, hook := range d.commitHooks {
+		hook(stored)
+	}
 	return nil
 }
+
+// ImportEvents inserts events that are not already stored, treating rows with
+// the same ts_utc, url and type as duplicates. It returns how many were new.
+func (d *Database) ImportEvents(events []models.Event) (int, error) {
+	transaction, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	existsStatement, err := transaction.Prepare(`SELECT EXISTS(SELECT 1 FROM events WHERE ts_utc = ? AND url = ? AND type = ?)`)
+	if err != nil {
+		_ = transaction.Rollback()
+		return 0, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer existsStatement.Close()
+	insertStatement, err := transaction.Prepare(insertEventSQL)
+	if err != nil {
+		_ = transaction.Rollback()
+		return 0, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer insertStatement.Close()
+	seq, err := nextSeq(transaction)
+	if err != nil {
+		_ = transaction.Rollback()
+		return 0, err
+	}
+
+	var stored []models.StoredEvent
+	for _, event := range events {
+		event.TSISO = ReconcileISOTimestamp(event.TSUTC, event.TSISO)
+		if err := d.ValidateEvent(event); err != nil {
+			_ = transaction.Rollback()
+			return 0, fmt.Errorf("invalid event: %w", err)
+		}
+
+		var exists bool
+		if err := existsStatement.QueryRow(event.TSUTC, d.Seal(event.URL), event.Type).Scan(&exists); err != nil {
+			_ = transaction.Rollback()
+			return 0, fmt.Errorf("failed to check for duplicate: %w", err)
+		}
+		if exists {
+			continue
+		}
+
+		storedEvent, err := d.insertEvent(transaction, insertStatement, event, models.StoredEvent{}, seq)
+		if err != nil {
+			_ = transaction.Rollback()
+			return 0, err
+		}
+		stored = append(stored, storedEvent)
+	}
+	if err := d.runInsertHooks(transaction, stored); err != nil {
+		_ = transaction.Rollback()
+		return 0, err
+	}
+	if err := transaction.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(stored), nil
+}
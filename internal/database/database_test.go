@@ -1,14 +1,19 @@
 package database
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/vincentbai/browsetrace-agent/internal/models"
 )
 
-func setupTestDB(t *testing.T) (*Database, func()) {
+func setupTestDB(t testing.TB) (*Database, func()) {
 	t.Helper()
 
 	// Create temporary directory for test database
@@ -33,6 +38,14 @@ func setupTestDB(t *testing.T) (*Database, func()) {
 	return db, cleanup
 }
 
+func int64Pointer(value int64) *int64 {
+	return &value
+}
+
+func stringPointer(value string) *string {
+	return &value
+}
+
 func TestNewDatabase(t *testing.T) {
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -57,7 +70,7 @@ func TestValidateEvent(t *testing.T) {
 		{
 			name: "valid navigate event",
 			event: models.Event{
-				TSUTC: 1234567890,
+				TSUTC: 1234567890000,
 				TSISO: "2009-02-13T23:31:30Z",
 				URL:   "https://example.com",
 				Type:  "navigate",
@@ -68,7 +81,7 @@ func TestValidateEvent(t *testing.T) {
 		{
 			name: "empty URL",
 			event: models.Event{
-				TSUTC: 1234567890,
+				TSUTC: 1234567890000,
 				TSISO: "2009-02-13T23:31:30Z",
 				URL:   "",
 				Type:  "navigate",
@@ -79,7 +92,7 @@ func TestValidateEvent(t *testing.T) {
 		{
 			name: "empty type",
 			event: models.Event{
-				TSUTC: 1234567890,
+				TSUTC: 1234567890000,
 				TSISO: "2009-02-13T23:31:30Z",
 				URL:   "https://example.com",
 				Type:  "",
@@ -90,7 +103,7 @@ func TestValidateEvent(t *testing.T) {
 		{
 			name: "invalid event type",
 			event: models.Event{
-				TSUTC: 1234567890,
+				TSUTC: 1234567890000,
 				TSISO: "2009-02-13T23:31:30Z",
 				URL:   "https://example.com",
 				Type:  "invalid_type",
@@ -109,6 +122,46 @@ func TestValidateEvent(t *testing.T) {
 			},
 			wantError: true,
 		},
+		{
+			name: "valid identity fields",
+			event: models.Event{
+				TSUTC:    1234567890000,
+				TSISO:    "2009-02-13T23:31:30Z",
+				URL:      "https://example.com",
+				Type:     "click",
+				Data:     map[string]any{},
+				TabID:    int64Pointer(7),
+				WindowID: int64Pointer(1),
+				FrameID:  int64Pointer(0),
+				Profile:  stringPointer("Default"),
+				ClientID: stringPointer("laptop"),
+			},
+			wantError: false,
+		},
+		{
+			name: "negative tab ID",
+			event: models.Event{
+				TSUTC: 1234567890000,
+				TSISO: "2009-02-13T23:31:30Z",
+				URL:   "https://example.com",
+				Type:  "click",
+				Data:  map[string]any{},
+				TabID: int64Pointer(-1),
+			},
+			wantError: true,
+		},
+		{
+			name: "empty client ID",
+			event: models.Event{
+				TSUTC:    1234567890000,
+				TSISO:    "2009-02-13T23:31:30Z",
+				URL:      "https://example.com",
+				Type:     "click",
+				Data:     map[string]any{},
+				ClientID: stringPointer(""),
+			},
+			wantError: true,
+		},
 		{
 			name: "negative timestamp",
 			event: models.Event{
@@ -120,6 +173,28 @@ func TestValidateEvent(t *testing.T) {
 			},
 			wantError: true,
 		},
+		{
+			name: "ts_iso disagreeing with ts_utc",
+			event: models.Event{
+				TSUTC: 1234567890,
+				TSISO: "2009-02-13T23:31:30Z",
+				URL:   "https://example.com",
+				Type:  "navigate",
+				Data:  map[string]any{},
+			},
+			wantError: true,
+		},
+		{
+			name: "ts_iso not a timestamp",
+			event: models.Event{
+				TSUTC: 1234567890000,
+				TSISO: "Fri Feb 13 2009",
+				URL:   "https://example.com",
+				Type:  "navigate",
+				Data:  map[string]any{},
+			},
+			wantError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -139,7 +214,7 @@ func TestInsertEvents(t *testing.T) {
 	title := "Test Page"
 	events := []models.Event{
 		{
-			TSUTC: 1234567890,
+			TSUTC: 1234567890000,
 			TSISO: "2009-02-13T23:31:30Z",
 			URL:   "https://example.com",
 			Title: &title,
@@ -147,7 +222,7 @@ func TestInsertEvents(t *testing.T) {
 			Data:  map[string]any{"referrer": "https://google.com"},
 		},
 		{
-			TSUTC: 1234567891,
+			TSUTC: 1234567891000,
 			TSISO: "2009-02-13T23:31:31Z",
 			URL:   "https://example.com/page2",
 			Title: nil,
@@ -179,9 +254,9 @@ func TestInsertEventsInvalidEvent(t *testing.T) {
 
 	events := []models.Event{
 		{
-			TSUTC: 1234567890,
+			TSUTC: 1234567890000,
 			TSISO: "2009-02-13T23:31:30Z",
-			URL:   "",  // Invalid: empty URL
+			URL:   "", // Invalid: empty URL
 			Type:  "navigate",
 			Data:  map[string]any{},
 		},
@@ -214,7 +289,7 @@ func TestAllEventTypes(t *testing.T) {
 		t.Run(eventType, func(t *testing.T) {
 			events := []models.Event{
 				{
-					TSUTC: 1234567890,
+					TSUTC: 1234567890000,
 					TSISO: "2009-02-13T23:31:30Z",
 					URL:   "https://example.com",
 					Type:  eventType,
@@ -247,13 +322,13 @@ func TestInsertEventsWithComplexData(t *testing.T) {
 
 	events := []models.Event{
 		{
-			TSUTC: 1234567890,
+			TSUTC: 1234567890000,
 			TSISO: "2009-02-13T23:31:30Z",
 			URL:   "https://example.com",
 			Type:  "input",
 			Data: map[string]any{
-				"field":  "email",
-				"value":  "test@example.com",
+				"field": "email",
+				"value": "test@example.com",
 				"nested": map[string]any{
 					"foo": "bar",
 					"baz": 123,
@@ -288,3 +363,282 @@ func TestDatabaseClose(t *testing.T) {
 		t.Errorf("Failed to close database: %v", err)
 	}
 }
+
+func TestImportEventsSkipsDuplicates(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01.000Z", URL: "https://example.com", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02.000Z", URL: "https://example.com", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02.000Z", URL: "https://example.com", Type: "navigate", Data: map[string]any{}},
+	}
+
+	inserted, err := db.ImportEvents(events)
+	if err != nil {
+		t.Fatalf("Failed to import events: %v", err)
+	}
+	if inserted != 2 {
+		t.Errorf("Expected 2 new events on first import, got %d", inserted)
+	}
+
+	inserted, err = db.ImportEvents(events)
+	if err != nil {
+		t.Fatalf("Failed to re-import events: %v", err)
+	}
+	if inserted != 0 {
+		t.Errorf("Expected 0 new events on re-import, got %d", inserted)
+	}
+
+	var count int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM events").Scan(&count); err != nil {
+		t.Fatalf("Failed to query count: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 events, got %d", count)
+	}
+}
+
+func TestReceivedTimestamps(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	before := time.Now().UnixMilli()
+	events := []models.Event{{TSUTC: 1000, TSISO: "1970-01-01T00:00:01.000Z", URL: "https://example.com/a", Type: "navigate", Data: map[string]any{}}}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	// History imported from elsewhere gets a ts_iso that agrees with ts_utc.
+	imported := []models.Event{{TSUTC: 2000, TSISO: "1970-01-01T01:00:00Z", URL: "https://example.com/b", Type: "navigate", Data: map[string]any{}}}
+	if _, err := db.ImportEvents(imported); err != nil {
+		t.Fatalf("Failed to import events: %v", err)
+	}
+
+	stored, err := db.QueryEvents(context.Background(), EventFilter{})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(stored))
+	}
+	for _, event := range stored {
+		if event.ReceivedTSUTC < before || event.ReceivedTSUTC > time.Now().UnixMilli() {
+			t.Errorf("Expected the receive time to be now, got %d", event.ReceivedTSUTC)
+		}
+	}
+	if stored[1].TSISO != "1970-01-01T00:00:02.000Z" {
+		t.Errorf("Expected the imported ts_iso to be reconciled, got %s", stored[1].TSISO)
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "browsetrace-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dbPath := filepath.Join(tmpDir, "legacy.db")
+
+	// Schema as created before identity columns existed.
+	legacy, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open legacy database: %v", err)
+	}
+	_, err = legacy.Exec(`
+	CREATE TABLE events(
+	  id        INTEGER PRIMARY KEY,
+	  ts_utc    INTEGER NOT NULL,
+	  ts_iso    TEXT    NOT NULL,
+	  url       TEXT    NOT NULL,
+	  title     TEXT,
+	  type      TEXT    NOT NULL CHECK (type IN ('navigate','visible_text','click','input','scroll','focus')),
+	  data_json TEXT    NOT NULL CHECK (json_valid(data_json))
+	);
+	INSERT INTO events(ts_utc, ts_iso, url, type, data_json) VALUES (1000, '1970-01-01T00:00:01.000Z', 'https://example.com', 'navigate', '{}');
+	`)
+	legacy.Close()
+	if err != nil {
+		t.Fatalf("Failed to create legacy schema: %v", err)
+	}
+
+	db, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open legacy database: %v", err)
+	}
+	defer func() { db.Close() }()
+
+	var version int
+	if err := db.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatalf("Failed to read user_version: %v", err)
+	}
+	if version != len(migrations) {
+		t.Errorf("Expected user_version %d, got %d", len(migrations), version)
+	}
+
+	var tabID sql.NullInt64
+	if err := db.db.QueryRow(`SELECT tab_id FROM events WHERE id = 1`).Scan(&tabID); err != nil {
+		t.Fatalf("Failed to read migrated row: %v", err)
+	}
+	if tabID.Valid {
+		t.Errorf("Expected NULL tab_id for legacy row, got %d", tabID.Int64)
+	}
+
+	// Legacy rows get their canonical URL from the backfill.
+	changed, err := db.CanonicalizeURLs(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Failed to backfill canonical URLs: %v", err)
+	}
+	var canonicalURL sql.NullString
+	if err := db.db.QueryRow(`SELECT canonical_url FROM events WHERE id = 1`).Scan(&canonicalURL); err != nil {
+		t.Fatalf("Failed to read backfilled row: %v", err)
+	}
+	if changed != 1 || canonicalURL.String != "https://example.com" {
+		t.Errorf("Expected 1 row backfilled to the raw URL, got %d, %q", changed, canonicalURL.String)
+	}
+
+	// Reopening must not re-run migrations.
+	db.Close()
+	db, err = NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen migrated database: %v", err)
+	}
+}
+
+func TestInsertHooks(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var seen []models.StoredEvent
+	db.AddInsertHook(func(transaction *sql.Tx, events []models.StoredEvent) error {
+		seen = append(seen, events...)
+		return nil
+	})
+
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01.000Z", URL: "https://example.com", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02.000Z", URL: "https://example.com", Type: "click", Data: map[string]any{}},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	if _, err := db.ImportEvents(events); err != nil {
+		t.Fatalf("Failed to import events: %v", err)
+	}
+
+	// The import was all duplicates, so the hook only saw the first batch.
+	if len(seen) != 2 {
+		t.Fatalf("Expected hook to see 2 events, got %d", len(seen))
+	}
+	if seen[0].ID != 1 || seen[1].ID != 2 || seen[1].Type != "click" {
+		t.Errorf("Unexpected events passed to hook: %+v", seen)
+	}
+}
+
+func TestInsertHookErrorRollsBack(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	db.AddInsertHook(func(*sql.Tx, []models.StoredEvent) error {
+		return errors.New("derived table unavailable")
+	})
+
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01.000Z", URL: "https://example.com", Type: "navigate", Data: map[string]any{}},
+	}
+	if err := db.InsertEvents(events); err == nil {
+		t.Fatal("Expected hook error to fail the insert")
+	}
+
+	var count int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM events").Scan(&count); err != nil {
+		t.Fatalf("Failed to query count: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected 0 events after rollback, got %d", count)
+	}
+}
+
+func TestCommitHooks(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var seen []models.StoredEvent
+	var stored int
+	db.AddCommitHook(func(events []models.StoredEvent) {
+		seen = append(seen, events...)
+		// The events are visible to other connections by now.
+		if err := db.db.QueryRow("SELECT COUNT(*) FROM events").Scan(&stored); err != nil {
+			t.Errorf("Failed to query count: %v", err)
+		}
+	})
+
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01.000Z", URL: "https://example.com", Type: "navigate", Data: map[string]any{}},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	if _, err := db.ImportEvents([]models.Event{{TSUTC: 500, URL: "https://example.com", Type: "navigate"}}); err != nil {
+		t.Fatalf("Failed to import events: %v", err)
+	}
+	if err := db.InsertEvents([]models.Event{{TSUTC: 2000, URL: "https://example.com", Type: "hover"}}); err == nil {
+		t.Fatal("Expected an invalid event to fail the insert")
+	}
+
+	if len(seen) != 1 || seen[0].TSUTC != 1000 || stored != 1 {
+		t.Errorf("Expected the hook to see only the committed insert, got %+v with %d stored", seen, stored)
+	}
+}
+
+func TestCanonicalURLs(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	stripQuery := func(rawURL string) string {
+		before, _, _ := strings.Cut(rawURL, "?")
+		return before
+	}
+	db.SetURLNormalizer(stripQuery)
+
+	var seen []models.StoredEvent
+	db.AddInsertHook(func(transaction *sql.Tx, events []models.StoredEvent) error {
+		seen = append(seen, events...)
+		return nil
+	})
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01.000Z", URL: "https://example.com/a?utm_source=x", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02.000Z", URL: "https://example.com/a?fbclid=y", Type: "navigate", Data: map[string]any{}},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	if len(seen) != 2 || seen[0].CanonicalURL != "https://example.com/a" {
+		t.Errorf("Expected hooks to see canonical URLs, got %+v", seen)
+	}
+
+	stored, err := db.QueryEvents(context.Background(), EventFilter{Canonical: "https://example.com/a"})
+	if err != nil {
+		t.Fatalf("Failed to query events: %v", err)
+	}
+	if len(stored) != 2 || stored[1].URL != "https://example.com/a?fbclid=y" {
+		t.Fatalf("Expected both variants with raw URLs preserved, got %+v", stored)
+	}
+
+	// Nothing is missing, so a plain backfill changes nothing; new rules
+	// apply to every row when all is set.
+	if changed, err := db.CanonicalizeURLs(context.Background(), false); err != nil || changed != 0 {
+		t.Errorf("Expected no rows to backfill, got %d, %v", changed, err)
+	}
+	db.SetURLNormalizer(strings.ToUpper)
+	changed, err := db.CanonicalizeURLs(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Failed to recompute canonical URLs: %v", err)
+	}
+	if changed != 2 {
+		t.Errorf("Expected 2 rows updated, got %d", changed)
+	}
+	stored, err = db.QueryEvents(context.Background(), EventFilter{Canonical: "HTTPS://EXAMPLE.COM/A?FBCLID=Y"})
+	if err != nil || len(stored) != 1 {
+		t.Errorf("Expected 1 event under the new canonical URL, got %d, %v", len(stored), err)
+	}
+}
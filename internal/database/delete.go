@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// DeleteHook brings data derived from events up to date after some were
+// deleted. It runs inside the delete transaction.
+type DeleteHook func(ctx context.Context, transaction *sql.Tx) error
+
+// AddDeleteHook registers hook to run after every delete that removed events.
+// Register hooks before the database is shared between goroutines.
+func (d *Database) AddDeleteHook(hook DeleteHook) {
+	d.deleteHooks = append(d.deleteHooks, hook)
+}
+
+// deleteChunk bounds the IDs bound to one DELETE statement.
+const deleteChunk = 500
+
+// DeleteEvents deletes the events matching filter and returns how many there
+// were. Page text no remaining event refers to is deleted with them, except
+// for snapshots that newer text is stored as a diff of. Each deleted event
+// leaves a tombstone for sync. A filter with a limit, or one that can only be
+// applied in Go on an encrypted database, first selects the events to delete.
+func (d *Database) DeleteEvents(ctx context.Context, filter EventFilter) (int64, error) {
+	var ids []int64
+	selectFirst := filter.Limit > 0 || filter.postFiltered(d)
+	if selectFirst {
+		err := d.StreamEvents(ctx, filter, func(event models.StoredEvent) error {
+			ids = append(ids, event.ID)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		if len(ids) == 0 {
+			return 0, nil
+		}
+	}
+
+	transaction, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	seq, err := nextSeq(transaction)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UnixMilli()
+	var deleted int64
+	if selectFirst {
+		for start := 0; start < len(ids); start += deleteChunk {
+			chunk := ids[start:min(start+deleteChunk, len(ids))]
+			args := make([]any, len(chunk))
+			for i, id := range chunk {
+				args[i] = id
+			}
+			placeholders := strings.TrimSuffix(strings.Repeat("?,", len(chunk)), ",")
+			count, err := d.deleteWhere(ctx, transaction, ` WHERE id IN (`+placeholders+`)`, args, seq, now)
+			if err != nil {
+				return 0, err
+			}
+			deleted += count
+		}
+	} else {
+		where, args := filter.whereClause(d)
+		if deleted, err = d.deleteWhere(ctx, transaction, where, args, seq, now); err != nil {
+			return 0, err
+		}
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+
+	if err := deleteOrphanedText(ctx, transaction); err != nil {
+		return 0, err
+	}
+	for _, hook := range d.deleteHooks {
+		if err := hook(ctx, transaction); err != nil {
+			return 0, fmt.Errorf("delete hook failed: %w", err)
+		}
+	}
+	if err := transaction.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return deleted, nil
+}
+
+// deleteWhere deletes the events where selects, leaving a tombstone for each
+// so that other devices delete their copies when they sync.
+func (d *Database) deleteWhere(ctx context.Context, transaction *sql.Tx, where string, args []any, seq, now int64) (int64, error) {
+	tombstoneArgs := append([]any{d.deviceID, now, seq, seq}, args...)
+	_, err := transaction.ExecContext(ctx, `INSERT OR IGNORE INTO tombstones(uid, device_id, deleted_ts, seq, origin_seq) SELECT uid, ?, ?, ?, ? FROM events`+where,
+		tombstoneArgs...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record deletions: %w", err)
+	}
+	result, err := transaction.ExecContext(ctx, `DELETE FROM events`+where, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete events: %w", err)
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted, nil
+}
+
+// deleteOrphanedText deletes text blobs that neither an event nor another blob
+// refers to, repeating until the chains of diffs are trimmed.
+func deleteOrphanedText(ctx context.Context, transaction *sql.Tx) error {
+	for {
+		result, err := transaction.ExecContext(ctx, `
+		DELETE FROM text_blobs
+		WHERE hash NOT IN (SELECT text_hash FROM events WHERE text_hash IS NOT NULL)
+		  AND hash NOT IN (SELECT base_hash FROM text_blobs WHERE base_hash IS NOT NULL)`)
+		if err != nil {
+			return fmt.Errorf("failed to delete unused text: %w", err)
+		}
+		if count, _ := result.RowsAffected(); count == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func TestDeleteEvents(t *testing.T) {
+	tests := []struct {
+		name        string
+		filter      EventFilter
+		wantDeleted int64
+		wantIDs     []int64
+	}{
+		{"by url", EventFilter{URL: "https://example.com/a"}, 2, []int64{3, 4}},
+		{"by prefix", EventFilter{URLPrefix: "https://example.com/"}, 3, []int64{3}},
+		{"by type and time", EventFilter{Types: []string{"navigate"}, Since: 2000}, 1, []int64{1, 2, 4}},
+		{"oldest first with limit", EventFilter{Limit: 1}, 1, []int64{2, 3, 4}},
+		{"newest first with limit", EventFilter{Newest: true, Limit: 2}, 2, []int64{1, 2}},
+		{"nothing matches", EventFilter{URL: "https://nowhere.test/"}, 0, []int64{1, 2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, cleanup := setupTestDB(t)
+			defer cleanup()
+			insertQueryFixtures(t, db)
+
+			deleted, err := db.DeleteEvents(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("DeleteEvents() error = %v", err)
+			}
+			if deleted != tt.wantDeleted {
+				t.Errorf("Expected %d deleted, got %d", tt.wantDeleted, deleted)
+			}
+			remaining, err := db.QueryEvents(context.Background(), EventFilter{})
+			if err != nil {
+				t.Fatalf("QueryEvents() error = %v", err)
+			}
+			if len(remaining) != len(tt.wantIDs) {
+				t.Fatalf("Expected %d remaining events, got %d", len(tt.wantIDs), len(remaining))
+			}
+			for i, event := range remaining {
+				if event.ID != tt.wantIDs[i] {
+					t.Errorf("Event %d: expected ID %d, got %d", i, tt.wantIDs[i], event.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteEventsEncrypted(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	if _, err := db.Reencrypt(context.Background(), testKey(t, 1)); err != nil {
+		t.Fatalf("Reencrypt() error = %v", err)
+	}
+	insertQueryFixtures(t, db)
+
+	// A prefix cannot be matched in SQL on sealed URLs.
+	deleted, err := db.DeleteEvents(context.Background(), EventFilter{URLPrefix: "https://example.com/"})
+	if err != nil {
+		t.Fatalf("DeleteEvents() error = %v", err)
+	}
+	if deleted != 3 {
+		t.Errorf("Expected 3 deleted, got %d", deleted)
+	}
+	remaining, err := db.QueryEvents(context.Background(), EventFilter{})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if len(remaining) != 1 || remaining[0].URL != "https://other.org/" {
+		t.Errorf("Expected only https://other.org/ left, got %+v", remaining)
+	}
+}
+
+func TestDeleteEventsRemovesUnusedText(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	db.SetTextDiffs(true)
+
+	events := []models.Event{
+		visibleText(1000, "https://example.com/a", pageLines(0, 30)),
+		visibleText(2000, "https://example.com/a", pageLines(1, 31)),
+		visibleText(3000, "https://example.com/b", pageLines(100, 130)),
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	if full, diffs := countBlobs(t, db); full != 2 || diffs != 1 {
+		t.Fatalf("Expected 2 full snapshots and 1 diff, got %d and %d", full, diffs)
+	}
+
+	// The first snapshot is the base of the second, so it stays.
+	if _, err := db.DeleteEvents(context.Background(), EventFilter{Until: 2000}); err != nil {
+		t.Fatalf("DeleteEvents() error = %v", err)
+	}
+	if full, diffs := countBlobs(t, db); full != 2 || diffs != 1 {
+		t.Errorf("Expected the base snapshot kept, got %d full and %d diffs", full, diffs)
+	}
+	stored, err := db.QueryEvents(context.Background(), EventFilter{URL: "https://example.com/a"})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if len(stored) != 1 || stored[0].Data["text"] != pageLines(1, 31) {
+		t.Errorf("Expected the remaining snapshot reassembled, got %+v", stored)
+	}
+
+	if _, err := db.DeleteEvents(context.Background(), EventFilter{URL: "https://example.com/a"}); err != nil {
+		t.Fatalf("DeleteEvents() error = %v", err)
+	}
+	if full, diffs := countBlobs(t, db); full != 1 || diffs != 0 {
+		t.Errorf("Expected only the other page's text left, got %d full and %d diffs", full, diffs)
+	}
+}
+
+func TestDeleteHooks(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	insertQueryFixtures(t, db)
+
+	var calls int
+	var remaining int
+	db.AddDeleteHook(func(ctx context.Context, transaction *sql.Tx) error {
+		calls++
+		return transaction.QueryRowContext(ctx, `SELECT COUNT(*) FROM events`).Scan(&remaining)
+	})
+	if _, err := db.DeleteEvents(context.Background(), EventFilter{URL: "https://nowhere.test/"}); err != nil {
+		t.Fatalf("DeleteEvents() error = %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("Expected no hook call when nothing was deleted, got %d", calls)
+	}
+	if _, err := db.DeleteEvents(context.Background(), EventFilter{Types: []string{"click"}}); err != nil {
+		t.Fatalf("DeleteEvents() error = %v", err)
+	}
+	if calls != 1 || remaining != 3 {
+		t.Errorf("Expected one hook call seeing 3 events, got %d calls seeing %d", calls, remaining)
+	}
+}
@@ -0,0 +1,350 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vincentbai/browsetrace-agent/internal/encryption"
+)
+
+// With an encryption key set, the url, title, data_json and canonical_url
+// columns of events, page text in text_blobs and trained compression
+// dictionaries are sealed before they are written (see package encryption).
+// data_json holds the sealed JSON as a JSON string so that it stays valid
+// JSON. Sealing is deterministic, so equality filters and grouping still run
+// in SQL; prefix and substring filters run in Go after decryption.
+//
+// The encryption_meta table records the ID of the key the database is
+// encrypted with, so that a missing or wrong key is caught at startup rather
+// than on the first read. Timestamps, event types, tab and client identity
+// and text hashes are not encrypted.
+
+var (
+	ErrEncrypted    = errors.New("database is encrypted and no key was given")
+	ErrNotEncrypted = errors.New("database is not encrypted")
+)
+
+// ReencryptHook rewrites values a package sealed into its own tables when the
+// key changes. reseal decrypts a value sealed with the old key (or stored in
+// plaintext) and seals it with the new one. It runs inside the rekey
+// transaction.
+type ReencryptHook func(transaction *sql.Tx, reseal func(string) (string, error)) error
+
+// AddReencryptHook registers hook to run whenever Reencrypt changes the key.
+func (d *Database) AddReencryptHook(hook ReencryptHook) {
+	d.reencryptHooks = append(d.reencryptHooks, hook)
+}
+
+// EncryptionKeyID returns the ID of the key the database is encrypted with,
+// or "" for a plaintext database.
+func (d *Database) EncryptionKeyID(ctx context.Context) (string, error) {
+	var keyID string
+	err := d.db.QueryRowContext(ctx, `SELECT value FROM encryption_meta WHERE name = 'key_id'`).Scan(&keyID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read encryption key ID: %w", err)
+	}
+	return keyID, nil
+}
+
+// EncryptionSalt returns the salt passphrases for this database are
+// stretched with, creating it on first use.
+func (d *Database) EncryptionSalt(ctx context.Context) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	if _, err := d.db.ExecContext(ctx, `INSERT OR IGNORE INTO encryption_meta(name, value) VALUES('salt', ?)`, salt); err != nil {
+		return nil, fmt.Errorf("failed to store salt: %w", err)
+	}
+	if err := d.db.QueryRowContext(ctx, `SELECT value FROM encryption_meta WHERE name = 'salt'`).Scan(&salt); err != nil {
+		return nil, fmt.Errorf("failed to read salt: %w", err)
+	}
+	return salt, nil
+}
+
+// SetEncryptionKey sets the key values are sealed and opened with. It fails
+// with ErrEncrypted when the database is encrypted and key is nil,
+// encryption.ErrWrongKey when key is not the one the database is encrypted
+// with, and ErrNotEncrypted when the database is plaintext; use Reencrypt to
+// encrypt it.
+func (d *Database) SetEncryptionKey(ctx context.Context, key *encryption.Key) error {
+	keyID, err := d.EncryptionKeyID(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case keyID == "" && key == nil:
+		return nil
+	case keyID == "":
+		return ErrNotEncrypted
+	case key == nil:
+		return ErrEncrypted
+	case key.ID() != keyID:
+		return fmt.Errorf("%w: database key is %s, given key is %s", encryption.ErrWrongKey, keyID, key.ID())
+	}
+	d.key = key
+	// Dictionaries are sealed too, so they could not be loaded without it.
+	return d.loadDictionaries()
+}
+
+// Seal encrypts a value for storage with the current key. Without a key it
+// returns value unchanged.
+func (d *Database) Seal(value string) string {
+	if d.key == nil || value == "" {
+		return value
+	}
+	return d.key.SealString(value)
+}
+
+// Unseal decrypts a value stored with Seal. Plaintext values are returned
+// unchanged.
+func (d *Database) Unseal(value string) (string, error) {
+	return d.key.OpenString(value)
+}
+
+func (d *Database) sealTitle(title *string) *string {
+	if title == nil {
+		return nil
+	}
+	sealed := d.Seal(*title)
+	return &sealed
+}
+
+func (d *Database) unsealTitle(title sql.NullString) (*string, error) {
+	if !title.Valid {
+		return nil, nil
+	}
+	opened, err := d.Unseal(title.String)
+	return &opened, err
+}
+
+// sealData wraps sealed data_json in a JSON string.
+func sealData(key *encryption.Key, dataJSON string) string {
+	if key == nil {
+		return dataJSON
+	}
+	encoded, _ := json.Marshal(key.SealString(dataJSON))
+	return string(encoded)
+}
+
+func (d *Database) sealData(dataJSON string) string {
+	return sealData(d.key, dataJSON)
+}
+
+// openData returns the JSON of a data_json value stored with sealData.
+func openData(key *encryption.Key, stored string) (string, error) {
+	if !strings.HasPrefix(stored, `"`) {
+		return stored, nil
+	}
+	var sealed string
+	if err := json.Unmarshal([]byte(stored), &sealed); err != nil || !encryption.IsSealed([]byte(sealed)) {
+		return stored, nil
+	}
+	return key.OpenString(sealed)
+}
+
+// sealBytes seals BLOB content such as a compression dictionary.
+func sealBytes(key *encryption.Key, content []byte) []byte {
+	if key == nil {
+		return content
+	}
+	return key.SealBytes(content)
+}
+
+// sealContent seals a text_blobs content value, which is a string when
+// uncompressed and []byte otherwise.
+func (d *Database) sealContent(content any) any {
+	if d.key == nil {
+		return content
+	}
+	if compressed, ok := content.([]byte); ok {
+		return d.key.SealBytes(compressed)
+	}
+	return d.key.SealBytes([]byte(content.(string)))
+}
+
+// Reencrypt rewrites every sealed value with newKey, or decrypts the
+// database when newKey is nil, and records the new key. Everything happens in
+// one transaction, so the database is never left under a mix of keys. The
+// file is then vacuumed and the WAL truncated so that no copy of the old
+// values is left in free pages. It returns how many events were rewritten.
+//
+// The new key only takes effect in this process, so no other process may
+// have the database open, and nothing else may use d meanwhile.
+func (d *Database) Reencrypt(ctx context.Context, newKey *encryption.Key) (int, error) {
+	transaction, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	oldKey := d.key
+	reseal := func(value string) (string, error) {
+		opened, err := oldKey.OpenString(value)
+		if err != nil || newKey == nil || opened == "" {
+			return opened, err
+		}
+		return newKey.SealString(opened), nil
+	}
+
+	events, err := d.reencryptEvents(ctx, transaction, oldKey, newKey, reseal)
+	if err != nil {
+		return 0, err
+	}
+	for _, table := range []struct {
+		name, column string
+		text         bool
+	}{
+		{"text_blobs", "content", true},
+		{"compression_dictionaries", "dictionary", false},
+	} {
+		if err := reencryptBlobs(ctx, transaction, table.name, table.column, table.text, oldKey, newKey); err != nil {
+			return 0, err
+		}
+	}
+	for _, hook := range d.reencryptHooks {
+		if err := hook(transaction, reseal); err != nil {
+			return 0, fmt.Errorf("reencrypt hook failed: %w", err)
+		}
+	}
+
+	if newKey == nil {
+		_, err = transaction.ExecContext(ctx, `DELETE FROM encryption_meta WHERE name = 'key_id'`)
+	} else {
+		_, err = transaction.ExecContext(ctx, `INSERT INTO encryption_meta(name, value) VALUES('key_id', ?)
+		ON CONFLICT(name) DO UPDATE SET value = excluded.value`, newKey.ID())
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to record encryption key: %w", err)
+	}
+	if err := transaction.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	d.key = newKey
+	if err := d.loadDictionaries(); err != nil {
+		return events, err
+	}
+	return events, d.Vacuum(ctx)
+}
+
+func (d *Database) reencryptEvents(ctx context.Context, transaction *sql.Tx, oldKey, newKey *encryption.Key, reseal func(string) (string, error)) (int, error) {
+	rows, err := transaction.QueryContext(ctx, `SELECT id, url, title, data_json, canonical_url FROM events`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query events: %w", err)
+	}
+	type row struct {
+		id                  int64
+		url, data           string
+		title, canonicalURL sql.NullString
+	}
+	var pending []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.url, &r.title, &r.data, &r.canonicalURL); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan event: %w", err)
+		}
+		pending = append(pending, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read events: %w", err)
+	}
+
+	statement, err := transaction.PrepareContext(ctx, `UPDATE events SET url = ?, title = ?, data_json = json(?), canonical_url = ? WHERE id = ?`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer statement.Close()
+	for _, r := range pending {
+		if r.url, err = reseal(r.url); err != nil {
+			return 0, fmt.Errorf("failed to decrypt event %d: %w", r.id, err)
+		}
+		if r.title.Valid {
+			if r.title.String, err = reseal(r.title.String); err != nil {
+				return 0, fmt.Errorf("failed to decrypt event %d: %w", r.id, err)
+			}
+		}
+		if r.canonicalURL.Valid {
+			if r.canonicalURL.String, err = reseal(r.canonicalURL.String); err != nil {
+				return 0, fmt.Errorf("failed to decrypt event %d: %w", r.id, err)
+			}
+		}
+		data, err := openData(oldKey, r.data)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt event %d: %w", r.id, err)
+		}
+		if _, err := statement.ExecContext(ctx, r.url, r.title, sealData(newKey, data), r.canonicalURL, r.id); err != nil {
+			return 0, fmt.Errorf("failed to update event %d: %w", r.id, err)
+		}
+	}
+	return len(pending), nil
+}
+
+// reencryptBlobs reseals a content column of a table keyed by hash. Sealed
+// values are BLOBs; with text set, content that decrypts to valid UTF-8 goes
+// back to being TEXT, as uncompressed page text is stored.
+func reencryptBlobs(ctx context.Context, transaction *sql.Tx, table, column string, text bool, oldKey, newKey *encryption.Key) error {
+	rows, err := transaction.QueryContext(ctx, `SELECT hash, `+column+` FROM `+table)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", table, err)
+	}
+	type row struct {
+		hash    string
+		content []byte
+	}
+	var pending []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.hash, &r.content); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan %s: %w", table, err)
+		}
+		pending = append(pending, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", table, err)
+	}
+
+	statement, err := transaction.PrepareContext(ctx, `UPDATE `+table+` SET `+column+` = ? WHERE hash = ?`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer statement.Close()
+	for _, r := range pending {
+		opened, err := oldKey.OpenBytes(r.content)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s %s: %w", table, r.hash, err)
+		}
+		var value any = sealBytes(newKey, opened)
+		if newKey == nil && text && utf8.Valid(opened) {
+			value = string(opened)
+		}
+		if _, err := statement.ExecContext(ctx, value, r.hash); err != nil {
+			return fmt.Errorf("failed to update %s %s: %w", table, r.hash, err)
+		}
+	}
+	return nil
+}
+
+// Vacuum rebuilds the database file and truncates the WAL, which drops any
+// stale copies of rewritten or deleted rows.
+func (d *Database) Vacuum(ctx context.Context) error {
+	if _, err := d.db.ExecContext(ctx, `VACUUM`); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	if _, err := d.db.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return fmt.Errorf("failed to checkpoint database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/encryption"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func testKey(t *testing.T, fill byte) *encryption.Key {
+	t.Helper()
+
+	key, err := encryption.NewKey(bytes.Repeat([]byte{fill}, encryption.SecretSize))
+	if err != nil {
+		t.Fatalf("NewKey() error = %v", err)
+	}
+	return key
+}
+
+// Markers are distinctive strings that must never appear in the database
+// file while it is encrypted.
+const (
+	secretURL   = "https://bank.example/statements/zebracrossing"
+	secretTitle = "Quarterly statement for Marmaduke"
+	secretInput = "hunter2-passwordish"
+	secretText  = "the diagnosis was xylophonic"
+)
+
+func secretEvents(base int64) []models.Event {
+	title := secretTitle
+	return []models.Event{
+		{TSUTC: base, TSISO: models.FormatTimestamp(base), URL: secretURL + "?utm_source=mail", Title: &title, Type: "navigate", Data: map[string]any{}},
+		{TSUTC: base + 1, TSISO: models.FormatTimestamp(base + 1), URL: secretURL, Type: "input", Data: map[string]any{"value": secretInput}},
+		visibleText(base+2, secretURL, secretText+"\n"+prose(base, 6000)),
+		visibleText(base+3, secretURL, secretText+"\n"+prose(base, 6000)+"\nmore"),
+	}
+}
+
+// databaseFiles returns the contents of the database file and its WAL.
+func databaseFiles(t *testing.T, db *Database) []byte {
+	t.Helper()
+
+	var seq int
+	var name, path string
+	if err := db.db.QueryRow(`PRAGMA database_list`).Scan(&seq, &name, &path); err != nil {
+		t.Fatalf("Failed to find database file: %v", err)
+	}
+	var contents []byte
+	for _, file := range []string{path, path + "-wal"} {
+		data, err := os.ReadFile(file)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Failed to read %s: %v", file, err)
+		}
+		contents = append(contents, data...)
+	}
+	return contents
+}
+
+func assertNoPlaintext(t *testing.T, db *Database) {
+	t.Helper()
+
+	contents := databaseFiles(t, db)
+	pageLine := strings.Split(prose(1000, 6000), "\n")[1]
+	for _, secret := range []string{"zebracrossing", "bank.example", secretTitle, secretInput, "xylophonic", pageLine} {
+		if bytes.Contains(contents, []byte(secret)) {
+			t.Errorf("Found plaintext %q in the database file", secret)
+		}
+	}
+}
+
+func TestEncryptedInsertLeavesNoPlaintext(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	db.SetURLNormalizer(func(rawURL string) string { return strings.Split(rawURL, "?")[0] })
+	db.SetTextDiffs(true)
+	db.SetCompression(Compression{Codec: CodecZstd, Threshold: 1024})
+	if _, err := db.Reencrypt(ctx, testKey(t, 1)); err != nil {
+		t.Fatalf("Reencrypt() error = %v", err)
+	}
+	if err := db.InsertEvents(secretEvents(1000)); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	if _, err := db.ImportEvents(secretEvents(1000)); err != nil {
+		t.Fatalf("Failed to import events: %v", err)
+	}
+
+	assertNoPlaintext(t, db)
+
+	events, err := db.QueryEvents(ctx, EventFilter{})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events (duplicates skipped on import), got %d", len(events))
+	}
+	if events[0].URL != secretURL+"?utm_source=mail" || events[0].CanonicalURL != secretURL {
+		t.Errorf("Expected decrypted URLs, got %q and %q", events[0].URL, events[0].CanonicalURL)
+	}
+	if events[0].Title == nil || *events[0].Title != secretTitle {
+		t.Errorf("Expected decrypted title, got %v", events[0].Title)
+	}
+	if events[1].Data["value"] != secretInput {
+		t.Errorf("Expected decrypted data, got %v", events[1].Data)
+	}
+	if text, _ := events[3].Data["text"].(string); !strings.HasSuffix(text, "\nmore") || !strings.HasPrefix(text, secretText) {
+		t.Errorf("Expected decrypted page text, got %.40q", text)
+	}
+
+	tests := []struct {
+		name   string
+		filter EventFilter
+		want   int
+	}{
+		{"exact URL", EventFilter{URL: secretURL}, 3},
+		{"canonical URL", EventFilter{Canonical: secretURL}, 4},
+		{"URL prefix", EventFilter{URLPrefix: "https://bank.example/"}, 4},
+		{"URL prefix with limit", EventFilter{URLPrefix: "https://bank.example/", Limit: 2}, 2},
+		{"title text", EventFilter{Text: "MARMADUKE"}, 1},
+		{"page text", EventFilter{Text: "xylophonic"}, 2},
+		{"no match", EventFilter{Text: "absent"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := db.QueryEvents(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("QueryEvents() error = %v", err)
+			}
+			if len(events) != tt.want {
+				t.Errorf("Expected %d events, got %d", tt.want, len(events))
+			}
+		})
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "browsetrace-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dbPath := filepath.Join(tmpDir, "test.db")
+	ctx := context.Background()
+
+	db, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer func() { db.Close() }()
+	db.SetCompression(Compression{Codec: CodecGzip, Threshold: 1024})
+	if err := db.InsertEvents(secretEvents(1000)); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	if !bytes.Contains(databaseFiles(t, db), []byte("zebracrossing")) {
+		t.Fatal("Expected the plaintext database to contain the URL")
+	}
+
+	// Encrypting an existing database must not leave old rows in free pages.
+	first := testKey(t, 1)
+	if err := db.SetEncryptionKey(ctx, first); !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("Expected ErrNotEncrypted, got %v", err)
+	}
+	count, err := db.Reencrypt(ctx, first)
+	if err != nil {
+		t.Fatalf("Reencrypt() error = %v", err)
+	}
+	if count != 4 {
+		t.Errorf("Expected 4 events rewritten, got %d", count)
+	}
+	assertNoPlaintext(t, db)
+
+	second := testKey(t, 2)
+	if _, err := db.Reencrypt(ctx, second); err != nil {
+		t.Fatalf("Reencrypt() error = %v", err)
+	}
+	assertNoPlaintext(t, db)
+
+	db.Close()
+	if db, err = NewDatabase(dbPath); err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	if err := db.SetEncryptionKey(ctx, nil); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("Expected ErrEncrypted without a key, got %v", err)
+	}
+	if err := db.SetEncryptionKey(ctx, first); !errors.Is(err, encryption.ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey for the old key, got %v", err)
+	}
+	if err := db.SetEncryptionKey(ctx, second); err != nil {
+		t.Fatalf("SetEncryptionKey() error = %v", err)
+	}
+	events, err := db.QueryEvents(ctx, EventFilter{Text: "xylophonic"})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events with the page text, got %d", len(events))
+	}
+
+	// Decrypting restores a plaintext database that opens without a key.
+	if _, err := db.Reencrypt(ctx, nil); err != nil {
+		t.Fatalf("Reencrypt() error = %v", err)
+	}
+	db.Close()
+	if db, err = NewDatabase(dbPath); err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	if err := db.SetEncryptionKey(ctx, nil); err != nil {
+		t.Fatalf("SetEncryptionKey() error = %v", err)
+	}
+	events, err = db.QueryEvents(ctx, EventFilter{Text: "xylophonic"})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if len(events) != 2 || events[0].URL != secretURL {
+		t.Errorf("Expected the decrypted events back, got %d", len(events))
+	}
+}
+
+func TestEncryptedDictionary(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "browsetrace-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dbPath := filepath.Join(tmpDir, "test.db")
+	ctx := context.Background()
+
+	db, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer func() { db.Close() }()
+	key := testKey(t, 1)
+	if _, err := db.Reencrypt(ctx, key); err != nil {
+		t.Fatalf("Reencrypt() error = %v", err)
+	}
+	var events []models.Event
+	for i := 0; i < 2*minimumSamples; i++ {
+		events = append(events, visibleText(int64(1000+i), fmt.Sprintf("https://example.com/%d", i), prose(int64(i), 8000)))
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	hash, err := db.TrainDictionary(ctx)
+	if err != nil {
+		t.Fatalf("TrainDictionary() error = %v", err)
+	}
+	var dictionary []byte
+	if err := db.db.QueryRow(`SELECT dictionary FROM compression_dictionaries`).Scan(&dictionary); err != nil {
+		t.Fatalf("Failed to read dictionary: %v", err)
+	}
+	if !encryption.IsSealed(dictionary) {
+		t.Error("Expected the stored dictionary to be encrypted")
+	}
+
+	// The dictionary only becomes current once the key is known.
+	db.Close()
+	if db, err = NewDatabase(dbPath); err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	if db.dictionary != "" {
+		t.Errorf("Expected no dictionary before the key is set, got %s", db.dictionary)
+	}
+	if err := db.SetEncryptionKey(ctx, key); err != nil {
+		t.Fatalf("SetEncryptionKey() error = %v", err)
+	}
+	if db.dictionary != hash {
+		t.Errorf("Expected dictionary %s, got %s", hash, db.dictionary)
+	}
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// localDateBatch is how many rows backfillLocalDates updates per transaction,
+// so that a large backfill does not hold the write lock for long.
+const localDateBatch = 1000
+
+// backfillLocalDates fills in local_date for rows stored before the column
+// existed. Those rows predate clients sending a time zone, so their day is
+// taken in the agent's: the agent runs on the machine the user browses on.
+func (d *Database) backfillLocalDates(ctx context.Context) error {
+	for {
+		filled, err := d.backfillLocalDateBatch(ctx)
+		if err != nil {
+			return err
+		}
+		if filled < localDateBatch {
+			return nil
+		}
+	}
+}
+
+func (d *Database) backfillLocalDateBatch(ctx context.Context) (int, error) {
+	transaction, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	rows, err := transaction.QueryContext(ctx,
+		`SELECT id, ts_utc, timezone, utc_offset_minutes FROM events WHERE local_date IS NULL LIMIT ?`, localDateBatch)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query events without a local date: %w", err)
+	}
+	dates := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var event models.Event
+		if err := rows.Scan(&id, &event.TSUTC, &event.Timezone, &event.UTCOffsetMinutes); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan event: %w", err)
+		}
+		dates[id] = event.LocalDate(models.DefaultLocation)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read events: %w", err)
+	}
+	if len(dates) == 0 {
+		return 0, nil
+	}
+
+	statement, err := transaction.PrepareContext(ctx, `UPDATE events SET local_date = ? WHERE id = ?`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer statement.Close()
+	for id, date := range dates {
+		if _, err := statement.ExecContext(ctx, date, id); err != nil {
+			return 0, fmt.Errorf("failed to set local date: %w", err)
+		}
+	}
+	if err := transaction.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(dates), nil
+}
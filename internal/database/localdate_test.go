@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func TestLocalDates(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	newYork, offset := "America/New_York", 540
+	// 2021-01-01T03:30:00Z: New Year's Eve in New York, noon in Tokyo.
+	const ts = 1609471800000
+	events := []models.Event{
+		{TSUTC: ts, URL: "https://example.com/utc", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: ts + 1, URL: "https://example.com/named", Type: "navigate", Data: map[string]any{}, Timezone: &newYork},
+		{TSUTC: ts + 2, URL: "https://example.com/offset", Type: "navigate", Data: map[string]any{}, UTCOffsetMinutes: &offset},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	stored, err := db.QueryEvents(context.Background(), EventFilter{})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	// The test process runs in UTC unless TZ says otherwise.
+	want := []string{models.Event{TSUTC: ts}.LocalDate(time.Local), "2020-12-31", "2021-01-01"}
+	for i, event := range stored {
+		if event.LocalDate != want[i] {
+			t.Errorf("Expected local date %s for %s, got %s", want[i], event.URL, event.LocalDate)
+		}
+	}
+	if stored[0].TSISO != "2021-01-01T03:30:00.000Z" {
+		t.Errorf("Expected ts_iso derived from ts_utc, got %q", stored[0].TSISO)
+	}
+	if stored[1].Timezone == nil || *stored[1].Timezone != newYork || stored[2].UTCOffsetMinutes == nil || *stored[2].UTCOffsetMinutes != offset {
+		t.Errorf("Expected time zones to be stored, got %+v and %+v", stored[1].Event, stored[2].Event)
+	}
+}
+
+func TestValidateEventTimezone(t *testing.T) {
+	valid, empty, unknown := "Europe/Berlin", "", "Mars/Olympus_Mons"
+	east, tooFar := 14*60, 15*60
+	tests := []struct {
+		name      string
+		timezone  *string
+		offset    *int
+		wantError bool
+	}{
+		{"IANA name", &valid, nil, false},
+		{"empty name", &empty, nil, true},
+		{"unknown name", &unknown, nil, true},
+		{"furthest offset", nil, &east, false},
+		{"offset out of range", nil, &tooFar, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := models.Event{TSUTC: 1000, URL: "https://example.com", Type: "click", Timezone: tt.timezone, UTCOffsetMinutes: tt.offset}
+			if err := ValidateEvent(event); (err != nil) != tt.wantError {
+				t.Errorf("ValidateEvent() error = %v, wantError %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestBackfillLocalDates(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "browsetrace-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "test.db")
+	db, err := NewDatabase(path)
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	events := make([]models.Event, localDateBatch+5)
+	for i := range events {
+		events[i] = models.Event{TSUTC: int64(i+1) * 3600000, URL: "https://example.com", Type: "click", Data: map[string]any{}}
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	// Rows stored before the column existed have no local date.
+	if _, err := db.db.Exec(`UPDATE events SET local_date = NULL`); err != nil {
+		t.Fatalf("Failed to clear local dates: %v", err)
+	}
+	db.Close()
+
+	if db, err = NewDatabase(path); err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer db.Close()
+	var missing int
+	if err := db.db.QueryRow(`SELECT COUNT(*) FROM events WHERE local_date IS NULL`).Scan(&missing); err != nil {
+		t.Fatalf("Failed to count events: %v", err)
+	}
+	if missing != 0 {
+		t.Errorf("Expected every row to get a local date, got %d without", missing)
+	}
+	var date string
+	if err := db.db.QueryRow(`SELECT local_date FROM events WHERE ts_utc = ?`, events[0].TSUTC).Scan(&date); err != nil {
+		t.Fatalf("Failed to read local date: %v", err)
+	}
+	if want := events[0].LocalDate(time.Local); date != want {
+		t.Errorf("Expected local date %s, got %s", want, date)
+	}
+}
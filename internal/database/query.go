@@ -0,0 +1,341 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// EventFilter selects events for the read APIs. Zero values mean "no constraint".
+type EventFilter struct {
+	Since     int64    // inclusive lower bound on ts_utc (ms)
+	Until     int64    // exclusive upper bound on ts_utc (ms)
+	SinceDate string   // inclusive lower bound on local_date (YYYY-MM-DD)
+	UntilDate string   // exclusive upper bound on local_date (YYYY-MM-DD)
+	Types     []string // only these event types
+	URLPrefix string   // only URLs starting with this prefix
+	URL       string   // only this exact URL
+	Canonical string   // only events whose canonical URL is this
+	Text      string   // only events whose URL, title or page text contains this (ASCII case-insensitive)
+	TabID     *int64   // only events from this tab
+	WindowID  *int64   // only events from this window
+	Profile   string   // only events from this browser profile
+	ClientID  string   // only events from this client/device
+	Limit     int      // maximum number of events, 0 for no limit
+	Newest    bool     // newest events first, so Limit keeps the most recent
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// prefixUpperBound returns the smallest string greater than every string
+// starting with prefix, comparing bytes as SQLite's default collation does.
+// There is none when prefix is all 0xFF bytes.
+func prefixUpperBound(prefix string) (string, bool) {
+	upper := []byte(prefix)
+	for i := len(upper) - 1; i >= 0; i-- {
+		if upper[i] < 0xFF {
+			upper[i]++
+			return string(upper[:i+1]), true
+		}
+	}
+	return "", false
+}
+
+// whereClause renders the filter as SQL. On an encrypted database, sealed
+// columns can only be compared for equality, so the prefix and text filters
+// are left to matchesSealed.
+func (f EventFilter) whereClause(d *Database) (string, []any) {
+	var conditions []string
+	var args []any
+	if f.Since > 0 {
+		conditions = append(conditions, "ts_utc >= ?")
+		args = append(args, f.Since)
+	}
+	if f.Until > 0 {
+		conditions = append(conditions, "ts_utc < ?")
+		args = append(args, f.Until)
+	}
+	if f.SinceDate != "" {
+		conditions = append(conditions, "local_date >= ?")
+		args = append(args, f.SinceDate)
+	}
+	if f.UntilDate != "" {
+		conditions = append(conditions, "local_date < ?")
+		args = append(args, f.UntilDate)
+	}
+	if len(f.Types) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(f.Types)), ",")
+		conditions = append(conditions, "type IN ("+placeholders+")")
+		for _, eventType := range f.Types {
+			args = append(args, eventType)
+		}
+	}
+	if f.URLPrefix != "" && d.key == nil {
+		// A range rather than substr, which counts characters, not bytes; it
+		// can also use the url index.
+		if upper, ok := prefixUpperBound(f.URLPrefix); ok {
+			conditions = append(conditions, "url >= ? AND url < ?")
+			args = append(args, f.URLPrefix, upper)
+		} else {
+			conditions = append(conditions, "url >= ?")
+			args = append(args, f.URLPrefix)
+		}
+	}
+	if f.URL != "" {
+		conditions = append(conditions, "url = ?")
+		args = append(args, d.Seal(f.URL))
+	}
+	if f.Canonical != "" {
+		conditions = append(conditions, "canonical_url = ?")
+		args = append(args, d.Seal(f.Canonical))
+	}
+	if f.Text != "" && d.key == nil {
+		pattern := "%" + likeEscaper.Replace(f.Text) + "%"
+		// Diffed page text only matches on the lines the diff inserted.
+		conditions = append(conditions, `(url LIKE ? ESCAPE '\' OR title LIKE ? ESCAPE '\' OR json_extract(data_json, '$.text') LIKE ? ESCAPE '\'`+
+			` OR (SELECT browsetrace_text(content, codec) FROM text_blobs WHERE hash = text_hash) LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern, pattern, pattern)
+	}
+	if f.TabID != nil {
+		conditions = append(conditions, "tab_id = ?")
+		args = append(args, *f.TabID)
+	}
+	if f.WindowID != nil {
+		conditions = append(conditions, "window_id = ?")
+		args = append(args, *f.WindowID)
+	}
+	if f.Profile != "" {
+		conditions = append(conditions, "profile = ?")
+		args = append(args, f.Profile)
+	}
+	if f.ClientID != "" {
+		conditions = append(conditions, "client_id = ?")
+		args = append(args, f.ClientID)
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// postFiltered reports whether some of the filter is applied in Go.
+func (f EventFilter) postFiltered(d *Database) bool {
+	return d.key != nil && (f.URLPrefix != "" || f.Text != "")
+}
+
+// matchesSealed applies the filters whereClause leaves out on an encrypted
+// database to a decrypted event, with the same semantics as the SQL.
+func (f EventFilter) matchesSealed(event models.StoredEvent) bool {
+	if f.URLPrefix != "" && !strings.HasPrefix(event.URL, f.URLPrefix) {
+		return false
+	}
+	if f.Text == "" {
+		return true
+	}
+	needle := asciiLower(f.Text)
+	if strings.Contains(asciiLower(event.URL), needle) {
+		return true
+	}
+	if event.Title != nil && strings.Contains(asciiLower(*event.Title), needle) {
+		return true
+	}
+	text, _ := event.Data["text"].(string)
+	return strings.Contains(asciiLower(text), needle)
+}
+
+// Matches reports whether event satisfies every condition of the filter; Limit
+// and Newest are left to the caller. Storage backends that filter in Go use it
+// to select exactly what the SQL does.
+func (f EventFilter) Matches(event models.StoredEvent) bool {
+	if f.Since > 0 && event.TSUTC < f.Since {
+		return false
+	}
+	if f.Until > 0 && event.TSUTC >= f.Until {
+		return false
+	}
+	if f.SinceDate != "" && event.LocalDate < f.SinceDate {
+		return false
+	}
+	if f.UntilDate != "" && event.LocalDate >= f.UntilDate {
+		return false
+	}
+	if len(f.Types) > 0 && !slices.Contains(f.Types, event.Type) {
+		return false
+	}
+	if f.URL != "" && event.URL != f.URL {
+		return false
+	}
+	if f.Canonical != "" && event.CanonicalURL != f.Canonical {
+		return false
+	}
+	if f.TabID != nil && (event.TabID == nil || *event.TabID != *f.TabID) {
+		return false
+	}
+	if f.WindowID != nil && (event.WindowID == nil || *event.WindowID != *f.WindowID) {
+		return false
+	}
+	if f.Profile != "" && (event.Profile == nil || *event.Profile != f.Profile) {
+		return false
+	}
+	if f.ClientID != "" && (event.ClientID == nil || *event.ClientID != f.ClientID) {
+		return false
+	}
+	return f.matchesSealed(event)
+}
+
+// asciiLower lowercases ASCII letters only, as SQLite's LIKE compares them.
+func asciiLower(value string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, value)
+}
+
+// StreamEvents calls fn for every event matching filter, in timestamp order
+// (newest first when filter.Newest is set), without materializing the result
+// set. Iteration stops at the first error.
+func (d *Database) StreamEvents(ctx context.Context, filter EventFilter, fn func(models.StoredEvent) error) error {
+	where, args := filter.whereClause(d)
+	postFiltered := filter.postFiltered(d)
+	order := ` ORDER BY ts_utc, id`
+	if filter.Newest {
+		order = ` ORDER BY ts_utc DESC, id DESC`
+	}
+	query := `SELECT ` + eventColumns + ` FROM events LEFT JOIN text_blobs ON hash = text_hash` + where + order
+	if filter.Limit > 0 && !postFiltered {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	cache := make(textCache)
+	matched := 0
+	for rows.Next() {
+		event, err := d.scanEvent(ctx, rows, cache)
+		if err != nil {
+			return err
+		}
+		if postFiltered && !filter.matchesSealed(event) {
+			continue
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+		if matched++; postFiltered && matched == filter.Limit {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read events: %w", err)
+	}
+	return nil
+}
+
+// QueryEvents returns all events matching filter. Prefer StreamEvents for
+// unbounded result sets.
+func (d *Database) QueryEvents(ctx context.Context, filter EventFilter) ([]models.StoredEvent, error) {
+	var events []models.StoredEvent
+	err := d.StreamEvents(ctx, filter, func(event models.StoredEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	return events, err
+}
+
+// eventColumns are selected from events joined with the text_blobs row of
+// their page text, if any.
+const eventColumns = `id, ts_utc, ts_iso, url, title, type, data_json, tab_id, window_id, frame_id, profile, client_id, canonical_url, ` +
+	`uid, device_id, origin_seq, received_ts_utc, timezone, utc_offset_minutes, local_date, text_hash, base_hash, content, codec`
+
+// scanEvent reads a row selected with eventColumns, reassembling page text
+// stored as a blob into data.text.
+func (d *Database) scanEvent(ctx context.Context, rows *sql.Rows, cache textCache) (models.StoredEvent, error) {
+	var event models.StoredEvent
+	var title, canonicalURL, uid, deviceID, localDate, textHash, baseHash, codec sql.NullString
+	var received sql.NullInt64
+	var dataJSON string
+	var content []byte
+	if err := rows.Scan(&event.ID, &event.TSUTC, &event.TSISO, &event.URL, &title, &event.Type, &dataJSON,
+		&event.TabID, &event.WindowID, &event.FrameID, &event.Profile, &event.ClientID, &canonicalURL,
+		&uid, &deviceID, &event.OriginSeq, &received, &event.Timezone, &event.UTCOffsetMinutes, &localDate, &textHash, &baseHash, &content, &codec); err != nil {
+		return event, fmt.Errorf("failed to scan event: %w", err)
+	}
+	event.UID, event.DeviceID, event.ReceivedTSUTC, event.LocalDate = uid.String, deviceID.String, received.Int64, localDate.String
+	var err error
+	if event.URL, err = d.Unseal(event.URL); err != nil {
+		return event, fmt.Errorf("failed to decrypt event %d: %w", event.ID, err)
+	}
+	if event.Title, err = d.unsealTitle(title); err != nil {
+		return event, fmt.Errorf("failed to decrypt event %d: %w", event.ID, err)
+	}
+	if event.CanonicalURL, err = d.Unseal(canonicalURL.String); err != nil {
+		return event, fmt.Errorf("failed to decrypt event %d: %w", event.ID, err)
+	}
+	if dataJSON, err = openData(d.key, dataJSON); err != nil {
+		return event, fmt.Errorf("failed to decrypt event %d: %w", event.ID, err)
+	}
+	if err := json.Unmarshal([]byte(dataJSON), &event.Data); err != nil {
+		return event, fmt.Errorf("failed to unmarshal data for event %d: %w", event.ID, err)
+	}
+	if textHash.Valid {
+		if !codec.Valid {
+			return event, fmt.Errorf("missing text %s for event %d", textHash.String, event.ID)
+		}
+		text, err := d.resolveText(ctx, d.db, baseHash, content, codec.String, cache)
+		if err != nil {
+			return event, fmt.Errorf("failed to reassemble text for event %d: %w", event.ID, err)
+		}
+		if event.Data == nil {
+			event.Data = make(map[string]any)
+		}
+		event.Data["text"] = text
+	}
+	return event, nil
+}
+
+// Stats summarizes the stored events.
+type Stats struct {
+	Events      int64            `json:"events"`
+	OldestTSUTC int64            `json:"oldest_ts_utc,omitempty"`
+	NewestTSUTC int64            `json:"newest_ts_utc,omitempty"`
+	Types       map[string]int64 `json:"types"`
+}
+
+// Stats counts the stored events in total and per type.
+func (d *Database) Stats(ctx context.Context) (Stats, error) {
+	stats := Stats{Types: make(map[string]int64)}
+	err := d.db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(MIN(ts_utc), 0), COALESCE(MAX(ts_utc), 0) FROM events`).
+		Scan(&stats.Events, &stats.OldestTSUTC, &stats.NewestTSUTC)
+	if err != nil {
+		return stats, fmt.Errorf("failed to count events: %w", err)
+	}
+	rows, err := d.db.QueryContext(ctx, `SELECT type, COUNT(*) FROM events GROUP BY type`)
+	if err != nil {
+		return stats, fmt.Errorf("failed to count event types: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var eventType string
+		var count int64
+		if err := rows.Scan(&eventType, &count); err != nil {
+			return stats, fmt.Errorf("failed to scan event type count: %w", err)
+		}
+		stats.Types[eventType] = count
+	}
+	if err := rows.Err(); err != nil {
+		return stats, fmt.Errorf("failed to read event type counts: %w", err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,272 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func insertQueryFixtures(t *testing.T, db *Database) {
+	t.Helper()
+
+	title := "Example"
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com/a", Title: &title, Type: "navigate", Data: map[string]any{"referrer": "https://google.com"}},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://example.com/a", Type: "click", Data: map[string]any{"x": 1}},
+		{TSUTC: 3000, TSISO: "1970-01-01T00:00:03Z", URL: "https://other.org/", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 4000, TSISO: "1970-01-01T00:00:04Z", URL: "https://example.com/b", Type: "scroll", Data: map[string]any{}},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert fixtures: %v", err)
+	}
+}
+
+func TestQueryEventsFilters(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	insertQueryFixtures(t, db)
+
+	tests := []struct {
+		name    string
+		filter  EventFilter
+		wantIDs []int64
+	}{
+		{"no filter", EventFilter{}, []int64{1, 2, 3, 4}},
+		{"since", EventFilter{Since: 2000}, []int64{2, 3, 4}},
+		{"until is exclusive", EventFilter{Until: 3000}, []int64{1, 2}},
+		{"types", EventFilter{Types: []string{"navigate", "scroll"}}, []int64{1, 3, 4}},
+		{"url prefix", EventFilter{URLPrefix: "https://example.com/"}, []int64{1, 2, 4}},
+		{"limit", EventFilter{Limit: 2}, []int64{1, 2}},
+		{"exact url", EventFilter{URL: "https://example.com/a"}, []int64{1, 2}},
+		{"newest first", EventFilter{Newest: true, Limit: 2}, []int64{4, 3}},
+		{"combined", EventFilter{Since: 1500, Types: []string{"navigate"}}, []int64{3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := db.QueryEvents(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("QueryEvents() error = %v", err)
+			}
+			if len(events) != len(tt.wantIDs) {
+				t.Fatalf("Expected %d events, got %d", len(tt.wantIDs), len(events))
+			}
+			for i, event := range events {
+				if event.ID != tt.wantIDs[i] {
+					t.Errorf("Event %d: expected ID %d, got %d", i, tt.wantIDs[i], event.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryEventsNonASCIIPrefix(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	events := []models.Event{
+		{TSUTC: 1000, URL: "https://ja.wikipedia.org/wiki/東京", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, URL: "https://ja.wikipedia.org/wiki/東北", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 3000, URL: "https://ja.wikipedia.org/wiki/大阪", Type: "navigate", Data: map[string]any{}},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   int
+	}{
+		{"https://ja.wikipedia.org/wiki/東", 2},
+		{"https://ja.wikipedia.org/wiki/東京", 1},
+		{"https://ja.wikipedia.org/wiki/", 3},
+		{"https://ja.wikipedia.org/wiki/京", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			stored, err := db.QueryEvents(context.Background(), EventFilter{URLPrefix: tt.prefix})
+			if err != nil {
+				t.Fatalf("QueryEvents() error = %v", err)
+			}
+			if len(stored) != tt.want {
+				t.Errorf("Expected %d events, got %d", tt.want, len(stored))
+			}
+		})
+	}
+}
+
+func TestPrefixUpperBound(t *testing.T) {
+	if upper, ok := prefixUpperBound("ab"); !ok || upper != "ac" {
+		t.Errorf("Expected ac, got %q", upper)
+	}
+	if upper, ok := prefixUpperBound("a\xff"); !ok || upper != "b" {
+		t.Errorf("Expected b, got %q", upper)
+	}
+	if _, ok := prefixUpperBound("\xff\xff"); ok {
+		t.Error("Expected no upper bound for a prefix of 0xFF bytes")
+	}
+}
+
+func TestQueryEventsText(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	title := "Release Notes"
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://go.dev/doc/go1.22", Title: &title, Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://go.dev/doc/go1.22", Type: "visible_text", Data: map[string]any{"text": "Range over integers"}},
+		{TSUTC: 3000, TSISO: "1970-01-01T00:00:03Z", URL: "https://example.com/100%_done", Type: "navigate", Data: map[string]any{}},
+		// Only the page text counts, not other data keys.
+		{TSUTC: 4000, TSISO: "1970-01-01T00:00:04Z", URL: "https://example.com/", Type: "click", Data: map[string]any{"selector": "#range"}},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	tests := []struct {
+		text    string
+		wantIDs []int64
+	}{
+		{"release", []int64{1}},
+		{"RANGE OVER", []int64{2}},
+		{"go1.22", []int64{1, 2}},
+		{"100%_", []int64{3}},
+		{"0%d", nil},
+	}
+	for _, tt := range tests {
+		got, err := db.QueryEvents(context.Background(), EventFilter{Text: tt.text})
+		if err != nil {
+			t.Fatalf("QueryEvents() error = %v", err)
+		}
+		if len(got) != len(tt.wantIDs) {
+			t.Errorf("%q: expected %d events, got %d", tt.text, len(tt.wantIDs), len(got))
+			continue
+		}
+		for i, event := range got {
+			if event.ID != tt.wantIDs[i] {
+				t.Errorf("%q: expected ID %d, got %d", tt.text, tt.wantIDs[i], event.ID)
+			}
+		}
+	}
+}
+
+func TestQueryEventsRoundTripsFields(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	insertQueryFixtures(t, db)
+
+	events, err := db.QueryEvents(context.Background(), EventFilter{Limit: 2})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+
+	first := events[0]
+	if first.Title == nil || *first.Title != "Example" {
+		t.Errorf("Expected title Example, got %v", first.Title)
+	}
+	if first.Data["referrer"] != "https://google.com" {
+		t.Errorf("Expected referrer in data, got %v", first.Data)
+	}
+	if events[1].Title != nil {
+		t.Errorf("Expected nil title, got %v", *events[1].Title)
+	}
+}
+
+func TestStreamEventsStopsOnError(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	insertQueryFixtures(t, db)
+
+	stop := errors.New("stop")
+	calls := 0
+	err := db.StreamEvents(context.Background(), EventFilter{}, func(models.StoredEvent) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("Expected stop error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 callback, got %d", calls)
+	}
+}
+
+func TestQueryEventsByIdentity(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://a.example", Type: "navigate", Data: map[string]any{}, TabID: int64Pointer(1), WindowID: int64Pointer(10), Profile: stringPointer("Work")},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://b.example", Type: "navigate", Data: map[string]any{}, TabID: int64Pointer(2), WindowID: int64Pointer(10), ClientID: stringPointer("desktop")},
+		{TSUTC: 3000, TSISO: "1970-01-01T00:00:03Z", URL: "https://a.example", Type: "click", Data: map[string]any{}, TabID: int64Pointer(1), WindowID: int64Pointer(10), FrameID: int64Pointer(0)},
+		{TSUTC: 4000, TSISO: "1970-01-01T00:00:04Z", URL: "https://c.example", Type: "navigate", Data: map[string]any{}},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		filter  EventFilter
+		wantIDs []int64
+	}{
+		{"tab", EventFilter{TabID: int64Pointer(1)}, []int64{1, 3}},
+		{"window", EventFilter{WindowID: int64Pointer(10)}, []int64{1, 2, 3}},
+		{"profile", EventFilter{Profile: "Work"}, []int64{1}},
+		{"client", EventFilter{ClientID: "desktop"}, []int64{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.QueryEvents(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("QueryEvents() error = %v", err)
+			}
+			if len(got) != len(tt.wantIDs) {
+				t.Fatalf("Expected %d events, got %d", len(tt.wantIDs), len(got))
+			}
+			for i, event := range got {
+				if event.ID != tt.wantIDs[i] {
+					t.Errorf("Event %d: expected ID %d, got %d", i, tt.wantIDs[i], event.ID)
+				}
+			}
+		})
+	}
+
+	all, err := db.QueryEvents(context.Background(), EventFilter{})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if all[2].FrameID == nil || *all[2].FrameID != 0 || all[2].TabID == nil || *all[2].TabID != 1 {
+		t.Errorf("Expected identity fields read back, got %+v", all[2])
+	}
+	if all[3].TabID != nil || all[3].Profile != nil {
+		t.Errorf("Expected nil identity fields, got %+v", all[3])
+	}
+}
+
+func TestStats(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	stats, err := db.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Events != 0 || stats.OldestTSUTC != 0 || len(stats.Types) != 0 {
+		t.Errorf("Expected empty stats, got %+v", stats)
+	}
+
+	insertQueryFixtures(t, db)
+	if stats, err = db.Stats(context.Background()); err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Events != 4 || stats.OldestTSUTC != 1000 || stats.NewestTSUTC != 4000 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if stats.Types["navigate"] != 2 || stats.Types["click"] != 1 || stats.Types["scroll"] != 1 {
+		t.Errorf("Unexpected type counts: %v", stats.Types)
+	}
+}
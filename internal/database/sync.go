@@ -0,0 +1,455 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// Every event has a UID that is unique across devices and the ID of the
+// device that first stored it. Every change, whether a batch of inserts, a
+// delete or a merge, takes the next number of a local sequence, stored on the
+// events and tombstones it wrote. The changes since a watermark are the rows
+// numbered above it; merged rows are numbered afresh, so that they travel on
+// to devices that only sync with this one.
+//
+// Rows also keep the number of the change that first wrote them on their
+// device, their origin number. Since every device numbers its own changes in
+// order, a version vector, the highest origin number held from each device,
+// says what a database has, and two databases can work out what the other one
+// lacks. Only peer-to-peer merges advance the vector, because they bring each
+// device's changes in order; a bundle may skip some.
+
+// Tombstone records that an event was deleted, so that syncing deletes it on
+// other devices too and does not bring it back.
+type Tombstone struct {
+	UID          string `json:"uid"`
+	DeviceID     string `json:"device_id"` // device the event was deleted on
+	DeletedTSUTC int64  `json:"deleted_ts_utc"`
+	OriginSeq    int64  `json:"origin_seq,omitempty"` // change number on that device
+}
+
+// VersionVector maps device IDs to the highest origin number from each device
+// up to which a database has all of that device's changes.
+type VersionVector map[string]int64
+
+// ChangePage is a page of the changes another database lacks, in origin order
+// for each device. Covers is that database's vector advanced past them, so
+// that merging the page with MergeChanges records what it now has.
+type ChangePage struct {
+	Events     []models.StoredEvent `json:"events"`
+	Tombstones []Tombstone          `json:"tombstones"`
+	Covers     VersionVector        `json:"covers"`
+	More       bool                 `json:"more"`
+}
+
+// Changes are the events and tombstones written after Since, up to and
+// including Watermark.
+type Changes struct {
+	Since      int64                `json:"since"`
+	Watermark  int64                `json:"watermark"`
+	Events     []models.StoredEvent `json:"events"`
+	Tombstones []Tombstone          `json:"tombstones"`
+}
+
+// MergeResult counts what merging changes did.
+type MergeResult struct {
+	Inserted int `json:"inserted"`
+	Deleted  int `json:"deleted"` // local events removed by tombstones
+	Skipped  int `json:"skipped"` // events already stored or deleted
+}
+
+func newUID() (string, error) {
+	uid := make([]byte, 16)
+	if _, err := rand.Read(uid); err != nil {
+		return "", fmt.Errorf("failed to generate event ID: %w", err)
+	}
+	return hex.EncodeToString(uid), nil
+}
+
+// nextSeq takes the next change number. Writers hold SQLite's write lock from
+// here until they commit, so numbers become visible in order.
+func nextSeq(transaction *sql.Tx) (int64, error) {
+	var seq int64
+	if err := transaction.QueryRow(`UPDATE sync_meta SET value = value + 1 WHERE name = 'seq' RETURNING value`).Scan(&seq); err != nil {
+		return 0, fmt.Errorf("failed to number change: %w", err)
+	}
+	return seq, nil
+}
+
+// DeviceID identifies this database among the devices it syncs with.
+func (d *Database) DeviceID() string {
+	return d.deviceID
+}
+
+// ChangesSince returns the changes after the watermark since. Pass the
+// returned Watermark next time to get only what changed in between.
+func (d *Database) ChangesSince(ctx context.Context, since int64) (Changes, error) {
+	changes := Changes{Since: since, Events: []models.StoredEvent{}, Tombstones: []Tombstone{}}
+	transaction, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return changes, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	if err := transaction.QueryRowContext(ctx, `SELECT value FROM sync_meta WHERE name = 'seq'`).Scan(&changes.Watermark); err != nil {
+		return changes, fmt.Errorf("failed to read watermark: %w", err)
+	}
+	rows, err := transaction.QueryContext(ctx, `SELECT `+eventColumns+` FROM events LEFT JOIN text_blobs ON hash = text_hash
+	WHERE seq > ? AND seq <= ? ORDER BY seq, id`, since, changes.Watermark)
+	if err != nil {
+		return changes, fmt.Errorf("failed to query changed events: %w", err)
+	}
+	cache := make(textCache)
+	for rows.Next() {
+		event, err := d.scanEvent(ctx, rows, cache)
+		if err != nil {
+			rows.Close()
+			return changes, err
+		}
+		changes.Events = append(changes.Events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return changes, fmt.Errorf("failed to read changed events: %w", err)
+	}
+
+	changes.Tombstones, err = queryTombstones(ctx, transaction, `seq > ? AND seq <= ? ORDER BY seq, uid`, since, changes.Watermark)
+	return changes, err
+}
+
+func queryTombstones(ctx context.Context, transaction *sql.Tx, where string, args ...any) ([]Tombstone, error) {
+	tombstones := []Tombstone{}
+	rows, err := transaction.QueryContext(ctx, `SELECT uid, device_id, deleted_ts, origin_seq FROM tombstones WHERE `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tombstones: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tombstone Tombstone
+		if err := rows.Scan(&tombstone.UID, &tombstone.DeviceID, &tombstone.DeletedTSUTC, &tombstone.OriginSeq); err != nil {
+			return nil, fmt.Errorf("failed to scan tombstone: %w", err)
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tombstones: %w", err)
+	}
+	return tombstones, nil
+}
+
+// VersionVector returns what this database has from every device. Its own
+// entry is the current change number.
+func (d *Database) VersionVector(ctx context.Context) (VersionVector, error) {
+	transaction, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+	return d.versionVector(ctx, transaction)
+}
+
+func (d *Database) versionVector(ctx context.Context, transaction *sql.Tx) (VersionVector, error) {
+	vector := make(VersionVector)
+	rows, err := transaction.QueryContext(ctx, `SELECT device_id, seq FROM sync_vector
+	UNION ALL SELECT ?, value FROM sync_meta WHERE name = 'seq'`, d.deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query version vector: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var deviceID string
+		var seq int64
+		if err := rows.Scan(&deviceID, &seq); err != nil {
+			return nil, fmt.Errorf("failed to scan version vector: %w", err)
+		}
+		vector[deviceID] = seq
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read version vector: %w", err)
+	}
+	return vector, nil
+}
+
+// MissingChanges returns the first page of the changes a database with the
+// vector have lacks, holding about limit events and tombstones. Changes
+// written by one transaction are never split across pages.
+func (d *Database) MissingChanges(ctx context.Context, have VersionVector, limit int) (ChangePage, error) {
+	page := ChangePage{Events: []models.StoredEvent{}, Tombstones: []Tombstone{}, Covers: make(VersionVector)}
+	if limit <= 0 {
+		return page, errors.New("page limit must be positive")
+	}
+	transaction, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return page, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+	vector, err := d.versionVector(ctx, transaction)
+	if err != nil {
+		return page, err
+	}
+	devices := make([]string, 0, len(vector))
+	for deviceID, seq := range vector {
+		if seq > have[deviceID] {
+			devices = append(devices, deviceID)
+		}
+	}
+	sort.Strings(devices)
+
+	cache := make(textCache)
+	size := 0
+	for _, deviceID := range devices {
+		from := have[deviceID]
+		to, complete, count, err := pageEnd(ctx, transaction, deviceID, from, vector[deviceID], limit-size, size == 0)
+		if err != nil {
+			return page, err
+		}
+		if to > from {
+			rows, err := transaction.QueryContext(ctx, `SELECT `+eventColumns+` FROM events LEFT JOIN text_blobs ON hash = text_hash
+			WHERE device_id = ? AND origin_seq > ? AND origin_seq <= ? ORDER BY origin_seq, id`, deviceID, from, to)
+			if err != nil {
+				return page, fmt.Errorf("failed to query missing events: %w", err)
+			}
+			for rows.Next() {
+				event, err := d.scanEvent(ctx, rows, cache)
+				if err != nil {
+					rows.Close()
+					return page, err
+				}
+				event.ID = 0 // row IDs are local to each database
+				page.Events = append(page.Events, event)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return page, fmt.Errorf("failed to read missing events: %w", err)
+			}
+			tombstones, err := queryTombstones(ctx, transaction, `device_id = ? AND origin_seq > ? AND origin_seq <= ? ORDER BY origin_seq, uid`,
+				deviceID, from, to)
+			if err != nil {
+				return page, err
+			}
+			page.Tombstones = append(page.Tombstones, tombstones...)
+			page.Covers[deviceID] = to
+		}
+		size += count
+		if !complete {
+			page.More = true
+			break
+		}
+	}
+	return page, nil
+}
+
+// pageEnd picks how far past from a page may take one device's changes: up to
+// to, unless that would exceed room rows. It returns the last origin number
+// to include, whether that reaches to, and how many rows it takes. A page
+// always takes at least the first change when first is set, however large.
+func pageEnd(ctx context.Context, transaction *sql.Tx, deviceID string, from, to int64, room int, first bool) (int64, bool, int, error) {
+	rows, err := transaction.QueryContext(ctx, `SELECT origin_seq, COUNT(*) FROM (
+	  SELECT origin_seq FROM events     WHERE device_id = ? AND origin_seq > ? AND origin_seq <= ?
+	  UNION ALL
+	  SELECT origin_seq FROM tombstones WHERE device_id = ? AND origin_seq > ? AND origin_seq <= ?
+	) GROUP BY origin_seq ORDER BY origin_seq`, deviceID, from, to, deviceID, from, to)
+	if err != nil {
+		return 0, false, 0, fmt.Errorf("failed to count missing changes: %w", err)
+	}
+	defer rows.Close()
+	end, size := from, 0
+	for rows.Next() {
+		var seq int64
+		var count int
+		if err := rows.Scan(&seq, &count); err != nil {
+			return 0, false, 0, fmt.Errorf("failed to scan change count: %w", err)
+		}
+		if size+count > room && !(first && size == 0) {
+			return end, false, size, nil
+		}
+		end, size = seq, size+count
+	}
+	if err := rows.Err(); err != nil {
+		return 0, false, 0, fmt.Errorf("failed to read change counts: %w", err)
+	}
+	// Gaps at the end, such as merges on the device, are covered too.
+	return to, true, size, nil
+}
+
+// MergeChanges applies events and tombstones from another device. Events are
+// matched by UID, so merging the same changes again, or changes that came
+// back through a third device, does nothing. Tombstones are applied first and
+// kept, so an event deleted anywhere is not resurrected by a stale copy.
+// Since events never change and deletes win, devices that merge each other's
+// changes in any order end up with the same events.
+//
+// covers, if not nil, is the version vector the changes complete, as in a
+// ChangePage; this database's vector is advanced to it.
+func (d *Database) MergeChanges(ctx context.Context, events []models.StoredEvent, tombstones []Tombstone, covers VersionVector) (MergeResult, error) {
+	var result MergeResult
+	transaction, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+	// The change number is taken once something is written, so that merges
+	// that only advance the vector do not look like new changes.
+	var seq int64
+	number := func() (int64, error) {
+		var err error
+		if seq == 0 {
+			seq, err = nextSeq(transaction)
+		}
+		return seq, err
+	}
+
+	changed := false
+	for _, tombstone := range tombstones {
+		if tombstone.UID == "" {
+			return result, errors.New("invalid tombstone: missing uid")
+		}
+		seq, err := number()
+		if err != nil {
+			return result, err
+		}
+		added, err := transaction.ExecContext(ctx, `INSERT OR IGNORE INTO tombstones(uid, device_id, deleted_ts, seq, origin_seq) VALUES(?,?,?,?,?)`,
+			tombstone.UID, tombstone.DeviceID, tombstone.DeletedTSUTC, seq, tombstone.OriginSeq)
+		if err != nil {
+			return result, fmt.Errorf("failed to record tombstone: %w", err)
+		}
+		if count, _ := added.RowsAffected(); count == 0 {
+			continue
+		}
+		changed = true
+		deleted, err := transaction.ExecContext(ctx, `DELETE FROM events WHERE uid = ?`, tombstone.UID)
+		if err != nil {
+			return result, fmt.Errorf("failed to delete event: %w", err)
+		}
+		count, _ := deleted.RowsAffected()
+		result.Deleted += int(count)
+	}
+	if result.Deleted > 0 {
+		if err := deleteOrphanedText(ctx, transaction); err != nil {
+			return result, err
+		}
+		for _, hook := range d.deleteHooks {
+			if err := hook(ctx, transaction); err != nil {
+				return result, fmt.Errorf("delete hook failed: %w", err)
+			}
+		}
+	}
+
+	statement, err := transaction.Prepare(insertEventSQL)
+	if err != nil {
+		return result, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer statement.Close()
+	known, err := transaction.Prepare(`SELECT EXISTS(SELECT 1 FROM events WHERE uid = ?) OR EXISTS(SELECT 1 FROM tombstones WHERE uid = ?)`)
+	if err != nil {
+		return result, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer known.Close()
+
+	var stored []models.StoredEvent
+	for _, event := range events {
+		if event.UID == "" || event.DeviceID == "" {
+			return result, errors.New("invalid event: missing uid or device_id")
+		}
+		event.TSISO = ReconcileISOTimestamp(event.TSUTC, event.TSISO)
+		if err := ValidateEvent(event.Event); err != nil {
+			return result, fmt.Errorf("invalid event %s: %w", event.UID, err)
+		}
+		var exists bool
+		if err := known.QueryRow(event.UID, event.UID).Scan(&exists); err != nil {
+			return result, fmt.Errorf("failed to check for duplicate: %w", err)
+		}
+		if exists {
+			result.Skipped++
+			continue
+		}
+		seq, err := number()
+		if err != nil {
+			return result, err
+		}
+		storedEvent, err := d.insertEvent(transaction, statement, event.Event, event, seq)
+		if err != nil {
+			return result, err
+		}
+		stored = append(stored, storedEvent)
+	}
+	result.Inserted = len(stored)
+	if err := d.runInsertHooks(transaction, stored); err != nil {
+		return result, err
+	}
+
+	advanced, err := d.advanceVector(ctx, transaction, covers)
+	if err != nil {
+		return result, err
+	}
+	if !changed && len(stored) == 0 && !advanced {
+		return result, nil // nothing new; leave the sequence alone
+	}
+	if err := transaction.Commit(); err != nil {
+		return result, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return result, nil
+}
+
+// advanceVector raises the stored vector to covers and reports whether it
+// moved. An entry for this device beyond its change number means this
+// database was restored from an older copy; the number skips ahead so that
+// new changes are not mistaken for ones the other devices already have.
+func (d *Database) advanceVector(ctx context.Context, transaction *sql.Tx, covers VersionVector) (bool, error) {
+	advanced := false
+	for deviceID, seq := range covers {
+		query := `INSERT INTO sync_vector(device_id, seq) VALUES(?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET seq = excluded.seq WHERE excluded.seq > seq`
+		args := []any{deviceID, seq}
+		if deviceID == d.deviceID {
+			query, args = `UPDATE sync_meta SET value = ? WHERE name = 'seq' AND value < ?`, []any{seq, seq}
+		}
+		result, err := transaction.ExecContext(ctx, query, args...)
+		if err != nil {
+			return false, fmt.Errorf("failed to advance version vector: %w", err)
+		}
+		if count, _ := result.RowsAffected(); count > 0 {
+			advanced = true
+		}
+	}
+	return advanced, nil
+}
+
+// EventsByUID returns the stored events with the given UIDs, in insertion
+// order. UIDs of deleted events are left out.
+func (d *Database) EventsByUID(ctx context.Context, uids []string) ([]models.StoredEvent, error) {
+	events := []models.StoredEvent{}
+	if len(uids) == 0 {
+		return events, nil
+	}
+	args := make([]any, len(uids))
+	for i, uid := range uids {
+		args[i] = uid
+	}
+	rows, err := d.db.QueryContext(ctx, `SELECT `+eventColumns+` FROM events LEFT JOIN text_blobs ON hash = text_hash
+	WHERE uid IN (`+strings.TrimSuffix(strings.Repeat("?,", len(uids)), ",")+`) ORDER BY id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+	cache := make(textCache)
+	for rows.Next() {
+		event, err := d.scanEvent(ctx, rows, cache)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read events: %w", err)
+	}
+	return events, nil
+}
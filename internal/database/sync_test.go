@@ -0,0 +1,321 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func TestEventsGetUIDs(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	insertQueryFixtures(t, db)
+
+	if len(db.DeviceID()) != 16 {
+		t.Errorf("Expected a 16 character device ID, got %q", db.DeviceID())
+	}
+	events, err := db.QueryEvents(context.Background(), EventFilter{})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	seen := make(map[string]bool)
+	for _, event := range events {
+		if len(event.UID) != 32 || seen[event.UID] {
+			t.Errorf("Expected a new 32 character UID, got %q", event.UID)
+		}
+		seen[event.UID] = true
+		if event.DeviceID != db.DeviceID() {
+			t.Errorf("Expected device ID %s, got %s", db.DeviceID(), event.DeviceID)
+		}
+	}
+}
+
+func TestMigrationAssignsUIDs(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "browsetrace-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "test.db")
+	db, err := NewDatabase(path)
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	insertQueryFixtures(t, db)
+	// Roll the database back to before sync existed.
+	_, err = db.db.Exec(`
+	DROP INDEX idx_events_uid; DROP INDEX idx_events_seq; DROP INDEX idx_events_origin; DROP INDEX idx_events_local_date;
+	ALTER TABLE events DROP COLUMN origin_seq; ALTER TABLE events DROP COLUMN received_ts_utc;
+	ALTER TABLE events DROP COLUMN timezone; ALTER TABLE events DROP COLUMN utc_offset_minutes; ALTER TABLE events DROP COLUMN local_date;
+	ALTER TABLE events DROP COLUMN uid; ALTER TABLE events DROP COLUMN device_id; ALTER TABLE events DROP COLUMN seq;
+	DROP TABLE sync_meta; DROP TABLE tombstones; DROP TABLE sync_vector;
+	PRAGMA user_version = 5;`)
+	if err != nil {
+		t.Fatalf("Failed to downgrade database: %v", err)
+	}
+	db.Close()
+
+	if db, err = NewDatabase(path); err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer db.Close()
+	changes, err := db.ChangesSince(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("ChangesSince() error = %v", err)
+	}
+	if len(changes.Events) != 4 {
+		t.Fatalf("Expected existing events to be changes, got %d", len(changes.Events))
+	}
+	if changes.Events[0].UID == "" || changes.Events[0].UID == changes.Events[1].UID || changes.Events[0].DeviceID != db.DeviceID() {
+		t.Errorf("Expected distinct UIDs and the local device, got %+v", changes.Events[:2])
+	}
+}
+
+func TestChangesSince(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	initial, err := db.ChangesSince(ctx, 0)
+	if err != nil {
+		t.Fatalf("ChangesSince() error = %v", err)
+	}
+	if len(initial.Events) != 0 || len(initial.Tombstones) != 0 {
+		t.Fatalf("Expected no changes in an empty database, got %+v", initial)
+	}
+
+	insertQueryFixtures(t, db)
+	first, err := db.ChangesSince(ctx, initial.Watermark)
+	if err != nil {
+		t.Fatalf("ChangesSince() error = %v", err)
+	}
+	if len(first.Events) != 4 || first.Watermark <= initial.Watermark {
+		t.Fatalf("Expected 4 events and a higher watermark, got %d events at %d", len(first.Events), first.Watermark)
+	}
+
+	if _, err := db.DeleteEvents(ctx, EventFilter{Types: []string{"click"}}); err != nil {
+		t.Fatalf("DeleteEvents() error = %v", err)
+	}
+	second, err := db.ChangesSince(ctx, first.Watermark)
+	if err != nil {
+		t.Fatalf("ChangesSince() error = %v", err)
+	}
+	if len(second.Events) != 0 || len(second.Tombstones) != 1 {
+		t.Fatalf("Expected only a tombstone, got %d events and %d tombstones", len(second.Events), len(second.Tombstones))
+	}
+	if second.Tombstones[0].UID != first.Events[1].UID || second.Tombstones[0].DeviceID != db.DeviceID() {
+		t.Errorf("Expected a tombstone for the click, got %+v", second.Tombstones[0])
+	}
+
+	again, err := db.ChangesSince(ctx, second.Watermark)
+	if err != nil {
+		t.Fatalf("ChangesSince() error = %v", err)
+	}
+	if len(again.Events) != 0 || len(again.Tombstones) != 0 || again.Watermark != second.Watermark {
+		t.Errorf("Expected nothing new, got %+v", again)
+	}
+}
+
+// openDevice opens a database that stands for one device, removed when the
+// test ends.
+func openDevice(t *testing.T) *Database {
+	t.Helper()
+
+	db, cleanup := setupTestDB(t)
+	t.Cleanup(cleanup)
+	return db
+}
+
+func syncDevices(t *testing.T, from, to *Database, since int64) (MergeResult, int64) {
+	t.Helper()
+
+	changes, err := from.ChangesSince(context.Background(), since)
+	if err != nil {
+		t.Fatalf("ChangesSince() error = %v", err)
+	}
+	result, err := to.MergeChanges(context.Background(), changes.Events, changes.Tombstones, nil)
+	if err != nil {
+		t.Fatalf("MergeChanges() error = %v", err)
+	}
+	return result, changes.Watermark
+}
+
+func uids(t *testing.T, db *Database) map[string]bool {
+	t.Helper()
+
+	events, err := db.QueryEvents(context.Background(), EventFilter{})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	found := make(map[string]bool)
+	for _, event := range events {
+		found[event.UID] = true
+	}
+	return found
+}
+
+func TestMergeChanges(t *testing.T) {
+	laptop, desktop, phone := openDevice(t), openDevice(t), openDevice(t)
+	insertQueryFixtures(t, laptop)
+	if err := desktop.InsertEvents([]models.Event{visibleText(5000, "https://go.dev/", pageLines(0, 10))}); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	result, laptopMark := syncDevices(t, laptop, desktop, 0)
+	if result.Inserted != 4 || result.Skipped != 0 {
+		t.Errorf("Expected 4 inserted, got %+v", result)
+	}
+	if result, _ = syncDevices(t, laptop, desktop, 0); result.Inserted != 0 || result.Skipped != 4 {
+		t.Errorf("Expected merging again to skip everything, got %+v", result)
+	}
+	// The phone only syncs with the desktop but gets the laptop's events.
+	result, desktopMark := syncDevices(t, desktop, phone, 0)
+	if result.Inserted != 5 {
+		t.Errorf("Expected 5 relayed events, got %+v", result)
+	}
+	phoneEvents, err := phone.QueryEvents(context.Background(), EventFilter{Types: []string{"visible_text"}})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if len(phoneEvents) != 1 || phoneEvents[0].Data["text"] != pageLines(0, 10) || phoneEvents[0].DeviceID != desktop.DeviceID() {
+		t.Errorf("Expected the desktop's page text with its device ID, got %+v", phoneEvents)
+	}
+
+	// Forgetting a page on the laptop erases it everywhere.
+	if _, err := laptop.DeleteEvents(context.Background(), EventFilter{URL: "https://example.com/a"}); err != nil {
+		t.Fatalf("DeleteEvents() error = %v", err)
+	}
+	if result, _ = syncDevices(t, laptop, desktop, laptopMark); result.Deleted != 2 {
+		t.Errorf("Expected 2 deleted on the desktop, got %+v", result)
+	}
+	if result, _ = syncDevices(t, desktop, phone, desktopMark); result.Deleted != 2 {
+		t.Errorf("Expected 2 deleted on the phone, got %+v", result)
+	}
+	// A stale copy of the deleted events does not bring them back.
+	if result, _ = syncDevices(t, laptop, phone, 0); result.Inserted != 0 {
+		t.Errorf("Expected nothing resurrected, got %+v", result)
+	}
+	want := uids(t, desktop)
+	for _, db := range []*Database{phone} {
+		got := uids(t, db)
+		if len(got) != 3 || len(got) != len(want) {
+			t.Errorf("Expected 3 events everywhere, got %d and %d", len(got), len(want))
+		}
+		for uid := range want {
+			if !got[uid] {
+				t.Errorf("Expected %s on every device", uid)
+			}
+		}
+	}
+}
+
+func TestMergeChangesRejectsInvalid(t *testing.T) {
+	db := openDevice(t)
+	event := models.StoredEvent{Event: models.Event{TSUTC: 1000, URL: "https://go.dev/", Type: "navigate"}}
+	if _, err := db.MergeChanges(context.Background(), []models.StoredEvent{event}, nil, nil); err == nil {
+		t.Error("Expected an event without a UID to be rejected")
+	}
+	event.UID, event.DeviceID, event.Type = "0123", "device", "hover"
+	if _, err := db.MergeChanges(context.Background(), []models.StoredEvent{event}, nil, nil); err == nil {
+		t.Error("Expected an invalid event to be rejected")
+	}
+}
+
+// pull merges everything from is missing into to, a page at a time, and
+// returns the number of pages.
+func pull(t *testing.T, from, to *Database, limit int) int {
+	t.Helper()
+
+	ctx := context.Background()
+	for pages := 1; ; pages++ {
+		have, err := to.VersionVector(ctx)
+		if err != nil {
+			t.Fatalf("VersionVector() error = %v", err)
+		}
+		page, err := from.MissingChanges(ctx, have, limit)
+		if err != nil {
+			t.Fatalf("MissingChanges() error = %v", err)
+		}
+		if _, err := to.MergeChanges(ctx, page.Events, page.Tombstones, page.Covers); err != nil {
+			t.Fatalf("MergeChanges() error = %v", err)
+		}
+		if !page.More {
+			return pages
+		}
+	}
+}
+
+func TestMissingChanges(t *testing.T) {
+	laptop, desktop, phone := openDevice(t), openDevice(t), openDevice(t)
+	ctx := context.Background()
+	for i := int64(0); i < 3; i++ {
+		batch := []models.Event{
+			{TSUTC: 1000 + i, URL: "https://go.dev/", Type: "navigate"},
+			{TSUTC: 1500 + i, URL: "https://go.dev/", Type: "click"},
+		}
+		if err := laptop.InsertEvents(batch); err != nil {
+			t.Fatalf("InsertEvents() error = %v", err)
+		}
+	}
+
+	page, err := laptop.MissingChanges(ctx, VersionVector{}, 3)
+	if err != nil {
+		t.Fatalf("MissingChanges() error = %v", err)
+	}
+	if len(page.Events) != 2 || !page.More || page.Covers[laptop.DeviceID()] != page.Events[0].OriginSeq {
+		t.Errorf("Expected the first batch alone, got %d events covering %v", len(page.Events), page.Covers)
+	}
+	if page, _ = laptop.MissingChanges(ctx, VersionVector{}, 1); len(page.Events) != 2 {
+		t.Errorf("Expected a batch not to be split, got %d events", len(page.Events))
+	}
+	if pages := pull(t, laptop, desktop, 3); pages != 3 {
+		t.Errorf("Expected 3 pages, got %d", pages)
+	}
+	if pages := pull(t, laptop, desktop, 3); pages != 1 || len(uids(t, desktop)) != 6 {
+		t.Errorf("Expected nothing left to pull, got %d pages", pages)
+	}
+
+	// The phone gets the laptop's events through the desktop, and then
+	// nothing more from the laptop itself.
+	if _, err := laptop.DeleteEvents(ctx, EventFilter{Types: []string{"click"}}); err != nil {
+		t.Fatalf("DeleteEvents() error = %v", err)
+	}
+	pull(t, laptop, desktop, 100)
+	pull(t, desktop, phone, 100)
+	if got := uids(t, phone); len(got) != 3 {
+		t.Errorf("Expected the 3 remaining events on the phone, got %d", len(got))
+	}
+	have, err := phone.VersionVector(ctx)
+	if err != nil {
+		t.Fatalf("VersionVector() error = %v", err)
+	}
+	page, err = laptop.MissingChanges(ctx, have, 100)
+	if err != nil {
+		t.Fatalf("MissingChanges() error = %v", err)
+	}
+	if len(page.Events) != 0 || len(page.Tombstones) != 0 || page.More {
+		t.Errorf("Expected the phone to be up to date with the laptop, got %+v", page)
+	}
+}
+
+func TestMergeChangesSkipsAheadAfterRestore(t *testing.T) {
+	db := openDevice(t)
+	ctx := context.Background()
+	insertQueryFixtures(t, db)
+	if _, err := db.MergeChanges(ctx, nil, nil, VersionVector{db.DeviceID(): 100}); err != nil {
+		t.Fatalf("MergeChanges() error = %v", err)
+	}
+	if err := db.InsertEvents([]models.Event{{TSUTC: 9000, URL: "https://go.dev/", Type: "navigate"}}); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+	vector, err := db.VersionVector(ctx)
+	if err != nil {
+		t.Fatalf("VersionVector() error = %v", err)
+	}
+	if vector[db.DeviceID()] != 101 {
+		t.Errorf("Expected new changes numbered after 100, got %v", vector)
+	}
+}
@@ -0,0 +1,285 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Page text from visible_text events lives in text_blobs, keyed by the SHA-256
+// of the full text, and the event row points at it through text_hash. Since
+// the extension resends the same text as the user scrolls, identical
+// snapshots are stored once. With diffs enabled, a snapshot can instead be
+// stored as a line diff against the previous snapshot of the same URL.
+//
+// A diff is one operation per line:
+//
+//	@12,30   copy 30 lines of the base text starting at line 12
+//	+text    insert this line
+//
+// Reads reassemble the full text, so data.text looks the same either way.
+// Blob content may additionally be compressed; see compress.go.
+
+// maxDiffDepth bounds how many diffs a read may have to apply; the next
+// snapshot after that many is stored in full.
+const maxDiffDepth = 16
+
+// SetTextDiffs enables storing page text as diffs against the previous
+// snapshot of the same URL when that is substantially smaller.
+func (d *Database) SetTextDiffs(enabled bool) {
+	d.textDiffs = enabled
+}
+
+// rowQuerier is satisfied by both *sql.DB and *sql.Tx.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func hashText(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// prepareData splits the page text off a visible_text event's data, returning
+// the JSON to store in data_json and the text, if any.
+func prepareData(eventType string, data map[string]any) (string, *string, error) {
+	text, isText := data["text"].(string)
+	if eventType != "visible_text" || !isText {
+		encoded, err := json.Marshal(data)
+		return string(encoded), nil, err
+	}
+	rest := make(map[string]any, len(data))
+	for key, value := range data {
+		if key != "text" {
+			rest[key] = value
+		}
+	}
+	encoded, err := json.Marshal(rest)
+	return string(encoded), &text, err
+}
+
+// storeText saves text as a blob unless it is already stored, and returns its
+// hash. url is the URL as stored, so sealed when the database is encrypted.
+func (d *Database) storeText(ctx context.Context, transaction *sql.Tx, url, text string) (string, error) {
+	hash := hashText(text)
+	var exists bool
+	if err := transaction.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM text_blobs WHERE hash = ?)`, hash).Scan(&exists); err != nil {
+		return "", fmt.Errorf("failed to look up text: %w", err)
+	}
+	if exists {
+		return hash, nil
+	}
+
+	var baseHash sql.NullString
+	content, depth := text, 0
+	if d.textDiffs {
+		diff, base, baseDepth, err := d.diffAgainstPrevious(ctx, transaction, url, text)
+		if err != nil {
+			return "", err
+		}
+		if base != "" && baseDepth < maxDiffDepth && len(diff) < len(text)/2 {
+			baseHash = sql.NullString{String: base, Valid: true}
+			content, depth = diff, baseDepth+1
+		}
+	}
+	stored, codec, err := d.compressText(content)
+	if err != nil {
+		return "", err
+	}
+	_, err = transaction.ExecContext(ctx, `INSERT INTO text_blobs(hash, base_hash, content, codec, length, depth) VALUES(?,?,?,?,?,?)`,
+		hash, baseHash, d.sealContent(stored), codec, utf8.RuneCountInString(text), depth)
+	if err != nil {
+		return "", fmt.Errorf("failed to store text: %w", err)
+	}
+	return hash, nil
+}
+
+// diffAgainstPrevious diffs text against the latest stored snapshot of url,
+// returning the diff, the snapshot's hash and its diff depth. The hash is
+// empty when the URL has no stored text.
+func (d *Database) diffAgainstPrevious(ctx context.Context, transaction *sql.Tx, url, text string) (string, string, int, error) {
+	var base string
+	var depth int
+	err := transaction.QueryRowContext(ctx, `
+	SELECT e.text_hash, b.depth FROM events e JOIN text_blobs b ON b.hash = e.text_hash
+	WHERE e.url = ? ORDER BY e.ts_utc DESC, e.id DESC LIMIT 1`, url).Scan(&base, &depth)
+	if err == sql.ErrNoRows {
+		return "", "", 0, nil
+	}
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to find previous text: %w", err)
+	}
+	baseText, err := d.loadText(ctx, transaction, base, nil)
+	if err != nil {
+		return "", "", 0, err
+	}
+	return diffLines(baseText, text), base, depth, nil
+}
+
+// textCache holds reassembled texts during one read, so that a run of diffs
+// against the same snapshot does not rebuild it each time.
+type textCache map[string]string
+
+const textCacheSize = 64
+
+// loadText returns the full text of a blob, applying diffs down to the
+// nearest full snapshot.
+func (d *Database) loadText(ctx context.Context, querier rowQuerier, hash string, cache textCache) (string, error) {
+	if text, ok := cache[hash]; ok {
+		return text, nil
+	}
+	var baseHash sql.NullString
+	var content []byte
+	var codec string
+	err := querier.QueryRowContext(ctx, `SELECT base_hash, content, codec FROM text_blobs WHERE hash = ?`, hash).Scan(&baseHash, &content, &codec)
+	if err != nil {
+		return "", fmt.Errorf("failed to load text %s: %w", hash, err)
+	}
+	return d.resolveText(ctx, querier, baseHash, content, codec, cache)
+}
+
+// resolveText decodes a blob's content and applies it to its base, if any.
+func (d *Database) resolveText(ctx context.Context, querier rowQuerier, baseHash sql.NullString, stored []byte, codec string, cache textCache) (string, error) {
+	content, err := d.openText(stored, codec)
+	if err != nil {
+		return "", err
+	}
+	if !baseHash.Valid {
+		return content, nil
+	}
+	base, err := d.loadText(ctx, querier, baseHash.String, cache)
+	if err != nil {
+		return "", err
+	}
+	if cache != nil {
+		if len(cache) >= textCacheSize {
+			clear(cache)
+		}
+		cache[baseHash.String] = base
+	}
+	return applyDiff(base, content)
+}
+
+// diffLines encodes next as copies of runs of lines from base and inserted
+// lines. Each line of next is matched greedily against the longest run in
+// base starting at one of its occurrences, which handles both edits and text
+// that scrolled up or down.
+func diffLines(base, next string) string {
+	baseLines := strings.Split(base, "\n")
+	nextLines := strings.Split(next, "\n")
+	positions := make(map[string][]int)
+	for i, line := range baseLines {
+		if len(positions[line]) < 8 {
+			positions[line] = append(positions[line], i)
+		}
+	}
+
+	var ops []string
+	for i := 0; i < len(nextLines); {
+		bestStart, bestLength := 0, 0
+		for _, start := range positions[nextLines[i]] {
+			length := 0
+			for i+length < len(nextLines) && start+length < len(baseLines) && nextLines[i+length] == baseLines[start+length] {
+				length++
+			}
+			if length > bestLength {
+				bestStart, bestLength = start, length
+			}
+		}
+		if bestLength == 0 {
+			ops = append(ops, "+"+nextLines[i])
+			i++
+			continue
+		}
+		ops = append(ops, "@"+strconv.Itoa(bestStart)+","+strconv.Itoa(bestLength))
+		i += bestLength
+	}
+	return strings.Join(ops, "\n")
+}
+
+func applyDiff(base, diff string) (string, error) {
+	baseLines := strings.Split(base, "\n")
+	var lines []string
+	for _, op := range strings.Split(diff, "\n") {
+		if line, ok := strings.CutPrefix(op, "+"); ok {
+			lines = append(lines, line)
+			continue
+		}
+		start, count, ok := strings.Cut(strings.TrimPrefix(op, "@"), ",")
+		from, err := strconv.Atoi(start)
+		if !ok || err != nil {
+			return "", fmt.Errorf("invalid text diff operation %q", op)
+		}
+		length, err := strconv.Atoi(count)
+		if err != nil || from < 0 || length < 0 || from+length > len(baseLines) {
+			return "", fmt.Errorf("invalid text diff operation %q", op)
+		}
+		lines = append(lines, baseLines[from:from+length]...)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// CompactText moves page text still stored inline in data_json, such as text
+// recorded before blobs existed, into text_blobs. It returns how many events
+// were rewritten.
+func (d *Database) CompactText(ctx context.Context) (int, error) {
+	transaction, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	rows, err := transaction.QueryContext(ctx, `
+	SELECT id, url, data_json FROM events
+	WHERE type = 'visible_text' AND text_hash IS NULL AND json_type(data_json, '$.text') = 'text'
+	ORDER BY ts_utc, id`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query events: %w", err)
+	}
+	type inline struct {
+		id       int64
+		url      string
+		dataJSON string
+	}
+	var pending []inline
+	for rows.Next() {
+		var row inline
+		if err := rows.Scan(&row.id, &row.url, &row.dataJSON); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan event: %w", err)
+		}
+		pending = append(pending, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read events: %w", err)
+	}
+
+	for _, row := range pending {
+		var data map[string]any
+		if err := json.Unmarshal([]byte(row.dataJSON), &data); err != nil {
+			return 0, fmt.Errorf("failed to unmarshal data for event %d: %w", row.id, err)
+		}
+		dataJSON, text, err := prepareData("visible_text", data)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal data for event %d: %w", row.id, err)
+		}
+		hash, err := d.storeText(ctx, transaction, row.url, *text)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := transaction.ExecContext(ctx, `UPDATE events SET data_json = json(?), text_hash = ? WHERE id = ?`, d.sealData(dataJSON), hash, row.id); err != nil {
+			return 0, fmt.Errorf("failed to update event %d: %w", row.id, err)
+		}
+	}
+	if err := transaction.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(pending), nil
+}
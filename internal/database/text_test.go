@@ -0,0 +1,202 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// pageLines returns lines first..last-1 of an imaginary long page.
+func pageLines(first, last int) string {
+	var lines []string
+	for i := first; i < last; i++ {
+		lines = append(lines, fmt.Sprintf("Paragraph %d of the article, long enough to matter.", i))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestDiffLinesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		base string
+		next string
+	}{
+		{"identical", pageLines(0, 20), pageLines(0, 20)},
+		{"scrolled down", pageLines(0, 20), pageLines(5, 25)},
+		{"scrolled up", pageLines(5, 25), pageLines(0, 20)},
+		{"edited line", "a\nb\nc\nd", "a\nB\nc\nd"},
+		{"unrelated", "one\ntwo", "three\nfour"},
+		{"empty base", "", "x\ny"},
+		{"empty next", "x\ny", ""},
+		{"blank lines", "a\n\n\nb", "\na\n\nb\n"},
+		{"operation-like text", "@1,2\n+x", "+x\n@1,2\n@9,9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := diffLines(tt.base, tt.next)
+			got, err := applyDiff(tt.base, diff)
+			if err != nil {
+				t.Fatalf("applyDiff() error = %v (diff %q)", err, diff)
+			}
+			if got != tt.next {
+				t.Errorf("Round trip mismatch:\nwant %q\ngot  %q\ndiff %q", tt.next, got, diff)
+			}
+		})
+	}
+
+	if diff := diffLines(pageLines(0, 20), pageLines(5, 25)); len(diff) > len(pageLines(5, 25))/2 {
+		t.Errorf("Expected a scroll to diff to well under half the text, got %d of %d bytes", len(diff), len(pageLines(5, 25)))
+	}
+}
+
+func TestApplyDiffInvalid(t *testing.T) {
+	for _, diff := range []string{"@0,5", "@x,1", "@-1,1", "copy"} {
+		if _, err := applyDiff("a\nb", diff); err == nil {
+			t.Errorf("Expected an error for diff %q", diff)
+		}
+	}
+}
+
+func visibleText(ts int64, url, text string) models.Event {
+	return models.Event{TSUTC: ts, TSISO: models.FormatTimestamp(ts), URL: url, Type: "visible_text",
+		Data: map[string]any{"text": text, "trigger": "scroll"}}
+}
+
+func countBlobs(t *testing.T, db *Database) (full, diffs int) {
+	t.Helper()
+
+	err := db.db.QueryRow(`SELECT COUNT(*) FILTER (WHERE base_hash IS NULL), COUNT(*) FILTER (WHERE base_hash IS NOT NULL) FROM text_blobs`).Scan(&full, &diffs)
+	if err != nil {
+		t.Fatalf("Failed to count blobs: %v", err)
+	}
+	return full, diffs
+}
+
+func TestVisibleTextDeduplicated(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	text := pageLines(0, 10)
+	events := []models.Event{
+		visibleText(1000, "https://example.com/a", text),
+		visibleText(2000, "https://example.com/a", text),
+		visibleText(3000, "https://example.com/b", text),
+		{TSUTC: 4000, TSISO: "1970-01-01T00:00:04.000Z", URL: "https://example.com/a", Type: "click", Data: map[string]any{"text": "Buy"}},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	if full, diffs := countBlobs(t, db); full != 1 || diffs != 0 {
+		t.Errorf("Expected one blob for three identical snapshots, got %d full and %d diffs", full, diffs)
+	}
+	var inline int
+	if err := db.db.QueryRow(`SELECT COUNT(*) FROM events WHERE json_extract(data_json, '$.text') IS NOT NULL`).Scan(&inline); err != nil {
+		t.Fatalf("Failed to query events: %v", err)
+	}
+	if inline != 1 {
+		t.Errorf("Expected only the click to keep inline text, got %d rows", inline)
+	}
+
+	stored, err := db.QueryEvents(context.Background(), EventFilter{})
+	if err != nil {
+		t.Fatalf("Failed to query events: %v", err)
+	}
+	for _, event := range stored[:3] {
+		if event.Data["text"] != text || event.Data["trigger"] != "scroll" {
+			t.Errorf("Expected text reassembled for event %d, got %v", event.ID, event.Data)
+		}
+	}
+	if stored[3].Data["text"] != "Buy" {
+		t.Errorf("Expected click data untouched, got %v", stored[3].Data)
+	}
+}
+
+func TestVisibleTextDiffs(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	db.SetTextDiffs(true)
+
+	var events []models.Event
+	for i := 0; i < maxDiffDepth+4; i++ {
+		events = append(events, visibleText(int64(1000*(i+1)), "https://example.com/long", pageLines(i, i+30)))
+	}
+	events = append(events, visibleText(100000, "https://example.com/long", pageLines(0, 30)+"\nA brand new closing remark."))
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	// Full snapshots: the first, the one after maxDiffDepth diffs, and the
+	// last, which shares too little with the one before it.
+	full, diffs := countBlobs(t, db)
+	if full != 3 || diffs != len(events)-3 {
+		t.Errorf("Expected 3 full snapshots and %d diffs, got %d and %d", len(events)-3, full, diffs)
+	}
+	var depth int
+	if err := db.db.QueryRow(`SELECT MAX(depth) FROM text_blobs`).Scan(&depth); err != nil {
+		t.Fatalf("Failed to query depth: %v", err)
+	}
+	if depth > maxDiffDepth {
+		t.Errorf("Expected diff chains capped at %d, got %d", maxDiffDepth, depth)
+	}
+
+	stored, err := db.QueryEvents(context.Background(), EventFilter{})
+	if err != nil {
+		t.Fatalf("Failed to query events: %v", err)
+	}
+	for i, event := range stored {
+		if event.Data["text"] != events[i].Data["text"] {
+			t.Errorf("Event %d: text not reassembled exactly", i)
+		}
+	}
+
+	found, err := db.QueryEvents(context.Background(), EventFilter{Text: "closing remark"})
+	if err != nil {
+		t.Fatalf("Failed to search events: %v", err)
+	}
+	if len(found) != 1 || found[0].TSUTC != 100000 {
+		t.Errorf("Expected search to find the line the diff inserted, got %d events", len(found))
+	}
+}
+
+func TestCompactText(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Rows as stored before text blobs existed.
+	_, err := db.db.Exec(`
+	INSERT INTO events(ts_utc, ts_iso, url, type, data_json) VALUES
+	  (1000, '1970-01-01T00:00:01.000Z', 'https://example.com', 'visible_text', '{"text":"hello\nworld","trigger":"load"}'),
+	  (2000, '1970-01-01T00:00:02.000Z', 'https://example.com', 'visible_text', '{"text":"hello\nworld"}'),
+	  (3000, '1970-01-01T00:00:03.000Z', 'https://example.com', 'scroll', '{"y":100}')`)
+	if err != nil {
+		t.Fatalf("Failed to insert legacy rows: %v", err)
+	}
+
+	compacted, err := db.CompactText(context.Background())
+	if err != nil {
+		t.Fatalf("CompactText() error = %v", err)
+	}
+	if compacted != 2 {
+		t.Errorf("Expected 2 events compacted, got %d", compacted)
+	}
+	if full, _ := countBlobs(t, db); full != 1 {
+		t.Errorf("Expected 1 blob, got %d", full)
+	}
+
+	stored, err := db.QueryEvents(context.Background(), EventFilter{Types: []string{"visible_text"}})
+	if err != nil {
+		t.Fatalf("Failed to query events: %v", err)
+	}
+	if len(stored) != 2 || stored[0].Data["text"] != "hello\nworld" || stored[0].Data["trigger"] != "load" {
+		t.Errorf("Expected compacted text to read back unchanged, got %+v", stored)
+	}
+
+	if compacted, err := db.CompactText(context.Background()); err != nil || compacted != 0 {
+		t.Errorf("Expected nothing left to compact, got %d, %v", compacted, err)
+	}
+}
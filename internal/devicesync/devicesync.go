@@ -0,0 +1,125 @@
+// Package devicesync merges the histories of one person's agents, through
+// bundle files or directly over HTTP. With bundles, one agent writes the
+// changes since a watermark to a file and another merges it; peers exchange
+// version vectors instead and send each other what is missing.
+//
+// A bundle holds the new events, with the UIDs and device IDs that identify
+// them everywhere, and tombstones for deleted ones, so that erasures spread
+// like inserts. It is signed with HMAC-SHA256 under a secret shared by the
+// devices, and merging refuses bundles with a bad signature. Bundles are not
+// encrypted: like an export, they hold the history in the clear.
+package devicesync
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+)
+
+// FormatVersion identifies the bundle layout.
+const FormatVersion = "browsetrace-sync/1"
+
+// ErrBadSignature means a bundle was signed with another secret or altered.
+var ErrBadSignature = errors.New("bundle signature does not match the sync secret")
+
+// Bundle carries the changes of one device since a watermark.
+type Bundle struct {
+	Format       string `json:"format"`
+	DeviceID     string `json:"device_id"`
+	CreatedTSUTC int64  `json:"created_ts_utc"`
+	database.Changes
+}
+
+// envelope is the file layout: the bundle as JSON, and its signature. The
+// signature covers the payload bytes exactly as written.
+type envelope struct {
+	Payload   json.RawMessage `json:"payload"`
+	Signature string          `json:"signature"`
+}
+
+// Export collects the changes in db since the watermark since.
+func Export(ctx context.Context, db *database.Database, since int64) (Bundle, error) {
+	changes, err := db.ChangesSince(ctx, since)
+	if err != nil {
+		return Bundle{}, err
+	}
+	// Row IDs are local to each database.
+	for i := range changes.Events {
+		changes.Events[i].ID = 0
+	}
+	return Bundle{
+		Format:       FormatVersion,
+		DeviceID:     db.DeviceID(),
+		CreatedTSUTC: time.Now().UnixMilli(),
+		Changes:      changes,
+	}, nil
+}
+
+// Import merges bundle into db.
+func Import(ctx context.Context, db *database.Database, bundle Bundle) (database.MergeResult, error) {
+	return db.MergeChanges(ctx, bundle.Events, bundle.Tombstones, nil)
+}
+
+func sign(payload, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Write signs bundle with secret and writes it to w, gzipped.
+func Write(w io.Writer, bundle Bundle, secret []byte) error {
+	if len(secret) == 0 {
+		return errors.New("a sync secret is required to sign bundles")
+	}
+	payload, err := json.Marshal(bundle)
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle: %w", err)
+	}
+	compressed := gzip.NewWriter(w)
+	if err := json.NewEncoder(compressed).Encode(envelope{Payload: payload, Signature: sign(payload, secret)}); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := compressed.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return nil
+}
+
+// Read reads a bundle written by Write and checks its signature with secret.
+func Read(r io.Reader, secret []byte) (Bundle, error) {
+	var bundle Bundle
+	if len(secret) == 0 {
+		return bundle, errors.New("a sync secret is required to verify bundles")
+	}
+	compressed, err := gzip.NewReader(r)
+	if err != nil {
+		return bundle, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	defer compressed.Close()
+	var file envelope
+	if err := json.NewDecoder(compressed).Decode(&file); err != nil {
+		return bundle, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	if !hmac.Equal([]byte(sign(file.Payload, secret)), []byte(file.Signature)) {
+		return bundle, ErrBadSignature
+	}
+	decoder := json.NewDecoder(bytes.NewReader(file.Payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&bundle); err != nil {
+		return bundle, fmt.Errorf("failed to decode bundle: %w", err)
+	}
+	if bundle.Format != FormatVersion {
+		return bundle, fmt.Errorf("unsupported bundle format %q", bundle.Format)
+	}
+	return bundle, nil
+}
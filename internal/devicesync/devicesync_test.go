@@ -0,0 +1,141 @@
+package devicesync
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+var secret = []byte("shared by every device")
+
+func setupTestDB(t *testing.T) (*database.Database, func()) {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "browsetrace-devicesync-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	db, err := database.NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+	return db, cleanup
+}
+
+func insertEvents(t *testing.T, db *database.Database) {
+	t.Helper()
+
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: models.FormatTimestamp(1000), URL: "https://go.dev/", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, TSISO: models.FormatTimestamp(2000), URL: "https://go.dev/", Type: "scroll",
+			Data: map[string]any{"depth": 0.75}},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	laptop, cleanupLaptop := setupTestDB(t)
+	defer cleanupLaptop()
+	desktop, cleanupDesktop := setupTestDB(t)
+	defer cleanupDesktop()
+	insertEvents(t, laptop)
+
+	bundle, err := Export(context.Background(), laptop, 0)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	var file bytes.Buffer
+	if err := Write(&file, bundle, secret); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	read, err := Read(bytes.NewReader(file.Bytes()), secret)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if read.DeviceID != laptop.DeviceID() || read.Watermark != bundle.Watermark || len(read.Events) != 2 {
+		t.Fatalf("Expected the bundle back, got %+v", read)
+	}
+
+	result, err := Import(context.Background(), desktop, read)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if result.Inserted != 2 {
+		t.Errorf("Expected 2 inserted, got %+v", result)
+	}
+	events, err := desktop.QueryEvents(context.Background(), database.EventFilter{Types: []string{"scroll"}})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if depth, _ := json.Marshal(events[0].Data["depth"]); string(depth) != "0.75" {
+		t.Errorf("Expected depth 0.75, got %s", depth)
+	}
+	if events[0].UID != bundle.Events[1].UID || events[0].DeviceID != laptop.DeviceID() {
+		t.Errorf("Expected the laptop's identity kept, got %s from %s", events[0].UID, events[0].DeviceID)
+	}
+
+	if result, err = Import(context.Background(), desktop, read); err != nil || result.Inserted != 0 {
+		t.Errorf("Expected importing twice to change nothing, got %+v, %v", result, err)
+	}
+}
+
+func TestReadRejectsBadBundles(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	insertEvents(t, db)
+	bundle, err := Export(context.Background(), db, 0)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	var file bytes.Buffer
+	if err := Write(&file, bundle, secret); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if _, err := Read(bytes.NewReader(file.Bytes()), []byte("another secret")); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected ErrBadSignature for the wrong secret, got %v", err)
+	}
+
+	// Change one URL without re-signing.
+	reader, err := gzip.NewReader(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to unzip bundle: %v", err)
+	}
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to unzip bundle: %v", err)
+	}
+	tampered := bytes.Replace(contents, []byte("https://go.dev/"), []byte("https://evil.test"), 1)
+	var rezipped bytes.Buffer
+	writer := gzip.NewWriter(&rezipped)
+	writer.Write(tampered)
+	writer.Close()
+	if _, err := Read(&rezipped, secret); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected ErrBadSignature for a tampered bundle, got %v", err)
+	}
+
+	if err := Write(io.Discard, bundle, nil); err == nil {
+		t.Error("Expected Write without a secret to fail")
+	}
+	if _, err := Read(bytes.NewReader([]byte("not a bundle")), secret); err == nil {
+		t.Error("Expected Read of garbage to fail")
+	}
+}
@@ -0,0 +1,307 @@
+package devicesync
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// Agents can also sync directly over HTTP. The calling agent posts its
+// version vector to the other agent's /sync endpoint and gets back a page of
+// the changes it lacks, until it has them all; then it posts the changes the
+// other agent lacks, a page per request. Every request and response body is
+// signed with the sync secret, together with the time it was sent and a
+// random nonce, so only agents that share the secret can read from or write
+// to each other, and a captured message cannot be sent again.
+
+const (
+	// DefaultPageSize is how many events and tombstones a sync page holds.
+	DefaultPageSize = 500
+	// MaxPageSize bounds the pages an agent serves.
+	MaxPageSize = 5000
+
+	// TimestampHeader, NonceHeader and SignatureHeader carry a sync
+	// message's signature.
+	TimestampHeader = "X-Browsetrace-Timestamp"
+	NonceHeader     = "X-Browsetrace-Nonce"
+	SignatureHeader = "X-Browsetrace-Signature"
+
+	// maxClockSkew is how old or early a signed message may be.
+	maxClockSkew = 5 * time.Minute
+)
+
+// ErrStale means a sync message was signed too long ago, or by a clock far
+// off this one.
+var ErrStale = errors.New("sync message timestamp is outside the allowed clock skew")
+
+// ErrReplayed means a sync message carries a nonce already seen.
+var ErrReplayed = errors.New("sync message nonce was already used")
+
+// Request is what an agent posts to /sync: its vector, and any changes it
+// pushes with the vector they complete.
+type Request struct {
+	DeviceID   string                 `json:"device_id"`
+	Vector     database.VersionVector `json:"vector"`
+	Limit      int                    `json:"limit,omitempty"`
+	Events     []models.StoredEvent   `json:"events,omitempty"`
+	Tombstones []database.Tombstone   `json:"tombstones,omitempty"`
+	Covers     database.VersionVector `json:"covers,omitempty"`
+}
+
+// Response is what /sync returns: what merging the pushed changes did, the
+// answering agent's vector after that, and a page of what the caller lacks.
+type Response struct {
+	DeviceID string                 `json:"device_id"`
+	Merged   database.MergeResult   `json:"merged"`
+	Vector   database.VersionVector `json:"vector"`
+	database.ChangePage
+}
+
+// Result sums up a sync with another agent.
+type Result struct {
+	Received database.MergeResult `json:"received"`
+	Sent     database.MergeResult `json:"sent"`
+}
+
+// Peer syncs one agent's database with others sharing a secret.
+type Peer struct {
+	db     *database.Database
+	secret []byte
+	client *http.Client
+	// PageSize is how many events and tombstones to ask for or send at once.
+	PageSize int
+
+	// seen holds the nonces of verified messages until their timestamps
+	// fall outside the clock skew.
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// NewPeer returns a Peer for db that signs its messages with secret.
+func NewPeer(db *database.Database, secret []byte) (*Peer, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("a sync secret is required to sync with peers")
+	}
+	return &Peer{
+		db: db, secret: secret, client: &http.Client{Timeout: time.Minute}, PageSize: DefaultPageSize,
+		seen: make(map[string]time.Time),
+	}, nil
+}
+
+// SetClient replaces the HTTP client used to reach other agents.
+func (p *Peer) SetClient(client *http.Client) {
+	p.client = client
+}
+
+// Sign returns the timestamp, nonce and signature headers for body.
+func (p *Peer) Sign(body []byte, now time.Time) (string, string, string) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	random := make([]byte, 16)
+	rand.Read(random)
+	nonce := hex.EncodeToString(random)
+	return timestamp, nonce, sign(signedMessage(body, timestamp, nonce), p.secret)
+}
+
+// Verify checks the timestamp, nonce and signature sent with body, and
+// refuses a nonce it already verified.
+func (p *Peer) Verify(body []byte, timestamp, nonce, signature string, now time.Time) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || nonce == "" {
+		return ErrBadSignature
+	}
+	expected := sign(signedMessage(body, timestamp, nonce), p.secret)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return ErrBadSignature
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for seen, expires := range p.seen {
+		if now.After(expires) {
+			delete(p.seen, seen)
+		}
+	}
+	sent := time.Unix(seconds, 0)
+	if skew := now.Sub(sent); skew > maxClockSkew || skew < -maxClockSkew {
+		return ErrStale
+	}
+	if _, ok := p.seen[nonce]; ok {
+		return ErrReplayed
+	}
+	p.seen[nonce] = sent.Add(maxClockSkew)
+	return nil
+}
+
+// signedMessage is what a sync message's signature covers.
+func signedMessage(body []byte, timestamp, nonce string) []byte {
+	return append([]byte(timestamp+"\n"+nonce+"\n"), body...)
+}
+
+// Handle answers a /sync request: it merges the pushed changes, then returns
+// a page of the changes the caller lacks.
+func (p *Peer) Handle(ctx context.Context, request Request) (Response, error) {
+	response := Response{DeviceID: p.db.DeviceID()}
+	if request.DeviceID == p.db.DeviceID() {
+		return response, errors.New("cannot sync a database with itself")
+	}
+	if len(request.Events) > 0 || len(request.Tombstones) > 0 || len(request.Covers) > 0 {
+		merged, err := p.db.MergeChanges(ctx, request.Events, request.Tombstones, request.Covers)
+		if err != nil {
+			return response, err
+		}
+		response.Merged = merged
+	}
+	limit := request.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	page, err := p.db.MissingChanges(ctx, request.Vector, min(limit, MaxPageSize))
+	if err != nil {
+		return response, err
+	}
+	response.ChangePage = page
+	if response.Vector, err = p.db.VersionVector(ctx); err != nil {
+		return response, err
+	}
+	return response, nil
+}
+
+// Sync pulls the changes this agent lacks from the agent at url, the base
+// address its server listens on, then pushes the changes it lacks.
+func (p *Peer) Sync(ctx context.Context, url string) (Result, error) {
+	var result Result
+	endpoint := strings.TrimSuffix(url, "/") + "/sync"
+
+	var theirs database.VersionVector
+	for {
+		mine, err := p.db.VersionVector(ctx)
+		if err != nil {
+			return result, err
+		}
+		response, err := p.post(ctx, endpoint, Request{DeviceID: p.db.DeviceID(), Vector: mine, Limit: p.PageSize})
+		if err != nil {
+			return result, err
+		}
+		if err := p.receive(ctx, response, &result); err != nil {
+			return result, err
+		}
+		theirs = response.Vector
+		if !response.More {
+			break
+		}
+	}
+
+	for {
+		page, err := p.db.MissingChanges(ctx, theirs, p.PageSize)
+		if err != nil {
+			return result, err
+		}
+		if !advances(page.Covers, theirs) {
+			return result, nil
+		}
+		mine, err := p.db.VersionVector(ctx)
+		if err != nil {
+			return result, err
+		}
+		response, err := p.post(ctx, endpoint, Request{
+			DeviceID: p.db.DeviceID(), Vector: mine, Limit: p.PageSize,
+			Events: page.Events, Tombstones: page.Tombstones, Covers: page.Covers,
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Sent.Inserted += response.Merged.Inserted
+		result.Sent.Deleted += response.Merged.Deleted
+		result.Sent.Skipped += response.Merged.Skipped
+		if err := p.receive(ctx, response, &result); err != nil {
+			return result, err
+		}
+		if !advances(response.Vector, theirs) {
+			return result, fmt.Errorf("peer %s did not take the changes sent to it", response.DeviceID)
+		}
+		theirs = response.Vector
+	}
+}
+
+// receive merges a page from another agent into the local database.
+func (p *Peer) receive(ctx context.Context, response Response, result *Result) error {
+	if response.DeviceID == p.db.DeviceID() {
+		return errors.New("cannot sync a database with itself")
+	}
+	if len(response.Events) == 0 && len(response.Tombstones) == 0 && len(response.Covers) == 0 {
+		return nil
+	}
+	merged, err := p.db.MergeChanges(ctx, response.Events, response.Tombstones, response.Covers)
+	if err != nil {
+		return err
+	}
+	result.Received.Inserted += merged.Inserted
+	result.Received.Deleted += merged.Deleted
+	result.Received.Skipped += merged.Skipped
+	return nil
+}
+
+// advances reports whether vector is ahead of base for any device.
+func advances(vector, base database.VersionVector) bool {
+	for deviceID, seq := range vector {
+		if seq > base[deviceID] {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Peer) post(ctx context.Context, endpoint string, request Request) (Response, error) {
+	var response Response
+	body, err := json.Marshal(request)
+	if err != nil {
+		return response, fmt.Errorf("failed to encode sync request: %w", err)
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return response, fmt.Errorf("failed to create sync request: %w", err)
+	}
+	timestamp, nonce, signature := p.Sign(body, time.Now())
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set(TimestampHeader, timestamp)
+	httpRequest.Header.Set(NonceHeader, nonce)
+	httpRequest.Header.Set(SignatureHeader, signature)
+
+	httpResponse, err := p.client.Do(httpRequest)
+	if err != nil {
+		return response, fmt.Errorf("failed to reach peer: %w", err)
+	}
+	defer httpResponse.Body.Close()
+	payload, err := io.ReadAll(httpResponse.Body)
+	if err != nil {
+		return response, fmt.Errorf("failed to read sync response: %w", err)
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		return response, fmt.Errorf("peer answered %s: %s", httpResponse.Status, strings.TrimSpace(string(payload)))
+	}
+	err = p.Verify(payload, httpResponse.Header.Get(TimestampHeader), httpResponse.Header.Get(NonceHeader),
+		httpResponse.Header.Get(SignatureHeader), time.Now())
+	if err != nil {
+		return response, fmt.Errorf("failed to verify sync response: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&response); err != nil {
+		return response, fmt.Errorf("failed to decode sync response: %w", err)
+	}
+	return response, nil
+}
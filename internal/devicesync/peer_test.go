@@ -0,0 +1,139 @@
+package devicesync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	peer, err := NewPeer(db, secret)
+	if err != nil {
+		t.Fatalf("NewPeer() error = %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"device_id":"laptop"}`)
+	timestamp, nonce, signature := peer.Sign(body, now)
+
+	tests := []struct {
+		name      string
+		secret    []byte
+		body      []byte
+		timestamp string
+		nonce     string
+		at        time.Time
+		want      error
+	}{
+		{"valid", secret, body, timestamp, nonce, now, nil},
+		{"slightly late", secret, body, timestamp, nonce, now.Add(time.Minute), nil},
+		{"other secret", []byte("another secret"), body, timestamp, nonce, now, ErrBadSignature},
+		{"altered body", secret, []byte(`{"device_id":"phone"}`), timestamp, nonce, now, ErrBadSignature},
+		{"altered timestamp", secret, body, "1700000001", nonce, now, ErrBadSignature},
+		{"altered nonce", secret, body, timestamp, "00000000000000000000000000000000", now, ErrBadSignature},
+		{"missing nonce", secret, body, timestamp, "", now, ErrBadSignature},
+		{"replayed later", secret, body, timestamp, nonce, now.Add(time.Hour), ErrStale},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, err := NewPeer(db, tt.secret)
+			if err != nil {
+				t.Fatalf("NewPeer() error = %v", err)
+			}
+			if err := verifier.Verify(tt.body, tt.timestamp, tt.nonce, signature, tt.at); !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	if _, err := NewPeer(db, nil); err == nil {
+		t.Error("Expected a peer without a secret to be refused")
+	}
+}
+
+func TestVerifyRejectsReplays(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	peer, err := NewPeer(db, secret)
+	if err != nil {
+		t.Fatalf("NewPeer() error = %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"device_id":"laptop"}`)
+
+	timestamp, nonce, signature := peer.Sign(body, now)
+	if err := peer.Verify(body, timestamp, nonce, signature, now); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if err := peer.Verify(body, timestamp, nonce, signature, now.Add(time.Minute)); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Expected %v, got %v", ErrReplayed, err)
+	}
+
+	// The same body signed again gets a fresh nonce.
+	timestamp, other, signature := peer.Sign(body, now)
+	if other == nonce {
+		t.Fatalf("Expected a fresh nonce, got %s twice", nonce)
+	}
+	if err := peer.Verify(body, timestamp, other, signature, now); err != nil {
+		t.Errorf("Expected a fresh nonce to verify, got %v", err)
+	}
+
+	// Nonces are forgotten once their messages would be stale anyway.
+	peer.Verify(body, timestamp, other, signature, now.Add(time.Hour))
+	if len(peer.seen) != 0 {
+		t.Errorf("Expected expired nonces to be pruned, got %d", len(peer.seen))
+	}
+}
+
+func TestHandle(t *testing.T) {
+	laptop, cleanupLaptop := setupTestDB(t)
+	defer cleanupLaptop()
+	desktop, cleanupDesktop := setupTestDB(t)
+	defer cleanupDesktop()
+	insertEvents(t, laptop)
+	ctx := context.Background()
+
+	peer, err := NewPeer(laptop, secret)
+	if err != nil {
+		t.Fatalf("NewPeer() error = %v", err)
+	}
+	vector, err := desktop.VersionVector(ctx)
+	if err != nil {
+		t.Fatalf("VersionVector() error = %v", err)
+	}
+	response, err := peer.Handle(ctx, Request{DeviceID: desktop.DeviceID(), Vector: vector})
+	if err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if response.DeviceID != laptop.DeviceID() || len(response.Events) != 2 || response.More {
+		t.Fatalf("Expected the laptop's 2 events in one page, got %+v", response)
+	}
+	if response.Events[0].ID != 0 {
+		t.Errorf("Expected row IDs to stay local, got %d", response.Events[0].ID)
+	}
+
+	// Pushing the page back changes nothing but the laptop's vector entry for
+	// the desktop.
+	result, err := desktop.MergeChanges(ctx, response.Events, response.Tombstones, response.Covers)
+	if err != nil || result.Inserted != 2 {
+		t.Fatalf("Expected 2 events merged, got %+v, %v", result, err)
+	}
+	response, err = peer.Handle(ctx, Request{
+		DeviceID: desktop.DeviceID(), Vector: database.VersionVector{laptop.DeviceID(): response.Vector[laptop.DeviceID()]},
+		Events: response.Events,
+	})
+	if err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if response.Merged.Skipped != 2 || len(response.Events) != 0 {
+		t.Errorf("Expected the pushed events to be known and nothing missing, got %+v", response)
+	}
+
+	if _, err := peer.Handle(ctx, Request{DeviceID: laptop.DeviceID()}); err == nil {
+		t.Error("Expected a request from the same database to be refused")
+	}
+}
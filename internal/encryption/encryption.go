@@ -0,0 +1,156 @@
+// Package encryption seals sensitive column values at rest.
+//
+// Values are encrypted with AES-256-GCM under a nonce derived from an HMAC of
+// the plaintext, so equal plaintexts give equal ciphertexts. That keeps
+// equality lookups, GROUP BY and uniqueness working in SQL at the cost of
+// revealing which stored values are equal; lengths are revealed as well.
+// Everything else about the value, including any prefix or substring, is
+// hidden.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SecretSize is the length of the secret a Key is built from.
+const SecretSize = 32
+
+// passphraseIterations follows the OWASP recommendation for PBKDF2-SHA256.
+const passphraseIterations = 600_000
+
+// prefix marks sealed values, followed by the key ID and a colon.
+const prefix = "enc1:"
+
+var ErrWrongKey = errors.New("value was encrypted with a different key")
+
+type Key struct {
+	id   string
+	aead cipher.AEAD
+	mac  []byte
+}
+
+// NewKey builds a key from a SecretSize-byte secret.
+func NewKey(secret []byte) (*Key, error) {
+	if len(secret) != SecretSize {
+		return nil, fmt.Errorf("encryption secret must be %d bytes, got %d", SecretSize, len(secret))
+	}
+	block, err := aes.NewCipher(derive(secret, "browsetrace encryption"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return &Key{
+		id:   hex.EncodeToString(derive(secret, "browsetrace key id")[:8]),
+		aead: aead,
+		mac:  derive(secret, "browsetrace nonce"),
+	}, nil
+}
+
+func derive(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// GenerateSecret returns a random secret for NewKey.
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return secret, nil
+}
+
+// DeriveSecret stretches a passphrase into a secret for NewKey.
+func DeriveSecret(passphrase string, salt []byte) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	return pbkdf2.Key(sha256.New, passphrase, salt, passphraseIterations, SecretSize)
+}
+
+// ID identifies the key without revealing it.
+func (k *Key) ID() string {
+	return k.id
+}
+
+// SealBytes encrypts plaintext into prefix, key ID, ":", nonce and ciphertext.
+func (k *Key) SealBytes(plaintext []byte) []byte {
+	mac := hmac.New(sha256.New, k.mac)
+	mac.Write(plaintext)
+	nonce := mac.Sum(nil)[:k.aead.NonceSize()]
+
+	sealed := make([]byte, 0, len(prefix)+len(k.id)+1+len(nonce)+len(plaintext)+k.aead.Overhead())
+	sealed = append(sealed, prefix...)
+	sealed = append(sealed, k.id...)
+	sealed = append(sealed, ':')
+	sealed = append(sealed, nonce...)
+	return k.aead.Seal(sealed, nonce, plaintext, []byte(k.id))
+}
+
+// OpenBytes decrypts a value from SealBytes. Values that are not sealed are
+// returned unchanged, so plaintext stored before encryption stays readable.
+func (k *Key) OpenBytes(value []byte) ([]byte, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	id, body, ok := strings.Cut(string(value[len(prefix):]), ":")
+	if !ok {
+		return nil, errors.New("malformed encrypted value")
+	}
+	if k == nil || id != k.id {
+		return nil, fmt.Errorf("%w (%s)", ErrWrongKey, id)
+	}
+	nonceSize := k.aead.NonceSize()
+	if len(body) < nonceSize {
+		return nil, errors.New("malformed encrypted value")
+	}
+	plaintext, err := k.aead.Open(nil, []byte(body[:nonceSize]), []byte(body[nonceSize:]), []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return plaintext, nil
+}
+
+// SealString encrypts text into a printable value for TEXT columns.
+func (k *Key) SealString(plaintext string) string {
+	sealed := k.SealBytes([]byte(plaintext))
+	header := len(prefix) + len(k.id) + 1
+	return string(sealed[:header]) + base64.RawURLEncoding.EncodeToString(sealed[header:])
+}
+
+// OpenString decrypts a value from SealString, returning plaintext values
+// unchanged.
+func (k *Key) OpenString(value string) (string, error) {
+	if !IsSealed([]byte(value)) {
+		return value, nil
+	}
+	id, body, ok := strings.Cut(value[len(prefix):], ":")
+	if !ok {
+		return "", errors.New("malformed encrypted value")
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value: %w", err)
+	}
+	plaintext, err := k.OpenBytes(append([]byte(prefix+id+":"), decoded...))
+	return string(plaintext), err
+}
+
+// IsSealed reports whether value came from SealBytes or SealString.
+func IsSealed(value []byte) bool {
+	return len(value) > len(prefix) && string(value[:len(prefix)]) == prefix
+}
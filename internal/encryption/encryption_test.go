@@ -0,0 +1,124 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey(t *testing.T, fill byte) *Key {
+	t.Helper()
+
+	key, err := NewKey(bytes.Repeat([]byte{fill}, SecretSize))
+	if err != nil {
+		t.Fatalf("NewKey() error = %v", err)
+	}
+	return key
+}
+
+func TestSealString(t *testing.T) {
+	key := testKey(t, 1)
+
+	tests := []string{"https://example.com/private?q=secret", "Bank statement", "", "ünïcode ✓"}
+	for _, plaintext := range tests {
+		sealed := key.SealString(plaintext)
+		if !IsSealed([]byte(sealed)) {
+			t.Errorf("SealString(%q) = %q, which is not marked as sealed", plaintext, sealed)
+		}
+		if plaintext != "" && strings.Contains(sealed, plaintext) {
+			t.Errorf("SealString(%q) = %q, which contains the plaintext", plaintext, sealed)
+		}
+		if again := key.SealString(plaintext); again != sealed {
+			t.Errorf("Expected sealing to be deterministic, got %q and %q", sealed, again)
+		}
+		opened, err := key.OpenString(sealed)
+		if err != nil {
+			t.Fatalf("OpenString() error = %v", err)
+		}
+		if opened != plaintext {
+			t.Errorf("Expected %q, got %q", plaintext, opened)
+		}
+	}
+
+	if key.SealString("a") == key.SealString("b") {
+		t.Error("Expected different plaintexts to seal differently")
+	}
+}
+
+func TestOpenPlaintext(t *testing.T) {
+	var noKey *Key
+	for _, key := range []*Key{testKey(t, 1), noKey} {
+		opened, err := key.OpenString("https://example.com/")
+		if err != nil {
+			t.Fatalf("OpenString() error = %v", err)
+		}
+		if opened != "https://example.com/" {
+			t.Errorf("Expected plaintext to pass through, got %q", opened)
+		}
+	}
+}
+
+func TestOpenWrongKey(t *testing.T) {
+	sealed := testKey(t, 1).SealString("secret")
+
+	if _, err := testKey(t, 2).OpenString(sealed); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey for another key, got %v", err)
+	}
+	var noKey *Key
+	if _, err := noKey.OpenString(sealed); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey without a key, got %v", err)
+	}
+
+	// Flipping a ciphertext byte must be detected rather than decrypt to junk.
+	tampered := []byte(testKey(t, 1).SealBytes([]byte("secret")))
+	tampered[len(tampered)-1] ^= 1
+	if _, err := testKey(t, 1).OpenBytes(tampered); err == nil {
+		t.Error("Expected an error for tampered ciphertext")
+	}
+}
+
+func TestSealBytes(t *testing.T) {
+	key := testKey(t, 3)
+	plaintext := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0xff}
+
+	sealed := key.SealBytes(plaintext)
+	if bytes.Contains(sealed, plaintext) {
+		t.Error("Expected sealed bytes not to contain the plaintext")
+	}
+	opened, err := key.OpenBytes(sealed)
+	if err != nil {
+		t.Fatalf("OpenBytes() error = %v", err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("Expected %x, got %x", plaintext, opened)
+	}
+}
+
+func TestDeriveSecret(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	first, err := DeriveSecret("correct horse", salt)
+	if err != nil {
+		t.Fatalf("DeriveSecret() error = %v", err)
+	}
+	second, err := DeriveSecret("correct horse", salt)
+	if err != nil {
+		t.Fatalf("DeriveSecret() error = %v", err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Error("Expected the same passphrase and salt to derive the same secret")
+	}
+	if len(first) != SecretSize {
+		t.Errorf("Expected %d bytes, got %d", SecretSize, len(first))
+	}
+	other, err := DeriveSecret("correct horse", []byte("fedcba9876543210"))
+	if err != nil {
+		t.Fatalf("DeriveSecret() error = %v", err)
+	}
+	if bytes.Equal(first, other) {
+		t.Error("Expected a different salt to derive a different secret")
+	}
+	if _, err := DeriveSecret("", salt); err == nil {
+		t.Error("Expected an error for an empty passphrase")
+	}
+}
@@ -0,0 +1,182 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// A Store keeps secrets in named slots. Key rotation writes the new secret to
+// a second slot before re-encrypting, so that a crash midway never leaves
+// data encrypted under a secret that was not saved.
+type Store interface {
+	// Get returns the secret in slot, or ErrNoSecret.
+	Get(slot string) ([]byte, error)
+	Set(slot string, secret []byte) error
+	Delete(slot string) error
+	// Name describes where secrets are kept, for messages.
+	Name() string
+}
+
+var (
+	ErrNoSecret  = errors.New("no secret stored")
+	ErrNoKeyring = errors.New("no OS keyring available")
+)
+
+// FileStore keeps each slot in a file next to path: path for the slot
+// "current", path.<slot> for others. Files are readable by the owner only.
+type FileStore struct {
+	Path string
+}
+
+func (f FileStore) slotPath(slot string) string {
+	if slot == "current" {
+		return f.Path
+	}
+	return f.Path + "." + slot
+}
+
+func (f FileStore) Get(slot string) ([]byte, error) {
+	encoded, err := os.ReadFile(f.slotPath(slot))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoSecret
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	return decodeSecret(string(encoded))
+}
+
+func (f FileStore) Set(slot string, secret []byte) error {
+	// Write and rename so that a crash cannot leave a truncated key behind.
+	path := f.slotPath(slot)
+	if err := os.WriteFile(path+".tmp", []byte(encodeSecret(secret)+"\n"), 0o600); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	return nil
+}
+
+func (f FileStore) Delete(slot string) error {
+	if err := os.Remove(f.slotPath(slot)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove key file: %w", err)
+	}
+	return nil
+}
+
+func (f FileStore) Name() string {
+	return f.Path
+}
+
+// KeyringStore keeps secrets in the OS keyring through its command-line
+// tool: security on macOS and secret-tool (libsecret) on Linux.
+type KeyringStore struct {
+	Service string
+}
+
+// NewKeyringStore returns a keyring store for service, or ErrNoKeyring when
+// the platform's keyring tool is not installed.
+func NewKeyringStore(service string) (*KeyringStore, error) {
+	var tool string
+	switch runtime.GOOS {
+	case "darwin":
+		tool = "security"
+	case "linux", "freebsd", "openbsd", "netbsd":
+		tool = "secret-tool"
+	default:
+		return nil, ErrNoKeyring
+	}
+	if _, err := exec.LookPath(tool); err != nil {
+		return nil, ErrNoKeyring
+	}
+	return &KeyringStore{Service: service}, nil
+}
+
+func (k *KeyringStore) Get(slot string) ([]byte, error) {
+	var command *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		command = exec.Command("security", "find-generic-password", "-s", k.Service, "-a", slot, "-w")
+	} else {
+		command = exec.Command("secret-tool", "lookup", "service", k.Service, "account", slot)
+	}
+	output, err := command.Output()
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) || (err == nil && len(bytes.TrimSpace(output)) == 0) {
+		// Both tools exit non-zero (or print nothing) for a missing item.
+		return nil, ErrNoSecret
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query keyring: %w", err)
+	}
+	return decodeSecret(string(output))
+}
+
+func (k *KeyringStore) Set(slot string, secret []byte) error {
+	var command *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		// In interactive mode security reads the command from stdin, which
+		// keeps the secret out of the argument list other users can see.
+		command = exec.Command("security", "-i")
+		command.Stdin = strings.NewReader(securityCommandLine("add-generic-password", "-U", "-s", k.Service, "-a", slot, "-w", encodeSecret(secret)))
+	} else {
+		command = exec.Command("secret-tool", "store", "--label", k.Service+" "+slot, "service", k.Service, "account", slot)
+		command.Stdin = strings.NewReader(encodeSecret(secret))
+	}
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to store secret in keyring: %w: %s", err, bytes.TrimSpace(output))
+	}
+	if runtime.GOOS == "darwin" {
+		// Interactive mode reports a failed command but still exits zero.
+		if stored, err := k.Get(slot); err != nil || !bytes.Equal(stored, secret) {
+			return fmt.Errorf("failed to store secret in keyring: %s", bytes.TrimSpace(output))
+		}
+	}
+	return nil
+}
+
+func (k *KeyringStore) Delete(slot string) error {
+	var command *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		command = exec.Command("security", "delete-generic-password", "-s", k.Service, "-a", slot)
+	} else {
+		command = exec.Command("secret-tool", "clear", "service", k.Service, "account", slot)
+	}
+	// Deleting a missing item fails on macOS; there is nothing left to do.
+	_ = command.Run()
+	return nil
+}
+
+func (k *KeyringStore) Name() string {
+	return "OS keyring (" + k.Service + ")"
+}
+
+// securityCommandLine renders a command for security's interactive mode,
+// which splits lines on spaces and honors double quotes with backslash
+// escapes.
+func securityCommandLine(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+	}
+	return strings.Join(quoted, " ") + "\n"
+}
+
+func encodeSecret(secret []byte) string {
+	return base64.StdEncoding.EncodeToString(secret)
+}
+
+func decodeSecret(encoded string) ([]byte, error) {
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(secret) != SecretSize {
+		return nil, errors.New("stored encryption secret is malformed")
+	}
+	return secret, nil
+}
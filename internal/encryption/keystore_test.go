@@ -0,0 +1,75 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	directory, err := os.MkdirTemp("", "browsetrace_keys_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(directory)
+	store := FileStore{Path: filepath.Join(directory, "encryption.key")}
+
+	if _, err := store.Get("current"); !errors.Is(err, ErrNoSecret) {
+		t.Fatalf("Expected ErrNoSecret before anything is stored, got %v", err)
+	}
+
+	current, _ := GenerateSecret()
+	next, _ := GenerateSecret()
+	if err := store.Set("current", current); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := store.Set("next", next); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	got, err := store.Get("current")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !bytes.Equal(got, current) {
+		t.Error("Expected the current secret back")
+	}
+	if got, _ := store.Get("next"); !bytes.Equal(got, next) {
+		t.Error("Expected the next secret back")
+	}
+
+	info, err := os.Stat(store.Path)
+	if err != nil {
+		t.Fatalf("Failed to stat key file: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("Expected key file mode 0600, got %o", mode)
+	}
+
+	if err := store.Delete("next"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.Get("next"); !errors.Is(err, ErrNoSecret) {
+		t.Errorf("Expected ErrNoSecret after Delete, got %v", err)
+	}
+	if err := store.Delete("next"); err != nil {
+		t.Errorf("Expected deleting a missing slot to succeed, got %v", err)
+	}
+
+	if err := os.WriteFile(store.Path, []byte("not base64\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	if _, err := store.Get("current"); err == nil {
+		t.Error("Expected an error for a malformed key file")
+	}
+}
+
+func TestSecurityCommandLine(t *testing.T) {
+	got := securityCommandLine("add-generic-password", "-s", `browse "trace"`, "-w", `a\b`)
+	want := `"add-generic-password" "-s" "browse \"trace\"" "-w" "a\\b"` + "\n"
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
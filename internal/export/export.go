@@ -0,0 +1,317 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/har"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/storage"
+)
+
+type Format string
+
+const (
+	FormatJSONL   Format = "jsonl"
+	FormatCSV     Format = "csv"
+	FormatParquet Format = "parquet"
+	// FormatHAR groups events into pages of a single HAR document, so unlike
+	// the row formats it is buffered in memory until the export finishes.
+	FormatHAR Format = "har"
+)
+
+// parquetRowGroupSize bounds how many rows the Parquet writer buffers in memory.
+const parquetRowGroupSize = 10000
+
+var mediaTypes = map[Format][]string{
+	FormatJSONL:   {"application/x-ndjson", "application/jsonl", "application/json"},
+	FormatCSV:     {"text/csv"},
+	FormatParquet: {"application/vnd.apache.parquet", "application/x-parquet"},
+	FormatHAR:     {"application/har+json"},
+}
+
+func ParseFormat(value string) (Format, error) {
+	switch Format(strings.ToLower(value)) {
+	case FormatJSONL, "ndjson", "json":
+		return FormatJSONL, nil
+	case FormatCSV:
+		return FormatCSV, nil
+	case FormatParquet:
+		return FormatParquet, nil
+	case FormatHAR:
+		return FormatHAR, nil
+	}
+	return "", fmt.Errorf("unsupported export format: %s", value)
+}
+
+// NegotiateFormat picks a format from an HTTP Accept header. An empty header
+// or a wildcard selects JSON Lines.
+func NegotiateFormat(accept string) (Format, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return FormatJSONL, true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if mediaType == "*/*" || mediaType == "application/*" {
+			return FormatJSONL, true
+		}
+		for format, types := range mediaTypes {
+			for _, candidate := range types {
+				if candidate == mediaType {
+					return format, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+func (f Format) ContentType() string {
+	return mediaTypes[f][0]
+}
+
+func (f Format) Extension() string {
+	return string(f)
+}
+
+type Options struct {
+	Format Format
+	Filter database.EventFilter
+	// CSVFields lists dot-separated key paths into Event.Data that become
+	// their own CSV columns. Without any, the raw data_json is written instead.
+	CSVFields []string
+}
+
+type eventWriter interface {
+	Write(event models.StoredEvent) error
+	Close() error
+}
+
+// Export streams the events selected by opts to w and returns how many were written.
+func Export(ctx context.Context, db storage.Store, w io.Writer, opts Options) (int, error) {
+	writer, err := newEventWriter(w, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = db.StreamEvents(ctx, opts.Filter, func(event models.StoredEvent) error {
+		if err := writer.Write(event); err != nil {
+			return fmt.Errorf("failed to write event %d: %w", event.ID, err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		writer.Close()
+		return count, err
+	}
+	if err := writer.Close(); err != nil {
+		return count, fmt.Errorf("failed to finish %s export: %w", opts.Format, err)
+	}
+	return count, nil
+}
+
+func newEventWriter(w io.Writer, opts Options) (eventWriter, error) {
+	switch opts.Format {
+	case FormatJSONL:
+		return &jsonlWriter{encoder: json.NewEncoder(w)}, nil
+	case FormatCSV:
+		return newCSVWriter(w, opts.CSVFields)
+	case FormatParquet:
+		return &parquetWriter{
+			writer: parquet.NewGenericWriter[parquetRow](w, parquet.MaxRowsPerRowGroup(parquetRowGroupSize)),
+		}, nil
+	case FormatHAR:
+		return &harWriter{output: w, builder: har.NewBuilder()}, nil
+	}
+	return nil, fmt.Errorf("unsupported export format: %s", opts.Format)
+}
+
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+func (j *jsonlWriter) Write(event models.StoredEvent) error {
+	return j.encoder.Encode(event)
+}
+
+func (j *jsonlWriter) Close() error {
+	return nil
+}
+
+type csvWriter struct {
+	writer *csv.Writer
+	fields [][]string
+}
+
+func newCSVWriter(w io.Writer, fields []string) (*csvWriter, error) {
+	c := &csvWriter{writer: csv.NewWriter(w)}
+	header := []string{"id", "ts_utc", "ts_iso", "url", "title", "type", "tab_id", "window_id", "frame_id", "profile", "client_id"}
+	if len(fields) == 0 {
+		header = append(header, "data_json")
+	}
+	for _, field := range fields {
+		c.fields = append(c.fields, strings.Split(field, "."))
+		header = append(header, "data."+field)
+	}
+	if err := c.writer.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write CSV header: %w", err)
+	}
+	return c, nil
+}
+
+func (c *csvWriter) Write(event models.StoredEvent) error {
+	title := formatOptionalString(event.Title)
+	record := []string{
+		strconv.FormatInt(event.ID, 10),
+		strconv.FormatInt(event.TSUTC, 10),
+		event.TSISO,
+		event.URL,
+		title,
+		event.Type,
+		formatOptionalInt(event.TabID),
+		formatOptionalInt(event.WindowID),
+		formatOptionalInt(event.FrameID),
+		formatOptionalString(event.Profile),
+		formatOptionalString(event.ClientID),
+	}
+	if len(c.fields) == 0 {
+		dataJSON, err := json.Marshal(event.Data)
+		if err != nil {
+			return err
+		}
+		record = append(record, string(dataJSON))
+	}
+	for _, path := range c.fields {
+		value, err := formatCSVValue(lookupPath(event.Data, path))
+		if err != nil {
+			return err
+		}
+		record = append(record, value)
+	}
+	return c.writer.Write(record)
+}
+
+func (c *csvWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+func formatOptionalInt(value *int64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatInt(*value, 10)
+}
+
+func formatOptionalString(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// lookupPath walks nested JSON objects along path, returning nil when any
+// segment is missing.
+func lookupPath(data map[string]any, path []string) any {
+	var current any = data
+	for _, key := range path {
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil
+		}
+		current = object[key]
+	}
+	return current
+}
+
+func formatCSVValue(value any) (string, error) {
+	switch typed := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return typed, nil
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(typed), nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+type parquetRow struct {
+	ID       int64   `parquet:"id"`
+	TSUTC    int64   `parquet:"ts_utc"`
+	TSISO    string  `parquet:"ts_iso"`
+	URL      string  `parquet:"url"`
+	Title    *string `parquet:"title,optional"`
+	Type     string  `parquet:"type"`
+	DataJSON string  `parquet:"data_json"`
+	TabID    *int64  `parquet:"tab_id,optional"`
+	WindowID *int64  `parquet:"window_id,optional"`
+	FrameID  *int64  `parquet:"frame_id,optional"`
+	Profile  *string `parquet:"profile,optional"`
+	ClientID *string `parquet:"client_id,optional"`
+}
+
+type parquetWriter struct {
+	writer *parquet.GenericWriter[parquetRow]
+}
+
+func (p *parquetWriter) Write(event models.StoredEvent) error {
+	dataJSON, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = p.writer.Write([]parquetRow{{
+		ID:       event.ID,
+		TSUTC:    event.TSUTC,
+		TSISO:    event.TSISO,
+		URL:      event.URL,
+		Title:    event.Title,
+		Type:     event.Type,
+		DataJSON: string(dataJSON),
+		TabID:    event.TabID,
+		WindowID: event.WindowID,
+		FrameID:  event.FrameID,
+		Profile:  event.Profile,
+		ClientID: event.ClientID,
+	}})
+	return err
+}
+
+func (p *parquetWriter) Close() error {
+	return p.writer.Close()
+}
+
+type harWriter struct {
+	output  io.Writer
+	builder *har.Builder
+}
+
+func (h *harWriter) Write(event models.StoredEvent) error {
+	h.builder.Add(event)
+	return nil
+}
+
+func (h *harWriter) Close() error {
+	encoder := json.NewEncoder(h.output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(h.builder.Document())
+}
@@ -0,0 +1,212 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/har"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func setupTestDB(t *testing.T) (*database.Database, func()) {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "browsetrace-export-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	db, err := database.NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+
+	title := "Example"
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com", Title: &title, Type: "navigate", Data: map[string]any{"referrer": "https://google.com"}},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://example.com", Type: "click", Data: map[string]any{"target": map[string]any{"selector": "#buy", "tag": "BUTTON"}, "x": 10}},
+		{TSUTC: 3000, TSISO: "1970-01-01T00:00:03Z", URL: "https://example.com", Type: "scroll", Data: map[string]any{}},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+	return db, cleanup
+}
+
+func TestExportJSONL(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var buf bytes.Buffer
+	count, err := Export(context.Background(), db, &buf, Options{Format: FormatJSONL})
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 events exported, got %d", count)
+	}
+
+	scanner := bufio.NewScanner(&buf)
+	lines := 0
+	for scanner.Scan() {
+		var event models.StoredEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Line %d is not valid JSON: %v", lines+1, err)
+		}
+		lines++
+		if event.ID != int64(lines) {
+			t.Errorf("Expected ID %d, got %d", lines, event.ID)
+		}
+	}
+	if lines != 3 {
+		t.Errorf("Expected 3 lines, got %d", lines)
+	}
+}
+
+func TestExportCSVFlattensFields(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var buf bytes.Buffer
+	_, err := Export(context.Background(), db, &buf, Options{
+		Format:    FormatCSV,
+		Filter:    database.EventFilter{Types: []string{"click"}},
+		CSVFields: []string{"target.selector", "x", "missing"},
+	})
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV output: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected header and 1 row, got %d records", len(records))
+	}
+	header, row := records[0], records[1]
+	want := map[string]string{"type": "click", "data.target.selector": "#buy", "data.x": "10", "data.missing": ""}
+	for i, column := range header {
+		if expected, ok := want[column]; ok && row[i] != expected {
+			t.Errorf("Column %s: expected %q, got %q", column, expected, row[i])
+		}
+	}
+}
+
+func TestExportCSVWithoutFieldsIncludesDataJSON(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var buf bytes.Buffer
+	if _, err := Export(context.Background(), db, &buf, Options{Format: FormatCSV, Filter: database.EventFilter{Limit: 1}}); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV output: %v", err)
+	}
+	last := len(records[0]) - 1
+	if records[0][last] != "data_json" {
+		t.Errorf("Expected last column data_json, got %s", records[0][last])
+	}
+	if records[1][last] != `{"referrer":"https://google.com"}` {
+		t.Errorf("Unexpected data_json %s", records[1][last])
+	}
+}
+
+func TestExportParquet(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var buf bytes.Buffer
+	if _, err := Export(context.Background(), db, &buf, Options{Format: FormatParquet}); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	rows, err := parquet.Read[parquetRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read Parquet output: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %d", len(rows))
+	}
+	if rows[0].Title == nil || *rows[0].Title != "Example" {
+		t.Errorf("Expected title Example, got %v", rows[0].Title)
+	}
+	if rows[1].Title != nil {
+		t.Errorf("Expected nil title, got %v", *rows[1].Title)
+	}
+	if rows[2].Type != "scroll" {
+		t.Errorf("Expected scroll, got %s", rows[2].Type)
+	}
+}
+
+func TestExportHAR(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var buf bytes.Buffer
+	if _, err := Export(context.Background(), db, &buf, Options{Format: FormatHAR}); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	var document har.Document
+	if err := json.Unmarshal(buf.Bytes(), &document); err != nil {
+		t.Fatalf("Invalid HAR output: %v", err)
+	}
+	if len(document.Log.Pages) != 1 || len(document.Log.Pages[0].Events) != 2 {
+		t.Errorf("Expected 1 page with 2 interaction events, got %+v", document.Log.Pages)
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   Format
+		ok     bool
+	}{
+		{"", FormatJSONL, true},
+		{"*/*", FormatJSONL, true},
+		{"text/csv", FormatCSV, true},
+		{"application/vnd.apache.parquet", FormatParquet, true},
+		{"text/html, text/csv;q=0.9", FormatCSV, true},
+		{"application/x-ndjson", FormatJSONL, true},
+		{"application/har+json", FormatHAR, true},
+		{"image/png", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			got, ok := NegotiateFormat(tt.accept)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("NegotiateFormat(%q) = %q, %v; want %q, %v", tt.accept, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, value := range []string{"jsonl", "ndjson", "CSV", "parquet", "har"} {
+		if _, err := ParseFormat(value); err != nil {
+			t.Errorf("ParseFormat(%q) error = %v", value, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("Expected error for unsupported format")
+	}
+}
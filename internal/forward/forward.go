@@ -0,0 +1,382 @@
+// Package forward sends the events an agent records on to a central
+// collector, for team deployments.
+//
+// Events enter an outbox table in the same transaction that stores them, so
+// none is lost if the agent stops before they are sent. The outbox holds only
+// event UIDs; the events themselves are read back when a batch is sent, so
+// encrypted history stays encrypted on disk and events deleted in the
+// meantime are never sent. Sites on the blocklist are not forwarded at all,
+// and neither are events merged from the user's other devices, which forward
+// their own.
+//
+// Delivery is at least once: a batch is retried with exponential backoff
+// until the collector accepts it, so the collector may see an event twice.
+// Every event carries its UID, and every request an Idempotency-Key header
+// derived from the UIDs in it, for the collector to drop repeats.
+package forward
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/privacy"
+)
+
+const (
+	DefaultBatchSize  = 100
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = 5 * time.Minute
+	// DefaultInterval is how often the outbox is checked when nothing wakes
+	// the forwarder.
+	DefaultInterval = 30 * time.Second
+
+	// linger is how long a new event waits for others to join its batch.
+	linger = 250 * time.Millisecond
+)
+
+// Config says where and how to forward events.
+type Config struct {
+	URL        string
+	Token      string // sent as a bearer token if set
+	BatchSize  int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Interval   time.Duration
+	Blocklist  *privacy.Blocklist
+}
+
+// Status describes the outbox.
+type Status struct {
+	Pending   int64  `json:"pending"`
+	Failed    int64  `json:"failed"` // rejected by the collector for good
+	LastError string `json:"last_error,omitempty"`
+}
+
+// rejectedError is a response that retrying will not change.
+type rejectedError struct {
+	status string
+	body   string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("collector rejected batch: %s: %s", e.status, e.body)
+}
+
+// retryableError is a failure worth retrying, after at least wait if set.
+type retryableError struct {
+	err  error
+	wait time.Duration
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+type Forwarder struct {
+	db     *database.Database
+	config Config
+	client *http.Client
+	wake   chan struct{}
+	now    func() time.Time
+}
+
+// New creates the outbox table and registers the forwarder as an insert hook
+// on db. Call Run to send what it collects.
+func New(db *database.Database, config Config) (*Forwarder, error) {
+	if config.URL == "" {
+		return nil, errors.New("a collector URL is required to forward events")
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = DefaultMinBackoff
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = max(DefaultMaxBackoff, config.MinBackoff)
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	_, err := db.DB().Exec(`
+	CREATE TABLE IF NOT EXISTS outbox(
+	  id         INTEGER PRIMARY KEY,
+	  uid        TEXT    NOT NULL,
+	  created_ts INTEGER NOT NULL,
+	  attempts   INTEGER NOT NULL DEFAULT 0,
+	  last_error TEXT,
+	  failed     INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_outbox_failed ON outbox(failed, id);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outbox table: %w", err)
+	}
+	f := &Forwarder{
+		db:     db,
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+		wake:   make(chan struct{}, 1),
+		now:    time.Now,
+	}
+	db.AddInsertHook(f.enqueue)
+	return f, nil
+}
+
+// enqueue adds this device's new events to the outbox.
+func (f *Forwarder) enqueue(transaction *sql.Tx, events []models.StoredEvent) error {
+	queued := false
+	now := f.now().UnixMilli()
+	for _, event := range events {
+		if event.DeviceID != f.db.DeviceID() || f.config.Blocklist.Blocks(event.URL) {
+			continue
+		}
+		if _, err := transaction.Exec(`INSERT INTO outbox(uid, created_ts) VALUES(?, ?)`, event.UID, now); err != nil {
+			return fmt.Errorf("failed to queue event for forwarding: %w", err)
+		}
+		queued = true
+	}
+	if queued {
+		select {
+		case f.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Status counts the events waiting in the outbox.
+func (f *Forwarder) Status(ctx context.Context) (Status, error) {
+	var status Status
+	var lastError sql.NullString
+	err := f.db.DB().QueryRowContext(ctx, `SELECT
+	  COUNT(*) FILTER (WHERE failed = 0),
+	  COUNT(*) FILTER (WHERE failed = 1),
+	  (SELECT last_error FROM outbox WHERE last_error IS NOT NULL ORDER BY id DESC LIMIT 1)
+	FROM outbox`).Scan(&status.Pending, &status.Failed, &lastError)
+	if err != nil {
+		return status, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	status.LastError = lastError.String
+	return status, nil
+}
+
+// Run forwards queued events until ctx is cancelled, backing off while the
+// collector is unreachable.
+func (f *Forwarder) Run(ctx context.Context) {
+	failures := 0
+	for {
+		wait := f.config.Interval
+		_, err := f.Flush(ctx)
+		var retry *retryableError
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.As(err, &retry):
+			failures++
+			wait = max(f.backoff(failures), retry.wait)
+			log.Printf("Forwarding failed (attempt %d), retrying in %s: %v", failures, wait, err)
+		case err != nil:
+			log.Printf("Forwarding failed: %v", err)
+		default:
+			failures = 0
+		}
+
+		wake := f.wake
+		if failures > 0 {
+			wake = nil // new events do not cut a backoff short
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-wake:
+			timer.Stop()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(linger):
+			}
+		}
+	}
+}
+
+// backoff is the wait after the given number of consecutive failures.
+func (f *Forwarder) backoff(failures int) time.Duration {
+	wait := f.config.MinBackoff
+	for i := 1; i < failures && wait < f.config.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, f.config.MaxBackoff)
+}
+
+// Flush sends queued events in batches until the outbox is empty or a batch
+// fails, and returns how many events the collector accepted.
+func (f *Forwarder) Flush(ctx context.Context) (int, error) {
+	sent := 0
+	for {
+		ids, uids, err := f.nextBatch(ctx)
+		if err != nil || len(ids) == 0 {
+			return sent, err
+		}
+		events, err := f.db.EventsByUID(ctx, uids)
+		if err != nil {
+			return sent, err
+		}
+		if len(events) > 0 {
+			err = f.send(ctx, events)
+		}
+		var rejected *rejectedError
+		switch {
+		case errors.As(err, &rejected):
+			// Retrying would be rejected again; set the batch aside.
+			log.Printf("Setting aside %d events: %v", len(ids), err)
+			if err := f.mark(ctx, ids, err, true); err != nil {
+				return sent, err
+			}
+			continue
+		case err != nil:
+			if markErr := f.mark(ctx, ids, err, false); markErr != nil {
+				return sent, markErr
+			}
+			return sent, err
+		}
+		if err := f.remove(ctx, ids); err != nil {
+			return sent, err
+		}
+		sent += len(events)
+	}
+}
+
+func (f *Forwarder) nextBatch(ctx context.Context) ([]int64, []string, error) {
+	rows, err := f.db.DB().QueryContext(ctx, `SELECT id, uid FROM outbox WHERE failed = 0 ORDER BY id LIMIT ?`, f.config.BatchSize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	defer rows.Close()
+	var ids []int64
+	var uids []string
+	for rows.Next() {
+		var id int64
+		var uid string
+		if err := rows.Scan(&id, &uid); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan outbox entry: %w", err)
+		}
+		ids = append(ids, id)
+		uids = append(uids, uid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	return ids, uids, nil
+}
+
+func idList(ids []int64) (string, []any) {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return "(" + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + ")", args
+}
+
+func (f *Forwarder) remove(ctx context.Context, ids []int64) error {
+	list, args := idList(ids)
+	if _, err := f.db.DB().ExecContext(ctx, `DELETE FROM outbox WHERE id IN `+list, args...); err != nil {
+		return fmt.Errorf("failed to remove forwarded events from outbox: %w", err)
+	}
+	return nil
+}
+
+// mark records a failed attempt at sending the entries ids, setting them
+// aside for good if failed is set.
+func (f *Forwarder) mark(ctx context.Context, ids []int64, cause error, failed bool) error {
+	list, args := idList(ids)
+	args = append([]any{cause.Error(), failed}, args...)
+	_, err := f.db.DB().ExecContext(ctx, `UPDATE outbox SET attempts = attempts + 1, last_error = ?, failed = ? WHERE id IN `+list, args...)
+	if err != nil {
+		return fmt.Errorf("failed to record forwarding attempt: %w", err)
+	}
+	return nil
+}
+
+// IdempotencyKey identifies a batch by the events in it, so that a retried
+// batch has the same key.
+func IdempotencyKey(events []models.StoredEvent) string {
+	hash := sha256.New()
+	for _, event := range events {
+		hash.Write([]byte(event.UID))
+		hash.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// send posts one batch to the collector.
+func (f *Forwarder) send(ctx context.Context, events []models.StoredEvent) error {
+	for i := range events {
+		events[i].ID = 0 // row IDs are local to this agent
+	}
+	body, err := json.Marshal(map[string]any{"events": events})
+	if err != nil {
+		return fmt.Errorf("failed to encode batch: %w", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, f.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Idempotency-Key", IdempotencyKey(events))
+	if f.config.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+f.config.Token)
+	}
+
+	response, err := f.client.Do(request)
+	if err != nil {
+		return &retryableError{err: fmt.Errorf("failed to reach collector: %w", err)}
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		io.Copy(io.Discard, response.Body)
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+	if permanent(response.StatusCode) {
+		return &rejectedError{status: response.Status, body: strings.TrimSpace(string(message))}
+	}
+	failure := &retryableError{err: fmt.Errorf("collector answered %s: %s", response.Status, strings.TrimSpace(string(message)))}
+	if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		failure.wait = min(time.Duration(seconds)*time.Second, f.config.MaxBackoff)
+	}
+	return failure
+}
+
+// permanent reports whether a collector's status means the batch itself is
+// unacceptable. Authentication and routing errors are retried, since fixing
+// the configuration makes the same batch go through.
+func permanent(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound,
+		http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return status >= 400 && status < 500
+}
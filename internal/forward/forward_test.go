@@ -0,0 +1,267 @@
+package forward
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/privacy"
+)
+
+func setupTestDB(t *testing.T) (*database.Database, func()) {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "browsetrace-forward-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	db, err := database.NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+	return db, cleanup
+}
+
+// collector stands in for the upstream collector. It answers with the queued
+// statuses in turn, then 202, and records the batches it accepts.
+type collector struct {
+	mu       sync.Mutex
+	statuses []int
+	requests int
+	keys     []string
+	events   []models.StoredEvent
+	server   *httptest.Server
+}
+
+func newCollector(t *testing.T, statuses ...int) *collector {
+	t.Helper()
+
+	c := &collector{statuses: statuses}
+	c.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.requests++
+		c.keys = append(c.keys, req.Header.Get("Idempotency-Key"))
+		if req.Header.Get("Authorization") != "Bearer team-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if len(c.statuses) > 0 {
+			status := c.statuses[0]
+			c.statuses = c.statuses[1:]
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		var batch struct {
+			Events []models.StoredEvent `json:"events"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.events = append(c.events, batch.Events...)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(c.server.Close)
+	return c
+}
+
+func (c *collector) received() []models.StoredEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]models.StoredEvent(nil), c.events...)
+}
+
+func newForwarder(t *testing.T, db *database.Database, c *collector) *Forwarder {
+	t.Helper()
+
+	f, err := New(db, Config{
+		URL:        c.server.URL,
+		Token:      "team-token",
+		BatchSize:  2,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 40 * time.Millisecond,
+		Blocklist:  privacy.New([]string{"bank.example"}),
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return f
+}
+
+func insert(t *testing.T, db *database.Database, urls ...string) {
+	t.Helper()
+
+	var events []models.Event
+	for i, url := range urls {
+		events = append(events, models.Event{TSUTC: int64(1000 + i), URL: url, Type: "navigate", Data: map[string]any{}})
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+}
+
+func status(t *testing.T, f *Forwarder) Status {
+	t.Helper()
+
+	status, err := f.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	return status
+}
+
+func TestFlush(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	c := newCollector(t)
+	f := newForwarder(t, db, c)
+	insert(t, db, "https://go.dev/", "https://www.bank.example/account", "https://go.dev/doc", "https://example.com/")
+	// Events from the user's other devices are theirs to forward.
+	other := models.StoredEvent{Event: models.Event{TSUTC: 5000, URL: "https://example.org/", Type: "navigate", Data: map[string]any{}},
+		UID: "0123456789abcdef0123456789abcdef", DeviceID: "another-device", OriginSeq: 1}
+	if _, err := db.MergeChanges(context.Background(), []models.StoredEvent{other}, nil, nil); err != nil {
+		t.Fatalf("MergeChanges() error = %v", err)
+	}
+	if got := status(t, f); got.Pending != 3 {
+		t.Errorf("Expected 3 queued events, got %+v", got)
+	}
+
+	sent, err := f.Flush(context.Background())
+	if err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	received := c.received()
+	if sent != 3 || len(received) != 3 || c.requests != 2 {
+		t.Fatalf("Expected 3 events in 2 batches, got %d events in %d requests", len(received), c.requests)
+	}
+	for _, event := range received {
+		if event.UID == "" || event.ID != 0 || event.URL == "https://www.bank.example/account" {
+			t.Errorf("Unexpected forwarded event %+v", event)
+		}
+	}
+	if c.keys[0] != IdempotencyKey(received[:2]) {
+		t.Errorf("Expected the batch's idempotency key, got %q", c.keys[0])
+	}
+	if got := status(t, f); got.Pending != 0 {
+		t.Errorf("Expected an empty outbox, got %+v", got)
+	}
+}
+
+func TestFlushRetries(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	c := newCollector(t, http.StatusServiceUnavailable)
+	f := newForwarder(t, db, c)
+	insert(t, db, "https://go.dev/")
+
+	if _, err := f.Flush(context.Background()); err == nil {
+		t.Fatal("Expected Flush() to fail while the collector is down")
+	}
+	if got := status(t, f); got.Pending != 1 || got.LastError == "" {
+		t.Errorf("Expected the event to stay queued with the error, got %+v", got)
+	}
+	if sent, err := f.Flush(context.Background()); err != nil || sent != 1 {
+		t.Fatalf("Expected the retry to send 1 event, got %d, %v", sent, err)
+	}
+	if c.keys[0] != c.keys[1] {
+		t.Errorf("Expected the retry to reuse the idempotency key, got %q and %q", c.keys[0], c.keys[1])
+	}
+}
+
+func TestFlushSetsAsideRejected(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	c := newCollector(t, http.StatusUnprocessableEntity)
+	f := newForwarder(t, db, c)
+	insert(t, db, "https://go.dev/", "https://go.dev/doc", "https://example.com/")
+
+	sent, err := f.Flush(context.Background())
+	if err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if got := status(t, f); sent != 1 || got.Pending != 0 || got.Failed != 2 {
+		t.Errorf("Expected the rejected batch set aside and the rest sent, got %d sent and %+v", sent, got)
+	}
+}
+
+func TestFlushSkipsDeleted(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	c := newCollector(t)
+	f := newForwarder(t, db, c)
+	insert(t, db, "https://go.dev/", "https://example.com/")
+	if _, err := db.DeleteEvents(context.Background(), database.EventFilter{URL: "https://example.com/"}); err != nil {
+		t.Fatalf("DeleteEvents() error = %v", err)
+	}
+
+	if sent, err := f.Flush(context.Background()); err != nil || sent != 1 {
+		t.Fatalf("Expected 1 event sent, got %d, %v", sent, err)
+	}
+	if received := c.received(); received[0].URL != "https://go.dev/" {
+		t.Errorf("Expected only the remaining event, got %+v", received)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	f := &Forwarder{config: Config{MinBackoff: time.Second, MaxBackoff: 10 * time.Second}}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := f.backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	c := newCollector(t, http.StatusBadGateway, http.StatusTooManyRequests)
+	f := newForwarder(t, db, c)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	insert(t, db, "https://go.dev/")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(c.received()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the event to be forwarded after retries")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := status(t, f); got.Pending != 0 {
+		t.Errorf("Expected an empty outbox, got %+v", got)
+	}
+}
@@ -0,0 +1,280 @@
+// Package har converts between stored events and HTTP Archive (HAR 1.2)
+// documents. Pages come from navigate events; the interaction events recorded
+// on a page travel in the custom _events field, which other tools ignore.
+package har
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+const (
+	Version        = "1.2"
+	creatorName    = "browsetrace-agent"
+	creatorVersion = "dev"
+)
+
+type Document struct {
+	Log Log `json:"log"`
+}
+
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Pages   []Page  `json:"pages"`
+	Entries []Entry `json:"entries"`
+}
+
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type Page struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	ID              string      `json:"id"`
+	Title           string      `json:"title"`
+	PageTimings     PageTimings `json:"pageTimings"`
+	Events          []PageEvent `json:"_events,omitempty"`
+}
+
+type PageTimings struct {
+	OnContentLoad float64 `json:"onContentLoad"`
+	OnLoad        float64 `json:"onLoad"`
+}
+
+// PageEvent is a non-navigate event recorded while the page was open.
+type PageEvent struct {
+	ID    int64          `json:"id"`
+	TSUTC int64          `json:"ts_utc"`
+	TSISO string         `json:"ts_iso"`
+	Type  string         `json:"type"`
+	Data  map[string]any `json:"data"`
+}
+
+type Entry struct {
+	Pageref         string   `json:"pageref,omitempty"`
+	StartedDateTime string   `json:"startedDateTime"`
+	Time            float64  `json:"time"`
+	Request         Request  `json:"request"`
+	Response        Response `json:"response"`
+	Cache           struct{} `json:"cache"`
+	Timings         Timings  `json:"timings"`
+}
+
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type Content struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type Timings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Builder assembles a Document from events delivered in timestamp order.
+type Builder struct {
+	document Document
+	// pageByURL maps a tab and URL to the index of its most recent page, so
+	// that interaction events land on the page they happened on.
+	pageByURL map[string]int
+}
+
+func NewBuilder() *Builder {
+	return &Builder{
+		document: Document{Log: Log{
+			Version: Version,
+			Creator: Creator{Name: creatorName, Version: creatorVersion},
+			Pages:   []Page{},
+			Entries: []Entry{},
+		}},
+		pageByURL: make(map[string]int),
+	}
+}
+
+func (b *Builder) Add(event models.StoredEvent) {
+	if event.Type == "navigate" {
+		b.addPage(event)
+		b.document.Log.Entries = append(b.document.Log.Entries, navigationEntry(event))
+		return
+	}
+	index, ok := b.pageByURL[pageKey(event)]
+	if !ok {
+		// Interaction without a recorded navigation, e.g. at the start of the range.
+		index = b.addPage(event)
+	}
+	page := &b.document.Log.Pages[index]
+	page.Events = append(page.Events, PageEvent{
+		ID:    event.ID,
+		TSUTC: event.TSUTC,
+		TSISO: event.TSISO,
+		Type:  event.Type,
+		Data:  event.Data,
+	})
+}
+
+func (b *Builder) addPage(event models.StoredEvent) int {
+	title := event.URL
+	if event.Title != nil && *event.Title != "" {
+		title = *event.Title
+	}
+	b.document.Log.Pages = append(b.document.Log.Pages, Page{
+		StartedDateTime: models.FormatTimestamp(event.TSUTC),
+		ID:              pageID(event.ID),
+		Title:           title,
+		// Load timings are not captured.
+		PageTimings: PageTimings{OnContentLoad: -1, OnLoad: -1},
+	})
+	index := len(b.document.Log.Pages) - 1
+	b.pageByURL[pageKey(event)] = index
+	return index
+}
+
+func pageKey(event models.StoredEvent) string {
+	if event.TabID == nil {
+		return event.URL
+	}
+	return fmt.Sprintf("%d %s", *event.TabID, event.URL)
+}
+
+func (b *Builder) Document() Document {
+	return b.document
+}
+
+func pageID(eventID int64) string {
+	return fmt.Sprintf("page_%d", eventID)
+}
+
+func navigationEntry(event models.StoredEvent) Entry {
+	request := Request{
+		Method:      "GET",
+		URL:         event.URL,
+		Cookies:     []NameValue{},
+		Headers:     []NameValue{},
+		QueryString: []NameValue{},
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+	if referrer, ok := event.Data["referrer"].(string); ok && referrer != "" {
+		request.Headers = append(request.Headers, NameValue{Name: "Referer", Value: referrer})
+	}
+	if parsed, err := url.Parse(event.URL); err == nil && parsed.RawQuery != "" {
+		// Split by hand to keep parameters in URL order.
+		for _, pair := range strings.Split(parsed.RawQuery, "&") {
+			name, value, _ := strings.Cut(pair, "=")
+			name, _ = url.QueryUnescape(name)
+			value, _ = url.QueryUnescape(value)
+			request.QueryString = append(request.QueryString, NameValue{Name: name, Value: value})
+		}
+	}
+	return Entry{
+		Pageref:         pageID(event.ID),
+		StartedDateTime: models.FormatTimestamp(event.TSUTC),
+		Time:            -1,
+		Request:         request,
+		// The extension does not observe responses; status 0 marks them unknown.
+		Response: Response{
+			Cookies:     []NameValue{},
+			Headers:     []NameValue{},
+			Content:     Content{MimeType: "text/html"},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Timings: Timings{Send: -1, Wait: -1, Receive: -1},
+	}
+}
+
+// NavigateEvents extracts one navigate event per page. Documents without a
+// pages list fall back to HTML responses among the entries.
+func NavigateEvents(document Document) ([]models.Event, error) {
+	var events []models.Event
+	if len(document.Log.Pages) == 0 {
+		for _, entry := range document.Log.Entries {
+			if !strings.HasPrefix(entry.Response.Content.MimeType, "text/html") {
+				continue
+			}
+			event, err := navigateEvent(entry.StartedDateTime, entry, "")
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+		}
+		return events, nil
+	}
+
+	firstEntry := make(map[string]Entry)
+	for _, entry := range document.Log.Entries {
+		if _, ok := firstEntry[entry.Pageref]; !ok && entry.Pageref != "" {
+			firstEntry[entry.Pageref] = entry
+		}
+	}
+	for _, page := range document.Log.Pages {
+		entry, ok := firstEntry[page.ID]
+		if !ok {
+			continue
+		}
+		event, err := navigateEvent(page.StartedDateTime, entry, page.Title)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func navigateEvent(startedDateTime string, entry Entry, title string) (models.Event, error) {
+	started, err := time.Parse(time.RFC3339Nano, startedDateTime)
+	if err != nil {
+		return models.Event{}, fmt.Errorf("invalid startedDateTime %q: %w", startedDateTime, err)
+	}
+	data := map[string]any{"source": "har"}
+	for _, header := range entry.Request.Headers {
+		if strings.EqualFold(header.Name, "Referer") {
+			data["referrer"] = header.Value
+		}
+	}
+	event := models.Event{
+		TSUTC: started.UnixMilli(),
+		TSISO: models.FormatTimestamp(started.UnixMilli()),
+		URL:   entry.Request.URL,
+		Type:  "navigate",
+		Data:  data,
+	}
+	if title != "" && title != entry.Request.URL {
+		event.Title = &title
+	}
+	return event, nil
+}
@@ -0,0 +1,156 @@
+package har
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func storedEvent(id, ts int64, url, eventType string, data map[string]any) models.StoredEvent {
+	return models.StoredEvent{
+		ID: id,
+		Event: models.Event{
+			TSUTC: ts,
+			TSISO: models.FormatTimestamp(ts),
+			URL:   url,
+			Type:  eventType,
+			Data:  data,
+		},
+	}
+}
+
+func TestBuilderGroupsEventsIntoPages(t *testing.T) {
+	title := "Search"
+	builder := NewBuilder()
+	first := storedEvent(1, 1609459200000, "https://search.example/?q=go&lang=en", "navigate", map[string]any{"referrer": "https://start.example/"})
+	first.Title = &title
+	builder.Add(first)
+	builder.Add(storedEvent(2, 1609459201000, "https://search.example/?q=go&lang=en", "click", map[string]any{"selector": "a.result"}))
+	builder.Add(storedEvent(3, 1609459202000, "https://go.dev/", "navigate", map[string]any{}))
+	builder.Add(storedEvent(4, 1609459203000, "https://go.dev/", "scroll", map[string]any{"y": 400}))
+
+	document := builder.Document()
+	if document.Log.Version != "1.2" {
+		t.Errorf("Expected version 1.2, got %s", document.Log.Version)
+	}
+	if len(document.Log.Pages) != 2 || len(document.Log.Entries) != 2 {
+		t.Fatalf("Expected 2 pages and 2 entries, got %d and %d", len(document.Log.Pages), len(document.Log.Entries))
+	}
+
+	page := document.Log.Pages[0]
+	if page.ID != "page_1" || page.Title != "Search" || page.StartedDateTime != "2021-01-01T00:00:00.000Z" {
+		t.Errorf("Unexpected first page: %+v", page)
+	}
+	if len(page.Events) != 1 || page.Events[0].Type != "click" {
+		t.Errorf("Expected click on first page, got %+v", page.Events)
+	}
+	if document.Log.Pages[1].Title != "https://go.dev/" {
+		t.Errorf("Expected URL as fallback title, got %s", document.Log.Pages[1].Title)
+	}
+
+	entry := document.Log.Entries[0]
+	if entry.Pageref != "page_1" || entry.Request.URL != first.URL {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+	wantQuery := []NameValue{{"q", "go"}, {"lang", "en"}}
+	if len(entry.Request.QueryString) != 2 || entry.Request.QueryString[0] != wantQuery[0] || entry.Request.QueryString[1] != wantQuery[1] {
+		t.Errorf("Unexpected query string: %+v", entry.Request.QueryString)
+	}
+	if len(entry.Request.Headers) != 1 || entry.Request.Headers[0].Value != "https://start.example/" {
+		t.Errorf("Expected Referer header, got %+v", entry.Request.Headers)
+	}
+}
+
+func TestBuilderCreatesPageForOrphanInteraction(t *testing.T) {
+	builder := NewBuilder()
+	builder.Add(storedEvent(7, 1000, "https://example.com", "click", map[string]any{}))
+
+	document := builder.Document()
+	if len(document.Log.Pages) != 1 || len(document.Log.Entries) != 0 {
+		t.Fatalf("Expected 1 page and no entries, got %+v", document.Log)
+	}
+	if len(document.Log.Pages[0].Events) != 1 {
+		t.Errorf("Expected orphan click on synthetic page")
+	}
+}
+
+func TestNavigateEventsRoundTrip(t *testing.T) {
+	title := "Go"
+	builder := NewBuilder()
+	event := storedEvent(1, 1609459200123, "https://go.dev/", "navigate", map[string]any{"referrer": "https://search.example/"})
+	event.Title = &title
+	builder.Add(event)
+	builder.Add(storedEvent(2, 1609459201000, "https://go.dev/", "click", map[string]any{}))
+
+	encoded, err := json.Marshal(builder.Document())
+	if err != nil {
+		t.Fatalf("Failed to marshal document: %v", err)
+	}
+	var document Document
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		t.Fatalf("Failed to unmarshal document: %v", err)
+	}
+
+	events, err := NavigateEvents(document)
+	if err != nil {
+		t.Fatalf("NavigateEvents() error = %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 navigate event, got %d", len(events))
+	}
+	got := events[0]
+	if got.TSUTC != event.TSUTC || got.URL != event.URL || got.Type != "navigate" {
+		t.Errorf("Unexpected event: %+v", got)
+	}
+	if got.Title == nil || *got.Title != "Go" {
+		t.Errorf("Expected title Go, got %v", got.Title)
+	}
+	if got.Data["referrer"] != "https://search.example/" || got.Data["source"] != "har" {
+		t.Errorf("Unexpected data: %v", got.Data)
+	}
+}
+
+func TestNavigateEventsWithoutPages(t *testing.T) {
+	document := Document{Log: Log{Entries: []Entry{
+		{StartedDateTime: "2021-01-01T01:00:00.000+01:00", Request: Request{URL: "https://example.com/"}, Response: Response{Content: Content{MimeType: "text/html; charset=utf-8"}}},
+		{StartedDateTime: "2021-01-01T01:00:01.000+01:00", Request: Request{URL: "https://example.com/app.js"}, Response: Response{Content: Content{MimeType: "application/javascript"}}},
+	}}}
+
+	events, err := NavigateEvents(document)
+	if err != nil {
+		t.Fatalf("NavigateEvents() error = %v", err)
+	}
+	if len(events) != 1 || events[0].TSUTC != 1609459200000 {
+		t.Errorf("Expected one HTML navigation at 2021-01-01T00:00:00Z, got %+v", events)
+	}
+}
+
+func TestNavigateEventsInvalidTime(t *testing.T) {
+	document := Document{Log: Log{
+		Pages:   []Page{{ID: "p", StartedDateTime: "not a time"}},
+		Entries: []Entry{{Pageref: "p", Request: Request{URL: "https://example.com/"}}},
+	}}
+	if _, err := NavigateEvents(document); err == nil {
+		t.Error("Expected error for invalid startedDateTime")
+	}
+}
+
+func TestBuilderSeparatesTabs(t *testing.T) {
+	firstTab, secondTab := int64(1), int64(2)
+	builder := NewBuilder()
+	a := storedEvent(1, 1000, "https://example.com", "navigate", map[string]any{})
+	a.TabID = &firstTab
+	b := storedEvent(2, 2000, "https://example.com", "navigate", map[string]any{})
+	b.TabID = &secondTab
+	click := storedEvent(3, 3000, "https://example.com", "click", map[string]any{})
+	click.TabID = &firstTab
+	builder.Add(a)
+	builder.Add(b)
+	builder.Add(click)
+
+	pages := builder.Document().Log.Pages
+	if len(pages[0].Events) != 1 || len(pages[1].Events) != 0 {
+		t.Errorf("Expected click on the first tab's page, got %+v", pages)
+	}
+}
@@ -0,0 +1,73 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// chromeEpochOffset is the number of milliseconds between Chrome's epoch
+// (1601-01-01 UTC, used by visit_time in microseconds) and the Unix epoch.
+const chromeEpochOffset = 11644473600000
+
+// chromeTransitions names the core page transition types (the low byte of
+// visits.transition).
+var chromeTransitions = map[int64]string{
+	0:  "link",
+	1:  "typed",
+	2:  "auto_bookmark",
+	3:  "auto_subframe",
+	4:  "manual_subframe",
+	5:  "generated",
+	6:  "auto_toplevel",
+	7:  "form_submit",
+	8:  "reload",
+	9:  "keyword",
+	10: "keyword_generated",
+}
+
+func chromeTimeToUnixMilli(visitTime int64) int64 {
+	return visitTime/1000 - chromeEpochOffset
+}
+
+func readChromeVisits(ctx context.Context, history *sql.DB, emit func(models.Event) error) error {
+	// Subframe transitions are page embeds, not navigations the user made.
+	rows, err := history.QueryContext(ctx, `
+	SELECT v.id, v.visit_time, v.transition, u.url, u.title, referrer.url
+	FROM visits v
+	JOIN urls u ON u.id = v.url
+	LEFT JOIN visits previous ON previous.id = v.from_visit
+	LEFT JOIN urls referrer ON referrer.id = previous.url
+	WHERE (v.transition & 255) NOT IN (3, 4)
+	ORDER BY v.visit_time`)
+	if err != nil {
+		return fmt.Errorf("failed to query Chrome visits: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var visitID, visitTime, transition int64
+		var url string
+		var title, referrer sql.NullString
+		if err := rows.Scan(&visitID, &visitTime, &transition, &url, &title, &referrer); err != nil {
+			return fmt.Errorf("failed to scan Chrome visit: %w", err)
+		}
+		data := map[string]any{
+			"source":     "chrome",
+			"visit_id":   visitID,
+			"transition": chromeTransitions[transition&0xFF],
+		}
+		if referrer.Valid {
+			data["referrer"] = referrer.String
+		}
+		if err := emit(navigateEvent(chromeTimeToUnixMilli(visitTime), url, title, data)); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read Chrome visits: %w", err)
+	}
+	return nil
+}
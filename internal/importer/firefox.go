@@ -0,0 +1,67 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// firefoxVisitTypes names moz_historyvisits.visit_type values.
+var firefoxVisitTypes = map[int64]string{
+	1: "link",
+	2: "typed",
+	3: "bookmark",
+	4: "embed",
+	5: "redirect_permanent",
+	6: "redirect_temporary",
+	7: "download",
+	8: "framed_link",
+	9: "reload",
+}
+
+// firefoxTimeToUnixMilli converts PRTime (microseconds since the Unix epoch).
+func firefoxTimeToUnixMilli(visitDate int64) int64 {
+	return visitDate / 1000
+}
+
+func readFirefoxVisits(ctx context.Context, history *sql.DB, emit func(models.Event) error) error {
+	// Embeds and framed links are subresources, not top-level navigations.
+	rows, err := history.QueryContext(ctx, `
+	SELECT v.id, v.visit_date, v.visit_type, p.url, p.title, referrer.url
+	FROM moz_historyvisits v
+	JOIN moz_places p ON p.id = v.place_id
+	LEFT JOIN moz_historyvisits previous ON previous.id = v.from_visit
+	LEFT JOIN moz_places referrer ON referrer.id = previous.place_id
+	WHERE v.visit_type NOT IN (4, 8)
+	ORDER BY v.visit_date`)
+	if err != nil {
+		return fmt.Errorf("failed to query Firefox visits: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var visitID, visitDate, visitType int64
+		var url string
+		var title, referrer sql.NullString
+		if err := rows.Scan(&visitID, &visitDate, &visitType, &url, &title, &referrer); err != nil {
+			return fmt.Errorf("failed to scan Firefox visit: %w", err)
+		}
+		data := map[string]any{
+			"source":     "firefox",
+			"visit_id":   visitID,
+			"transition": firefoxVisitTypes[visitType],
+		}
+		if referrer.Valid {
+			data["referrer"] = referrer.String
+		}
+		if err := emit(navigateEvent(firefoxTimeToUnixMilli(visitDate), url, title, data)); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read Firefox visits: %w", err)
+	}
+	return nil
+}
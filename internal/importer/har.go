@@ -0,0 +1,38 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/har"
+)
+
+// ImportHAR stores the pages of a HAR document as navigate events.
+func ImportHAR(ctx context.Context, db *database.Database, r io.Reader) (Result, error) {
+	var result Result
+
+	var document har.Document
+	if err := json.NewDecoder(r).Decode(&document); err != nil {
+		return result, fmt.Errorf("failed to parse HAR: %w", err)
+	}
+	events, err := har.NavigateEvents(document)
+	if err != nil {
+		return result, err
+	}
+	result.Read = len(events)
+
+	for start := 0; start < len(events); start += batchSize {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		imported, err := db.ImportEvents(events[start:min(start+batchSize, len(events))])
+		if err != nil {
+			return result, err
+		}
+		result.Imported += imported
+	}
+	return result, nil
+}
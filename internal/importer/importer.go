@@ -0,0 +1,184 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	_ "modernc.org/sqlite" // CGO-free SQLite
+)
+
+// batchSize bounds how many visits are held in memory between inserts.
+const batchSize = 1000
+
+type Result struct {
+	Read     int // visits read from the browser database
+	Imported int // visits stored as new navigate events
+	Skipped  int // visits read without a usable time, such as a zero visit_time
+}
+
+// visitReader emits navigate events from an opened browser history database.
+type visitReader func(ctx context.Context, history *sql.DB, emit func(models.Event) error) error
+
+// ImportChrome imports visits from a Chrome (or Chromium-based) History file.
+func ImportChrome(ctx context.Context, db *database.Database, historyPath string) (Result, error) {
+	return importHistory(ctx, db, historyPath, readChromeVisits)
+}
+
+// ImportFirefox imports visits from a Firefox places.sqlite file.
+func ImportFirefox(ctx context.Context, db *database.Database, placesPath string) (Result, error) {
+	return importHistory(ctx, db, placesPath, readFirefoxVisits)
+}
+
+func importHistory(ctx context.Context, db *database.Database, path string, read visitReader) (Result, error) {
+	var result Result
+
+	// The browser keeps its database locked while running, so work on a copy.
+	tmpDir, err := os.MkdirTemp("", "browsetrace-import-*")
+	if err != nil {
+		return result, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	copyPath := filepath.Join(tmpDir, filepath.Base(path))
+	if err := copyFile(path, copyPath); err != nil {
+		return result, err
+	}
+	// Recent visits may still live in the write-ahead log.
+	if _, err := os.Stat(path + "-wal"); err == nil {
+		if err := copyFile(path+"-wal", copyPath+"-wal"); err != nil {
+			return result, err
+		}
+	}
+
+	history, err := sql.Open("sqlite", readOnlyURI(copyPath))
+	if err != nil {
+		return result, fmt.Errorf("failed to open history database: %w", err)
+	}
+	defer history.Close()
+
+	batch := make([]models.Event, 0, batchSize)
+	flush := func() error {
+		imported, err := db.ImportEvents(batch)
+		if err != nil {
+			return err
+		}
+		result.Imported += imported
+		batch = batch[:0]
+		return nil
+	}
+
+	err = read(ctx, history, func(event models.Event) error {
+		result.Read++
+		// Browsers leave the time unset on some rows; one bad row should not
+		// abort the import.
+		if event.TSUTC <= 0 {
+			result.Skipped++
+			return nil
+		}
+		batch = append(batch, event)
+		if len(batch) == batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// readOnlyURI returns an SQLite URI opening path read-only, escaping
+// characters such as ? and # that would otherwise end the path.
+func readOnlyURI(path string) string {
+	slashed := filepath.ToSlash(path)
+	if !strings.HasPrefix(slashed, "/") {
+		slashed = "/" + slashed // a Windows drive letter
+	}
+	return (&url.URL{Scheme: "file", Path: slashed, RawQuery: "mode=ro"}).String()
+}
+
+func copyFile(source, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", source, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(destination)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", destination, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy %s: %w", source, err)
+	}
+	return out.Close()
+}
+
+func navigateEvent(milliseconds int64, url string, title sql.NullString, data map[string]any) models.Event {
+	event := models.Event{
+		TSUTC: milliseconds,
+		TSISO: models.FormatTimestamp(milliseconds),
+		URL:   url,
+		Type:  "navigate",
+		Data:  data,
+	}
+	if title.Valid && title.String != "" {
+		event.Title = &title.String
+	}
+	return event
+}
+
+// DefaultChromePath returns the History file of Chrome's default profile.
+func DefaultChromePath() (string, error) {
+	homeDirectory, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(homeDirectory, "Library", "Application Support", "Google", "Chrome", "Default", "History"), nil
+	case "windows":
+		return filepath.Join(homeDirectory, "AppData", "Local", "Google", "Chrome", "User Data", "Default", "History"), nil
+	default:
+		return filepath.Join(homeDirectory, ".config", "google-chrome", "Default", "History"), nil
+	}
+}
+
+// DefaultFirefoxPath returns places.sqlite of the first default Firefox profile.
+func DefaultFirefoxPath() (string, error) {
+	homeDirectory, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	var profiles string
+	switch runtime.GOOS {
+	case "darwin":
+		profiles = filepath.Join(homeDirectory, "Library", "Application Support", "Firefox", "Profiles")
+	case "windows":
+		profiles = filepath.Join(homeDirectory, "AppData", "Roaming", "Mozilla", "Firefox", "Profiles")
+	default:
+		profiles = filepath.Join(homeDirectory, ".mozilla", "firefox")
+	}
+	for _, pattern := range []string{"*.default-release", "*.default"} {
+		matches, _ := filepath.Glob(filepath.Join(profiles, pattern, "places.sqlite"))
+		if len(matches) > 0 {
+			return matches[0], nil
+		}
+	}
+	return "", fmt.Errorf("no Firefox profile found under %s", profiles)
+}
@@ -0,0 +1,247 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+)
+
+func setupTestDB(t *testing.T) (*database.Database, string, func()) {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "browsetrace-import-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	db, err := database.NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+	return db, tmpDir, cleanup
+}
+
+// createHistoryFixture builds a browser history database from raw SQL.
+func createHistoryFixture(t *testing.T, path, script string) {
+	t.Helper()
+
+	history, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Failed to create fixture: %v", err)
+	}
+	defer history.Close()
+	if _, err := history.Exec(script); err != nil {
+		t.Fatalf("Failed to populate fixture: %v", err)
+	}
+}
+
+const chromeFixture = `
+CREATE TABLE urls(id INTEGER PRIMARY KEY, url LONGVARCHAR, title LONGVARCHAR, visit_count INTEGER, typed_count INTEGER, last_visit_time INTEGER, hidden INTEGER);
+CREATE TABLE visits(id INTEGER PRIMARY KEY, url INTEGER NOT NULL, visit_time INTEGER NOT NULL, from_visit INTEGER, transition INTEGER DEFAULT 0 NOT NULL);
+INSERT INTO urls VALUES (1, 'https://search.example/?q=go', 'go - Search', 1, 0, 0, 0);
+INSERT INTO urls VALUES (2, 'https://go.dev/', 'The Go Programming Language', 1, 0, 0, 0);
+INSERT INTO urls VALUES (3, 'https://ads.example/frame', '', 1, 0, 0, 0);
+-- 2021-01-01T00:00:00Z and one second later, in microseconds since 1601
+INSERT INTO visits VALUES (10, 1, 13253932800000000, 0, 1);
+INSERT INTO visits VALUES (11, 2, 13253932801000000, 10, 805306368);
+INSERT INTO visits VALUES (12, 3, 13253932801500000, 11, 3);
+`
+
+const firefoxFixture = `
+CREATE TABLE moz_places(id INTEGER PRIMARY KEY, url LONGVARCHAR, title LONGVARCHAR);
+CREATE TABLE moz_historyvisits(id INTEGER PRIMARY KEY, from_visit INTEGER, place_id INTEGER, visit_date INTEGER, visit_type INTEGER);
+INSERT INTO moz_places VALUES (1, 'https://developer.mozilla.org/', 'MDN Web Docs');
+INSERT INTO moz_places VALUES (2, 'https://developer.mozilla.org/en-US/docs/Web', NULL);
+-- 2021-01-01T00:00:00Z and one second later, in microseconds since 1970
+INSERT INTO moz_historyvisits VALUES (20, 0, 1, 1609459200000000, 2);
+INSERT INTO moz_historyvisits VALUES (21, 20, 2, 1609459201000000, 1);
+INSERT INTO moz_historyvisits VALUES (22, 21, 2, 1609459201500000, 4);
+`
+
+func TestImportChrome(t *testing.T) {
+	db, tmpDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	historyPath := filepath.Join(tmpDir, "History")
+	createHistoryFixture(t, historyPath, chromeFixture)
+
+	result, err := ImportChrome(context.Background(), db, historyPath)
+	if err != nil {
+		t.Fatalf("ImportChrome() error = %v", err)
+	}
+	if result.Read != 2 || result.Imported != 2 {
+		t.Errorf("Expected 2 read and 2 imported, got %+v", result)
+	}
+
+	events, err := db.QueryEvents(context.Background(), database.EventFilter{})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	first, second := events[0], events[1]
+	if first.TSUTC != 1609459200000 || first.TSISO != "2021-01-01T00:00:00.000Z" {
+		t.Errorf("Unexpected timestamp conversion: %d %s", first.TSUTC, first.TSISO)
+	}
+	if first.Type != "navigate" || first.Data["transition"] != "typed" || first.Data["source"] != "chrome" {
+		t.Errorf("Unexpected first event: %+v", first)
+	}
+	if second.Title == nil || *second.Title != "The Go Programming Language" {
+		t.Errorf("Unexpected title: %v", second.Title)
+	}
+	if second.Data["referrer"] != "https://search.example/?q=go" || second.Data["transition"] != "link" {
+		t.Errorf("Unexpected second event data: %v", second.Data)
+	}
+}
+
+func TestImportSkipsVisitsWithoutTime(t *testing.T) {
+	db, tmpDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// The ? and # must not be taken for the start of a query or fragment.
+	fixturePath := filepath.Join(tmpDir, "History")
+	createHistoryFixture(t, fixturePath, chromeFixture+`INSERT INTO visits VALUES (13, 2, 0, 0, 1);`)
+	historyPath := filepath.Join(tmpDir, "History?mode=rw#1")
+	if err := os.Rename(fixturePath, historyPath); err != nil {
+		t.Fatalf("Failed to rename fixture: %v", err)
+	}
+
+	result, err := ImportChrome(context.Background(), db, historyPath)
+	if err != nil {
+		t.Fatalf("ImportChrome() error = %v", err)
+	}
+	if result.Read != 3 || result.Imported != 2 || result.Skipped != 1 {
+		t.Errorf("Expected 3 read, 2 imported and 1 skipped, got %+v", result)
+	}
+}
+
+func TestImportFirefox(t *testing.T) {
+	db, tmpDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	placesPath := filepath.Join(tmpDir, "places.sqlite")
+	createHistoryFixture(t, placesPath, firefoxFixture)
+
+	result, err := ImportFirefox(context.Background(), db, placesPath)
+	if err != nil {
+		t.Fatalf("ImportFirefox() error = %v", err)
+	}
+	if result.Read != 2 || result.Imported != 2 {
+		t.Errorf("Expected 2 read and 2 imported, got %+v", result)
+	}
+
+	events, err := db.QueryEvents(context.Background(), database.EventFilter{})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if events[0].TSUTC != 1609459200000 || events[1].TSISO != "2021-01-01T00:00:01.000Z" {
+		t.Errorf("Unexpected timestamp conversion: %d %s", events[0].TSUTC, events[1].TSISO)
+	}
+	if events[1].Title != nil {
+		t.Errorf("Expected nil title, got %s", *events[1].Title)
+	}
+	if events[1].Data["referrer"] != "https://developer.mozilla.org/" {
+		t.Errorf("Unexpected referrer: %v", events[1].Data["referrer"])
+	}
+}
+
+func TestReimportDeduplicates(t *testing.T) {
+	db, tmpDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	historyPath := filepath.Join(tmpDir, "History")
+	createHistoryFixture(t, historyPath, chromeFixture)
+
+	if _, err := ImportChrome(context.Background(), db, historyPath); err != nil {
+		t.Fatalf("First import error = %v", err)
+	}
+	result, err := ImportChrome(context.Background(), db, historyPath)
+	if err != nil {
+		t.Fatalf("Second import error = %v", err)
+	}
+	if result.Read != 2 || result.Imported != 0 {
+		t.Errorf("Expected re-import to skip all visits, got %+v", result)
+	}
+}
+
+func TestImportLeavesSourceUntouched(t *testing.T) {
+	db, tmpDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	placesPath := filepath.Join(tmpDir, "places.sqlite")
+	createHistoryFixture(t, placesPath, firefoxFixture)
+	before, err := os.ReadFile(placesPath)
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	if _, err := ImportFirefox(context.Background(), db, placesPath); err != nil {
+		t.Fatalf("ImportFirefox() error = %v", err)
+	}
+
+	after, err := os.ReadFile(placesPath)
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	if string(before) != string(after) {
+		t.Error("Expected source database to be unchanged")
+	}
+}
+
+func TestImportMissingFile(t *testing.T) {
+	db, tmpDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := ImportChrome(context.Background(), db, filepath.Join(tmpDir, "missing")); err == nil {
+		t.Error("Expected error for missing history file")
+	}
+}
+
+func TestImportHAR(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	document := `{"log": {"version": "1.2", "creator": {"name": "devtools", "version": "1"},
+		"pages": [{"startedDateTime": "2021-01-01T00:00:00.000Z", "id": "page_1", "title": "Example", "pageTimings": {}}],
+		"entries": [
+			{"pageref": "page_1", "startedDateTime": "2021-01-01T00:00:00.000Z", "request": {"method": "GET", "url": "https://example.com/"}, "response": {"status": 200, "content": {"mimeType": "text/html"}}},
+			{"pageref": "page_1", "startedDateTime": "2021-01-01T00:00:00.100Z", "request": {"method": "GET", "url": "https://example.com/style.css"}, "response": {"status": 200, "content": {"mimeType": "text/css"}}}
+		]}}`
+
+	result, err := ImportHAR(context.Background(), db, strings.NewReader(document))
+	if err != nil {
+		t.Fatalf("ImportHAR() error = %v", err)
+	}
+	if result.Read != 1 || result.Imported != 1 {
+		t.Errorf("Expected 1 read and 1 imported, got %+v", result)
+	}
+
+	result, err = ImportHAR(context.Background(), db, strings.NewReader(document))
+	if err != nil {
+		t.Fatalf("Second ImportHAR() error = %v", err)
+	}
+	if result.Imported != 0 {
+		t.Errorf("Expected re-import to skip the page, got %+v", result)
+	}
+}
+
+func TestImportHARInvalidJSON(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := ImportHAR(context.Background(), db, strings.NewReader("{not json")); err == nil {
+		t.Error("Expected error for invalid HAR")
+	}
+}
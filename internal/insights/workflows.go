@@ -0,0 +1,315 @@
+// Package insights mines recorded browsing for patterns worth surfacing.
+//
+// Workflow mining reduces each session to a sequence of steps, an action type
+// on a URL template (https://shop.example/orders/1234?x=1 becomes
+// shop.example/orders/{id}), and counts every contiguous run of steps across
+// sessions. Runs repeated in enough sessions are reported as workflows,
+// leaving out those that are only part of a longer workflow seen as often.
+package insights
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/sessions"
+)
+
+const (
+	DefaultMinSupport = 3
+	DefaultMinLength  = 3
+	DefaultMaxLength  = 10
+	DefaultLimit      = 20
+
+	// maxExamples caps the session IDs listed per workflow.
+	maxExamples = 5
+)
+
+// workflowTypes are the events that make up a routine. Scrolls, focus changes
+// and page text vary between repetitions without changing what was done.
+var workflowTypes = map[string]bool{
+	"navigate": true,
+	"click":    true,
+	"input":    true,
+}
+
+// Ranking orders mined workflows.
+type Ranking string
+
+const (
+	// RankFrequency puts workflows repeated in the most sessions first.
+	RankFrequency Ranking = "frequency"
+	// RankTime puts workflows with the most total time spent first.
+	RankTime Ranking = "time"
+)
+
+func ParseRanking(value string) (Ranking, error) {
+	switch Ranking(value) {
+	case "", RankFrequency:
+		return RankFrequency, nil
+	case RankTime:
+		return RankTime, nil
+	}
+	return "", fmt.Errorf("invalid sort %q (want frequency or time)", value)
+}
+
+type WorkflowOptions struct {
+	Since      int64 // sessions ending at or after this time (ms)
+	Until      int64 // sessions starting before this time (ms)
+	MinSupport int   // sessions a workflow must appear in
+	MinLength  int   // steps
+	MaxLength  int   // steps
+	Limit      int
+	Sort       Ranking
+}
+
+// Step is one normalized action.
+type Step struct {
+	Type        string `json:"type"`
+	URLTemplate string `json:"url_template"`
+}
+
+type Workflow struct {
+	Steps           []Step  `json:"steps"`
+	Sessions        int     `json:"sessions"`
+	Occurrences     int     `json:"occurrences"`
+	TotalDurationMS int64   `json:"total_duration_ms"`
+	AvgDurationMS   int64   `json:"avg_duration_ms"`
+	FirstSeenTSUTC  int64   `json:"first_seen_ts_utc"`
+	LastSeenTSUTC   int64   `json:"last_seen_ts_utc"`
+	LastSeenTSISO   string  `json:"last_seen_ts_iso"`
+	ExampleSessions []int64 `json:"example_sessions"`
+}
+
+type Miner struct {
+	sessions *sessions.Sessionizer
+}
+
+// NewMiner returns a miner over the sessions recorded by sessionizer.
+func NewMiner(sessionizer *sessions.Sessionizer) *Miner {
+	return &Miner{sessions: sessionizer}
+}
+
+// Workflows mines the sessions in the requested range.
+func (m *Miner) Workflows(ctx context.Context, opts WorkflowOptions) ([]Workflow, error) {
+	list, err := m.sessions.List(ctx, sessions.ListOptions{Since: opts.Since, Until: opts.Until})
+	if err != nil {
+		return nil, err
+	}
+	var sequences []Sequence
+	for _, session := range list {
+		detail, err := m.sessions.Get(ctx, session.ID)
+		if err != nil {
+			return nil, err
+		}
+		sequences = append(sequences, Normalize(session.ID, detail.Events))
+	}
+	return Mine(sequences, opts), nil
+}
+
+// Sequence is a session reduced to normalized steps.
+type Sequence struct {
+	SessionID int64
+	Steps     []Step
+	TSUTC     []int64 // time of each step
+}
+
+// Normalize keeps the workflow events of a session as steps, collapsing
+// repeats of the same step (keystroke inputs, reloads) into one.
+func Normalize(sessionID int64, events []models.StoredEvent) Sequence {
+	sequence := Sequence{SessionID: sessionID}
+	for _, event := range events {
+		if !workflowTypes[event.Type] {
+			continue
+		}
+		step := Step{Type: event.Type, URLTemplate: URLTemplate(event.URL)}
+		if last := len(sequence.Steps) - 1; last >= 0 && sequence.Steps[last] == step {
+			continue
+		}
+		sequence.Steps = append(sequence.Steps, step)
+		sequence.TSUTC = append(sequence.TSUTC, event.TSUTC)
+	}
+	return sequence
+}
+
+// URLTemplate drops the scheme, query and fragment of a URL and replaces path
+// segments that look like identifiers with {id}.
+func URLTemplate(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return rawURL
+	}
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	for i, segment := range segments {
+		if isIdentifier(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	path := strings.Join(segments, "/")
+	if path == "" {
+		return host
+	}
+	return host + "/" + path
+}
+
+// isIdentifier reports whether a path segment is a number, a UUID or hash, or
+// a long token mixing letters and digits, rather than a word.
+func isIdentifier(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	var digits, letters, hex int
+	for _, r := range segment {
+		switch {
+		case unicode.IsDigit(r):
+			digits++
+			hex++
+		case unicode.IsLetter(r):
+			letters++
+			if strings.ContainsRune("abcdefABCDEF", r) {
+				hex++
+			}
+		case r != '-' && r != '_':
+			return false
+		}
+	}
+	switch {
+	case digits == 0:
+		return false
+	case letters == 0:
+		return true
+	case hex == digits+letters && len(segment) >= 8:
+		return true
+	}
+	return len(segment) >= 16
+}
+
+type candidate struct {
+	steps       []Step
+	sessions    map[int64]bool
+	occurrences int
+	totalMS     int64
+	firstSeen   int64
+	lastSeen    int64
+	closed      bool
+}
+
+// Mine counts every run of MinLength to MaxLength consecutive steps and
+// returns those seen in at least MinSupport sessions, ranked by opts.Sort.
+func Mine(sequences []Sequence, opts WorkflowOptions) []Workflow {
+	minSupport := opts.MinSupport
+	if minSupport <= 0 {
+		minSupport = DefaultMinSupport
+	}
+	minLength := opts.MinLength
+	if minLength <= 0 {
+		minLength = DefaultMinLength
+	}
+	minLength = max(minLength, 2)
+	maxLength := opts.MaxLength
+	if maxLength <= 0 {
+		maxLength = DefaultMaxLength
+	}
+	maxLength = max(maxLength, minLength)
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	candidates := make(map[string]*candidate)
+	for _, sequence := range sequences {
+		for length := minLength; length <= maxLength; length++ {
+			for start := 0; start+length <= len(sequence.Steps); start++ {
+				steps := sequence.Steps[start : start+length]
+				key := stepsKey(steps)
+				c := candidates[key]
+				if c == nil {
+					c = &candidate{steps: steps, sessions: make(map[int64]bool), firstSeen: sequence.TSUTC[start], closed: true}
+					candidates[key] = c
+				}
+				began, ended := sequence.TSUTC[start], sequence.TSUTC[start+length-1]
+				c.sessions[sequence.SessionID] = true
+				c.occurrences++
+				c.totalMS += ended - began
+				c.firstSeen = min(c.firstSeen, began)
+				c.lastSeen = max(c.lastSeen, ended)
+			}
+		}
+	}
+
+	// A run is not a workflow of its own when one step more on either end is
+	// seen in as many sessions. Support only shrinks as runs grow, so checking
+	// the prefix and suffix of each longer run covers every contained run.
+	for _, c := range candidates {
+		if len(c.steps) <= minLength || len(c.sessions) < minSupport {
+			continue
+		}
+		for _, part := range [][]Step{c.steps[:len(c.steps)-1], c.steps[1:]} {
+			if shorter := candidates[stepsKey(part)]; shorter != nil && len(shorter.sessions) == len(c.sessions) {
+				shorter.closed = false
+			}
+		}
+	}
+
+	workflows := []Workflow{}
+	for _, c := range candidates {
+		if !c.closed || len(c.sessions) < minSupport {
+			continue
+		}
+		workflow := Workflow{
+			Steps:           c.steps,
+			Sessions:        len(c.sessions),
+			Occurrences:     c.occurrences,
+			TotalDurationMS: c.totalMS,
+			AvgDurationMS:   c.totalMS / int64(c.occurrences),
+			FirstSeenTSUTC:  c.firstSeen,
+			LastSeenTSUTC:   c.lastSeen,
+			LastSeenTSISO:   models.FormatTimestamp(c.lastSeen),
+		}
+		for id := range c.sessions {
+			workflow.ExampleSessions = append(workflow.ExampleSessions, id)
+		}
+		sort.Slice(workflow.ExampleSessions, func(i, j int) bool { return workflow.ExampleSessions[i] > workflow.ExampleSessions[j] })
+		if len(workflow.ExampleSessions) > maxExamples {
+			workflow.ExampleSessions = workflow.ExampleSessions[:maxExamples]
+		}
+		workflows = append(workflows, workflow)
+	}
+
+	sort.Slice(workflows, func(i, j int) bool {
+		a, b := workflows[i], workflows[j]
+		if opts.Sort == RankTime && a.TotalDurationMS != b.TotalDurationMS {
+			return a.TotalDurationMS > b.TotalDurationMS
+		}
+		if a.Sessions != b.Sessions {
+			return a.Sessions > b.Sessions
+		}
+		if len(a.Steps) != len(b.Steps) {
+			return len(a.Steps) > len(b.Steps)
+		}
+		if a.TotalDurationMS != b.TotalDurationMS {
+			return a.TotalDurationMS > b.TotalDurationMS
+		}
+		return stepsKey(a.Steps) < stepsKey(b.Steps)
+	})
+	if len(workflows) > limit {
+		workflows = workflows[:limit]
+	}
+	return workflows
+}
+
+func stepsKey(steps []Step) string {
+	var builder strings.Builder
+	for _, step := range steps {
+		builder.WriteString(step.Type)
+		builder.WriteByte(' ')
+		builder.WriteString(step.URLTemplate)
+		builder.WriteByte('\n')
+	}
+	return builder.String()
+}
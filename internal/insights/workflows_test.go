@@ -0,0 +1,226 @@
+package insights
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/sessions"
+)
+
+const minute = int64(60 * 1000)
+
+func setupTestDB(t *testing.T) (*database.Database, func()) {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "browsetrace-insights-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	db, err := database.NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+	return db, cleanup
+}
+
+func TestURLTemplate(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://www.Example.com/", "example.com"},
+		{"https://shop.example/orders/1234?tab=items#top", "shop.example/orders/{id}"},
+		{"https://github.com/org/repo/pull/42/files", "github.com/org/repo/pull/{id}/files"},
+		{"https://app.example/doc/3f2a9c1e-77b0-4c1e-9d2a-0b6c8e4f1a2d/edit", "app.example/doc/{id}/edit"},
+		{"https://app.example/commit/a94a8fe5ccb19ba6", "app.example/commit/{id}"},
+		{"https://app.example/v2/settings", "app.example/v2/settings"},
+		{"https://app.example/session/Xk29sLq81mZp0aTy", "app.example/session/{id}"},
+		{"https://app.example/deadbeef", "app.example/deadbeef"},
+		{"about:blank", "about:blank"},
+	}
+
+	for _, tt := range tests {
+		if got := URLTemplate(tt.url); got != tt.want {
+			t.Errorf("URLTemplate(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func stored(ts int64, url, eventType string) models.StoredEvent {
+	return models.StoredEvent{Event: models.Event{TSUTC: ts, URL: url, Type: eventType, Data: map[string]any{}}}
+}
+
+func TestNormalize(t *testing.T) {
+	events := []models.StoredEvent{
+		stored(0, "https://mail.example/inbox", "navigate"),
+		stored(1000, "https://mail.example/inbox", "scroll"),
+		stored(2000, "https://mail.example/inbox/17", "navigate"),
+		stored(3000, "https://mail.example/inbox/17", "input"),
+		stored(3100, "https://mail.example/inbox/17", "input"),
+		stored(3200, "https://mail.example/inbox/17", "input"),
+		stored(4000, "https://mail.example/inbox/17", "click"),
+	}
+
+	sequence := Normalize(7, events)
+	want := []Step{
+		{"navigate", "mail.example/inbox"},
+		{"navigate", "mail.example/inbox/{id}"},
+		{"input", "mail.example/inbox/{id}"},
+		{"click", "mail.example/inbox/{id}"},
+	}
+	if len(sequence.Steps) != len(want) {
+		t.Fatalf("Expected %d steps, got %+v", len(want), sequence.Steps)
+	}
+	for i := range want {
+		if sequence.Steps[i] != want[i] {
+			t.Errorf("Step %d: expected %+v, got %+v", i, want[i], sequence.Steps[i])
+		}
+	}
+	if sequence.TSUTC[2] != 3000 || sequence.SessionID != 7 {
+		t.Errorf("Unexpected sequence: %+v", sequence)
+	}
+}
+
+func sequenceOf(id int64, start int64, templates ...string) Sequence {
+	sequence := Sequence{SessionID: id}
+	for i, template := range templates {
+		sequence.Steps = append(sequence.Steps, Step{Type: "navigate", URLTemplate: template})
+		sequence.TSUTC = append(sequence.TSUTC, start+int64(i)*minute)
+	}
+	return sequence
+}
+
+func TestMineKeepsLongestWorkflow(t *testing.T) {
+	sequences := []Sequence{
+		sequenceOf(1, 0, "x", "a", "b", "c", "d", "y"),
+		sequenceOf(2, 100*minute, "a", "b", "c", "d"),
+		sequenceOf(3, 200*minute, "z", "a", "b", "c", "d"),
+		sequenceOf(4, 300*minute, "a", "b", "c"),
+	}
+
+	workflows := Mine(sequences, WorkflowOptions{MinSupport: 3})
+	if len(workflows) != 2 {
+		t.Fatalf("Expected 2 workflows, got %d: %+v", len(workflows), workflows)
+	}
+	// a→b→c is in all four sessions; a→b→c→d in three. b→c→d is only ever
+	// part of a→b→c→d and is left out.
+	first, second := workflows[0], workflows[1]
+	if stepsKey(first.Steps) != stepsKey(sequenceOf(0, 0, "a", "b", "c").Steps) || first.Sessions != 4 {
+		t.Errorf("Unexpected first workflow: %+v", first)
+	}
+	if len(second.Steps) != 4 || second.Sessions != 3 || second.Occurrences != 3 {
+		t.Errorf("Unexpected second workflow: %+v", second)
+	}
+	if second.AvgDurationMS != 3*minute || second.TotalDurationMS != 9*minute {
+		t.Errorf("Expected 3 minutes per run, got %+v", second)
+	}
+	if second.FirstSeenTSUTC != 1*minute || second.LastSeenTSUTC != 204*minute {
+		t.Errorf("Unexpected first/last seen: %d, %d", second.FirstSeenTSUTC, second.LastSeenTSUTC)
+	}
+	if len(second.ExampleSessions) != 3 || second.ExampleSessions[0] != 3 {
+		t.Errorf("Unexpected example sessions: %v", second.ExampleSessions)
+	}
+}
+
+func TestMineRanking(t *testing.T) {
+	slow := func(id int64) Sequence {
+		sequence := sequenceOf(id, 0, "p", "q", "r")
+		sequence.TSUTC[2] = 60 * minute
+		return sequence
+	}
+	sequences := []Sequence{
+		sequenceOf(1, 0, "a", "b", "c"),
+		sequenceOf(2, 0, "a", "b", "c"),
+		sequenceOf(3, 0, "a", "b", "c"),
+		slow(4),
+		slow(5),
+	}
+
+	tests := []struct {
+		sort  Ranking
+		first string
+	}{
+		{RankFrequency, "a"},
+		{RankTime, "p"},
+	}
+	for _, tt := range tests {
+		workflows := Mine(sequences, WorkflowOptions{MinSupport: 2, Sort: tt.sort})
+		if len(workflows) != 2 {
+			t.Fatalf("Expected 2 workflows, got %+v", workflows)
+		}
+		if got := workflows[0].Steps[0].URLTemplate; got != tt.first {
+			t.Errorf("Sort %s: expected %s first, got %s", tt.sort, tt.first, got)
+		}
+	}
+
+	if workflows := Mine(sequences, WorkflowOptions{MinSupport: 2, Limit: 1}); len(workflows) != 1 {
+		t.Errorf("Expected limit to apply, got %d workflows", len(workflows))
+	}
+	if workflows := Mine(sequences, WorkflowOptions{MinSupport: 4}); len(workflows) != 0 {
+		t.Errorf("Expected no workflow in 4 sessions, got %+v", workflows)
+	}
+}
+
+func TestParseRanking(t *testing.T) {
+	if ranking, err := ParseRanking(""); err != nil || ranking != RankFrequency {
+		t.Errorf("Expected frequency by default, got %q, %v", ranking, err)
+	}
+	if _, err := ParseRanking("alphabetical"); err == nil {
+		t.Error("Expected an error for an unknown ranking")
+	}
+}
+
+func TestMinerWorkflows(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	sessionizer, err := sessions.New(db, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("sessions.New() error = %v", err)
+	}
+
+	// The same morning routine on three days, with a different ticket each time.
+	var events []models.Event
+	for day, ticket := range []string{"101", "202", "303"} {
+		start := int64(1609459200000) + int64(day)*24*60*minute
+		for i, step := range []struct{ url, eventType string }{
+			{"https://mail.example/", "navigate"},
+			{"https://tracker.example/board", "navigate"},
+			{"https://tracker.example/ticket/" + ticket, "navigate"},
+			{"https://tracker.example/ticket/" + ticket, "click"},
+		} {
+			ts := start + int64(i)*minute
+			events = append(events, models.Event{TSUTC: ts, TSISO: models.FormatTimestamp(ts), URL: step.url, Type: step.eventType, Data: map[string]any{}})
+		}
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	workflows, err := NewMiner(sessionizer).Workflows(context.Background(), WorkflowOptions{})
+	if err != nil {
+		t.Fatalf("Workflows() error = %v", err)
+	}
+	if len(workflows) != 1 {
+		t.Fatalf("Expected 1 workflow, got %+v", workflows)
+	}
+	workflow := workflows[0]
+	if len(workflow.Steps) != 4 || workflow.Sessions != 3 {
+		t.Errorf("Unexpected workflow: %+v", workflow)
+	}
+	if workflow.Steps[3] != (Step{"click", "tracker.example/ticket/{id}"}) {
+		t.Errorf("Unexpected last step: %+v", workflow.Steps[3])
+	}
+}
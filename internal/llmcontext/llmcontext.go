@@ -0,0 +1,399 @@
+// Package llmcontext compiles recent browsing into compact text for an LLM
+// prompt: one block per page visit with its title, URL, the clicks and inputs
+// made on it, and its visible text without repeats, fitted to a token budget.
+package llmcontext
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/privacy"
+	"github.com/vincentbai/browsetrace-agent/internal/sessions"
+)
+
+// DefaultBudget is the token budget when Options.Budget is zero.
+const DefaultBudget = 4000
+
+// minimumBudget leaves room for the heading and at least one page header.
+const minimumBudget = 50
+
+// maxActionsPerPage keeps long form-filling runs from crowding out page text.
+const maxActionsPerPage = 25
+
+// Strategy decides which text gives way when the budget is exceeded.
+type Strategy string
+
+const (
+	// StrategyRecent keeps the newest pages whole and truncates or drops
+	// older ones first.
+	StrategyRecent Strategy = "recent"
+	// StrategyEven keeps every page header and action list, and splits the
+	// remaining budget evenly between the pages' text.
+	StrategyEven Strategy = "even"
+)
+
+func ParseStrategy(value string) (Strategy, error) {
+	switch Strategy(value) {
+	case "", StrategyRecent:
+		return StrategyRecent, nil
+	case StrategyEven:
+		return StrategyEven, nil
+	}
+	return "", fmt.Errorf("invalid strategy %q (want recent or even)", value)
+}
+
+type Options struct {
+	Budget   int // tokens
+	Strategy Strategy
+}
+
+type Result struct {
+	Text            string `json:"text"`
+	EstimatedTokens int    `json:"estimated_tokens"`
+	Pages           int    `json:"pages"`
+	OmittedPages    int    `json:"omitted_pages"`
+	Truncated       bool   `json:"truncated"`
+}
+
+// contextTypes are the events worth showing to a model; scroll and focus
+// only add noise.
+var contextTypes = []string{"navigate", "visible_text", "click", "input"}
+
+type Compiler struct {
+	db        *database.Database
+	sessions  *sessions.Sessionizer
+	blocklist *privacy.Blocklist
+}
+
+// NewCompiler returns a compiler over db. Session contexts need sessionizer;
+// pages on blocklist are left out.
+func NewCompiler(db *database.Database, sessionizer *sessions.Sessionizer, blocklist *privacy.Blocklist) *Compiler {
+	return &Compiler{db: db, sessions: sessionizer, blocklist: blocklist}
+}
+
+// Window compiles the events in [since, until). A zero until means now.
+func (c *Compiler) Window(ctx context.Context, since, until int64, opts Options) (Result, error) {
+	events, err := c.db.QueryEvents(ctx, database.EventFilter{Since: since, Until: until, Types: contextTypes})
+	if err != nil {
+		return Result{}, err
+	}
+	return Build(events, opts, c.blocklist), nil
+}
+
+// Session compiles the events of one session. It returns sessions.ErrNotFound
+// for unknown IDs.
+func (c *Compiler) Session(ctx context.Context, id int64, opts Options) (Result, error) {
+	if c.sessions == nil {
+		return Result{}, fmt.Errorf("sessions are not enabled")
+	}
+	detail, err := c.sessions.Get(ctx, id)
+	if err != nil {
+		return Result{}, err
+	}
+	return Build(detail.Events, opts, c.blocklist), nil
+}
+
+// EstimateTokens approximates a tokenizer at four characters per token, which
+// is close for English prose and errs high for code and URLs.
+func EstimateTokens(text string) int {
+	return (len([]rune(text)) + 3) / 4
+}
+
+// page is one visit: a navigation and everything until the next navigation
+// in the same tab.
+type page struct {
+	url     string
+	title   string
+	start   int64
+	end     int64
+	actions []string
+	chunks  []string
+}
+
+func (p *page) header() string {
+	var builder strings.Builder
+	title := p.title
+	if title == "" {
+		title = p.url
+	}
+	fmt.Fprintf(&builder, "## %s\nURL: %s\nTime: %s", title, p.url, clock(p.start))
+	if p.end > p.start {
+		fmt.Fprintf(&builder, " to %s", clock(p.end))
+	}
+	builder.WriteString("\n")
+	if len(p.actions) > 0 {
+		builder.WriteString("Actions:\n")
+		for _, action := range p.actions {
+			builder.WriteString("- " + action + "\n")
+		}
+	}
+	return builder.String()
+}
+
+func (p *page) text() string {
+	return strings.Join(p.chunks, "\n")
+}
+
+func clock(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format("15:04:05")
+}
+
+// Build compiles timestamp-ordered events into a context within the budget.
+func Build(events []models.StoredEvent, opts Options, blocklist *privacy.Blocklist) Result {
+	budget := opts.Budget
+	if budget <= 0 {
+		budget = DefaultBudget
+	}
+	budget = max(budget, minimumBudget)
+	pages := collectPages(events, blocklist)
+
+	result := Result{}
+	heading := "# Browsing context\n"
+	if len(pages) > 0 {
+		heading = fmt.Sprintf("# Browsing context, %s to %s UTC\n",
+			time.UnixMilli(pages[0].start).UTC().Format(time.DateTime), clock(pages[len(pages)-1].end))
+	}
+	// Room for the heading and the omitted-pages note, whichever way it goes.
+	remaining := budget - EstimateTokens(heading) - EstimateTokens(omittedNote(99999))
+
+	var blocks []string
+	if opts.Strategy == StrategyEven {
+		blocks, result.OmittedPages, result.Truncated = fitEven(pages, remaining)
+	} else {
+		blocks, result.OmittedPages, result.Truncated = fitRecent(pages, remaining)
+	}
+
+	var builder strings.Builder
+	builder.WriteString(heading)
+	if result.OmittedPages > 0 {
+		builder.WriteString(omittedNote(result.OmittedPages))
+	}
+	for _, block := range blocks {
+		builder.WriteString("\n" + block)
+	}
+	result.Text = builder.String()
+	result.EstimatedTokens = EstimateTokens(result.Text)
+	result.Pages = len(blocks)
+	return result
+}
+
+func omittedNote(count int) string {
+	return fmt.Sprintf("(%d earlier pages omitted)\n", count)
+}
+
+// collectPages groups events into page visits. Events before a page's first
+// navigation (e.g. at the start of a window) open a visit of their own.
+func collectPages(events []models.StoredEvent, blocklist *privacy.Blocklist) []*page {
+	var pages []*page
+	current := make(map[int64]*page)             // by tab ID; -1 for events without one
+	seenText := make(map[string]map[string]bool) // by URL, across visits
+
+	for _, event := range events {
+		if !slices.Contains(contextTypes, event.Type) || blocklist.Blocks(event.URL) {
+			continue
+		}
+		tab := int64(-1)
+		if event.TabID != nil {
+			tab = *event.TabID
+		}
+		visit := current[tab]
+		if event.Type == "navigate" || visit == nil || visit.url != event.URL {
+			visit = &page{url: event.URL, start: event.TSUTC}
+			pages = append(pages, visit)
+			current[tab] = visit
+			if seenText[event.URL] == nil {
+				seenText[event.URL] = make(map[string]bool)
+			}
+		}
+		visit.end = event.TSUTC
+		if event.Title != nil && *event.Title != "" {
+			visit.title = *event.Title
+		}
+
+		switch event.Type {
+		case "visible_text":
+			// Text is resent as the user scrolls; keep each line once.
+			text, _ := event.Data["text"].(string)
+			for _, line := range strings.Split(text, "\n") {
+				line = strings.Join(strings.Fields(line), " ")
+				if line == "" || seenText[event.URL][line] {
+					continue
+				}
+				seenText[event.URL][line] = true
+				visit.chunks = append(visit.chunks, line)
+			}
+		case "click", "input":
+			if len(visit.actions) < maxActionsPerPage {
+				visit.actions = append(visit.actions, clock(event.TSUTC)+" "+describeAction(event))
+			}
+		}
+	}
+	return pages
+}
+
+// describeAction renders a click or input from the fields the extension
+// commonly sends: a selector (top level or under "target"), the element's
+// text, the field name and the typed value.
+func describeAction(event models.StoredEvent) string {
+	data := event.Data
+	target, _ := data["target"].(map[string]any)
+	lookup := func(key string) string {
+		if value, ok := data[key].(string); ok && value != "" {
+			return value
+		}
+		if value, ok := target[key].(string); ok && value != "" {
+			return value
+		}
+		return ""
+	}
+
+	parts := []string{event.Type}
+	if text := lookup("text"); text != "" {
+		parts = append(parts, fmt.Sprintf("%q", shorten(strings.Join(strings.Fields(text), " "), 60)))
+	}
+	if field := firstNonEmpty(lookup("field"), lookup("name")); field != "" {
+		parts = append(parts, "field "+field)
+	}
+	if selector := lookup("selector"); selector != "" {
+		parts = append(parts, "("+selector+")")
+	}
+	if event.Type == "input" {
+		if redacted, _ := data["redacted"].(bool); redacted {
+			parts = append(parts, "= [redacted]")
+		} else if value := lookup("value"); value != "" {
+			parts = append(parts, fmt.Sprintf("= %q", shorten(value, 60)))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// shorten cuts text to at most limit runes, marking the cut with an ellipsis.
+func shorten(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+// truncateTokens cuts text to about tokens tokens at a line or word boundary.
+func truncateTokens(text string, tokens int) string {
+	if EstimateTokens(text) <= tokens {
+		return text
+	}
+	const marker = "\n[…truncated]"
+	limit := tokens*4 - len(marker)
+	if limit <= 0 {
+		return ""
+	}
+	cut := string([]rune(text)[:limit])
+	if index := strings.LastIndexAny(cut, "\n "); index > limit/2 {
+		cut = cut[:index]
+	}
+	return cut + marker
+}
+
+func block(p *page, text string) string {
+	if text == "" {
+		return p.header()
+	}
+	return p.header() + "Text:\n" + text + "\n"
+}
+
+// fitRecent walks from the newest page back, including pages whole while they
+// fit, then the text of one page truncated, then stops.
+func fitRecent(pages []*page, budget int) ([]string, int, bool) {
+	var reversed []string
+	truncated := false
+	included := 0
+	for i := len(pages) - 1; i >= 0; i-- {
+		full := block(pages[i], pages[i].text())
+		if cost := EstimateTokens(full) + 1; cost <= budget {
+			reversed = append(reversed, full)
+			budget -= cost
+			included++
+			continue
+		}
+		header := block(pages[i], "")
+		if headerCost := EstimateTokens(header) + 1; headerCost <= budget {
+			text := truncateTokens(pages[i].text(), budget-headerCost-2)
+			reversed = append(reversed, block(pages[i], text))
+			included++
+		}
+		truncated = true
+		break
+	}
+
+	blocks := make([]string, len(reversed))
+	for i, b := range reversed {
+		blocks[len(reversed)-1-i] = b
+	}
+	return blocks, len(pages) - included, truncated
+}
+
+// fitEven keeps as many page headers as fit, newest first, then shares the
+// rest of the budget between their texts: short texts are kept whole and
+// what they leave over goes to the longer ones.
+func fitEven(pages []*page, budget int) ([]string, int, bool) {
+	first := len(pages)
+	for first > 0 {
+		cost := EstimateTokens(block(pages[first-1], "")) + 1
+		if cost > budget {
+			break
+		}
+		budget -= cost
+		first--
+	}
+	kept := pages[first:]
+
+	// Water-fill: visit texts from shortest to longest.
+	order := make([]int, len(kept))
+	for i := range order {
+		order[i] = i
+	}
+	costs := make([]int, len(kept))
+	for i, p := range kept {
+		if text := p.text(); text != "" {
+			costs[i] = EstimateTokens(text) + 2 // "Text:" line
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return costs[order[i]] < costs[order[j]] })
+
+	texts := make([]string, len(kept))
+	truncated := first > 0
+	for position, index := range order {
+		if costs[index] == 0 {
+			continue
+		}
+		share := budget / (len(order) - position)
+		if costs[index] <= share {
+			texts[index] = kept[index].text()
+			budget -= costs[index]
+			continue
+		}
+		texts[index] = truncateTokens(kept[index].text(), share-2)
+		budget -= share
+		truncated = true
+	}
+
+	blocks := make([]string, len(kept))
+	for i, p := range kept {
+		blocks[i] = block(p, texts[i])
+	}
+	return blocks, first, truncated
+}
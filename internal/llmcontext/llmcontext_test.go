@@ -0,0 +1,211 @@
+package llmcontext
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/privacy"
+	"github.com/vincentbai/browsetrace-agent/internal/sessions"
+)
+
+// 2021-01-01T00:00:00Z
+const base = int64(1609459200000)
+
+func stored(id int64, url, eventType string, data map[string]any) models.StoredEvent {
+	if data == nil {
+		data = map[string]any{}
+	}
+	ts := base + id*1000
+	return models.StoredEvent{ID: id, Event: models.Event{TSUTC: ts, TSISO: models.FormatTimestamp(ts), URL: url, Type: eventType, Data: data}}
+}
+
+func sampleEvents() []models.StoredEvent {
+	title := "Checkout"
+	checkout := stored(4, "https://shop.example/checkout", "navigate", nil)
+	checkout.Title = &title
+	return []models.StoredEvent{
+		stored(1, "https://shop.example/cart", "navigate", nil),
+		stored(2, "https://shop.example/cart", "visible_text", map[string]any{"text": "Your cart\n2 items"}),
+		stored(3, "https://shop.example/cart", "visible_text", map[string]any{"text": "2 items\nSubtotal: $40"}),
+		checkout,
+		stored(5, "https://shop.example/checkout", "click", map[string]any{"target": map[string]any{"selector": "#pay", "text": "Pay now"}}),
+		stored(6, "https://shop.example/checkout", "input", map[string]any{"field": "email", "selector": "input[name=email]", "value": "me@example.com"}),
+		stored(7, "https://shop.example/checkout", "input", map[string]any{"field": "card", "redacted": true}),
+		stored(8, "https://bank.example/3ds", "navigate", nil),
+		stored(9, "https://bank.example/3ds", "visible_text", map[string]any{"text": "Approve payment"}),
+	}
+}
+
+func TestBuildCompilesPages(t *testing.T) {
+	result := Build(sampleEvents(), Options{}, privacy.New([]string{"bank.example"}))
+
+	if result.Pages != 2 || result.OmittedPages != 0 || result.Truncated {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	for _, want := range []string{
+		"# Browsing context, 2021-01-01 00:00:01 to 00:00:07 UTC",
+		"## https://shop.example/cart\nURL: https://shop.example/cart\nTime: 00:00:01 to 00:00:03\n",
+		"Text:\nYour cart\n2 items\nSubtotal: $40\n",
+		"## Checkout\n",
+		`- 00:00:05 click "Pay now" (#pay)`,
+		`- 00:00:06 input field email (input[name=email]) = "me@example.com"`,
+		"- 00:00:07 input field card = [redacted]",
+	} {
+		if !strings.Contains(result.Text, want) {
+			t.Errorf("Expected %q in context:\n%s", want, result.Text)
+		}
+	}
+	if strings.Contains(result.Text, "bank.example") || strings.Contains(result.Text, "Approve") {
+		t.Errorf("Blocklisted page leaked into context:\n%s", result.Text)
+	}
+	if result.EstimatedTokens != EstimateTokens(result.Text) {
+		t.Errorf("Expected estimate %d, got %d", EstimateTokens(result.Text), result.EstimatedTokens)
+	}
+}
+
+func TestBuildDeduplicatesAcrossVisits(t *testing.T) {
+	events := []models.StoredEvent{
+		stored(1, "https://a.example/", "navigate", nil),
+		stored(2, "https://a.example/", "visible_text", map[string]any{"text": "Header   text"}),
+		stored(3, "https://b.example/", "navigate", nil),
+		stored(4, "https://a.example/", "navigate", nil),
+		stored(5, "https://a.example/", "visible_text", map[string]any{"text": "Header text\nNew line"}),
+	}
+	result := Build(events, Options{}, nil)
+	if strings.Count(result.Text, "Header text") != 1 || !strings.Contains(result.Text, "New line") {
+		t.Errorf("Expected repeated text once:\n%s", result.Text)
+	}
+	if result.Pages != 3 {
+		t.Errorf("Expected 3 visits, got %d", result.Pages)
+	}
+}
+
+// longPages returns count visits, each with about 250 tokens of text.
+func longPages(count int) []models.StoredEvent {
+	var events []models.StoredEvent
+	for i := 0; i < count; i++ {
+		url := "https://example.com/" + string(rune('a'+i))
+		id := int64(i * 2)
+		events = append(events,
+			stored(id+1, url, "navigate", nil),
+			stored(id+2, url, "visible_text", map[string]any{"text": strings.Repeat(string(rune('a'+i))+"word ", 200)}),
+		)
+	}
+	return events
+}
+
+func TestBuildRecentStrategy(t *testing.T) {
+	result := Build(longPages(6), Options{Budget: 700, Strategy: StrategyRecent}, nil)
+
+	if result.EstimatedTokens > 700 {
+		t.Errorf("Expected at most 700 tokens, got %d", result.EstimatedTokens)
+	}
+	if !result.Truncated || result.OmittedPages == 0 {
+		t.Fatalf("Expected older pages dropped, got %+v", result)
+	}
+	if !strings.Contains(result.Text, "fword fword") || !strings.Contains(result.Text, "eword eword") {
+		t.Errorf("Expected the newest pages whole:\n%s", result.Text)
+	}
+	if strings.Contains(result.Text, "aword") {
+		t.Errorf("Expected the oldest page dropped:\n%s", result.Text)
+	}
+	if !strings.Contains(result.Text, "earlier pages omitted") || !strings.Contains(result.Text, "[…truncated]") {
+		t.Errorf("Expected omission and truncation markers:\n%s", result.Text)
+	}
+}
+
+func TestBuildEvenStrategy(t *testing.T) {
+	result := Build(longPages(6), Options{Budget: 700, Strategy: StrategyEven}, nil)
+
+	if result.EstimatedTokens > 700 {
+		t.Errorf("Expected at most 700 tokens, got %d", result.EstimatedTokens)
+	}
+	if result.Pages != 6 || result.OmittedPages != 0 || !result.Truncated {
+		t.Fatalf("Expected every page kept and truncated, got %+v", result)
+	}
+	for _, word := range []string{"aword", "cword", "fword"} {
+		if count := strings.Count(result.Text, word); count < 10 {
+			t.Errorf("Expected a share of text for %s, got %d occurrences", word, count)
+		}
+	}
+}
+
+func TestBuildEvenGivesLeftoverToLongPages(t *testing.T) {
+	events := append(longPages(2), stored(100, "https://short.example/", "visible_text", map[string]any{"text": "tiny"}))
+	result := Build(events, Options{Budget: 400, Strategy: StrategyEven}, nil)
+
+	if !strings.Contains(result.Text, "tiny") {
+		t.Errorf("Expected short text kept whole:\n%s", result.Text)
+	}
+	if result.EstimatedTokens > 400 || result.EstimatedTokens < 300 {
+		t.Errorf("Expected the budget mostly used, got %d tokens", result.EstimatedTokens)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	if strategy, err := ParseStrategy(""); err != nil || strategy != StrategyRecent {
+		t.Errorf("Expected recent by default, got %q, %v", strategy, err)
+	}
+	if _, err := ParseStrategy("random"); err == nil {
+		t.Error("Expected error for unknown strategy")
+	}
+}
+
+func TestCompilerWindowAndSession(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "browsetrace-llmcontext-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db, err := database.NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer func() { db.Close() }()
+	sessionizer, err := sessions.New(db, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create sessionizer: %v", err)
+	}
+
+	var events []models.Event
+	for _, event := range sampleEvents() {
+		events = append(events, event.Event)
+	}
+	// A scroll is captured but not useful context.
+	events = append(events, stored(10, "https://shop.example/checkout", "scroll", nil).Event)
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	compiler := NewCompiler(db, sessionizer, nil)
+	window, err := compiler.Window(context.Background(), base+4000, 0, Options{})
+	if err != nil {
+		t.Fatalf("Window() error = %v", err)
+	}
+	if strings.Contains(window.Text, "/cart") || !strings.Contains(window.Text, "Approve payment") {
+		t.Errorf("Unexpected window context:\n%s", window.Text)
+	}
+
+	list, err := sessionizer.List(context.Background(), sessions.ListOptions{})
+	if err != nil || len(list) != 1 {
+		t.Fatalf("Expected one session, got %v, %v", list, err)
+	}
+	session, err := compiler.Session(context.Background(), list[0].ID, Options{})
+	if err != nil {
+		t.Fatalf("Session() error = %v", err)
+	}
+	if session.Pages != 3 || strings.Contains(session.Text, "scroll") {
+		t.Errorf("Unexpected session context: %+v", session)
+	}
+	if _, err := compiler.Session(context.Background(), 99, Options{}); !errors.Is(err, sessions.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
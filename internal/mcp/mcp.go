@@ -0,0 +1,178 @@
+// Package mcp exposes the browsing trace to LLM assistants as Model Context
+// Protocol tools, over stdio or streamable HTTP.
+//
+// Only the tools part of the protocol is implemented. Every tool hides events
+// from sites on the privacy blocklist.
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/privacy"
+	"github.com/vincentbai/browsetrace-agent/internal/report"
+	"github.com/vincentbai/browsetrace-agent/internal/sessions"
+)
+
+const (
+	serverName    = "browsetrace-agent"
+	serverVersion = "dev"
+)
+
+// supportedVersions lists the protocol revisions we speak, newest first.
+var supportedVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// JSON-RPC 2.0 error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// isNotification reports whether the message expects no response. Responses
+// from the client (to requests we never send) are treated the same way.
+func (r request) isNotification() bool {
+	return len(r.ID) == 0 || r.Method == ""
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type Server struct {
+	db        *database.Database
+	sessions  *sessions.Sessionizer
+	reports   *report.Generator
+	blocklist *privacy.Blocklist
+	tools     []tool
+}
+
+// New returns an MCP server over db. The session and report tools are only
+// offered when sessionizer and reports are non-nil; blocklist may be nil.
+func New(db *database.Database, sessionizer *sessions.Sessionizer, reports *report.Generator, blocklist *privacy.Blocklist) *Server {
+	s := &Server{db: db, sessions: sessionizer, reports: reports, blocklist: blocklist}
+	s.tools = s.availableTools()
+	return s
+}
+
+// Handle processes one JSON-RPC message and returns the encoded response, or
+// nil when the message is a notification.
+func (s *Server) Handle(ctx context.Context, message []byte) []byte {
+	var req request
+	if err := json.Unmarshal(message, &req); err != nil {
+		return encode(response{ID: json.RawMessage("null"), Error: &rpcError{Code: codeParseError, Message: "Parse error"}})
+	}
+	if req.isNotification() {
+		return nil
+	}
+	if req.JSONRPC != "2.0" {
+		return encode(response{ID: req.ID, Error: &rpcError{Code: codeInvalidRequest, Message: "jsonrpc must be 2.0"}})
+	}
+
+	result, rpcErr := s.dispatch(ctx, req)
+	return encode(response{ID: req.ID, Result: result, Error: rpcErr})
+}
+
+func (s *Server) dispatch(ctx context.Context, req request) (any, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+			}
+		}
+		return map[string]any{
+			"protocolVersion": negotiateVersion(params.ProtocolVersion),
+			"capabilities":    map[string]any{"tools": map[string]any{"listChanged": false}},
+			"serverInfo":      map[string]any{"name": serverName, "version": serverVersion},
+			"instructions": "Tools for looking up the user's own web browsing history recorded by BrowserTrace. " +
+				"Times are UTC; pass dates as YYYY-MM-DD or RFC 3339.",
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "tools/list":
+		definitions := make([]toolDefinition, 0, len(s.tools))
+		for _, tool := range s.tools {
+			definitions = append(definitions, tool.toolDefinition)
+		}
+		return map[string]any{"tools": definitions}, nil
+	case "tools/call":
+		return s.callTool(ctx, req.Params)
+	}
+	return nil, &rpcError{Code: codeMethodNotFound, Message: "Method not found: " + req.Method}
+}
+
+func negotiateVersion(requested string) string {
+	for _, version := range supportedVersions {
+		if version == requested {
+			return version
+		}
+	}
+	return supportedVersions[0]
+}
+
+func (s *Server) callTool(ctx context.Context, raw json.RawMessage) (any, *rpcError) {
+	var params struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	for _, tool := range s.tools {
+		if tool.Name != params.Name {
+			continue
+		}
+		arguments := params.Arguments
+		if len(arguments) == 0 || string(arguments) == "null" {
+			arguments = json.RawMessage("{}")
+		}
+		// Tool failures are reported to the model, not as protocol errors, so
+		// that it can correct its arguments and retry.
+		text, err := tool.call(ctx, arguments)
+		if err != nil {
+			return toolResult(err.Error(), true), nil
+		}
+		return toolResult(text, false), nil
+	}
+	return nil, &rpcError{Code: codeInvalidParams, Message: "Unknown tool: " + params.Name}
+}
+
+func toolResult(text string, isError bool) map[string]any {
+	return map[string]any{
+		"content": []map[string]any{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
+
+func encode(resp response) []byte {
+	resp.JSONRPC = "2.0"
+	encoded, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("Failed to encode MCP response: %v", err)
+		encoded, _ = json.Marshal(response{JSONRPC: "2.0", ID: resp.ID, Error: &rpcError{Code: codeInternalError, Message: "Internal error"}})
+	}
+	return encoded
+}
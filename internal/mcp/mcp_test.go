@@ -0,0 +1,194 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/analytics"
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/privacy"
+	"github.com/vincentbai/browsetrace-agent/internal/report"
+	"github.com/vincentbai/browsetrace-agent/internal/sessions"
+)
+
+// 2021-01-01T00:00:00Z
+const base = int64(1609459200000)
+
+// Events without a time zone fall on the agent's days; pin them to UTC so
+// that the tests do not depend on where they run.
+func TestMain(m *testing.M) {
+	models.DefaultLocation = time.UTC
+	os.Exit(m.Run())
+}
+
+func setupTestServer(t *testing.T) (*Server, func()) {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "browsetrace-mcp-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	db, err := database.NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+
+	sessionizer, err := sessions.New(db, 30*time.Minute)
+	if err != nil {
+		cleanup()
+		t.Fatalf("Failed to create sessionizer: %v", err)
+	}
+	tracker, err := analytics.New(db, analytics.DefaultIdleCutoff)
+	if err != nil {
+		cleanup()
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+
+	docs, bank := "Go Documentation", "My Bank"
+	events := []models.Event{
+		{TSUTC: base, URL: "https://www.google.com/search?q=golang+context", Type: "navigate"},
+		{TSUTC: base + 60000, URL: "https://go.dev/pkg/context/", Title: &docs, Type: "navigate"},
+		{TSUTC: base + 70000, URL: "https://go.dev/pkg/context/", Type: "visible_text", Data: map[string]any{"text": "Package context defines the Context type, which carries deadlines."}},
+		{TSUTC: base + 80000, URL: "https://go.dev/pkg/context/", Type: "visible_text", Data: map[string]any{"text": "Package context defines the Context type, which carries deadlines."}},
+		{TSUTC: base + 90000, URL: "https://go.dev/pkg/context/", Type: "visible_text", Data: map[string]any{"text": "WithTimeout returns WithDeadline(parent, now+timeout)."}},
+		{TSUTC: base + 120000, URL: "https://www.bank.example/context", Title: &bank, Type: "navigate"},
+		{TSUTC: base + 130000, URL: "https://www.bank.example/context", Type: "visible_text", Data: map[string]any{"text": "Balance: context of your account"}},
+	}
+	for i := range events {
+		events[i].TSISO = models.FormatTimestamp(events[i].TSUTC)
+		if events[i].Data == nil {
+			events[i].Data = map[string]any{}
+		}
+	}
+	if err := db.InsertEvents(events); err != nil {
+		cleanup()
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	server := New(db, sessionizer, report.New(db, tracker), privacy.New([]string{"bank.example"}))
+	return server, cleanup
+}
+
+// call sends a request and decodes the JSON-RPC response.
+func call(t *testing.T, server *Server, message string) (result json.RawMessage, rpcErr *rpcError) {
+	t.Helper()
+
+	reply := server.Handle(context.Background(), []byte(message))
+	if reply == nil {
+		t.Fatalf("Expected a response to %s", message)
+	}
+	var resp struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  json.RawMessage `json:"result"`
+		Error   *rpcError       `json:"error"`
+	}
+	if err := json.Unmarshal(reply, &resp); err != nil {
+		t.Fatalf("Invalid response %s: %v", reply, err)
+	}
+	if resp.JSONRPC != "2.0" {
+		t.Errorf("Expected jsonrpc 2.0, got %q", resp.JSONRPC)
+	}
+	return resp.Result, resp.Error
+}
+
+func TestInitializeNegotiatesVersion(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	tests := map[string]string{
+		"2025-03-26": "2025-03-26",
+		"2099-01-01": supportedVersions[0],
+	}
+	for requested, want := range tests {
+		result, rpcErr := call(t, server, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"`+requested+`","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`)
+		if rpcErr != nil {
+			t.Fatalf("initialize error: %+v", rpcErr)
+		}
+		var body struct {
+			ProtocolVersion string `json:"protocolVersion"`
+			Capabilities    struct {
+				Tools map[string]any `json:"tools"`
+			} `json:"capabilities"`
+			ServerInfo struct {
+				Name string `json:"name"`
+			} `json:"serverInfo"`
+		}
+		if err := json.Unmarshal(result, &body); err != nil {
+			t.Fatalf("Invalid initialize result: %v", err)
+		}
+		if body.ProtocolVersion != want || body.Capabilities.Tools == nil || body.ServerInfo.Name != serverName {
+			t.Errorf("Requested %s: unexpected result %s", requested, result)
+		}
+	}
+}
+
+func TestHandleProtocolErrors(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	if reply := server.Handle(context.Background(), []byte(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)); reply != nil {
+		t.Errorf("Expected no response to a notification, got %s", reply)
+	}
+
+	tests := map[string]int{
+		`{not json`: codeParseError,
+		`{"jsonrpc":"1.0","id":1,"method":"ping"}`:                              codeInvalidRequest,
+		`{"jsonrpc":"2.0","id":1,"method":"resources/list"}`:                    codeMethodNotFound,
+		`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"rm"}}`: codeInvalidParams,
+	}
+	for message, want := range tests {
+		_, rpcErr := call(t, server, message)
+		if rpcErr == nil || rpcErr.Code != want {
+			t.Errorf("%s: expected error %d, got %+v", message, want, rpcErr)
+		}
+	}
+
+	if result, rpcErr := call(t, server, `{"jsonrpc":"2.0","id":"abc","method":"ping"}`); rpcErr != nil || string(result) != "{}" {
+		t.Errorf("Unexpected ping response: %s, %+v", result, rpcErr)
+	}
+}
+
+func TestToolsListDependsOnComponents(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	names := func(s *Server) []string {
+		result, rpcErr := call(t, s, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+		if rpcErr != nil {
+			t.Fatalf("tools/list error: %+v", rpcErr)
+		}
+		var body struct {
+			Tools []toolDefinition `json:"tools"`
+		}
+		if err := json.Unmarshal(result, &body); err != nil {
+			t.Fatalf("Invalid tools/list result: %v", err)
+		}
+		var list []string
+		for _, tool := range body.Tools {
+			if tool.InputSchema["type"] != "object" {
+				t.Errorf("Tool %s: expected an object input schema", tool.Name)
+			}
+			list = append(list, tool.Name)
+		}
+		return list
+	}
+
+	if got := names(server); len(got) != 4 {
+		t.Errorf("Expected 4 tools, got %v", got)
+	}
+	if got := names(New(server.db, nil, nil, nil)); len(got) != 2 || got[0] != "search_history" || got[1] != "get_page_text" {
+		t.Errorf("Expected only the event tools without sessions and reports, got %v", got)
+	}
+}
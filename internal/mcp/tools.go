@@ -0,0 +1,411 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/report"
+	"github.com/vincentbai/browsetrace-agent/internal/sessions"
+)
+
+const (
+	defaultSearchLimit   = 20
+	maxSearchLimit       = 100
+	defaultSessionLimit  = 10
+	maxSessionLimit      = 50
+	maxPagesPerSession   = 20
+	defaultPageTextChars = 20000
+	snippetRadius        = 120
+)
+
+type toolDefinition struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+type tool struct {
+	toolDefinition
+	call func(ctx context.Context, arguments json.RawMessage) (string, error)
+}
+
+// errEnough stops a stream once a tool has collected what it needs.
+var errEnough = errors.New("enough results")
+
+func objectSchema(properties map[string]any, required ...string) map[string]any {
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func stringProperty(description string) map[string]any {
+	return map[string]any{"type": "string", "description": description}
+}
+
+func integerProperty(description string) map[string]any {
+	return map[string]any{"type": "integer", "description": description, "minimum": 1}
+}
+
+func (s *Server) availableTools() []tool {
+	tools := []tool{
+		{
+			toolDefinition: toolDefinition{
+				Name:        "search_history",
+				Description: "Search visited pages by URL, title or on-screen text. Returns the most recent matching pages first.",
+				InputSchema: objectSchema(map[string]any{
+					"query": stringProperty("Text to look for (case-insensitive substring)"),
+					"since": stringProperty("Only pages seen at or after this time (YYYY-MM-DD or RFC 3339)"),
+					"until": stringProperty("Only pages seen before this time (YYYY-MM-DD or RFC 3339)"),
+					"limit": integerProperty(fmt.Sprintf("Maximum number of pages (default %d, at most %d)", defaultSearchLimit, maxSearchLimit)),
+				}, "query"),
+			},
+			call: s.searchHistory,
+		},
+		{
+			toolDefinition: toolDefinition{
+				Name:        "get_page_text",
+				Description: "Return the text the user had on screen for a visited URL, in the order it was captured.",
+				InputSchema: objectSchema(map[string]any{
+					"url":       stringProperty("Exact page URL, as returned by search_history"),
+					"max_chars": integerProperty(fmt.Sprintf("Truncate the text to this many characters (default %d)", defaultPageTextChars)),
+				}, "url"),
+			},
+			call: s.getPageText,
+		},
+	}
+	if s.sessions != nil {
+		tools = append(tools, tool{
+			toolDefinition: toolDefinition{
+				Name:        "list_recent_sessions",
+				Description: "List recent browsing sessions, newest first, with the pages navigated to in each.",
+				InputSchema: objectSchema(map[string]any{
+					"since": stringProperty("Only sessions ending at or after this time (YYYY-MM-DD or RFC 3339)"),
+					"limit": integerProperty(fmt.Sprintf("Maximum number of sessions (default %d, at most %d)", defaultSessionLimit, maxSessionLimit)),
+				}),
+			},
+			call: s.listRecentSessions,
+		})
+	}
+	if s.reports != nil {
+		tools = append(tools, tool{
+			toolDefinition: toolDefinition{
+				Name:        "summarize_day",
+				Description: "Summarize one day of browsing, in the agent's time zone, as Markdown: time per domain, most-read pages, searches and new sites.",
+				InputSchema: objectSchema(map[string]any{
+					"date": stringProperty("Day to summarize (YYYY-MM-DD); defaults to today"),
+				}),
+			},
+			call: s.summarizeDay,
+		})
+	}
+	return tools
+}
+
+func decodeArguments(arguments json.RawMessage, target any) error {
+	if err := json.Unmarshal(arguments, target); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+func parseOptionalTime(name, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	ms, err := models.ParseTimestamp(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return ms, nil
+}
+
+func clampLimit(limit, fallback, maximum int) int {
+	if limit <= 0 {
+		return fallback
+	}
+	return min(limit, maximum)
+}
+
+func jsonText(value any) (string, error) {
+	encoded, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode result: %w", err)
+	}
+	return string(encoded), nil
+}
+
+type searchHit struct {
+	URL      string `json:"url"`
+	Title    string `json:"title,omitempty"`
+	LastSeen string `json:"last_seen"`
+	Snippet  string `json:"snippet,omitempty"`
+}
+
+func (s *Server) searchHistory(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Query string `json:"query"`
+		Since string `json:"since"`
+		Until string `json:"until"`
+		Limit int    `json:"limit"`
+	}
+	if err := decodeArguments(arguments, &args); err != nil {
+		return "", err
+	}
+	args.Query = strings.TrimSpace(args.Query)
+	if args.Query == "" {
+		return "", errors.New("query is required")
+	}
+	filter := database.EventFilter{Types: []string{"navigate", "visible_text"}, Text: args.Query, Newest: true}
+	var err error
+	if filter.Since, err = parseOptionalTime("since", args.Since); err != nil {
+		return "", err
+	}
+	if filter.Until, err = parseOptionalTime("until", args.Until); err != nil {
+		return "", err
+	}
+	limit := clampLimit(args.Limit, defaultSearchLimit, maxSearchLimit)
+
+	// One hit per page: the newest match, with a title and snippet filled in
+	// from older matches when the newest lacks them.
+	hits := []*searchHit{}
+	byURL := make(map[string]*searchHit)
+	err = s.db.StreamEvents(ctx, filter, func(event models.StoredEvent) error {
+		if s.blocklist.Blocks(event.URL) {
+			return nil
+		}
+		hit, seen := byURL[event.URL]
+		if !seen {
+			if len(hits) == limit {
+				return errEnough
+			}
+			hit = &searchHit{URL: event.URL, LastSeen: event.TSISO}
+			byURL[event.URL] = hit
+			hits = append(hits, hit)
+		}
+		if hit.Title == "" && event.Title != nil {
+			hit.Title = *event.Title
+		}
+		if text, ok := event.Data["text"].(string); ok && hit.Snippet == "" {
+			hit.Snippet = snippet(text, args.Query)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errEnough) {
+		return "", err
+	}
+	return jsonText(map[string]any{"results": hits})
+}
+
+// snippet returns the text around the first case-insensitive match of query.
+func snippet(text, query string) string {
+	index := strings.Index(strings.ToLower(text), strings.ToLower(query))
+	if index < 0 {
+		return ""
+	}
+	start, end := max(index-snippetRadius, 0), min(index+len(query)+snippetRadius, len(text))
+	// Widen to rune boundaries so multi-byte characters are not cut.
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	result := strings.Join(strings.Fields(text[start:end]), " ")
+	if start > 0 {
+		result = "…" + result
+	}
+	if end < len(text) {
+		result += "…"
+	}
+	return result
+}
+
+func (s *Server) getPageText(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		URL      string `json:"url"`
+		MaxChars int    `json:"max_chars"`
+	}
+	if err := decodeArguments(arguments, &args); err != nil {
+		return "", err
+	}
+	if args.URL == "" {
+		return "", errors.New("url is required")
+	}
+	if s.blocklist.Blocks(args.URL) {
+		return "", errors.New("this site is on the privacy blocklist")
+	}
+	maxChars := args.MaxChars
+	if maxChars <= 0 {
+		maxChars = defaultPageTextChars
+	}
+
+	// The extension resends text as the user scrolls back and forth; keep
+	// each distinct chunk once.
+	var builder strings.Builder
+	seen := make(map[string]bool)
+	chars := 0
+	truncated := false
+	filter := database.EventFilter{Types: []string{"visible_text"}, URL: args.URL}
+	err := s.db.StreamEvents(ctx, filter, func(event models.StoredEvent) error {
+		text, _ := event.Data["text"].(string)
+		text = strings.TrimSpace(text)
+		if text == "" || seen[text] {
+			return nil
+		}
+		seen[text] = true
+		if builder.Len() > 0 {
+			builder.WriteString("\n\n")
+		}
+		for _, r := range text {
+			if chars == maxChars {
+				truncated = true
+				return errEnough
+			}
+			builder.WriteRune(r)
+			chars++
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errEnough) {
+		return "", err
+	}
+	if builder.Len() == 0 {
+		return "", fmt.Errorf("no page text captured for %s", args.URL)
+	}
+	if truncated {
+		builder.WriteString("\n\n[truncated]")
+	}
+	return builder.String(), nil
+}
+
+type sessionPage struct {
+	URL   string  `json:"url"`
+	Title *string `json:"title,omitempty"`
+}
+
+type sessionSummary struct {
+	sessions.Session
+	Pages []sessionPage `json:"pages"`
+	// HiddenPages counts navigations to blocklisted sites.
+	HiddenPages int `json:"hidden_pages,omitempty"`
+}
+
+func (s *Server) listRecentSessions(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Since string `json:"since"`
+		Limit int    `json:"limit"`
+	}
+	if err := decodeArguments(arguments, &args); err != nil {
+		return "", err
+	}
+	since, err := parseOptionalTime("since", args.Since)
+	if err != nil {
+		return "", err
+	}
+	list, err := s.sessions.List(ctx, sessions.ListOptions{Since: since, Limit: clampLimit(args.Limit, defaultSessionLimit, maxSessionLimit)})
+	if err != nil {
+		return "", err
+	}
+
+	summaries := make([]sessionSummary, 0, len(list))
+	for _, session := range list {
+		detail, err := s.sessions.Get(ctx, session.ID)
+		if err != nil {
+			return "", err
+		}
+		summary := sessionSummary{Session: session, Pages: []sessionPage{}}
+		seen := make(map[string]bool)
+		for _, chain := range detail.Tabs {
+			for _, navigation := range chain.Navigations {
+				if s.blocklist.Blocks(navigation.URL) {
+					summary.HiddenPages++
+					continue
+				}
+				if seen[navigation.URL] || len(summary.Pages) == maxPagesPerSession {
+					continue
+				}
+				seen[navigation.URL] = true
+				summary.Pages = append(summary.Pages, sessionPage{URL: navigation.URL, Title: navigation.Title})
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	return jsonText(map[string]any{"sessions": summaries})
+}
+
+func (s *Server) summarizeDay(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Date string `json:"date"`
+	}
+	if err := decodeArguments(arguments, &args); err != nil {
+		return "", err
+	}
+	opts := report.Options{Period: report.PeriodDay, Location: models.DefaultLocation}
+	if day, err := time.ParseInLocation(time.DateOnly, args.Date, opts.Location); err == nil {
+		// A plain date names that day where the agent runs, not in UTC.
+		opts.Date = day
+	} else if args.Date != "" {
+		ms, err := parseOptionalTime("date", args.Date)
+		if err != nil {
+			return "", err
+		}
+		opts.Date = time.UnixMilli(ms)
+	}
+	digest, err := s.reports.Generate(ctx, opts)
+	if err != nil {
+		return "", err
+	}
+	s.redactReport(digest)
+
+	var buffer bytes.Buffer
+	if err := report.Render(&buffer, digest, report.FormatMarkdown); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// redactReport removes blocklisted sites from every section of a digest.
+// Totals still include their time, but not which sites it was spent on.
+func (s *Server) redactReport(digest *report.Report) {
+	domains := digest.TopDomains[:0]
+	for _, item := range digest.TopDomains {
+		if !s.blocklist.BlocksHost(item.Key) {
+			domains = append(domains, item)
+		}
+	}
+	digest.TopDomains = domains
+
+	pages := digest.MostRead[:0]
+	for _, page := range digest.MostRead {
+		if !s.blocklist.Blocks(page.URL) {
+			pages = append(pages, page)
+		}
+	}
+	digest.MostRead = pages
+
+	searches := digest.Searches[:0]
+	for _, search := range digest.Searches {
+		if !s.blocklist.Blocks(search.URL) {
+			searches = append(searches, search)
+		}
+	}
+	digest.Searches = searches
+
+	sites := digest.NewSites[:0]
+	for _, site := range digest.NewSites {
+		if !s.blocklist.Blocks(site.FirstURL) {
+			sites = append(sites, site)
+		}
+	}
+	digest.NewSites = sites
+}
@@ -0,0 +1,157 @@
+package mcp
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// callTool invokes a tool and returns its text content and error flag.
+func callTool(t *testing.T, server *Server, name string, arguments string) (string, bool) {
+	t.Helper()
+
+	result, rpcErr := call(t, server, `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"`+name+`","arguments":`+arguments+`}}`)
+	if rpcErr != nil {
+		t.Fatalf("%s: unexpected protocol error %+v", name, rpcErr)
+	}
+	var body struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		IsError bool `json:"isError"`
+	}
+	if err := json.Unmarshal(result, &body); err != nil || len(body.Content) != 1 || body.Content[0].Type != "text" {
+		t.Fatalf("%s: unexpected result %s", name, result)
+	}
+	return body.Content[0].Text, body.IsError
+}
+
+func TestSearchHistory(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	text, isError := callTool(t, server, "search_history", `{"query":"CONTEXT"}`)
+	if isError {
+		t.Fatalf("search_history failed: %s", text)
+	}
+	var body struct {
+		Results []searchHit `json:"results"`
+	}
+	if err := json.Unmarshal([]byte(text), &body); err != nil {
+		t.Fatalf("Invalid search results: %v", err)
+	}
+	// Newest first, one hit per page, bank.example hidden.
+	if len(body.Results) != 2 {
+		t.Fatalf("Expected 2 results, got %+v", body.Results)
+	}
+	docs := body.Results[0]
+	if docs.URL != "https://go.dev/pkg/context/" || docs.Title != "Go Documentation" || docs.LastSeen != "2021-01-01T00:01:30.000Z" {
+		t.Errorf("Unexpected first result: %+v", docs)
+	}
+	if !strings.Contains(docs.Snippet, "Package context defines") {
+		t.Errorf("Expected a snippet of the page text, got %q", docs.Snippet)
+	}
+	if body.Results[1].URL != "https://www.google.com/search?q=golang+context" {
+		t.Errorf("Unexpected second result: %+v", body.Results[1])
+	}
+	if strings.Contains(text, "bank.example") {
+		t.Errorf("Blocklisted site leaked into results: %s", text)
+	}
+
+	text, _ = callTool(t, server, "search_history", `{"query":"context","limit":1}`)
+	if strings.Count(text, `"url"`) != 1 {
+		t.Errorf("Expected limit to apply, got %s", text)
+	}
+
+	if text, isError := callTool(t, server, "search_history", `{"query":"  "}`); !isError {
+		t.Errorf("Expected an error for an empty query, got %s", text)
+	}
+	if text, isError := callTool(t, server, "search_history", `{"query":"x","since":"last week"}`); !isError || !strings.Contains(text, "since") {
+		t.Errorf("Expected an invalid since error, got %s", text)
+	}
+}
+
+func TestGetPageText(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	text, isError := callTool(t, server, "get_page_text", `{"url":"https://go.dev/pkg/context/"}`)
+	if isError {
+		t.Fatalf("get_page_text failed: %s", text)
+	}
+	want := "Package context defines the Context type, which carries deadlines.\n\nWithTimeout returns WithDeadline(parent, now+timeout)."
+	if text != want {
+		t.Errorf("Expected deduplicated text %q, got %q", want, text)
+	}
+
+	text, _ = callTool(t, server, "get_page_text", `{"url":"https://go.dev/pkg/context/","max_chars":7}`)
+	if text != "Package\n\n[truncated]" {
+		t.Errorf("Expected truncated text, got %q", text)
+	}
+
+	if text, isError := callTool(t, server, "get_page_text", `{"url":"https://www.bank.example/context"}`); !isError || strings.Contains(text, "Balance") {
+		t.Errorf("Expected blocklisted page to be refused, got %q", text)
+	}
+	if _, isError := callTool(t, server, "get_page_text", `{"url":"https://never.example/"}`); !isError {
+		t.Error("Expected an error for a page without text")
+	}
+}
+
+func TestListRecentSessions(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	text, isError := callTool(t, server, "list_recent_sessions", `{}`)
+	if isError {
+		t.Fatalf("list_recent_sessions failed: %s", text)
+	}
+	var body struct {
+		Sessions []sessionSummary `json:"sessions"`
+	}
+	if err := json.Unmarshal([]byte(text), &body); err != nil {
+		t.Fatalf("Invalid sessions: %v", err)
+	}
+	if len(body.Sessions) != 1 {
+		t.Fatalf("Expected 1 session, got %+v", body.Sessions)
+	}
+	session := body.Sessions[0]
+	if len(session.Pages) != 2 || session.HiddenPages != 1 || session.EventCount != 7 {
+		t.Errorf("Unexpected session summary: %+v", session)
+	}
+	if strings.Contains(text, "bank.example") {
+		t.Errorf("Blocklisted site leaked into sessions: %s", text)
+	}
+}
+
+func TestSummarizeDay(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	text, isError := callTool(t, server, "summarize_day", `{"date":"2021-01-01"}`)
+	if isError {
+		t.Fatalf("summarize_day failed: %s", text)
+	}
+	for _, want := range []string{"# Browsing digest: 2021-01-01", "Google: golang context", "go.dev"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in summary:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "bank.example") {
+		t.Errorf("Blocklisted site leaked into summary:\n%s", text)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("a", 200) + " needle " + strings.Repeat("b", 200)
+	got := snippet(long, "NEEDLE")
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") || !strings.Contains(got, " needle ") {
+		t.Errorf("Unexpected snippet %q", got)
+	}
+	if got := snippet("short needle text", "needle"); got != "short needle text" {
+		t.Errorf("Expected whole short text, got %q", got)
+	}
+	if got := snippet("no match", "needle"); got != "" {
+		t.Errorf("Expected empty snippet, got %q", got)
+	}
+}
@@ -0,0 +1,88 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// maxMessageSize bounds a single JSON-RPC message on either transport.
+const maxMessageSize = 4 << 20
+
+// ServeStdio reads newline-delimited JSON-RPC messages from r and writes each
+// response to w on its own line, until r is exhausted or ctx is done. Logs
+// must go elsewhere (the log package writes to stderr), since w is the
+// protocol stream.
+func (s *Server) ServeStdio(ctx context.Context, r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if reply := s.Handle(ctx, line); reply != nil {
+			if _, err := w.Write(append(reply, '\n')); err != nil {
+				return fmt.Errorf("failed to write response: %w", err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read request: %w", err)
+	}
+	return nil
+}
+
+// ServeHTTP implements the streamable HTTP transport: each POST carries one
+// JSON-RPC message and gets a JSON response. The server never initiates
+// messages, so there is no event stream to GET.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if origin := req.Header.Get("Origin"); origin != "" && !isLocalOrigin(origin) {
+		http.Error(w, "Forbidden origin", http.StatusForbidden)
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxMessageSize))
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusRequestEntityTooLarge)
+		return
+	}
+	reply := s.Handle(req.Context(), body)
+	if reply == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(reply); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// isLocalOrigin reports whether an Origin header names this machine. Browsers
+// send it on cross-site requests, which lets us refuse DNS rebinding attacks
+// from web pages.
+func isLocalOrigin(origin string) bool {
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := parsed.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
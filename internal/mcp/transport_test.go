@@ -0,0 +1,90 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeStdio(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18"}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		``,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+	}, "\n")
+	var output bytes.Buffer
+	if err := server.ServeStdio(context.Background(), strings.NewReader(input), &output); err != nil {
+		t.Fatalf("ServeStdio() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 responses, got %d: %s", len(lines), output.String())
+	}
+	for i, line := range lines {
+		var resp struct {
+			ID int `json:"id"`
+		}
+		if err := json.Unmarshal([]byte(line), &resp); err != nil || resp.ID != i+1 {
+			t.Errorf("Response %d: unexpected %s", i, line)
+		}
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+	req.Header.Set("Origin", "http://localhost:3000")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected JSON response, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), `"result":{}`) {
+		t.Errorf("Unexpected body: %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","method":"notifications/initialized"}`))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted || w.Body.Len() != 0 {
+		t.Errorf("Expected 202 for a notification, got %d %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/mcp", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", w.Code)
+	}
+}
+
+func TestServeHTTPRejectsForeignOrigin(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for origin, want := range map[string]int{
+		"https://evil.example":   http.StatusForbidden,
+		"http://127.0.0.1:8123":  http.StatusOK,
+		"http://[::1]:8123":      http.StatusOK,
+		"http://localhost.evil/": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+		req.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("Origin %s: expected %d, got %d", origin, want, w.Code)
+		}
+	}
+}
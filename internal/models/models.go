@@ -1,14 +1,137 @@
 package models
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type Event struct {
-	TSUTC int64          `json:"ts_utc"`
-	TSISO string         `json:"ts_iso"`
+	TSUTC int64          `json:"ts_utc"` // Unix milliseconds
+	TSISO string         `json:"ts_iso"` // optional; the agent derives it from ts_utc
 	URL   string         `json:"url"`
 	Title *string        `json:"title"` // nullable
 	Type  string         `json:"type"`  // navigate|visible_text|click|input|scroll|focus
 	Data  map[string]any `json:"data"`  // arbitrary JSON
+
+	// Optional origin of the event; older clients omit these.
+	TabID    *int64  `json:"tab_id,omitempty"`    // browser tab ID
+	WindowID *int64  `json:"window_id,omitempty"` // browser window ID
+	FrameID  *int64  `json:"frame_id,omitempty"`  // 0 for the top-level frame
+	Profile  *string `json:"profile,omitempty"`   // browser profile name
+	ClientID *string `json:"client_id,omitempty"` // installation/device the event came from
+
+	// Optional time zone of the client, used to place the event in the user's
+	// local day. UTCOffsetMinutes is the offset in effect at ts_utc, east of
+	// UTC: 60 for CET, -300 for EST (the negation of JavaScript's
+	// getTimezoneOffset). When both are given, the offset wins.
+	Timezone         *string `json:"timezone,omitempty"`           // IANA name such as "Europe/Berlin"
+	UTCOffsetMinutes *int    `json:"utc_offset_minutes,omitempty"` // minutes east of UTC
 }
 
 type Batch struct {
 	Events []Event `json:"events"`
-}
\ No newline at end of file
+}
+
+// StoredEvent is an Event read back from the database together with its row
+// ID, the normalized form of its URL and, where the backend syncs between
+// devices, its global identity.
+type StoredEvent struct {
+	ID int64 `json:"id"`
+	Event
+	CanonicalURL string `json:"canonical_url,omitempty"`
+	UID          string `json:"uid,omitempty"`        // unique across devices
+	DeviceID     string `json:"device_id,omitempty"`  // agent that first stored the event
+	OriginSeq    int64  `json:"origin_seq,omitempty"` // change number on that agent
+	// ReceivedTSUTC is when the agent that first stored the event received
+	// it, in Unix milliseconds; 0 for events stored before this was kept.
+	ReceivedTSUTC int64 `json:"received_ts_utc,omitempty"`
+	// LocalDate is the day, YYYY-MM-DD, the event happened on in the user's
+	// time zone.
+	LocalDate string `json:"local_date,omitempty"`
+}
+
+// isoLayout matches JavaScript's Date.prototype.toISOString, which is what the
+// extension sends in ts_iso.
+const isoLayout = "2006-01-02T15:04:05.000Z"
+
+// FormatTimestamp renders Unix milliseconds the way clients fill in ts_iso.
+func FormatTimestamp(milliseconds int64) string {
+	return time.UnixMilli(milliseconds).UTC().Format(isoLayout)
+}
+
+// ISOTimestamp returns the event's ts_iso, or the one derived from ts_utc if
+// the client left it out.
+func (e Event) ISOTimestamp() string {
+	if e.TSISO == "" {
+		return FormatTimestamp(e.TSUTC)
+	}
+	return e.TSISO
+}
+
+// DefaultLocation is the time zone days are counted in when neither an event
+// nor a query names one: the agent's, since it runs on the machine the user
+// browses on. Stored local dates, reports, time stats and rules all fall back
+// to it, so that they agree on where a day starts.
+var DefaultLocation = time.Local
+
+// Location returns the time zone the event was recorded in: a fixed zone
+// for its UTC offset, its named zone, or fallback when it has neither or the
+// name is unknown.
+func (e Event) Location(fallback *time.Location) *time.Location {
+	if e.UTCOffsetMinutes != nil {
+		name := ""
+		if e.Timezone != nil {
+			name = *e.Timezone
+		}
+		return time.FixedZone(name, *e.UTCOffsetMinutes*60)
+	}
+	if e.Timezone != nil {
+		if location, err := time.LoadLocation(*e.Timezone); err == nil {
+			return location
+		}
+	}
+	return fallback
+}
+
+// LocalDate returns the day, YYYY-MM-DD, the event happened on in its time
+// zone, or in fallback's for clients that do not send one.
+func (e Event) LocalDate(fallback *time.Location) string {
+	return time.UnixMilli(e.TSUTC).In(e.Location(fallback)).Format(time.DateOnly)
+}
+
+// CheckISOTimestamp verifies that iso, an event's ts_iso, is an RFC 3339
+// timestamp naming the same instant as milliseconds, its ts_utc, to the
+// precision iso is written in: "2024-03-01T09:00:00Z" agrees with any
+// millisecond within that second. An empty ts_iso is left to the server.
+func CheckISOTimestamp(milliseconds int64, iso string) error {
+	if iso == "" {
+		return nil
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, iso)
+	if err != nil {
+		return fmt.Errorf("ts_iso %q is not an RFC 3339 timestamp", iso)
+	}
+	difference := milliseconds - parsed.UnixMilli()
+	if difference == 0 || (parsed.Nanosecond() == 0 && !strings.Contains(iso, ".") && difference > 0 && difference < 1000) {
+		return nil
+	}
+	return fmt.Errorf("ts_iso %s does not match ts_utc %d (%s)", iso, milliseconds, FormatTimestamp(milliseconds))
+}
+
+// ParseTimestamp converts a user-supplied time into Unix milliseconds. It
+// accepts raw milliseconds, RFC 3339 timestamps and plain dates, which start
+// at midnight in DefaultLocation like the days events are stored under.
+func ParseTimestamp(value string) (int64, error) {
+	if milliseconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return milliseconds, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed.UnixMilli(), nil
+	}
+	if parsed, err := time.ParseInLocation(time.DateOnly, value, DefaultLocation); err == nil {
+		return parsed.UnixMilli(), nil
+	}
+	return 0, fmt.Errorf("invalid timestamp %q: want milliseconds, RFC 3339 or YYYY-MM-DD", value)
+}
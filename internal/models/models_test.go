@@ -3,6 +3,7 @@ package models
 import (
 	"encoding/json"
 	"testing"
+	"time"
 )
 
 func TestEventJSONMarshaling(t *testing.T) {
@@ -123,3 +124,206 @@ func TestEmptyBatch(t *testing.T) {
 		t.Errorf("Expected 0 events, got %d", len(unmarshaled.Events))
 	}
 }
+
+func TestStoredEventJSONFlattensEvent(t *testing.T) {
+	stored := StoredEvent{
+		ID: 42,
+		Event: Event{
+			TSUTC: 1234567890,
+			TSISO: "2009-02-13T23:31:30Z",
+			URL:   "https://example.com",
+			Type:  "click",
+			Data:  map[string]any{},
+		},
+	}
+
+	jsonData, err := json.Marshal(stored)
+	if err != nil {
+		t.Fatalf("Failed to marshal stored event: %v", err)
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(jsonData, &fields); err != nil {
+		t.Fatalf("Failed to unmarshal stored event: %v", err)
+	}
+	if fields["id"] != float64(42) {
+		t.Errorf("Expected id 42, got %v", fields["id"])
+	}
+	if fields["url"] != "https://example.com" {
+		t.Errorf("Expected url at top level, got %v", fields["url"])
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	saved := DefaultLocation
+	DefaultLocation = time.UTC
+	defer func() { DefaultLocation = saved }()
+
+	tests := []struct {
+		input     string
+		want      int64
+		wantError bool
+	}{
+		{"1609459200000", 1609459200000, false},
+		{"2021-01-01T00:00:00Z", 1609459200000, false},
+		{"2021-01-01T01:00:00+01:00", 1609459200000, false},
+		{"2021-01-01", 1609459200000, false},
+		{"yesterday", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseTimestamp(tt.input)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("ParseTimestamp(%q) error = %v, wantError %v", tt.input, err, tt.wantError)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTimestamp(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+
+	// A plain date starts at midnight where the agent runs; other forms name
+	// their instant whatever the zone.
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+	DefaultLocation = newYork
+	for input, want := range map[string]int64{
+		"2021-01-01":           1609477200000,
+		"2021-01-01T00:00:00Z": 1609459200000,
+		"1609459200000":        1609459200000,
+	} {
+		if got, err := ParseTimestamp(input); err != nil || got != want {
+			t.Errorf("ParseTimestamp(%q) in New York = %d, %v, want %d", input, got, err, want)
+		}
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	if got := FormatTimestamp(1609459200123); got != "2021-01-01T00:00:00.123Z" {
+		t.Errorf("FormatTimestamp() = %s, want 2021-01-01T00:00:00.123Z", got)
+	}
+}
+
+func TestEventIdentityFieldsOptional(t *testing.T) {
+	// Clients that predate tab identity send none of the new fields.
+	legacy := `{"ts_utc":1,"ts_iso":"1970-01-01T00:00:00.001Z","url":"https://example.com","title":null,"type":"click","data":{}}`
+
+	var event Event
+	if err := json.Unmarshal([]byte(legacy), &event); err != nil {
+		t.Fatalf("Failed to unmarshal legacy event: %v", err)
+	}
+	if event.TabID != nil || event.WindowID != nil || event.FrameID != nil || event.Profile != nil || event.ClientID != nil {
+		t.Errorf("Expected identity fields to be nil, got %+v", event)
+	}
+
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("Failed to marshal event: %v", err)
+	}
+	if string(jsonData) != legacy {
+		t.Errorf("Expected legacy encoding %s, got %s", legacy, jsonData)
+	}
+}
+
+func TestEventIdentityFieldsRoundTrip(t *testing.T) {
+	tabID, windowID, frameID := int64(12), int64(3), int64(0)
+	profile, clientID := "Work", "laptop-1"
+	event := Event{
+		TSUTC:    1,
+		URL:      "https://example.com",
+		Type:     "click",
+		Data:     map[string]any{},
+		TabID:    &tabID,
+		WindowID: &windowID,
+		FrameID:  &frameID,
+		Profile:  &profile,
+		ClientID: &clientID,
+	}
+
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("Failed to marshal event: %v", err)
+	}
+	var unmarshaled Event
+	if err := json.Unmarshal(jsonData, &unmarshaled); err != nil {
+		t.Fatalf("Failed to unmarshal event: %v", err)
+	}
+	if *unmarshaled.TabID != 12 || *unmarshaled.WindowID != 3 || *unmarshaled.FrameID != 0 {
+		t.Errorf("Unexpected IDs: %+v", unmarshaled)
+	}
+	if *unmarshaled.Profile != "Work" || *unmarshaled.ClientID != "laptop-1" {
+		t.Errorf("Unexpected profile/client: %+v", unmarshaled)
+	}
+}
+
+func TestCheckISOTimestamp(t *testing.T) {
+	tests := []struct {
+		name    string
+		tsUTC   int64
+		tsISO   string
+		wantErr bool
+	}{
+		{"empty", 1234567890123, "", false},
+		{"milliseconds", 1234567890123, "2009-02-13T23:31:30.123Z", false},
+		{"offset", 1234567890123, "2009-02-14T00:31:30.123+01:00", false},
+		{"whole seconds", 1234567890123, "2009-02-13T23:31:30Z", false},
+		{"wrong millisecond", 1234567890124, "2009-02-13T23:31:30.123Z", true},
+		{"next second", 1234567891000, "2009-02-13T23:31:30Z", true},
+		{"seconds as milliseconds", 1234567890, "2009-02-13T23:31:30Z", true},
+		{"not a timestamp", 1000, "yesterday", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckISOTimestamp(tt.tsUTC, tt.tsISO); (err != nil) != tt.wantErr {
+				t.Errorf("CheckISOTimestamp(%d, %q) error = %v, wantErr %v", tt.tsUTC, tt.tsISO, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEventISOTimestamp(t *testing.T) {
+	event := Event{TSUTC: 1234567890123}
+	if got := event.ISOTimestamp(); got != "2009-02-13T23:31:30.123Z" {
+		t.Errorf("Expected ts_iso derived from ts_utc, got %s", got)
+	}
+	event.TSISO = "2009-02-14T00:31:30.123+01:00"
+	if got := event.ISOTimestamp(); got != event.TSISO {
+		t.Errorf("Expected the client's ts_iso kept, got %s", got)
+	}
+}
+
+func TestEventLocalDate(t *testing.T) {
+	newYork, berlin := "America/New_York", "Europe/Berlin"
+	minus5, plus14 := -300, 840
+	// 2021-01-01T03:30:00Z: still New Year's Eve in New York.
+	const ts = 1609471800000
+	tests := []struct {
+		name     string
+		timezone *string
+		offset   *int
+		want     string
+	}{
+		{"no time zone uses fallback", nil, nil, "2021-01-01"},
+		{"named zone", &newYork, nil, "2020-12-31"},
+		{"offset", nil, &minus5, "2020-12-31"},
+		{"offset wins over name", &berlin, &minus5, "2020-12-31"},
+		{"far east offset", nil, &plus14, "2021-01-01"},
+		{"unknown zone uses fallback", stringPointer("Mars/Olympus_Mons"), nil, "2021-01-01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := Event{TSUTC: ts, Timezone: tt.timezone, UTCOffsetMinutes: tt.offset}
+			if got := event.LocalDate(time.UTC); got != tt.want {
+				t.Errorf("Expected local date %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func stringPointer(value string) *string {
+	return &value
+}
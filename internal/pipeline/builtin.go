@@ -0,0 +1,284 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/privacy"
+	"github.com/vincentbai/browsetrace-agent/internal/urlnorm"
+)
+
+func init() {
+	Register("drop", func(options json.RawMessage) (Processor, error) {
+		var config DropConfig
+		if err := decodeOptions(options, &config); err != nil {
+			return nil, err
+		}
+		return NewDrop(config)
+	})
+	Register("redact", func(options json.RawMessage) (Processor, error) {
+		var config RedactConfig
+		if err := decodeOptions(options, &config); err != nil {
+			return nil, err
+		}
+		return NewRedact(config)
+	})
+	Register("normalize", func(options json.RawMessage) (Processor, error) {
+		var config NormalizeConfig
+		if err := decodeOptions(options, &config); err != nil {
+			return nil, err
+		}
+		return NewNormalize(config)
+	})
+	Register("set", func(options json.RawMessage) (Processor, error) {
+		var config SetConfig
+		if err := decodeOptions(options, &config); err != nil {
+			return nil, err
+		}
+		return NewSet(config)
+	})
+	Register("plugin", func(options json.RawMessage) (Processor, error) {
+		var config PluginConfig
+		if err := decodeOptions(options, &config); err != nil {
+			return nil, err
+		}
+		return NewPlugin(config)
+	})
+}
+
+// decodeOptions reads a stage's options strictly, so that a misspelt option
+// is an error rather than silently ignored.
+func decodeOptions(options json.RawMessage, config any) error {
+	if len(options) == 0 {
+		options = []byte("{}")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(options))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("invalid options: %w", err)
+	}
+	return nil
+}
+
+// DropConfig selects the events a drop stage discards: those matching every
+// condition it sets.
+type DropConfig struct {
+	Types []string `json:"types,omitempty"`
+	// Domains matches events on these domains and their subdomains.
+	Domains []string `json:"domains,omitempty"`
+	// URL is a pattern on the full URL in which * matches any run of
+	// characters. Case is ignored.
+	URL string `json:"url,omitempty"`
+}
+
+// NewDrop returns a stage that discards the events config selects.
+func NewDrop(config DropConfig) (Processor, error) {
+	if len(config.Types) == 0 && len(config.Domains) == 0 && config.URL == "" {
+		return nil, errors.New("drop needs types, domains or url")
+	}
+	types := make(map[string]bool, len(config.Types))
+	for _, eventType := range config.Types {
+		types[eventType] = true
+	}
+	var domains *privacy.Blocklist
+	if len(config.Domains) > 0 {
+		domains = privacy.New(config.Domains)
+	}
+	var pattern *regexp.Regexp
+	if config.URL != "" {
+		pattern = regexp.MustCompile(`(?i)^` + strings.ReplaceAll(regexp.QuoteMeta(config.URL), `\*`, `.*`) + `$`)
+	}
+	return EventFunc(func(_ context.Context, event models.Event) ([]models.Event, error) {
+		matched := (len(types) == 0 || types[event.Type]) &&
+			(domains == nil || domains.Blocks(event.URL)) &&
+			(pattern == nil || pattern.MatchString(event.URL))
+		if matched {
+			return nil, nil
+		}
+		return []models.Event{event}, nil
+	}), nil
+}
+
+// DefaultReplacement is what a redact stage writes in place of a match.
+const DefaultReplacement = "[redacted]"
+
+// RedactConfig describes a redact stage. Each pattern is a regular
+// expression; its matches in the title and in the string values of the data,
+// or only the values at the dot-separated paths in Fields, are replaced.
+type RedactConfig struct {
+	Patterns    []string `json:"patterns"`
+	Replacement *string  `json:"replacement,omitempty"`
+	Fields      []string `json:"fields,omitempty"`
+}
+
+// NewRedact returns a stage that blanks out what config's patterns match.
+func NewRedact(config RedactConfig) (Processor, error) {
+	if len(config.Patterns) == 0 {
+		return nil, errors.New("redact needs patterns")
+	}
+	patterns := make([]*regexp.Regexp, len(config.Patterns))
+	for i, pattern := range config.Patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		patterns[i] = compiled
+	}
+	replacement := DefaultReplacement
+	if config.Replacement != nil {
+		replacement = *config.Replacement
+	}
+	var fields [][]string
+	for _, field := range config.Fields {
+		fields = append(fields, strings.Split(field, "."))
+	}
+	redact := func(value string) string {
+		for _, pattern := range patterns {
+			value = pattern.ReplaceAllLiteralString(value, replacement)
+		}
+		return value
+	}
+	return EventFunc(func(_ context.Context, event models.Event) ([]models.Event, error) {
+		event.Data = cloneValue(event.Data).(map[string]any)
+		if fields == nil {
+			if event.Title != nil {
+				title := redact(*event.Title)
+				event.Title = &title
+			}
+			rewriteValues(event.Data, redact)
+		} else {
+			for _, path := range fields {
+				rewritePath(event.Data, path, redact)
+			}
+		}
+		return []models.Event{event}, nil
+	}), nil
+}
+
+// rewriteValues rewrites every string nested in value.
+func rewriteValues(value any, rewrite func(string) string) any {
+	switch typed := value.(type) {
+	case string:
+		return rewrite(typed)
+	case map[string]any:
+		for key, nested := range typed {
+			typed[key] = rewriteValues(nested, rewrite)
+		}
+	case []any:
+		for i, nested := range typed {
+			typed[i] = rewriteValues(nested, rewrite)
+		}
+	}
+	return value
+}
+
+// rewritePath rewrites the strings at path within data, if it exists.
+func rewritePath(data map[string]any, path []string, rewrite func(string) string) {
+	object := data
+	for _, key := range path[:len(path)-1] {
+		nested, ok := object[key].(map[string]any)
+		if !ok {
+			return
+		}
+		object = nested
+	}
+	last := path[len(path)-1]
+	if value, ok := object[last]; ok {
+		object[last] = rewriteValues(value, rewrite)
+	}
+}
+
+// NormalizeConfig describes a normalize stage, which canonicalizes each
+// event's URL the way canonical_url is derived: tracking parameters stripped,
+// scheme and host lowercased, default ports and in-page anchors dropped.
+// Strip and Keep add parameter rules to the defaults, in the syntax of the URL
+// rules file; Fragment is drop, keep or routes. Fields lists dot-separated
+// paths in the data that hold URLs too, such as "referrer".
+type NormalizeConfig struct {
+	Strip    []string `json:"strip,omitempty"`
+	Keep     []string `json:"keep,omitempty"`
+	Fragment string   `json:"fragment,omitempty"`
+	Fields   []string `json:"fields,omitempty"`
+}
+
+// NewNormalize returns a stage that rewrites URLs to their canonical form.
+func NewNormalize(config NormalizeConfig) (Processor, error) {
+	rules := urlnorm.DefaultRules()
+	rules.Strip = append(rules.Strip, config.Strip...)
+	rules.Keep = append(rules.Keep, config.Keep...)
+	if config.Fragment != "" {
+		mode, err := urlnorm.ParseFragmentMode(config.Fragment)
+		if err != nil {
+			return nil, err
+		}
+		rules.Fragment = mode
+	}
+	normalizer, err := urlnorm.New(rules)
+	if err != nil {
+		return nil, err
+	}
+	var fields [][]string
+	for _, field := range config.Fields {
+		fields = append(fields, strings.Split(field, "."))
+	}
+	return EventFunc(func(_ context.Context, event models.Event) ([]models.Event, error) {
+		event.URL = normalizer.Normalize(event.URL)
+		if fields != nil {
+			event.Data = cloneValue(event.Data).(map[string]any)
+			for _, path := range fields {
+				rewritePath(event.Data, path, normalizer.Normalize)
+			}
+		}
+		return []models.Event{event}, nil
+	}), nil
+}
+
+// SetConfig describes a set stage, which writes Data's fields into every
+// event's data, replacing any already there.
+type SetConfig struct {
+	Data map[string]any `json:"data"`
+}
+
+// NewSet returns a stage that adds config's fields to every event.
+func NewSet(config SetConfig) (Processor, error) {
+	if len(config.Data) == 0 {
+		return nil, errors.New("set needs data")
+	}
+	return EventFunc(func(_ context.Context, event models.Event) ([]models.Event, error) {
+		data := make(map[string]any, len(event.Data)+len(config.Data))
+		for key, value := range event.Data {
+			data[key] = value
+		}
+		for key, value := range config.Data {
+			data[key] = cloneValue(value)
+		}
+		event.Data = data
+		return []models.Event{event}, nil
+	}), nil
+}
+
+// cloneValue deep-copies decoded JSON, so that a stage can change an event's
+// data without touching the caller's. A nil map clones to an empty one.
+func cloneValue(value any) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		cloned := make(map[string]any, len(typed))
+		for key, nested := range typed {
+			cloned[key] = cloneValue(nested)
+		}
+		return cloned
+	case []any:
+		cloned := make([]any, len(typed))
+		for i, nested := range typed {
+			cloned[i] = cloneValue(nested)
+		}
+		return cloned
+	}
+	return value
+}
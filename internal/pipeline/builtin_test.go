@@ -0,0 +1,186 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func TestDrop(t *testing.T) {
+	events := []models.Event{
+		{TSUTC: 1000, URL: "https://bank.example/login", Type: "navigate"},
+		{TSUTC: 2000, URL: "https://www.bank.example/", Type: "scroll"},
+		{TSUTC: 3000, URL: "https://example.com/", Type: "scroll"},
+		{TSUTC: 4000, URL: "https://example.com/admin/users", Type: "click"},
+	}
+	tests := []struct {
+		name   string
+		config DropConfig
+		want   []int64
+	}{
+		{"by type", DropConfig{Types: []string{"scroll"}}, []int64{1000, 4000}},
+		{"by domain", DropConfig{Domains: []string{"bank.example"}}, []int64{3000, 4000}},
+		{"by url", DropConfig{URL: "https://example.com/ADMIN/*"}, []int64{1000, 2000, 3000}},
+		{"every condition", DropConfig{Types: []string{"scroll"}, Domains: []string{"bank.example"}}, []int64{1000, 3000, 4000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drop, err := NewDrop(tt.config)
+			if err != nil {
+				t.Fatalf("NewDrop() error = %v", err)
+			}
+			kept, err := drop.Process(context.Background(), events)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			var got []int64
+			for _, event := range kept {
+				got = append(got, event.TSUTC)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v kept, got %v", tt.want, got)
+			}
+		})
+	}
+	if _, err := NewDrop(DropConfig{}); err == nil {
+		t.Error("Expected a drop stage without conditions to be refused")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	title := "Card 4111111111111111"
+	original := models.Event{
+		TSUTC: 1000, URL: "https://shop.example/", Type: "input", Title: &title,
+		Data: map[string]any{
+			"value":  "4111111111111111",
+			"fields": []any{map[string]any{"card": "4111 1111 1111 1111"}},
+			"count":  float64(4111111111111111),
+		},
+	}
+	replacement := "****"
+	tests := []struct {
+		name      string
+		config    RedactConfig
+		wantTitle string
+		wantData  map[string]any
+	}{
+		{
+			name:      "everywhere",
+			config:    RedactConfig{Patterns: []string{`\d{16}`, `(\d{4} ){3}\d{4}`}},
+			wantTitle: "Card [redacted]",
+			wantData: map[string]any{
+				"value":  "[redacted]",
+				"fields": []any{map[string]any{"card": "[redacted]"}},
+				"count":  float64(4111111111111111),
+			},
+		},
+		{
+			name:      "only fields",
+			config:    RedactConfig{Patterns: []string{`\d`}, Replacement: &replacement, Fields: []string{"value", "missing.path"}},
+			wantTitle: title,
+			wantData: map[string]any{
+				"value":  "****************************************************************",
+				"fields": []any{map[string]any{"card": "4111 1111 1111 1111"}},
+				"count":  float64(4111111111111111),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redact, err := NewRedact(tt.config)
+			if err != nil {
+				t.Fatalf("NewRedact() error = %v", err)
+			}
+			redacted, err := redact.Process(context.Background(), []models.Event{original})
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if *redacted[0].Title != tt.wantTitle {
+				t.Errorf("Expected title %q, got %q", tt.wantTitle, *redacted[0].Title)
+			}
+			if !reflect.DeepEqual(redacted[0].Data, tt.wantData) {
+				t.Errorf("Expected data %v, got %v", tt.wantData, redacted[0].Data)
+			}
+		})
+	}
+	if original.Data["value"] != "4111111111111111" || title != "Card 4111111111111111" {
+		t.Errorf("Expected the original event to be left alone, got %+v", original.Data)
+	}
+}
+
+func TestSet(t *testing.T) {
+	set, err := NewSet(SetConfig{Data: map[string]any{"team": "research", "tags": []any{"a"}}})
+	if err != nil {
+		t.Fatalf("NewSet() error = %v", err)
+	}
+	original := models.Event{TSUTC: 1000, URL: "https://example.com/", Type: "navigate", Data: map[string]any{"team": "old", "x": 1.0}}
+	processed, err := set.Process(context.Background(), []models.Event{original, {TSUTC: 2000, Type: "click"}})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	want := map[string]any{"team": "research", "tags": []any{"a"}, "x": 1.0}
+	if !reflect.DeepEqual(processed[0].Data, want) {
+		t.Errorf("Expected %v, got %v", want, processed[0].Data)
+	}
+	if processed[1].Data["team"] != "research" {
+		t.Errorf("Expected events without data to get the fields, got %v", processed[1].Data)
+	}
+	if original.Data["team"] != "old" {
+		t.Errorf("Expected the original event to be left alone, got %v", original.Data)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	original := models.Event{
+		TSUTC: 1000, URL: "HTTPS://Example.com:443/a?utm_source=x&b=2&a=1#top", Type: "navigate",
+		Data: map[string]any{"referrer": "https://search.example/?q=go&ref=home", "note": "https://example.com/?fbclid=1"},
+	}
+	tests := []struct {
+		name     string
+		config   NormalizeConfig
+		wantURL  string
+		wantData map[string]any
+	}{
+		{
+			name:     "defaults",
+			config:   NormalizeConfig{},
+			wantURL:  "https://example.com/a?a=1&b=2",
+			wantData: original.Data,
+		},
+		{
+			name:    "extra rules and fields",
+			config:  NormalizeConfig{Strip: []string{"ref"}, Keep: []string{"utm_source"}, Fragment: "keep", Fields: []string{"referrer"}},
+			wantURL: "https://example.com/a?a=1&b=2&utm_source=x#top",
+			wantData: map[string]any{
+				"referrer": "https://search.example/?q=go",
+				"note":     "https://example.com/?fbclid=1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalize, err := NewNormalize(tt.config)
+			if err != nil {
+				t.Fatalf("NewNormalize() error = %v", err)
+			}
+			events, err := normalize.Process(context.Background(), []models.Event{original})
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if events[0].URL != tt.wantURL {
+				t.Errorf("Expected URL %s, got %s", tt.wantURL, events[0].URL)
+			}
+			if !reflect.DeepEqual(events[0].Data, tt.wantData) {
+				t.Errorf("Expected data %v, got %v", tt.wantData, events[0].Data)
+			}
+		})
+	}
+	if original.Data["referrer"] != "https://search.example/?q=go&ref=home" {
+		t.Errorf("Expected the original event to be untouched, got %v", original.Data)
+	}
+	if _, err := NewNormalize(NormalizeConfig{Fragment: "sometimes"}); err == nil {
+		t.Error("Expected an invalid fragment mode to be refused")
+	}
+}
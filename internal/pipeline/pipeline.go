@@ -0,0 +1,225 @@
+// Package pipeline runs incoming events through ordered processing stages
+// before they are stored. A stage may rewrite events, drop them or split one
+// into several: redacting secrets, normalizing URLs, filtering out sites,
+// adding fields.
+//
+// Stages are listed in a JSON file and run in order:
+//
+//	{"stages": [
+//	  {"type": "drop", "options": {"types": ["scroll"], "domains": ["bank.example"]}},
+//	  {"type": "redact", "options": {"patterns": ["\\b\\d{13,16}\\b"]}},
+//	  {"type": "normalize", "options": {"strip": ["ref"], "fields": ["referrer"]}},
+//	  {"type": "set", "options": {"data": {"team": "research"}}},
+//	  {"type": "plugin", "options": {"command": ["./enrich.py"], "timeout": "2s"}, "optional": true}
+//	]}
+//
+// Besides the built-in stages, a plugin stage hands events to a program of the
+// user's own over stdin and stdout; see Plugin.
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// Processor is one stage of a pipeline. Process returns the events that take
+// the place of events: fewer to drop some, more to split some, changed ones
+// to rewrite them. It must not modify the events it is given in place.
+type Processor interface {
+	Process(ctx context.Context, events []models.Event) ([]models.Event, error)
+}
+
+// EventFunc is a Processor that handles each event on its own, returning
+// none, one or several events in its place.
+type EventFunc func(ctx context.Context, event models.Event) ([]models.Event, error)
+
+func (f EventFunc) Process(ctx context.Context, events []models.Event) ([]models.Event, error) {
+	processed := make([]models.Event, 0, len(events))
+	for _, event := range events {
+		replacements, err := f(ctx, event)
+		if err != nil {
+			return nil, err
+		}
+		processed = append(processed, replacements...)
+	}
+	return processed, nil
+}
+
+// Factory builds a stage from the options given for it in the pipeline file.
+type Factory func(options json.RawMessage) (Processor, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a stage type available to pipeline files. It panics if the
+// type is already taken.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("pipeline: stage type " + name + " registered twice")
+	}
+	registry[name] = factory
+}
+
+// Types returns the registered stage types in order.
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stage is one entry of the pipeline file. An optional stage that fails is
+// logged and skipped; otherwise the failure rejects the whole batch.
+type Stage struct {
+	Type     string          `json:"type"`
+	Options  json.RawMessage `json:"options,omitempty"`
+	Optional bool            `json:"optional,omitempty"`
+}
+
+// File is the layout of the pipeline file.
+type File struct {
+	Stages []Stage `json:"stages"`
+}
+
+type stage struct {
+	name      string
+	processor Processor
+	optional  bool
+}
+
+// Pipeline runs events through its stages in order. A nil or empty Pipeline
+// passes events through untouched.
+type Pipeline struct {
+	stages []stage
+}
+
+// New returns a pipeline of the given required stages.
+func New(processors ...Processor) *Pipeline {
+	p := &Pipeline{}
+	for i, processor := range processors {
+		p.stages = append(p.stages, stage{name: fmt.Sprintf("stage %d", i+1), processor: processor})
+	}
+	return p
+}
+
+// Parse reads a pipeline file and builds its stages.
+func Parse(r io.Reader) (*Pipeline, error) {
+	var file File
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse pipeline: %w", err)
+	}
+	p := &Pipeline{}
+	for i, entry := range file.Stages {
+		registryMu.RLock()
+		factory, ok := registry[entry.Type]
+		registryMu.RUnlock()
+		if !ok {
+			p.Close()
+			return nil, fmt.Errorf("stage %d: unknown type %q (want %s)", i+1, entry.Type, strings.Join(Types(), ", "))
+		}
+		processor, err := factory(entry.Options)
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("stage %d (%s): %w", i+1, entry.Type, err)
+		}
+		p.stages = append(p.stages, stage{
+			name:      fmt.Sprintf("stage %d (%s)", i+1, entry.Type),
+			processor: processor,
+			optional:  entry.Optional,
+		})
+	}
+	return p, nil
+}
+
+// Load reads a pipeline file. A missing file is an empty pipeline.
+func Load(path string) (*Pipeline, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Pipeline{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pipeline: %w", err)
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+// Len returns the number of stages.
+func (p *Pipeline) Len() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.stages)
+}
+
+// Process runs events through every stage in turn.
+func (p *Pipeline) Process(ctx context.Context, events []models.Event) ([]models.Event, error) {
+	if p.Len() == 0 {
+		return events, nil
+	}
+	for _, stage := range p.stages {
+		if len(events) == 0 {
+			break
+		}
+		processed, err := stage.processor.Process(ctx, events)
+		if err == nil {
+			err = validate(processed)
+		}
+		if err != nil {
+			if stage.optional && ctx.Err() == nil {
+				log.Printf("Skipping pipeline %s: %v", stage.name, err)
+				continue
+			}
+			return nil, fmt.Errorf("pipeline %s failed: %w", stage.name, err)
+		}
+		events = processed
+	}
+	return events, nil
+}
+
+// Close stops any stage that holds resources, such as a plugin's process.
+func (p *Pipeline) Close() error {
+	if p == nil {
+		return nil
+	}
+	var errs []error
+	for _, stage := range p.stages {
+		if closer, ok := stage.processor.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks that a stage left events that can still be stored.
+func validate(events []models.Event) error {
+	for i := range events {
+		if events[i].Type == "" {
+			return fmt.Errorf("event %d has no type", i+1)
+		}
+		if events[i].Data == nil {
+			events[i].Data = map[string]any{}
+		}
+	}
+	return nil
+}
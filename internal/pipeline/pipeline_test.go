@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func TestProcess(t *testing.T) {
+	split := EventFunc(func(_ context.Context, event models.Event) ([]models.Event, error) {
+		second := event
+		second.Type = "copy"
+		return []models.Event{event, second}, nil
+	})
+	dropClicks := EventFunc(func(_ context.Context, event models.Event) ([]models.Event, error) {
+		if event.Type == "click" {
+			return nil, nil
+		}
+		return []models.Event{event}, nil
+	})
+	failing := EventFunc(func(context.Context, models.Event) ([]models.Event, error) {
+		return nil, errors.New("boom")
+	})
+	untyped := EventFunc(func(_ context.Context, event models.Event) ([]models.Event, error) {
+		event.Type = ""
+		return []models.Event{event}, nil
+	})
+	events := []models.Event{
+		{TSUTC: 1000, URL: "https://example.com/", Type: "navigate"},
+		{TSUTC: 2000, URL: "https://example.com/", Type: "click"},
+	}
+
+	tests := []struct {
+		name     string
+		pipeline *Pipeline
+		want     []string
+		wantErr  bool
+	}{
+		{"nil passes through", nil, []string{"navigate", "click"}, false},
+		{"stages run in order", New(dropClicks, split), []string{"navigate", "copy"}, false},
+		{"split then drop", New(split, dropClicks), []string{"navigate", "copy", "copy"}, false},
+		{"failure rejects the batch", New(split, failing), nil, true},
+		{"events need a type", New(untyped), nil, true},
+		{"optional failures are skipped", &Pipeline{stages: []stage{
+			{name: "failing", processor: failing, optional: true},
+			{name: "drop", processor: dropClicks},
+		}}, []string{"navigate"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processed, err := tt.pipeline.Process(context.Background(), events)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var types []string
+			for _, event := range processed {
+				types = append(types, event.Type)
+				if event.Data == nil && tt.pipeline.Len() > 0 {
+					t.Errorf("Expected data to be filled in, got nil")
+				}
+			}
+			if strings.Join(types, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Expected %v, got %v", tt.want, types)
+			}
+		})
+	}
+	if events[1].Type != "click" {
+		t.Errorf("Expected the input to be left alone, got %+v", events)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		stages  int
+		wantErr string
+	}{
+		{"empty", `{"stages": []}`, 0, ""},
+		{"built-ins", `{"stages": [
+			{"type": "drop", "options": {"types": ["scroll"]}},
+			{"type": "redact", "options": {"patterns": ["\\d{16}"]}},
+			{"type": "set", "options": {"data": {"team": "research"}}},
+			{"type": "plugin", "options": {"command": ["enrich"], "timeout": "2s"}, "optional": true}]}`, 4, ""},
+		{"unknown type", `{"stages": [{"type": "shout"}]}`, 0, `unknown type "shout"`},
+		{"unknown option", `{"stages": [{"type": "drop", "options": {"typo": true}}]}`, 0, "invalid options"},
+		{"unknown field", `{"stages": [], "extra": 1}`, 0, "failed to parse"},
+		{"invalid options", `{"stages": [{"type": "redact", "options": {"patterns": ["("]}}]}`, 0, "invalid pattern"},
+		{"missing options", `{"stages": [{"type": "set"}]}`, 0, "set needs data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, err := Parse(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			defer pipeline.Close()
+			if pipeline.Len() != tt.stages {
+				t.Errorf("Expected %d stages, got %d", tt.stages, pipeline.Len())
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "browsetrace-pipeline-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pipeline, err := Load(filepath.Join(tmpDir, "missing.json"))
+	if err != nil || pipeline.Len() != 0 {
+		t.Errorf("Expected an empty pipeline for a missing file, got %d stages, %v", pipeline.Len(), err)
+	}
+
+	path := filepath.Join(tmpDir, "pipeline.json")
+	if err := os.WriteFile(path, []byte(`{"stages": [{"type": "drop", "options": {"types": ["scroll"]}}]}`), 0o600); err != nil {
+		t.Fatalf("Failed to write pipeline: %v", err)
+	}
+	if pipeline, err = Load(path); err != nil || pipeline.Len() != 1 {
+		t.Errorf("Expected 1 stage, got %d, %v", pipeline.Len(), err)
+	}
+}
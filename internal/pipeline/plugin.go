@@ -0,0 +1,261 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// DefaultPluginTimeout bounds how long a plugin may take over one batch. It
+// stays below the server's write timeout, so that a slow plugin fails the
+// request with an error instead of leaving it unanswered. The context a batch
+// is processed with can cut it shorter still.
+const DefaultPluginTimeout = 3 * time.Second
+
+// maxPluginLine bounds a plugin's reply to one batch.
+const maxPluginLine = 64 << 20
+
+// maxPluginLogLine bounds a line a plugin writes to stderr; longer lines are
+// logged cut short.
+const maxPluginLogLine = 4 << 10
+
+// pluginStopGrace is how long a plugin that is closed may take to exit
+// before it is killed.
+const pluginStopGrace = time.Second
+
+// PluginConfig describes a plugin stage. Command is the program and its
+// arguments, run without a shell; Timeout is a duration such as "5s".
+type PluginConfig struct {
+	Command []string          `json:"command"`
+	Env     map[string]string `json:"env,omitempty"`
+	Timeout string            `json:"timeout,omitempty"`
+}
+
+// PluginRequest is what a plugin reads from stdin for each batch, as one line
+// of JSON.
+type PluginRequest struct {
+	Events []models.Event `json:"events"`
+}
+
+// PluginResponse is the line of JSON a plugin writes to stdout in answer: the
+// events that replace the batch, or an error that rejects it.
+type PluginResponse struct {
+	Events []models.Event `json:"events"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// Plugin runs a stage in a separate program, so that teams can enrich events
+// in any language without rebuilding the agent. The program is started on
+// first use and kept running: it reads one PluginRequest per line from stdin
+// and writes one PluginResponse per line to stdout, in order. Anything it
+// writes to stderr is logged. If it exits, misbehaves or takes longer than the
+// timeout, it is stopped and started afresh for the next batch.
+type Plugin struct {
+	command []string
+	env     []string
+	timeout time.Duration
+
+	mu      sync.Mutex // one batch at a time
+	process *pluginProcess
+}
+
+type pluginProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *os.File
+	reader *bufio.Reader
+	done   chan struct{} // closed once the program exits
+}
+
+// NewPlugin returns a stage that runs config's program.
+func NewPlugin(config PluginConfig) (*Plugin, error) {
+	if len(config.Command) == 0 {
+		return nil, errors.New("plugin needs a command to run")
+	}
+	plugin := &Plugin{command: config.Command, timeout: DefaultPluginTimeout}
+	if config.Timeout != "" {
+		timeout, err := time.ParseDuration(config.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", config.Timeout)
+		}
+		plugin.timeout = timeout
+	}
+	for key, value := range config.Env {
+		plugin.env = append(plugin.env, key+"="+value)
+	}
+	return plugin, nil
+}
+
+func (p *Plugin) Process(ctx context.Context, events []models.Event) ([]models.Event, error) {
+	request, err := json.Marshal(PluginRequest{Events: events})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode events for plugin: %w", err)
+	}
+	request = append(request, '\n')
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.process == nil {
+		if p.process, err = p.start(); err != nil {
+			return nil, err
+		}
+	}
+	process := p.process
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	type reply struct {
+		line []byte
+		err  error
+	}
+	replies := make(chan reply, 1)
+	go func() {
+		if _, err := process.stdin.Write(request); err != nil {
+			replies <- reply{err: fmt.Errorf("failed to write to plugin: %w", err)}
+			return
+		}
+		line, err := readLine(process.reader)
+		replies <- reply{line: line, err: err}
+	}()
+
+	// A batch's deadline is what the caller has left to answer its own
+	// client, so a plugin that fails one is killed rather than waited for.
+	select {
+	case <-ctx.Done():
+		p.stop(0)
+		return nil, fmt.Errorf("plugin %s did not answer: %w", p.command[0], ctx.Err())
+	case result := <-replies:
+		if result.err != nil {
+			p.stop(0)
+			return nil, result.err
+		}
+		var response PluginResponse
+		if err := json.Unmarshal(result.line, &response); err != nil {
+			p.stop(0)
+			return nil, fmt.Errorf("failed to decode plugin response: %w", err)
+		}
+		if response.Error != "" {
+			return nil, fmt.Errorf("plugin %s: %s", p.command[0], response.Error)
+		}
+		return response.Events, nil
+	}
+}
+
+// readLine reads one newline-terminated line of at most maxPluginLine bytes.
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxPluginLine {
+			return nil, errors.New("plugin response is too large")
+		}
+		switch {
+		case err == nil:
+			return line, nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF):
+			return nil, errors.New("plugin exited without answering")
+		default:
+			return nil, fmt.Errorf("failed to read from plugin: %w", err)
+		}
+	}
+}
+
+// start launches the plugin's program. The caller holds p.mu.
+func (p *Plugin) start() (*pluginProcess, error) {
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Env = append(os.Environ(), p.env...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start plugin: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start plugin: %w", err)
+	}
+	// Unlike StdoutPipe, a pipe of our own stays open for reading after the
+	// program exits, so a reply in flight is read before EOF rather than cut
+	// off by Wait.
+	stdout, writer, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start plugin: %w", err)
+	}
+	cmd.Stdout = writer
+	err = cmd.Start()
+	writer.Close()
+	if err != nil {
+		stdout.Close()
+		return nil, fmt.Errorf("failed to start plugin: %w", err)
+	}
+	process := &pluginProcess{cmd: cmd, stdin: stdin, stdout: stdout, reader: bufio.NewReader(stdout), done: make(chan struct{})}
+	go func() {
+		logStderr(p.command[0], stderr)
+		cmd.Wait()
+		close(process.done)
+	}()
+	return process, nil
+}
+
+// logStderr logs what a plugin writes to stderr a line at a time, until it
+// exits. Lines longer than maxPluginLogLine are cut short rather than left
+// unread, which would block the plugin once the pipe fills.
+func logStderr(command string, stderr io.Reader) {
+	reader := bufio.NewReaderSize(stderr, maxPluginLogLine)
+	skipping := false
+	for {
+		line, err := reader.ReadSlice('\n')
+		long := errors.Is(err, bufio.ErrBufferFull)
+		if !skipping && len(line) > 0 {
+			text := strings.TrimRight(string(line), "\r\n")
+			if long {
+				text += " [cut]"
+			}
+			log.Printf("Plugin %s: %s", command, text)
+		}
+		// The rest of a long line is read and dropped.
+		skipping = long
+		if err != nil && !long {
+			return
+		}
+	}
+}
+
+// stop ends the running program, if any, giving it up to grace to exit on
+// its own before killing it. The caller holds p.mu.
+func (p *Plugin) stop(grace time.Duration) {
+	if p.process == nil {
+		return
+	}
+	process := p.process
+	p.process = nil
+	// Closing stdin asks a well-behaved plugin to exit; the rest are killed.
+	process.stdin.Close()
+	select {
+	case <-process.done:
+	case <-time.After(grace):
+		process.cmd.Process.Kill()
+		<-process.done
+	}
+	process.stdout.Close()
+}
+
+// Close stops the plugin's program.
+func (p *Plugin) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stop(pluginStopGrace)
+	return nil
+}
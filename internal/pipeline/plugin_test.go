@@ -0,0 +1,166 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// TestMain lets the test binary stand in for a plugin: run with
+// BROWSETRACE_TEST_PLUGIN set, it answers on stdin and stdout as that mode
+// says instead of running the tests.
+func TestMain(m *testing.M) {
+	if mode := os.Getenv("BROWSETRACE_TEST_PLUGIN"); mode != "" {
+		runTestPlugin(mode)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func runTestPlugin(mode string) {
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(nil, 1<<20)
+	for batch := 1; scanner.Scan(); batch++ {
+		var request PluginRequest
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			fmt.Fprintln(os.Stderr, "bad request:", err)
+			os.Exit(1)
+		}
+		var response PluginResponse
+		switch mode {
+		case "enrich":
+			// Tags each event with the batch number, proving the process is
+			// kept between batches, and splits out a copy of each click.
+			for _, event := range request.Events {
+				event.Data["batch"] = batch
+				event.Data["label"] = os.Getenv("LABEL")
+				response.Events = append(response.Events, event)
+				if event.Type == "click" {
+					event.Type = "click_copy"
+					response.Events = append(response.Events, event)
+				}
+			}
+		case "refuse":
+			response.Error = "not today"
+		case "hang":
+			time.Sleep(time.Hour)
+		case "crash":
+			fmt.Fprintln(os.Stderr, "crashing")
+			os.Exit(2)
+		case "garbage":
+			fmt.Println("not json")
+			continue
+		case "chatty":
+			// More than a pipe holds, on one line, before every reply.
+			fmt.Fprintln(os.Stderr, strings.Repeat("x", 1<<20))
+			response.Events = request.Events
+		}
+		line, _ := json.Marshal(response)
+		fmt.Println(string(line))
+	}
+}
+
+func testPlugin(t *testing.T, mode, timeout string) *Plugin {
+	t.Helper()
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Failed to find the test binary: %v", err)
+	}
+	plugin, err := NewPlugin(PluginConfig{
+		Command: []string{executable},
+		Env:     map[string]string{"BROWSETRACE_TEST_PLUGIN": mode, "LABEL": "enriched"},
+		Timeout: timeout,
+	})
+	if err != nil {
+		t.Fatalf("NewPlugin() error = %v", err)
+	}
+	t.Cleanup(func() { plugin.Close() })
+	return plugin
+}
+
+func TestPlugin(t *testing.T) {
+	plugin := testPlugin(t, "enrich", "")
+	events := []models.Event{
+		{TSUTC: 1000, URL: "https://example.com/", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, URL: "https://example.com/", Type: "click", Data: map[string]any{"x": 1.0}},
+	}
+
+	for batch := 1; batch <= 2; batch++ {
+		processed, err := plugin.Process(context.Background(), events)
+		if err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+		var types []string
+		for _, event := range processed {
+			types = append(types, event.Type)
+			if event.Data["batch"] != float64(batch) || event.Data["label"] != "enriched" {
+				t.Errorf("Expected batch %d from the same process with its env, got %v", batch, event.Data)
+			}
+		}
+		if strings.Join(types, ",") != "navigate,click,click_copy" {
+			t.Errorf("Expected the click to be split, got %v", types)
+		}
+	}
+}
+
+func TestPluginFailures(t *testing.T) {
+	events := []models.Event{{TSUTC: 1000, URL: "https://example.com/", Type: "navigate", Data: map[string]any{}}}
+	tests := []struct {
+		mode    string
+		timeout string
+		wantErr string
+	}{
+		{"refuse", "", "not today"},
+		{"hang", "200ms", "did not answer"},
+		{"crash", "", "exited without answering"},
+		{"garbage", "", "failed to decode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			plugin := testPlugin(t, tt.mode, tt.timeout)
+			// A failed plugin is started afresh for the next batch.
+			for attempt := 0; attempt < 2; attempt++ {
+				started := time.Now()
+				_, err := plugin.Process(context.Background(), events)
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				// A plugin that fails a batch is killed, not given time to exit.
+				if elapsed := time.Since(started); elapsed > 800*time.Millisecond {
+					t.Errorf("Expected the failure within the timeout, took %v", elapsed)
+				}
+			}
+		})
+	}
+
+	if _, err := NewPlugin(PluginConfig{}); err == nil {
+		t.Error("Expected a plugin without a command to be refused")
+	}
+	if _, err := NewPlugin(PluginConfig{Command: []string{"x"}, Timeout: "soon"}); err == nil {
+		t.Error("Expected an invalid timeout to be refused")
+	}
+}
+
+func TestPluginChattyStderr(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	plugin := testPlugin(t, "chatty", "5s")
+	events := []models.Event{{TSUTC: 1000, URL: "https://example.com/", Type: "navigate", Data: map[string]any{}}}
+	for batch := 1; batch <= 3; batch++ {
+		processed, err := plugin.Process(context.Background(), events)
+		if err != nil || len(processed) != 1 {
+			t.Fatalf("Batch %d: expected the event back despite long stderr lines, got %v, %v", batch, processed, err)
+		}
+	}
+}
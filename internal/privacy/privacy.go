@@ -0,0 +1,111 @@
+// Package privacy holds the user's blocklist of sites whose browsing must not
+// be handed to other tools, such as LLM assistants.
+package privacy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Blocklist matches URLs by host. An entry blocks its domain and every
+// subdomain: "bank.example" blocks "www.bank.example" but not "notbank.example".
+// The zero value and a nil *Blocklist block nothing.
+type Blocklist struct {
+	domains map[string]bool
+}
+
+func New(domains []string) *Blocklist {
+	b := &Blocklist{domains: make(map[string]bool)}
+	for _, domain := range domains {
+		if domain = normalizeEntry(domain); domain != "" {
+			b.domains[domain] = true
+		}
+	}
+	return b
+}
+
+// Parse reads one domain per line. Blank lines and lines starting with # are
+// ignored; entries may be written as URLs.
+func Parse(r io.Reader) (*Blocklist, error) {
+	var domains []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read blocklist: %w", err)
+	}
+	return New(domains), nil
+}
+
+// Load reads a blocklist file. A missing file is an empty blocklist.
+func Load(path string) (*Blocklist, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return New(nil), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blocklist: %w", err)
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+func normalizeEntry(entry string) string {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	if strings.Contains(entry, "://") {
+		if parsed, err := url.Parse(entry); err == nil {
+			entry = parsed.Hostname()
+		}
+	}
+	return strings.Trim(strings.TrimPrefix(entry, "*."), ".")
+}
+
+// Len returns the number of blocked domains.
+func (b *Blocklist) Len() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.domains)
+}
+
+// Blocks reports whether rawURL belongs to a blocked domain.
+func (b *Blocklist) Blocks(rawURL string) bool {
+	if b.Len() == 0 {
+		return false
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		// Unparseable URLs cannot be checked, so err on the side of hiding them.
+		return true
+	}
+	return b.BlocksHost(parsed.Hostname())
+}
+
+// BlocksHost reports whether host is a blocked domain or a subdomain of one.
+func (b *Blocklist) BlocksHost(host string) bool {
+	if b.Len() == 0 {
+		return false
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for host != "" {
+		if b.domains[host] {
+			return true
+		}
+		dot := strings.IndexByte(host, '.')
+		if dot < 0 {
+			break
+		}
+		host = host[dot+1:]
+	}
+	return false
+}
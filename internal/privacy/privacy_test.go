@@ -0,0 +1,66 @@
+package privacy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlocks(t *testing.T) {
+	blocklist := New([]string{"bank.example", "https://Mail.Example.com/inbox", "*.health.example"})
+
+	tests := map[string]bool{
+		"https://bank.example/login":        true,
+		"https://www.bank.example/":         true,
+		"https://notbank.example/":          false,
+		"https://mail.example.com/u/0":      true,
+		"https://example.com/":              false,
+		"https://portal.health.example/x":   true,
+		"https://health.example/":           true,
+		"https://search.example/?q=bank":    false,
+		"http://BANK.EXAMPLE.:8080/account": true,
+	}
+	for url, want := range tests {
+		if got := blocklist.Blocks(url); got != want {
+			t.Errorf("Blocks(%q) = %v, want %v", url, got, want)
+		}
+	}
+}
+
+func TestEmptyBlocklist(t *testing.T) {
+	var nilList *Blocklist
+	if nilList.Blocks("https://bank.example/") || New(nil).Blocks("::not a url") {
+		t.Error("Expected an empty blocklist to block nothing")
+	}
+}
+
+func TestParseAndLoad(t *testing.T) {
+	blocklist, err := Parse(strings.NewReader("# banking\nbank.example\n\n  health.example  \n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if blocklist.Len() != 2 || !blocklist.Blocks("https://health.example/") {
+		t.Errorf("Unexpected blocklist: %+v", blocklist)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "browsetrace-privacy-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	missing, err := Load(filepath.Join(tmpDir, "missing.txt"))
+	if err != nil || missing.Len() != 0 {
+		t.Errorf("Expected empty blocklist for a missing file, got %v, %v", missing, err)
+	}
+
+	path := filepath.Join(tmpDir, "blocklist.txt")
+	if err := os.WriteFile(path, []byte("bank.example\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write blocklist: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil || !loaded.Blocks("https://bank.example/") {
+		t.Errorf("Expected loaded blocklist to block bank.example, got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+type Format string
+
+const (
+	FormatMarkdown Format = "markdown"
+	FormatHTML     Format = "html"
+	FormatJSON     Format = "json"
+)
+
+func ParseFormat(value string) (Format, error) {
+	switch Format(strings.ToLower(value)) {
+	case "", FormatMarkdown, "md":
+		return FormatMarkdown, nil
+	case FormatHTML:
+		return FormatHTML, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	}
+	return "", fmt.Errorf("unsupported report format: %s", value)
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	case FormatJSON:
+		return "application/json"
+	}
+	return "text/markdown; charset=utf-8"
+}
+
+func (f Format) Extension() string {
+	if f == FormatMarkdown {
+		return "md"
+	}
+	return string(f)
+}
+
+var templateFunctions = map[string]any{
+	"duration": formatDuration,
+	"time": func(ms int64) string {
+		return time.UnixMilli(ms).UTC().Format("Mon 15:04")
+	},
+	"title": func(page Page) string {
+		if page.Title != nil && *page.Title != "" {
+			return *page.Title
+		}
+		return page.URL
+	},
+	"heading": func(r *Report) string {
+		if r.Period == PeriodWeek {
+			return fmt.Sprintf("Browsing digest: week of %s", r.Start)
+		}
+		return fmt.Sprintf("Browsing digest: %s", r.Start)
+	},
+}
+
+// markdownEscaper keeps page titles and queries from breaking list items.
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, "*", `\*`, "_", `\_`, "`", "\\`", "\n", " ")
+
+var markdownTemplate = texttemplate.Must(texttemplate.New("markdown").Funcs(templateFunctions).Funcs(map[string]any{
+	"md": markdownEscaper.Replace,
+}).Parse(`# {{heading .}}
+
+{{.EventCount}} events, {{duration .TotalMS}} active.
+
+## Top domains
+{{range .TopDomains}}
+- {{md .Key}}: {{duration .MS}}
+{{- else}}
+No browsing time recorded.
+{{- end}}
+
+## Most read
+{{range .MostRead}}
+- [{{md (title .)}}](<{{.URL}}>) ({{.Characters}} characters)
+{{- else}}
+No page text captured.
+{{- end}}
+
+## Searches
+{{range .Searches}}
+- {{time .TSUTC}} {{.Engine}}: {{md .Query}}
+{{- else}}
+No searches.
+{{- end}}
+
+## New sites
+{{range .NewSites}}
+- [{{md .Domain}}](<{{.FirstURL}}>), first visited {{time .FirstTSUTC}}
+{{- else}}
+No new sites.
+{{- end}}
+`))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(templateFunctions).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{heading .}}</title>
+</head>
+<body>
+<h1>{{heading .}}</h1>
+<p>{{.EventCount}} events, {{duration .TotalMS}} active.</p>
+<h2>Top domains</h2>
+{{with .TopDomains}}<ol>
+{{range .}}<li>{{.Key}}: {{duration .MS}}</li>
+{{end}}</ol>{{else}}<p>No browsing time recorded.</p>{{end}}
+<h2>Most read</h2>
+{{with .MostRead}}<ol>
+{{range .}}<li><a href="{{.URL}}">{{title .}}</a> ({{.Characters}} characters)</li>
+{{end}}</ol>{{else}}<p>No page text captured.</p>{{end}}
+<h2>Searches</h2>
+{{with .Searches}}<ul>
+{{range .}}<li>{{time .TSUTC}} {{.Engine}}: <a href="{{.URL}}">{{.Query}}</a></li>
+{{end}}</ul>{{else}}<p>No searches.</p>{{end}}
+<h2>New sites</h2>
+{{with .NewSites}}<ul>
+{{range .}}<li><a href="{{.FirstURL}}">{{.Domain}}</a>, first visited {{time .FirstTSUTC}}</li>
+{{end}}</ul>{{else}}<p>No new sites.</p>{{end}}
+</body>
+</html>
+`))
+
+// Render writes report to w in the given format.
+func Render(w io.Writer, report *Report, format Format) error {
+	var err error
+	switch format {
+	case FormatHTML:
+		err = htmlTemplate.Execute(w, report)
+	case FormatJSON:
+		err = json.NewEncoder(w).Encode(report)
+	default:
+		err = markdownTemplate.Execute(w, report)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to render report: %w", err)
+	}
+	return nil
+}
+
+// formatDuration renders milliseconds as e.g. "2h 5m", "4m" or "30s".
+func formatDuration(ms int64) string {
+	d := time.Duration(ms) * time.Millisecond
+	switch {
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%ds", int(d.Seconds()))
+}
@@ -0,0 +1,101 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/analytics"
+)
+
+func sampleReport() *Report {
+	title := "Tips & [tricks]"
+	return &Report{
+		Period:     PeriodDay,
+		Start:      "2021-01-06",
+		End:        "2021-01-06",
+		EventCount: 12,
+		TotalMS:    3_900_000,
+		TopDomains: []analytics.Item{{Key: "go.dev", MS: 3_600_000}, {Key: "example.com", MS: 300_000}},
+		MostRead:   []Page{{URL: "https://go.dev/doc/", Title: &title, Characters: 420}},
+		Searches:   []Search{{TSUTC: 1609927200000, Engine: "Google", Query: "<script>", URL: "https://www.google.com/search?q=%3Cscript%3E"}},
+		NewSites:   []Site{{Domain: "go.dev", FirstURL: "https://go.dev/", FirstTSUTC: 1609927260000}},
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := Render(&buffer, sampleReport(), FormatMarkdown); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	output := buffer.String()
+	for _, want := range []string{
+		"# Browsing digest: 2021-01-06",
+		"12 events, 1h 5m active.",
+		"- go.dev: 1h 0m",
+		"- example.com: 5m",
+		`- [Tips & \[tricks\]](<https://go.dev/doc/>) (420 characters)`,
+		"- Wed 10:00 Google: <script>",
+		"- [go.dev](<https://go.dev/>), first visited Wed 10:01",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %q in output:\n%s", want, output)
+		}
+	}
+}
+
+func TestRenderHTMLEscapes(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := Render(&buffer, sampleReport(), FormatHTML); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	output := buffer.String()
+	if strings.Contains(output, "<script>") {
+		t.Errorf("Expected query to be escaped:\n%s", output)
+	}
+	for _, want := range []string{"<h1>Browsing digest: 2021-01-06</h1>", "&lt;script&gt;", "Tips &amp; [tricks]"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %q in output:\n%s", want, output)
+		}
+	}
+}
+
+func TestRenderEmptySections(t *testing.T) {
+	var buffer bytes.Buffer
+	empty := &Report{Period: PeriodWeek, Start: "2021-01-04", End: "2021-01-10"}
+	if err := Render(&buffer, empty, FormatMarkdown); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	output := buffer.String()
+	for _, want := range []string{"week of 2021-01-04", "No browsing time recorded.", "No searches.", "No new sites."} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %q in output:\n%s", want, output)
+		}
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := Render(&buffer, sampleReport(), FormatJSON); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(buffer.Bytes(), &decoded); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if decoded.TotalMS != 3_900_000 || len(decoded.Searches) != 1 {
+		t.Errorf("Unexpected round trip: %+v", decoded)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for input, want := range map[string]Format{"": FormatMarkdown, "md": FormatMarkdown, "HTML": FormatHTML, "json": FormatJSON} {
+		if got, err := ParseFormat(input); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("pdf"); err == nil {
+		t.Error("Expected error for unsupported format")
+	}
+}
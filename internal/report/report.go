@@ -0,0 +1,256 @@
+// Package report builds daily and weekly browsing digests: where the time
+// went, what was read, what was searched for and which sites were new.
+package report
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/analytics"
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/urlnorm"
+)
+
+type Period string
+
+const (
+	PeriodDay  Period = "day"
+	PeriodWeek Period = "week" // Monday to Sunday
+)
+
+// DefaultLimit is how many entries each section lists when Options.Limit is zero.
+const DefaultLimit = 10
+
+func ParsePeriod(value string) (Period, error) {
+	switch Period(value) {
+	case PeriodDay, "daily":
+		return PeriodDay, nil
+	case PeriodWeek, "weekly":
+		return PeriodWeek, nil
+	}
+	return "", fmt.Errorf("invalid period %q (want day or week)", value)
+}
+
+// Bounds returns the [start, end) of the period containing at, with days
+// starting at midnight in at's location.
+func (p Period) Bounds(at time.Time) (time.Time, time.Time) {
+	start := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location())
+	if p == PeriodWeek {
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7)
+	}
+	return start, start.AddDate(0, 0, 1)
+}
+
+type Options struct {
+	Period Period
+	Date   time.Time // any moment within the period; zero means now
+	Limit  int       // entries per section
+	// Location is the time zone whose days the period covers;
+	// models.DefaultLocation when nil.
+	Location *time.Location
+}
+
+type Report struct {
+	Period     Period           `json:"period"`
+	Start      string           `json:"start"` // first day, YYYY-MM-DD
+	End        string           `json:"end"`   // last day, inclusive
+	StartTSUTC int64            `json:"start_ts_utc"`
+	EndTSUTC   int64            `json:"end_ts_utc"` // exclusive
+	EventCount int              `json:"event_count"`
+	TotalMS    int64            `json:"total_ms"`
+	TopDomains []analytics.Item `json:"top_domains"`
+	MostRead   []Page           `json:"most_read"`
+	Searches   []Search         `json:"searches"`
+	NewSites   []Site           `json:"new_sites"`
+}
+
+// Page is a page ranked by how much text the user had on screen.
+type Page struct {
+	URL        string  `json:"url"`
+	Title      *string `json:"title"`
+	Characters int64   `json:"characters"`
+}
+
+// Site is a registrable domain first navigated to during the period.
+type Site struct {
+	Domain     string `json:"domain"`
+	FirstURL   string `json:"first_url"`
+	FirstTSUTC int64  `json:"first_ts_utc"`
+}
+
+type Generator struct {
+	db        *database.Database
+	analytics *analytics.Tracker
+}
+
+// New returns a generator reading events from db and time spent from tracker.
+func New(db *database.Database, tracker *analytics.Tracker) *Generator {
+	return &Generator{db: db, analytics: tracker}
+}
+
+func (g *Generator) Generate(ctx context.Context, opts Options) (*Report, error) {
+	at := opts.Date
+	if at.IsZero() {
+		at = time.Now()
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	location := opts.Location
+	if location == nil {
+		location = models.DefaultLocation
+	}
+	start, end := opts.Period.Bounds(at.In(location))
+	report := &Report{
+		Period:     opts.Period,
+		Start:      start.Format(time.DateOnly),
+		End:        end.AddDate(0, 0, -1).Format(time.DateOnly),
+		StartTSUTC: start.UnixMilli(),
+		EndTSUTC:   end.UnixMilli(),
+		TopDomains: []analytics.Item{},
+		Searches:   []Search{},
+		NewSites:   []Site{},
+	}
+
+	group := analytics.GroupDay
+	if opts.Period == PeriodWeek {
+		group = analytics.GroupWeek
+	}
+	buckets, err := g.analytics.TimeSpent(ctx, analytics.Query{
+		Group:    group,
+		By:       analytics.ByDomain,
+		Since:    report.StartTSUTC,
+		Until:    report.EndTSUTC,
+		Limit:    limit,
+		Location: location,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, bucket := range buckets {
+		report.TotalMS += bucket.TotalMS
+		report.TopDomains = append(report.TopDomains, bucket.Items...)
+	}
+
+	// Every section selects events by the local day stored with each, as the
+	// time rollups are keyed, so that they all cover the same days.
+	endDate := end.Format(time.DateOnly)
+	if report.MostRead, err = g.mostRead(ctx, report.Start, endDate, limit); err != nil {
+		return nil, err
+	}
+
+	candidates := make(map[string]*Site)
+	var lastSearch *Search
+	filter := database.EventFilter{SinceDate: report.Start, UntilDate: endDate}
+	err = g.db.StreamEvents(ctx, filter, func(event models.StoredEvent) error {
+		report.EventCount++
+		if event.Type != "navigate" {
+			return nil
+		}
+		if search, ok := ParseSearch(event.URL); ok {
+			search.TSUTC = event.TSUTC
+			// Paging through results or reloading repeats the same query.
+			if lastSearch == nil || lastSearch.Engine != search.Engine || lastSearch.Query != search.Query {
+				report.Searches = append(report.Searches, search)
+				lastSearch = &report.Searches[len(report.Searches)-1]
+			}
+		}
+		domain := urlnorm.RegistrableDomain(event.URL)
+		if _, seen := candidates[domain]; !seen && domain != "" {
+			candidates[domain] = &Site{Domain: domain, FirstURL: event.URL, FirstTSUTC: event.TSUTC}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := g.dropVisitedBefore(ctx, candidates, report.Start); err != nil {
+		return nil, err
+	}
+	for _, site := range candidates {
+		report.NewSites = append(report.NewSites, *site)
+	}
+	sort.Slice(report.NewSites, func(i, j int) bool {
+		return report.NewSites[i].FirstTSUTC < report.NewSites[j].FirstTSUTC
+	})
+	return report, nil
+}
+
+// mostRead ranks pages by the total length of their visible_text events on
+// the local days from since up to until, both YYYY-MM-DD.
+func (g *Generator) mostRead(ctx context.Context, since, until string, limit int) ([]Page, error) {
+	rows, err := g.db.DB().QueryContext(ctx, `
+	SELECT url,
+	       (SELECT title FROM events t WHERE t.url = e.url AND t.title IS NOT NULL AND t.local_date >= ? AND t.local_date < ?
+	        ORDER BY t.ts_utc DESC LIMIT 1),
+	       SUM(COALESCE((SELECT length FROM text_blobs WHERE hash = text_hash), LENGTH(json_extract(data_json, '$.text')), 0)) AS characters
+	FROM events e
+	WHERE type = 'visible_text' AND local_date >= ? AND local_date < ?
+	GROUP BY url
+	HAVING characters > 0
+	ORDER BY characters DESC, url
+	LIMIT ?`, since, until, since, until, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query visible text: %w", err)
+	}
+	defer rows.Close()
+
+	pages := []Page{}
+	for rows.Next() {
+		var page Page
+		if err := rows.Scan(&page.URL, &page.Title, &page.Characters); err != nil {
+			return nil, fmt.Errorf("failed to scan page: %w", err)
+		}
+		if page.URL, err = g.db.Unseal(page.URL); err != nil {
+			return nil, fmt.Errorf("failed to decrypt page: %w", err)
+		}
+		if page.Title != nil {
+			title, err := g.db.Unseal(*page.Title)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt page: %w", err)
+			}
+			page.Title = &title
+		}
+		pages = append(pages, page)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pages: %w", err)
+	}
+	return pages, nil
+}
+
+// dropVisitedBefore removes the domains that were navigated to on a local
+// day before since, YYYY-MM-DD. It walks earlier navigations newest first and
+// stops once every candidate has been ruled out.
+func (g *Generator) dropVisitedBefore(ctx context.Context, candidates map[string]*Site, since string) error {
+	if len(candidates) == 0 {
+		return nil
+	}
+	rows, err := g.db.DB().QueryContext(ctx, `
+	SELECT url FROM events WHERE type = 'navigate' AND local_date < ? ORDER BY ts_utc DESC`, since)
+	if err != nil {
+		return fmt.Errorf("failed to query earlier navigations: %w", err)
+	}
+	defer rows.Close()
+
+	for len(candidates) > 0 && rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return fmt.Errorf("failed to scan navigation: %w", err)
+		}
+		if url, err = g.db.Unseal(url); err != nil {
+			return fmt.Errorf("failed to decrypt navigation: %w", err)
+		}
+		delete(candidates, urlnorm.RegistrableDomain(url))
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read navigations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package report
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/analytics"
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+const (
+	minute = int64(60 * 1000)
+	day    = 24 * 60 * minute
+	// 2021-01-04T00:00:00Z, a Monday.
+	monday = int64(1609718400000)
+)
+
+// Events without a time zone fall on the agent's days; pin them to UTC so
+// that the tests do not depend on where they run.
+func TestMain(m *testing.M) {
+	models.DefaultLocation = time.UTC
+	os.Exit(m.Run())
+}
+
+func setupTestGenerator(t *testing.T) (*database.Database, *Generator, func()) {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "browsetrace-report-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	db, err := database.NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	tracker, err := analytics.New(db, analytics.DefaultIdleCutoff)
+	if err != nil {
+		db.Close()
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+	return db, New(db, tracker), cleanup
+}
+
+func event(ts int64, url, eventType string, data map[string]any) models.Event {
+	if data == nil {
+		data = map[string]any{}
+	}
+	return models.Event{TSUTC: ts, TSISO: models.FormatTimestamp(ts), URL: url, Type: eventType, Data: data}
+}
+
+func insertSampleWeek(t *testing.T, db *database.Database) {
+	t.Helper()
+
+	title := "Effective Go"
+	article := event(monday+2*day+3*minute, "https://go.dev/doc/effective_go", "navigate", nil)
+	article.Title = &title
+	events := []models.Event{
+		// The week before: example.com is not new.
+		event(monday-day, "https://www.example.com/", "navigate", nil),
+		// Wednesday.
+		event(monday+2*day, "https://www.google.com/search?q=golang+generics&oq=golang", "navigate", nil),
+		event(monday+2*day+minute, "https://www.google.com/search?q=golang+generics&start=10", "navigate", nil),
+		event(monday+2*day+2*minute, "https://www.example.com/page", "navigate", nil),
+		article,
+		event(monday+2*day+4*minute, "https://go.dev/doc/effective_go", "visible_text", map[string]any{"text": "Formatting, commentary, names"}),
+		event(monday+2*day+5*minute, "https://go.dev/doc/effective_go", "visible_text", map[string]any{"text": "Semicolons"}),
+		event(monday+2*day+6*minute, "https://news.example.org/", "visible_text", map[string]any{"text": "Headline"}),
+		event(monday+2*day+8*minute, "https://go.dev/doc/effective_go", "scroll", nil),
+		// Friday.
+		event(monday+4*day, "https://duckduckgo.com/?q=sqlite+vacuum+into", "navigate", nil),
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+}
+
+func TestGenerateWeek(t *testing.T) {
+	db, generator, cleanup := setupTestGenerator(t)
+	defer cleanup()
+	insertSampleWeek(t, db)
+
+	report, err := generator.Generate(context.Background(), Options{Period: PeriodWeek, Date: time.UnixMilli(monday + 3*day)})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if report.Start != "2021-01-04" || report.End != "2021-01-10" {
+		t.Errorf("Unexpected bounds: %s to %s", report.Start, report.End)
+	}
+	if report.EventCount != 9 {
+		t.Errorf("Expected 9 events in the week, got %d", report.EventCount)
+	}
+	if len(report.TopDomains) == 0 || report.TopDomains[0].Key != "go.dev" || report.TopDomains[0].MS != 10*minute {
+		t.Errorf("Unexpected top domains: %+v", report.TopDomains)
+	}
+
+	if len(report.MostRead) != 2 {
+		t.Fatalf("Expected 2 read pages, got %+v", report.MostRead)
+	}
+	if report.MostRead[0].URL != "https://go.dev/doc/effective_go" || report.MostRead[0].Characters != 39 {
+		t.Errorf("Unexpected most read page: %+v", report.MostRead[0])
+	}
+	if report.MostRead[0].Title == nil || *report.MostRead[0].Title != "Effective Go" {
+		t.Errorf("Expected the page title, got %v", report.MostRead[0].Title)
+	}
+
+	if len(report.Searches) != 2 {
+		t.Fatalf("Expected paging collapsed into 2 searches, got %+v", report.Searches)
+	}
+	if report.Searches[0].Query != "golang generics" || report.Searches[1].Engine != "DuckDuckGo" {
+		t.Errorf("Unexpected searches: %+v", report.Searches)
+	}
+
+	var newDomains []string
+	for _, site := range report.NewSites {
+		newDomains = append(newDomains, site.Domain)
+	}
+	if len(newDomains) != 3 || newDomains[0] != "google.com" || newDomains[1] != "go.dev" || newDomains[2] != "duckduckgo.com" {
+		t.Errorf("Expected google.com, go.dev and duckduckgo.com as new, got %v", newDomains)
+	}
+}
+
+func TestGenerateDay(t *testing.T) {
+	db, generator, cleanup := setupTestGenerator(t)
+	defer cleanup()
+	insertSampleWeek(t, db)
+
+	report, err := generator.Generate(context.Background(), Options{Period: PeriodDay, Date: time.UnixMilli(monday + 4*day + 12*60*minute), Limit: 1})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if report.Start != "2021-01-08" || report.End != report.Start || report.EventCount != 1 {
+		t.Errorf("Unexpected day report: %+v", report)
+	}
+	if len(report.Searches) != 1 || len(report.MostRead) != 0 || len(report.NewSites) != 1 {
+		t.Errorf("Unexpected sections: %+v", report)
+	}
+}
+
+func TestPeriodBounds(t *testing.T) {
+	sunday := time.Date(2021, 1, 10, 23, 59, 0, 0, time.UTC)
+	start, end := PeriodWeek.Bounds(sunday)
+	if start.Format(time.DateOnly) != "2021-01-04" || end.Format(time.DateOnly) != "2021-01-11" {
+		t.Errorf("Unexpected week bounds: %v to %v", start, end)
+	}
+	start, end = PeriodDay.Bounds(sunday)
+	if start.Format(time.DateOnly) != "2021-01-10" || end.Sub(start) != 24*time.Hour {
+		t.Errorf("Unexpected day bounds: %v to %v", start, end)
+	}
+
+	if _, err := ParsePeriod("month"); err == nil {
+		t.Error("Expected error for unsupported period")
+	}
+}
+
+func TestGenerateUsesEventDays(t *testing.T) {
+	db, generator, cleanup := setupTestGenerator(t)
+	defer cleanup()
+
+	// 20:00 UTC on Friday is already Saturday for a client in Tokyo: every
+	// section of the report counts it on Saturday, as its time is rolled up.
+	tokyo := "Asia/Tokyo"
+	evening := monday + 4*day + 20*60*minute
+	events := []models.Event{
+		event(evening, "https://www.google.com/search?q=tokyo", "navigate", nil),
+		event(evening+minute, "https://go.dev/", "navigate", nil),
+		event(evening+2*minute, "https://go.dev/", "visible_text", map[string]any{"text": "Go"}),
+	}
+	for i := range events {
+		events[i].Timezone = &tokyo
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	tests := []struct {
+		date      int64
+		wantCount int
+	}{
+		{monday + 4*day, 0},
+		{monday + 5*day, 3},
+	}
+	for _, tt := range tests {
+		report, err := generator.Generate(context.Background(), Options{Period: PeriodDay, Date: time.UnixMilli(tt.date)})
+		if err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+		sections := len(report.TopDomains) + len(report.Searches) + len(report.MostRead) + len(report.NewSites)
+		if report.EventCount != tt.wantCount || (tt.wantCount == 0) != (sections == 0) {
+			t.Errorf("%s: expected %d events and matching sections, got %+v", report.Start, tt.wantCount, report)
+		}
+	}
+}
+
+func TestGenerateLocalDay(t *testing.T) {
+	db, generator, cleanup := setupTestGenerator(t)
+	defer cleanup()
+
+	newYork := "America/New_York"
+	location, err := time.LoadLocation(newYork)
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+	// Friday 22:00 in New York is already Saturday in UTC.
+	evening := time.Date(2021, 1, 8, 22, 0, 0, 0, location).UnixMilli()
+	events := []models.Event{
+		event(evening, "https://go.dev/", "navigate", nil),
+		event(evening+10*minute, "https://go.dev/doc/", "navigate", nil),
+	}
+	for i := range events {
+		events[i].Timezone = &newYork
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	report, err := generator.Generate(context.Background(), Options{Period: PeriodDay, Date: time.UnixMilli(evening), Location: location})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if report.Start != "2021-01-08" || report.EventCount != 2 {
+		t.Errorf("Expected both events on Friday, got %+v", report)
+	}
+	if report.TotalMS != 5*minute {
+		t.Errorf("Expected the time on Friday, got %d ms", report.TotalMS)
+	}
+}
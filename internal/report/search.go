@@ -0,0 +1,80 @@
+package report
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Search is a query typed into a search engine.
+type Search struct {
+	TSUTC  int64  `json:"ts_utc"`
+	Engine string `json:"engine"`
+	Query  string `json:"query"`
+	URL    string `json:"url"`
+}
+
+type searchEngine struct {
+	name  string
+	host  string // matched against the host and its subdomains
+	path  string // required path prefix, if any
+	param string
+}
+
+// searchEngines are matched in order, so more specific hosts come first.
+var searchEngines = []searchEngine{
+	{name: "Yahoo", host: "search.yahoo.com", param: "p"},
+	{name: "Brave", host: "search.brave.com", path: "/search", param: "q"},
+	{name: "Bing", host: "bing.com", path: "/search", param: "q"},
+	{name: "DuckDuckGo", host: "duckduckgo.com", param: "q"},
+	{name: "Ecosia", host: "ecosia.org", path: "/search", param: "q"},
+	{name: "Startpage", host: "startpage.com", param: "query"},
+	{name: "Yandex", host: "yandex.com", path: "/search", param: "text"},
+	{name: "Yandex", host: "yandex.ru", path: "/search", param: "text"},
+	{name: "Baidu", host: "baidu.com", path: "/s", param: "wd"},
+	{name: "Kagi", host: "kagi.com", path: "/search", param: "q"},
+}
+
+// ParseSearch extracts the query from a search engine results URL.
+func ParseSearch(rawURL string) (Search, bool) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return Search{}, false
+	}
+	host := strings.ToLower(parsed.Hostname())
+
+	engine, ok := googleEngine(host, parsed.Path)
+	if !ok {
+		engine, ok = matchEngine(host, parsed.Path)
+	}
+	if !ok {
+		return Search{}, false
+	}
+
+	query := strings.TrimSpace(parsed.Query().Get(engine.param))
+	if query == "" {
+		return Search{}, false
+	}
+	return Search{Engine: engine.name, Query: query, URL: rawURL}, true
+}
+
+func matchEngine(host, path string) (searchEngine, bool) {
+	for _, engine := range searchEngines {
+		if host != engine.host && !strings.HasSuffix(host, "."+engine.host) {
+			continue
+		}
+		if strings.HasPrefix(path, engine.path) {
+			return engine, true
+		}
+	}
+	return searchEngine{}, false
+}
+
+// googleEngine matches Google's country domains (google.com, google.co.uk,
+// www.google.de, ...).
+func googleEngine(host, path string) (searchEngine, bool) {
+	labels := strings.Split(strings.TrimPrefix(host, "www."), ".")
+	if len(labels) < 2 || labels[0] != "google" || path != "/search" {
+		return searchEngine{}, false
+	}
+	return searchEngine{name: "Google", param: "q"}, true
+}
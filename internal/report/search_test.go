@@ -0,0 +1,41 @@
+package report
+
+import "testing"
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		url    string
+		engine string
+		query  string
+	}{
+		{"https://www.google.com/search?q=go+modules", "Google", "go modules"},
+		{"https://www.google.co.uk/search?q=weather&hl=en", "Google", "weather"},
+		{"https://www.bing.com/search?q=sqlite%20wal", "Bing", "sqlite wal"},
+		{"https://duckduckgo.com/?q=parquet&ia=web", "DuckDuckGo", "parquet"},
+		{"https://search.yahoo.com/search?p=news", "Yahoo", "news"},
+		{"https://www.baidu.com/s?wd=golang", "Baidu", "golang"},
+		{"https://search.brave.com/search?q=privacy", "Brave", "privacy"},
+	}
+	for _, tt := range tests {
+		search, ok := ParseSearch(tt.url)
+		if !ok {
+			t.Errorf("ParseSearch(%q): expected a search", tt.url)
+			continue
+		}
+		if search.Engine != tt.engine || search.Query != tt.query {
+			t.Errorf("ParseSearch(%q) = %s %q, want %s %q", tt.url, search.Engine, search.Query, tt.engine, tt.query)
+		}
+	}
+
+	for _, url := range []string{
+		"https://www.google.com/maps?q=berlin",
+		"https://www.google.com/search?q=",
+		"https://notgoogle.example/search?q=x",
+		"https://www.bing.com/images?q=cats",
+		"https://example.com/?q=test",
+	} {
+		if search, ok := ParseSearch(url); ok {
+			t.Errorf("ParseSearch(%q): expected no search, got %+v", url, search)
+		}
+	}
+}
@@ -0,0 +1,463 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/analytics"
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+const (
+	// DefaultReloadInterval is how often Watch checks the rules file.
+	DefaultReloadInterval = 5 * time.Second
+
+	actionTimeout = 30 * time.Second
+)
+
+// Firing is what an action learns about a rule firing.
+type Firing struct {
+	Rule        string             `json:"rule"`
+	Event       models.StoredEvent `json:"event"`
+	FiredTSUTC  int64              `json:"fired_ts_utc"`
+	Count       int                `json:"count,omitempty"`         // matching events in the window, for count rules
+	TimeTodayMS int64              `json:"time_today_ms,omitempty"` // for time_today rules
+	Message     string             `json:"message,omitempty"`       // set for the action it is sent to
+}
+
+// TimeToday is the time spent today as text, such as "31m0s", for messages.
+func (f Firing) TimeToday() string {
+	return (time.Duration(f.TimeTodayMS) * time.Millisecond).Round(time.Second).String()
+}
+
+// Notification is a message stored by a notify action.
+type Notification struct {
+	ID           int64  `json:"id"`
+	Rule         string `json:"rule"`
+	Message      string `json:"message"`
+	EventUID     string `json:"event_uid,omitempty"`
+	CreatedTSUTC int64  `json:"created_ts_utc"`
+	ReadTSUTC    *int64 `json:"read_ts_utc,omitempty"`
+}
+
+// state is what the engine remembers about a rule between events.
+type state struct {
+	lastFired time.Time
+	window    []int64 // times of recent matching events, for count rules
+	firedDay  string  // local day a time_today rule last fired
+}
+
+// Engine evaluates the rules in a file against newly recorded events.
+type Engine struct {
+	db      *database.Database
+	tracker *analytics.Tracker
+	path    string
+	client  *http.Client
+	now     func() time.Time
+
+	mu       sync.Mutex
+	rules    *Set
+	states   map[string]*state
+	modified time.Time
+	size     int64
+	running  sync.WaitGroup
+}
+
+// New creates the notifications table, loads the rules at path and registers
+// the engine to run after every insert. tracker, which may be nil, supplies
+// the time spent for time_today rules.
+func New(db *database.Database, tracker *analytics.Tracker, path string) (*Engine, error) {
+	_, err := db.DB().Exec(`
+	CREATE TABLE IF NOT EXISTS notifications(
+	  id         INTEGER PRIMARY KEY,
+	  rule       TEXT    NOT NULL,
+	  message    TEXT    NOT NULL,
+	  event_uid  TEXT,
+	  created_ts INTEGER NOT NULL,
+	  read_ts    INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_notifications_read ON notifications(read_ts, id);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create notifications table: %w", err)
+	}
+	e := &Engine{
+		db:      db,
+		tracker: tracker,
+		path:    path,
+		client:  &http.Client{Timeout: actionTimeout},
+		now:     time.Now,
+		states:  make(map[string]*state),
+	}
+	if _, err := e.Reload(); err != nil {
+		return nil, err
+	}
+	db.AddCommitHook(e.evaluate)
+	db.AddReencryptHook(reencrypt)
+	return e, nil
+}
+
+// Reload reads the rules file again if it changed since it was last read,
+// and reports whether it did. On error the rules in force are kept.
+func (e *Engine) Reload() (bool, error) {
+	info, err := os.Stat(e.path)
+	var modified time.Time
+	var size int64
+	if err == nil {
+		modified, size = info.ModTime(), info.Size()
+	} else if !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to read rules: %w", err)
+	}
+	e.mu.Lock()
+	unchanged := modified.Equal(e.modified) && size == e.size
+	e.mu.Unlock()
+	if unchanged {
+		return false, nil
+	}
+
+	rules, err := Load(e.path)
+	if err != nil {
+		return false, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules, e.modified, e.size = rules, modified, size
+	// Keep the state of rules that are still there, so that editing one rule
+	// does not fire the others again.
+	states := make(map[string]*state)
+	for _, rule := range rules.rules {
+		if previous, ok := e.states[rule.Name]; ok {
+			states[rule.Name] = previous
+		}
+	}
+	e.states = states
+	return true, nil
+}
+
+// Watch reloads the rules file every interval until ctx is cancelled.
+func (e *Engine) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := e.Reload()
+		if err != nil {
+			log.Printf("Failed to reload rules, keeping the previous ones: %v", err)
+		} else if reloaded {
+			e.mu.Lock()
+			log.Printf("Loaded %d rules from %s", e.rules.Len(), e.path)
+			e.mu.Unlock()
+		}
+	}
+}
+
+// Wait blocks until the webhooks and commands started so far have finished.
+func (e *Engine) Wait() {
+	e.running.Wait()
+}
+
+// evaluate is the commit hook: it fires the rules the new events meet.
+func (e *Engine) evaluate(events []models.StoredEvent) {
+	e.mu.Lock()
+	rules := e.rules
+	e.mu.Unlock()
+	if rules.Len() == 0 {
+		return
+	}
+	for _, event := range events {
+		for _, rule := range rules.rules {
+			firing, ok, err := e.check(rule, event)
+			if err != nil {
+				log.Printf("Rule %s failed: %v", rule.Name, err)
+				continue
+			}
+			if ok {
+				e.fire(rule, firing)
+			}
+		}
+	}
+}
+
+// check decides whether rule fires for event, updating its state.
+func (e *Engine) check(rule *compiled, event models.StoredEvent) (Firing, bool, error) {
+	firing := Firing{Rule: rule.Name, Event: event}
+	if !rule.matches(event) {
+		return firing, false, nil
+	}
+	now := e.now()
+	e.mu.Lock()
+	current := e.states[rule.Name]
+	if current == nil {
+		current = &state{}
+		e.states[rule.Name] = current
+	}
+	if rule.Count != nil {
+		cutoff := event.TSUTC - time.Duration(rule.Count.Within).Milliseconds()
+		window := current.window[:0]
+		for _, ts := range current.window {
+			if ts > cutoff {
+				window = append(window, ts)
+			}
+		}
+		current.window = append(window, event.TSUTC)
+		firing.Count = len(current.window)
+		if firing.Count <= rule.Count.Over {
+			e.mu.Unlock()
+			return firing, false, nil
+		}
+	}
+	// The user's day, which is how time spent is rolled up.
+	day := event.LocalDate
+	if day == "" {
+		day = event.Event.LocalDate(models.DefaultLocation)
+	}
+	if rule.TimeToday != nil && current.firedDay == day {
+		e.mu.Unlock()
+		return firing, false, nil
+	}
+	if rule.Cooldown > 0 && !current.lastFired.IsZero() && now.Sub(current.lastFired) < time.Duration(rule.Cooldown) {
+		e.mu.Unlock()
+		return firing, false, nil
+	}
+	e.mu.Unlock()
+
+	if rule.TimeToday != nil {
+		spent, err := e.timeOn(rule, day)
+		if err != nil {
+			return firing, false, err
+		}
+		firing.TimeTodayMS = spent
+		if spent <= time.Duration(rule.TimeToday.Over).Milliseconds() {
+			return firing, false, nil
+		}
+	}
+
+	e.mu.Lock()
+	current.lastFired = now
+	current.window = nil
+	if rule.TimeToday != nil {
+		current.firedDay = day
+	}
+	e.mu.Unlock()
+	firing.FiredTSUTC = now.UnixMilli()
+	return firing, true, nil
+}
+
+// timeOn sums the time spent on the rule's time_today domains on day.
+func (e *Engine) timeOn(rule *compiled, day string) (int64, error) {
+	if e.tracker == nil {
+		return 0, nil
+	}
+	// TimeSpent selects the rollup days a range overlaps in Location, so the
+	// range must run from midnight to midnight in that same zone.
+	start, err := time.ParseInLocation(time.DateOnly, day, models.DefaultLocation)
+	if err != nil {
+		return 0, err
+	}
+	buckets, err := e.tracker.TimeSpent(context.Background(), analytics.Query{
+		Group:    analytics.GroupDay,
+		By:       analytics.ByDomain,
+		Since:    start.UnixMilli(),
+		Until:    start.AddDate(0, 0, 1).UnixMilli(),
+		Limit:    1 << 30,
+		Location: models.DefaultLocation,
+	})
+	if err != nil {
+		return 0, err
+	}
+	var spent int64
+	for _, bucket := range buckets {
+		for _, item := range bucket.Items {
+			if rule.timeSites.BlocksHost(item.Key) {
+				spent += item.MS
+			}
+		}
+	}
+	return spent, nil
+}
+
+// fire runs the rule's actions. Notifications are stored right away;
+// webhooks and commands run in the background so that recording events does
+// not wait for them.
+func (e *Engine) fire(rule *compiled, firing Firing) {
+	log.Printf("Rule %s fired on event %s", rule.Name, firing.Event.UID)
+	for i, action := range rule.Actions {
+		firing := firing
+		if message := rule.messages[i]; message != nil {
+			var text strings.Builder
+			if err := message.Execute(&text, firing); err != nil {
+				log.Printf("Rule %s: failed to render message: %v", rule.Name, err)
+				continue
+			}
+			firing.Message = text.String()
+		}
+		switch action.Type {
+		case ActionNotify:
+			if err := e.notify(firing); err != nil {
+				log.Printf("Rule %s: %v", rule.Name, err)
+			}
+		case ActionWebhook, ActionCommand:
+			e.running.Add(1)
+			go func() {
+				defer e.running.Done()
+				run := e.callWebhook
+				if action.Type == ActionCommand {
+					run = e.runCommand
+				}
+				if err := run(action, firing); err != nil {
+					log.Printf("Rule %s: %v", rule.Name, err)
+				}
+			}()
+		}
+	}
+}
+
+func (e *Engine) notify(firing Firing) error {
+	_, err := e.db.DB().Exec(`INSERT INTO notifications(rule, message, event_uid, created_ts) VALUES(?,?,?,?)`,
+		firing.Rule, e.db.Seal(firing.Message), firing.Event.UID, firing.FiredTSUTC)
+	if err != nil {
+		return fmt.Errorf("failed to store notification: %w", err)
+	}
+	return nil
+}
+
+func (e *Engine) callWebhook(action Action, firing Firing) error {
+	body, err := json.Marshal(firing)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+	request, err := http.NewRequest(http.MethodPost, action.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range action.Headers {
+		request.Header.Set(name, value)
+	}
+	response, err := e.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("webhook failed: %w", err)
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", response.Status)
+	}
+	return nil
+}
+
+// runCommand runs the action's program directly, without a shell, with the
+// firing as JSON on stdin and the rule's name and message in the environment.
+func (e *Engine) runCommand(action Action, firing Firing) error {
+	body, err := json.Marshal(firing)
+	if err != nil {
+		return fmt.Errorf("failed to encode command input: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), actionTimeout)
+	defer cancel()
+	command := exec.CommandContext(ctx, action.Command[0], action.Command[1:]...)
+	command.Stdin = bytes.NewReader(body)
+	command.Env = append(os.Environ(), "BROWSETRACE_RULE="+firing.Rule, "BROWSETRACE_MESSAGE="+firing.Message)
+	if output, err := command.CombinedOutput(); err != nil {
+		return fmt.Errorf("command %s failed: %w: %s", action.Command[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Notifications lists stored notifications, newest first, optionally only
+// unread ones. A limit of 0 lists them all.
+func (e *Engine) Notifications(ctx context.Context, unread bool, limit int) ([]Notification, error) {
+	query := `SELECT id, rule, message, event_uid, created_ts, read_ts FROM notifications`
+	if unread {
+		query += ` WHERE read_ts IS NULL`
+	}
+	query += ` ORDER BY id DESC`
+	var args []any
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := e.db.DB().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notifications: %w", err)
+	}
+	defer rows.Close()
+	notifications := []Notification{}
+	for rows.Next() {
+		var notification Notification
+		var eventUID sql.NullString
+		var readTS sql.NullInt64
+		if err := rows.Scan(&notification.ID, &notification.Rule, &notification.Message, &eventUID,
+			&notification.CreatedTSUTC, &readTS); err != nil {
+			return nil, fmt.Errorf("failed to scan notification: %w", err)
+		}
+		if notification.Message, err = e.db.Unseal(notification.Message); err != nil {
+			return nil, fmt.Errorf("failed to decrypt notification: %w", err)
+		}
+		notification.EventUID = eventUID.String
+		if readTS.Valid {
+			notification.ReadTSUTC = &readTS.Int64
+		}
+		notifications = append(notifications, notification)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read notifications: %w", err)
+	}
+	return notifications, nil
+}
+
+// MarkRead marks notifications up to and including id as read and returns
+// how many were unread.
+func (e *Engine) MarkRead(ctx context.Context, id int64) (int64, error) {
+	result, err := e.db.DB().ExecContext(ctx, `UPDATE notifications SET read_ts = ? WHERE id <= ? AND read_ts IS NULL`,
+		e.now().UnixMilli(), id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark notifications read: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// reencrypt reseals notification messages when the database key changes.
+func reencrypt(transaction *sql.Tx, reseal func(string) (string, error)) error {
+	rows, err := transaction.Query(`SELECT id, message FROM notifications`)
+	if err != nil {
+		return fmt.Errorf("failed to query notifications: %w", err)
+	}
+	resealed := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var message string
+		if err := rows.Scan(&id, &message); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan notification: %w", err)
+		}
+		if resealed[id], err = reseal(message); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to decrypt notification: %w", err)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read notifications: %w", err)
+	}
+	for id, message := range resealed {
+		if _, err := transaction.Exec(`UPDATE notifications SET message = ? WHERE id = ?`, message, id); err != nil {
+			return fmt.Errorf("failed to update notification: %w", err)
+		}
+	}
+	return nil
+}
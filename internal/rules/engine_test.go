@@ -0,0 +1,297 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/analytics"
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// setupTestEngine opens a database with a rules file in its directory.
+func setupTestEngine(t *testing.T, rules string) (*database.Database, *Engine, string, func()) {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "browsetrace-rules-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	db, err := database.NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+	tracker, err := analytics.New(db, time.Hour)
+	if err != nil {
+		cleanup()
+		t.Fatalf("analytics.New() error = %v", err)
+	}
+	path := filepath.Join(tmpDir, "rules.json")
+	if rules != "" {
+		if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+			cleanup()
+			t.Fatalf("Failed to write rules: %v", err)
+		}
+	}
+	engine, err := New(db, tracker, path)
+	if err != nil {
+		cleanup()
+		t.Fatalf("New() error = %v", err)
+	}
+	return db, engine, path, cleanup
+}
+
+func record(t *testing.T, db *database.Database, events ...models.Event) {
+	t.Helper()
+
+	for i := range events {
+		if events[i].Data == nil {
+			events[i].Data = map[string]any{}
+		}
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+}
+
+func notifications(t *testing.T, engine *Engine) []Notification {
+	t.Helper()
+
+	list, err := engine.Notifications(context.Background(), false, 0)
+	if err != nil {
+		t.Fatalf("Notifications() error = %v", err)
+	}
+	return list
+}
+
+func TestNotifyAction(t *testing.T) {
+	db, engine, _, cleanup := setupTestEngine(t, `{"rules": [{
+		"name": "docs", "types": ["navigate"], "domains": ["go.dev"],
+		"actions": [{"type": "notify", "message": "Reading {{.Event.URL}}"}]}]}`)
+	defer cleanup()
+
+	record(t, db,
+		models.Event{TSUTC: 1000, URL: "https://go.dev/doc", Type: "navigate"},
+		models.Event{TSUTC: 2000, URL: "https://go.dev/doc", Type: "click"},
+		models.Event{TSUTC: 3000, URL: "https://example.com/", Type: "navigate"},
+	)
+	// History imports are not new activity.
+	if _, err := db.ImportEvents([]models.Event{{TSUTC: 500, URL: "https://go.dev/", Type: "navigate"}}); err != nil {
+		t.Fatalf("ImportEvents() error = %v", err)
+	}
+
+	list := notifications(t, engine)
+	if len(list) != 1 || list[0].Message != "Reading https://go.dev/doc" || list[0].Rule != "docs" || list[0].EventUID == "" {
+		t.Fatalf("Expected one notification for the docs page, got %+v", list)
+	}
+	if marked, err := engine.MarkRead(context.Background(), list[0].ID); err != nil || marked != 1 {
+		t.Errorf("Expected 1 notification marked read, got %d, %v", marked, err)
+	}
+	if unread, err := engine.Notifications(context.Background(), true, 0); err != nil || len(unread) != 0 {
+		t.Errorf("Expected no unread notifications, got %+v, %v", unread, err)
+	}
+}
+
+func TestWebhookAction(t *testing.T) {
+	var mu sync.Mutex
+	var received []Firing
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var firing Firing
+		if err := json.NewDecoder(req.Body).Decode(&firing); err != nil || req.Header.Get("X-Token") != "secret" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, firing)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	db, engine, _, cleanup := setupTestEngine(t, `{"rules": [{
+		"name": "invoices", "text": "(?i)invoice",
+		"actions": [{"type": "webhook", "url": "`+hook.URL+`", "headers": {"X-Token": "secret"}, "message": "{{.Rule}}!"}]}]}`)
+	defer cleanup()
+
+	record(t, db, models.Event{TSUTC: 1000, URL: "https://example.com/", Type: "visible_text", Data: map[string]any{"text": "Invoice #42"}})
+	engine.Wait()
+	if len(received) != 1 || received[0].Rule != "invoices" || received[0].Message != "invoices!" ||
+		received[0].Event.URL != "https://example.com/" {
+		t.Errorf("Expected the firing posted to the webhook, got %+v", received)
+	}
+}
+
+func TestCommandAction(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh to run commands with")
+	}
+	db, engine, path, cleanup := setupTestEngine(t, "")
+	defer cleanup()
+	output := filepath.Join(filepath.Dir(path), "fired.json")
+	rules := `{"rules": [{"name": "any", "actions": [{"type": "command",
+		"command": ["sh", "-c", "cat > ` + output + `; echo \"$BROWSETRACE_RULE\" >> ` + output + `"]}]}]}`
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatalf("Failed to write rules: %v", err)
+	}
+	if reloaded, err := engine.Reload(); err != nil || !reloaded {
+		t.Fatalf("Expected the new rules file to load, got %v, %v", reloaded, err)
+	}
+
+	record(t, db, models.Event{TSUTC: 1000, URL: "https://example.com/", Type: "navigate"})
+	engine.Wait()
+	written, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Expected the command to run: %v", err)
+	}
+	if !strings.Contains(string(written), `"url":"https://example.com/"`) || !strings.HasSuffix(string(written), "any\n") {
+		t.Errorf("Expected the firing on stdin and the rule in the environment, got %s", written)
+	}
+}
+
+func TestCountRule(t *testing.T) {
+	db, engine, _, cleanup := setupTestEngine(t, `{"rules": [{
+		"name": "busy", "types": ["click"], "count": {"over": 2, "within": "1m"},
+		"actions": [{"type": "notify", "message": "{{.Count}} clicks"}]}]}`)
+	defer cleanup()
+
+	for _, ts := range []int64{0, 10_000, 70_000, 80_000, 90_000, 100_000} {
+		record(t, db, models.Event{TSUTC: 1_000_000 + ts, URL: "https://example.com/", Type: "click"})
+	}
+	// The first two clicks fall out of the window before the third arrives;
+	// after firing on the fifth, the count starts over.
+	list := notifications(t, engine)
+	if len(list) != 1 || list[0].Message != "3 clicks" {
+		t.Errorf("Expected one notification for 3 clicks, got %+v", list)
+	}
+}
+
+func TestTimeTodayRule(t *testing.T) {
+	db, engine, _, cleanup := setupTestEngine(t, `{"rules": [{
+		"name": "social", "time_today": {"domains": ["twitter.com"], "over": "30m"},
+		"actions": [{"type": "notify", "message": "{{.TimeToday}} on social media"}]}]}`)
+	defer cleanup()
+	day := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	at := func(minutes int) int64 { return day.Add(time.Duration(minutes) * time.Minute).UnixMilli() }
+
+	record(t, db, models.Event{TSUTC: at(0), URL: "https://twitter.com/home", Type: "navigate"})
+	record(t, db, models.Event{TSUTC: at(20), URL: "https://mobile.twitter.com/home", Type: "scroll"})
+	if list := notifications(t, engine); len(list) != 0 {
+		t.Fatalf("Expected no notification after 20 minutes, got %+v", list)
+	}
+	record(t, db, models.Event{TSUTC: at(40), URL: "https://go.dev/", Type: "navigate"})
+	record(t, db, models.Event{TSUTC: at(50), URL: "https://twitter.com/home", Type: "navigate"})
+	record(t, db, models.Event{TSUTC: at(90), URL: "https://go.dev/", Type: "navigate"})
+
+	list := notifications(t, engine)
+	if len(list) != 1 || list[0].Message != "40m0s on social media" {
+		t.Errorf("Expected one notification for the day, got %+v", list)
+	}
+	// A new day starts a new allowance.
+	record(t, db, models.Event{TSUTC: at(24 * 60), URL: "https://twitter.com/home", Type: "navigate"})
+	record(t, db, models.Event{TSUTC: at(24*60 + 45), URL: "https://go.dev/", Type: "navigate"})
+	if list := notifications(t, engine); len(list) != 2 {
+		t.Errorf("Expected a second notification the next day, got %+v", list)
+	}
+}
+
+func TestTimeTodayRuleOutsideUTC(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+	saved := models.DefaultLocation
+	models.DefaultLocation = newYork
+	defer func() { models.DefaultLocation = saved }()
+
+	db, engine, _, cleanup := setupTestEngine(t, `{"rules": [{
+		"name": "social", "time_today": {"domains": ["twitter.com"], "over": "30m"},
+		"actions": [{"type": "notify", "message": "{{.TimeToday}} on social media"}]}]}`)
+	defer cleanup()
+	// Twenty minutes each on two days in New York stay under the allowance
+	// of either day.
+	for _, day := range []time.Time{
+		time.Date(2024, 3, 1, 9, 0, 0, 0, newYork),
+		time.Date(2024, 3, 2, 9, 0, 0, 0, newYork),
+	} {
+		record(t, db, models.Event{TSUTC: day.UnixMilli(), URL: "https://twitter.com/home", Type: "navigate"})
+		record(t, db, models.Event{TSUTC: day.Add(20 * time.Minute).UnixMilli(), URL: "https://go.dev/", Type: "navigate"})
+		record(t, db, models.Event{TSUTC: day.Add(30 * time.Minute).UnixMilli(), URL: "https://go.dev/", Type: "click"})
+	}
+	if list := notifications(t, engine); len(list) != 0 {
+		t.Errorf("Expected no notification for 20 minutes a day, got %+v", list)
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	db, engine, _, cleanup := setupTestEngine(t, `{"rules": [{
+		"name": "any", "cooldown": "1h", "actions": [{"type": "notify", "message": "seen"}]}]}`)
+	defer cleanup()
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	engine.now = func() time.Time { return now }
+
+	record(t, db, models.Event{TSUTC: 1000, URL: "https://example.com/", Type: "navigate"})
+	now = now.Add(30 * time.Minute)
+	record(t, db, models.Event{TSUTC: 2000, URL: "https://example.com/", Type: "navigate"})
+	now = now.Add(time.Hour)
+	record(t, db, models.Event{TSUTC: 3000, URL: "https://example.com/", Type: "navigate"})
+	if list := notifications(t, engine); len(list) != 2 {
+		t.Errorf("Expected 2 notifications an hour apart, got %d", len(list))
+	}
+}
+
+func TestReload(t *testing.T) {
+	db, engine, path, cleanup := setupTestEngine(t, "")
+	defer cleanup()
+
+	record(t, db, models.Event{TSUTC: 1000, URL: "https://example.com/", Type: "navigate"})
+	if reloaded, err := engine.Reload(); err != nil || reloaded {
+		t.Errorf("Expected nothing to reload without a file, got %v, %v", reloaded, err)
+	}
+
+	valid := `{"rules": [{"name": "any", "actions": [{"type": "notify", "message": "seen"}]}]}`
+	if err := os.WriteFile(path, []byte(valid), 0o600); err != nil {
+		t.Fatalf("Failed to write rules: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		engine.Watch(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(notifications(t, engine)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the watched rules file to be loaded")
+		}
+		record(t, db, models.Event{TSUTC: 2000, URL: "https://example.com/", Type: "navigate"})
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	// A broken edit keeps the rules in force.
+	if err := os.WriteFile(path, []byte(`{"rules": [`), 0o600); err != nil {
+		t.Fatalf("Failed to write rules: %v", err)
+	}
+	if _, err := engine.Reload(); err == nil {
+		t.Error("Expected an invalid rules file to fail to load")
+	}
+	before := len(notifications(t, engine))
+	record(t, db, models.Event{TSUTC: 3000, URL: "https://example.com/", Type: "navigate"})
+	if after := len(notifications(t, engine)); after != before+1 {
+		t.Errorf("Expected the previous rules to keep firing, got %d then %d notifications", before, after)
+	}
+}
@@ -0,0 +1,308 @@
+// Package rules runs user-defined actions when recorded events meet
+// conditions: a webhook is called, a local command run, or a notification
+// stored for the user to read later.
+//
+// Rules live in a JSON file:
+//
+//	{"rules": [{
+//	  "name": "social-media",
+//	  "time_today": {"domains": ["twitter.com", "reddit.com"], "over": "30m"},
+//	  "actions": [{"type": "notify", "message": "{{.TimeToday}} on social media today"}]
+//	}, {
+//	  "name": "invoices",
+//	  "types": ["visible_text"],
+//	  "url": "https://*.example.com/*",
+//	  "text": "(?i)invoice",
+//	  "cooldown": "1h",
+//	  "actions": [{"type": "webhook", "url": "https://hooks.example/invoices"},
+//	              {"type": "command", "command": ["notify-send", "Invoice seen"]}]
+//	}]}
+//
+// A rule matches an event when every condition it sets holds. Rules are
+// evaluated after events are committed, and the file is reloaded when it
+// changes.
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/privacy"
+)
+
+// Action kinds.
+const (
+	ActionWebhook = "webhook"
+	ActionCommand = "command"
+	ActionNotify  = "notify"
+)
+
+// Duration is a time.Duration written as a string such as "30m" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("durations are strings such as \"30m\": %w", err)
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Rule is one entry of the rules file.
+type Rule struct {
+	Name  string   `json:"name"`
+	Types []string `json:"types,omitempty"`
+	// URL is a pattern on the full URL in which * matches any run of
+	// characters. Case is ignored.
+	URL string `json:"url,omitempty"`
+	// Domains matches events on these domains and their subdomains.
+	Domains []string `json:"domains,omitempty"`
+	// Text is a regular expression matched against the string values in the
+	// event's data, or only the one at the dot-separated path Field.
+	Text  string `json:"text,omitempty"`
+	Field string `json:"field,omitempty"`
+	// Count fires once more than Over matching events arrive within Within.
+	Count *Rate `json:"count,omitempty"`
+	// TimeToday fires once a day, the event's local one, when the time spent
+	// on its domains that day goes past Over.
+	TimeToday *TimeLimit `json:"time_today,omitempty"`
+	// Cooldown is the least time between two firings.
+	Cooldown Duration `json:"cooldown,omitempty"`
+	Actions  []Action `json:"actions"`
+}
+
+type Rate struct {
+	Over   int      `json:"over"`
+	Within Duration `json:"within"`
+}
+
+type TimeLimit struct {
+	Domains []string `json:"domains"`
+	Over    Duration `json:"over"`
+}
+
+// Action is what a rule does when it fires. Message is a text/template
+// executed with a Firing; a webhook posts the Firing as JSON and a command
+// gets it on stdin.
+type Action struct {
+	Type    string            `json:"type"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Command []string          `json:"command,omitempty"`
+	Message string            `json:"message,omitempty"`
+}
+
+// File is the layout of the rules file.
+type File struct {
+	Rules []Rule `json:"rules"`
+}
+
+// compiled is a validated rule, ready to match events.
+type compiled struct {
+	Rule
+	url       *regexp.Regexp
+	domains   *privacy.Blocklist // used as a set of domains
+	text      *regexp.Regexp
+	field     []string
+	timeSites *privacy.Blocklist
+	messages  []*template.Template // per action; nil without a message
+}
+
+// Set is a validated rules file, ready to match events.
+type Set struct {
+	rules []*compiled
+}
+
+// Len returns the number of rules in the set.
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.rules)
+}
+
+// Parse reads and validates a rules file.
+func Parse(r io.Reader) (*Set, error) {
+	var file File
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+	names := make(map[string]bool)
+	rules := make([]*compiled, 0, len(file.Rules))
+	for i, rule := range file.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d: missing name", i+1)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %d: duplicate name %q", i+1, rule.Name)
+		}
+		names[rule.Name] = true
+		compiledRule, err := compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		rules = append(rules, compiledRule)
+	}
+	return &Set{rules: rules}, nil
+}
+
+// Load reads a rules file. A missing file means no rules.
+func Load(path string) (*Set, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Set{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rules: %w", err)
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+func compile(rule Rule) (*compiled, error) {
+	c := &compiled{Rule: rule}
+	var err error
+	if rule.URL != "" {
+		pattern := strings.ReplaceAll(regexp.QuoteMeta(rule.URL), `\*`, `.*`)
+		c.url = regexp.MustCompile(`(?i)^` + pattern + `$`)
+	}
+	if len(rule.Domains) > 0 {
+		c.domains = privacy.New(rule.Domains)
+	}
+	if rule.Text != "" {
+		if c.text, err = regexp.Compile(rule.Text); err != nil {
+			return nil, fmt.Errorf("invalid text pattern: %w", err)
+		}
+	} else if rule.Field != "" {
+		return nil, errors.New("field needs a text pattern")
+	}
+	if rule.Field != "" {
+		c.field = strings.Split(rule.Field, ".")
+	}
+	if rule.Count != nil && (rule.Count.Over < 0 || rule.Count.Within <= 0) {
+		return nil, errors.New("count needs a non-negative over and a positive within")
+	}
+	if rule.TimeToday != nil {
+		if len(rule.TimeToday.Domains) == 0 || rule.TimeToday.Over <= 0 {
+			return nil, errors.New("time_today needs domains and a positive over")
+		}
+		c.timeSites = privacy.New(rule.TimeToday.Domains)
+	}
+	if len(rule.Actions) == 0 {
+		return nil, errors.New("no actions")
+	}
+	for i, action := range rule.Actions {
+		switch action.Type {
+		case ActionWebhook:
+			if parsed, err := url.Parse(action.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+				return nil, fmt.Errorf("action %d: webhook needs an http or https url", i+1)
+			}
+		case ActionCommand:
+			if len(action.Command) == 0 {
+				return nil, fmt.Errorf("action %d: command needs a program to run", i+1)
+			}
+		case ActionNotify:
+			if action.Message == "" {
+				return nil, fmt.Errorf("action %d: notify needs a message", i+1)
+			}
+		default:
+			return nil, fmt.Errorf("action %d: unknown type %q (want webhook, command or notify)", i+1, action.Type)
+		}
+		var message *template.Template
+		if action.Message != "" {
+			if message, err = template.New(rule.Name).Parse(action.Message); err != nil {
+				return nil, fmt.Errorf("action %d: invalid message: %w", i+1, err)
+			}
+		}
+		c.messages = append(c.messages, message)
+	}
+	return c, nil
+}
+
+// matches checks the conditions on the event itself; Count and TimeToday
+// are checked by the engine, which keeps their state.
+func (c *compiled) matches(event models.StoredEvent) bool {
+	if len(c.Types) > 0 && !contains(c.Types, event.Type) {
+		return false
+	}
+	if c.url != nil && !c.url.MatchString(event.URL) {
+		return false
+	}
+	if c.domains != nil && !c.domains.Blocks(event.URL) {
+		return false
+	}
+	if c.text != nil {
+		var value any = event.Data
+		if c.field != nil {
+			value = lookupPath(event.Data, c.field)
+		}
+		if !matchesText(c.text, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupPath walks nested JSON objects along path, returning nil when any
+// segment is missing.
+func lookupPath(data map[string]any, path []string) any {
+	var current any = data
+	for _, key := range path {
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil
+		}
+		current = object[key]
+	}
+	return current
+}
+
+// matchesText reports whether pattern matches any string within value.
+func matchesText(pattern *regexp.Regexp, value any) bool {
+	switch typed := value.(type) {
+	case string:
+		return pattern.MatchString(typed)
+	case map[string]any:
+		for _, nested := range typed {
+			if matchesText(pattern, nested) {
+				return true
+			}
+		}
+	case []any:
+		for _, nested := range typed {
+			if matchesText(pattern, nested) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"empty", `{"rules": []}`, ""},
+		{"notify", `{"rules": [{"name": "a", "types": ["navigate"], "actions": [{"type": "notify", "message": "hi"}]}]}`, ""},
+		{"every condition", `{"rules": [{"name": "a", "url": "https://*.example.com/*", "domains": ["example.com"],
+			"text": "(?i)invoice", "field": "text", "count": {"over": 3, "within": "10m"},
+			"time_today": {"domains": ["twitter.com"], "over": "30m"}, "cooldown": "1h",
+			"actions": [{"type": "webhook", "url": "https://hooks.example/"}, {"type": "command", "command": ["true"]}]}]}`, ""},
+		{"missing name", `{"rules": [{"actions": [{"type": "notify", "message": "hi"}]}]}`, "missing name"},
+		{"duplicate name", `{"rules": [{"name": "a", "actions": [{"type": "notify", "message": "hi"}]},
+			{"name": "a", "actions": [{"type": "notify", "message": "hi"}]}]}`, "duplicate name"},
+		{"no actions", `{"rules": [{"name": "a"}]}`, "no actions"},
+		{"unknown action", `{"rules": [{"name": "a", "actions": [{"type": "email"}]}]}`, "unknown type"},
+		{"webhook without url", `{"rules": [{"name": "a", "actions": [{"type": "webhook", "url": "file:///etc/passwd"}]}]}`, "http or https"},
+		{"bad pattern", `{"rules": [{"name": "a", "text": "(", "actions": [{"type": "notify", "message": "hi"}]}]}`, "invalid text pattern"},
+		{"bad template", `{"rules": [{"name": "a", "actions": [{"type": "notify", "message": "{{.Nope"}]}]}`, "invalid message"},
+		{"bad duration", `{"rules": [{"name": "a", "cooldown": 60, "actions": [{"type": "notify", "message": "hi"}]}]}`, "durations are strings"},
+		{"time without domains", `{"rules": [{"name": "a", "time_today": {"over": "1h"}, "actions": [{"type": "notify", "message": "hi"}]}]}`, "time_today"},
+		{"unknown field", `{"rules": [{"name": "a", "typo": true, "actions": [{"type": "notify", "message": "hi"}]}]}`, "unknown field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.input))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Parse() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	event := models.StoredEvent{Event: models.Event{
+		URL:  "https://billing.example.com/invoices/42",
+		Type: "visible_text",
+		Data: map[string]any{"text": "Your Invoice is ready", "meta": map[string]any{"lang": "en"}},
+	}}
+	tests := []struct {
+		name string
+		rule Rule
+		want bool
+	}{
+		{"no conditions", Rule{}, true},
+		{"type", Rule{Types: []string{"navigate", "visible_text"}}, true},
+		{"other type", Rule{Types: []string{"navigate"}}, false},
+		{"url pattern", Rule{URL: "https://*.EXAMPLE.com/invoices/*"}, true},
+		{"url pattern anchored", Rule{URL: "https://example.com/*"}, false},
+		{"domain", Rule{Domains: []string{"example.com"}}, true},
+		{"other domain", Rule{Domains: []string{"example.org"}}, false},
+		{"text anywhere", Rule{Text: "(?i)invoice"}, true},
+		{"nested text", Rule{Text: "^en$"}, true},
+		{"text in field", Rule{Text: "^en$", Field: "meta.lang"}, true},
+		{"text not in field", Rule{Text: "(?i)invoice", Field: "meta.lang"}, false},
+		{"all conditions", Rule{Types: []string{"visible_text"}, Domains: []string{"example.com"}, Text: "ready"}, true},
+		{"one condition fails", Rule{Types: []string{"visible_text"}, Domains: []string{"example.com"}, Text: "overdue"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name = tt.name
+			tt.rule.Actions = []Action{{Type: ActionNotify, Message: "hi"}}
+			rule, err := compile(tt.rule)
+			if err != nil {
+				t.Fatalf("compile() error = %v", err)
+			}
+			if got := rule.matches(event); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
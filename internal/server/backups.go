@@ -0,0 +1,32 @@
+package server
+
+import (
+	"log"
+	"net/http"
+)
+
+// handleBackups lists backups on GET and takes one on POST.
+func (s *Server) handleBackups(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		backups, err := s.backups.List()
+		if err != nil {
+			log.Printf("Backup error: %v", err)
+			http.Error(w, "Failed to list backups", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{"backups": backups})
+	case http.MethodPost:
+		backup, err := s.backups.Backup(req.Context())
+		if err != nil {
+			log.Printf("Backup error: %v", err)
+			http.Error(w, "Failed to back up database", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, backup)
+	default:
+		http.Error(w, "GET or POST only", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/backup"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func TestHandleBackups(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	directory, err := os.MkdirTemp("", "browsetrace-backups-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(directory)
+	WithBackups(backup.NewManager(sqliteDB(server), directory, backup.DefaultPolicy, 0))(server)
+	mux := server.setupRoutes()
+
+	events := []models.Event{{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com/", Type: "navigate", Data: map[string]any{}}}
+	if err := sqliteDB(server).InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/backups", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created backup.Backup
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if created.Name == "" || created.Size == 0 {
+		t.Errorf("Unexpected backup: %+v", created)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/backups", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var body struct {
+		Backups []backup.Backup `json:"backups"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if len(body.Backups) != 1 || body.Backups[0].Name != created.Name {
+		t.Errorf("Expected the new backup to be listed, got %+v", body.Backups)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/backups", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/capture"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// maxCaptureWait bounds how long GET /capture holds a poll open.
+const maxCaptureWait = time.Minute
+
+// requireJSON refuses a request whose body is not declared as JSON. A web page
+// can send a form or text/plain POST to the agent without asking first; an
+// application/json one needs a CORS preflight, which the agent never grants,
+// so this keeps other sites from switching capture on or off.
+func requireJSON(w http.ResponseWriter, req *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return false
+	}
+	return true
+}
+
+// handleCapture returns the capture state for the extension to show. With
+// ?version= set to the version it last saw and ?wait= a duration, it holds
+// the request until the state changes or the wait is over, so that the
+// extension can long-poll instead of asking every few seconds.
+func (s *Server) handleCapture(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	query := req.URL.Query()
+	var wait time.Duration
+	if value := query.Get("wait"); value != "" {
+		var err error
+		if wait, err = time.ParseDuration(value); err != nil || wait < 0 {
+			http.Error(w, "invalid wait: "+value, http.StatusBadRequest)
+			return
+		}
+		wait = min(wait, maxCaptureWait)
+	}
+	var version int64
+	if value := query.Get("version"); value != "" {
+		var err error
+		if version, err = strconv.ParseInt(value, 10, 64); err != nil {
+			http.Error(w, "invalid version: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+
+	var state capture.State
+	var err error
+	if wait > 0 && version > 0 {
+		// Polls can outlive the server-wide write timeout.
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		ctx, cancel := context.WithTimeout(req.Context(), wait)
+		state, err = s.capture.Wait(ctx, version)
+		cancel()
+	} else {
+		state, err = s.capture.State(req.Context())
+	}
+	if err != nil {
+		log.Printf("Capture state error: %v", err)
+		http.Error(w, "Failed to read capture state", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, state)
+}
+
+// handleCapturePause stops recording. Like the other capture switches it
+// takes only application/json requests. The optional body gives the end of the
+// pause, as {"for": "30m"} or {"until": <timestamp>}; without one the pause
+// lasts until resumed.
+func (s *Server) handleCapturePause(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireJSON(w, req) {
+		return
+	}
+	var body struct {
+		For   string `json:"for"`
+		Until string `json:"until"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+	var until time.Time
+	switch {
+	case body.For != "" && body.Until != "":
+		http.Error(w, "give for or until, not both", http.StatusBadRequest)
+		return
+	case body.For != "":
+		duration, err := time.ParseDuration(body.For)
+		if err != nil || duration <= 0 {
+			http.Error(w, "invalid for: "+body.For, http.StatusBadRequest)
+			return
+		}
+		until = time.Now().Add(duration)
+	case body.Until != "":
+		milliseconds, err := models.ParseTimestamp(body.Until)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if until = time.UnixMilli(milliseconds); !until.After(time.Now()) {
+			http.Error(w, "until must be in the future", http.StatusBadRequest)
+			return
+		}
+	}
+	state, err := s.capture.Pause(req.Context(), until)
+	if err != nil {
+		log.Printf("Capture switch error: %v", err)
+		http.Error(w, "Failed to pause capture", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Capture paused")
+	writeJSON(w, state)
+}
+
+// handleCaptureResume starts recording again.
+func (s *Server) handleCaptureResume(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireJSON(w, req) {
+		return
+	}
+	state, err := s.capture.Resume(req.Context())
+	if err != nil {
+		log.Printf("Capture switch error: %v", err)
+		http.Error(w, "Failed to resume capture", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Capture resumed")
+	writeJSON(w, state)
+}
+
+// handleCaptureWindows marks a browser window private, so that none of its
+// events are recorded, or records it again:
+// {"client_id": "...", "window_id": 7, "private": true}.
+func (s *Server) handleCaptureWindows(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireJSON(w, req) {
+		return
+	}
+	var body struct {
+		ClientID string `json:"client_id"`
+		WindowID *int64 `json:"window_id"`
+		Private  bool   `json:"private"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+	if body.WindowID == nil {
+		http.Error(w, "window_id is required", http.StatusBadRequest)
+		return
+	}
+	state, err := s.capture.SetPrivate(req.Context(), body.ClientID, *body.WindowID, body.Private)
+	if err != nil {
+		log.Printf("Capture switch error: %v", err)
+		http.Error(w, "Failed to update private windows", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, state)
+}
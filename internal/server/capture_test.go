@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/capture"
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func TestHandleCapture(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	sw, err := capture.New(sqliteDB(server))
+	if err != nil {
+		t.Fatalf("capture.New() error = %v", err)
+	}
+	WithCapture(sw)(server)
+	mux := server.setupRoutes()
+
+	do := func(method, path, body string) (int, capture.State) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		var state capture.State
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil {
+				t.Fatalf("Invalid JSON response: %v", err)
+			}
+		}
+		return w.Code, state
+	}
+	post := func(events ...models.Event) {
+		t.Helper()
+		jsonData, _ := json.Marshal(models.Batch{Events: events})
+		req := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(jsonData))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", w.Code)
+		}
+	}
+	stored := func() int {
+		t.Helper()
+		events, err := sqliteDB(server).QueryEvents(t.Context(), database.EventFilter{})
+		if err != nil {
+			t.Fatalf("QueryEvents() error = %v", err)
+		}
+		return len(events)
+	}
+	windowID := int64(7)
+	event := func(ts int64, window *int64) models.Event {
+		return models.Event{TSUTC: ts, URL: "https://example.com/", Type: "navigate", Data: map[string]any{}, WindowID: window}
+	}
+
+	if code, state := do(http.MethodGet, "/capture", ""); code != http.StatusOK || !state.Recording() {
+		t.Fatalf("Expected recording, got %d %+v", code, state)
+	}
+	if code, state := do(http.MethodPost, "/capture/pause", ""); code != http.StatusOK || state.State != capture.Paused {
+		t.Fatalf("Expected paused, got %d %+v", code, state)
+	}
+	post(event(1000, nil))
+	if n := stored(); n != 0 {
+		t.Errorf("Expected no events stored while paused, got %d", n)
+	}
+
+	if code, state := do(http.MethodPost, "/capture/resume", ""); code != http.StatusOK || !state.Recording() {
+		t.Fatalf("Expected recording after resume, got %d %+v", code, state)
+	}
+	code, state := do(http.MethodPost, "/capture/windows", `{"window_id": 7, "private": true}`)
+	if code != http.StatusOK || len(state.PrivateWindows) != 1 {
+		t.Fatalf("Expected a private window, got %d %+v", code, state)
+	}
+	post(event(2000, &windowID), event(3000, nil))
+	if n := stored(); n != 1 {
+		t.Errorf("Expected only the event outside the private window stored, got %d", n)
+	}
+
+	code, state = do(http.MethodPost, "/capture/pause", `{"for": "30m"}`)
+	if code != http.StatusOK || state.PausedUntilTSUTC == nil {
+		t.Fatalf("Expected a timed pause, got %d %+v", code, state)
+	}
+	if until := time.UnixMilli(*state.PausedUntilTSUTC); time.Until(until) < 29*time.Minute {
+		t.Errorf("Expected the pause to last 30 minutes, until %v", until)
+	}
+
+	// A long poll returns once the state moves past the version it was given.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		sw.Resume(t.Context())
+	}()
+	polled, polledState := do(http.MethodGet, "/capture?wait=10s&version="+strconv.FormatInt(state.Version, 10), "")
+	if polled != http.StatusOK || !polledState.Recording() || polledState.Version != state.Version+1 {
+		t.Errorf("Expected the poll to return the resumed state, got %d %+v", polled, polledState)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/capture", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/capture?wait=soon&version=1", "", http.StatusBadRequest},
+		{http.MethodGet, "/capture/pause", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/capture/pause", `{"for": "-1m"}`, http.StatusBadRequest},
+		{http.MethodPost, "/capture/pause", `{"until": "2001-01-01"}`, http.StatusBadRequest},
+		{http.MethodPost, "/capture/pause", `{"for": "1m", "until": "2999-01-01"}`, http.StatusBadRequest},
+		{http.MethodPost, "/capture/pause", `{bad`, http.StatusBadRequest},
+		{http.MethodGet, "/capture/resume", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/capture/windows", `{"private": true}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code, _ := do(tt.method, tt.path, tt.body); code != tt.status {
+			t.Errorf("%s %s %s: Expected status %d, got %d", tt.method, tt.path, tt.body, tt.status, code)
+		}
+	}
+
+	// Requests a web page could send without a CORS preflight are refused.
+	before, err := sw.State(t.Context())
+	if err != nil {
+		t.Fatalf("State() error = %v", err)
+	}
+	for _, path := range []string{"/capture/pause", "/capture/resume", "/capture/windows"} {
+		for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"window_id": 7, "private": false}`))
+			if contentType != "" {
+				req.Header.Set("Content-Type", contentType)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if w.Code != http.StatusUnsupportedMediaType {
+				t.Errorf("POST %s as %q: Expected status %d, got %d", path, contentType, http.StatusUnsupportedMediaType, w.Code)
+			}
+		}
+	}
+	after, err := sw.State(t.Context())
+	if err != nil {
+		t.Fatalf("State() error = %v", err)
+	}
+	if after.Version != before.Version {
+		t.Errorf("Expected refused requests to leave capture alone, got version %d after %d", after.Version, before.Version)
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/llmcontext"
+	"github.com/vincentbai/browsetrace-agent/internal/sessions"
+)
+
+// defaultContextMinutes is the window used when neither a session nor a time
+// range is requested.
+const defaultContextMinutes = 30
+
+// handleContext compiles either ?session=ID or a time window (?minutes=N, or
+// since/until) into a token-budgeted text context. ?format=json returns the
+// text with its token estimate and truncation details.
+func (s *Server) handleContext(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	query := req.URL.Query()
+	filter, err := filterFromQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := llmcontext.Options{}
+	if value := query.Get("budget"); value != "" {
+		if opts.Budget, err = strconv.Atoi(value); err != nil || opts.Budget <= 0 {
+			http.Error(w, "invalid budget: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	if opts.Strategy, err = llmcontext.ParseStrategy(query.Get("strategy")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result llmcontext.Result
+	if value := query.Get("session"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid session ID", http.StatusBadRequest)
+			return
+		}
+		result, err = s.context.Session(req.Context(), id, opts)
+		if errors.Is(err, sessions.ErrNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "Failed to compile context", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		if filter.Since == 0 {
+			minutes := defaultContextMinutes
+			if value := query.Get("minutes"); value != "" {
+				if minutes, err = strconv.Atoi(value); err != nil || minutes <= 0 {
+					http.Error(w, "invalid minutes: "+value, http.StatusBadRequest)
+					return
+				}
+			}
+			filter.Since = time.Now().Add(-time.Duration(minutes) * time.Minute).UnixMilli()
+		}
+		result, err = s.context.Window(req.Context(), filter.Since, filter.Until, opts)
+		if err != nil {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "Failed to compile context", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	switch query.Get("format") {
+	case "json":
+		writeJSON(w, result)
+	case "", "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Estimated-Tokens", strconv.Itoa(result.EstimatedTokens))
+		w.Write([]byte(result.Text))
+	default:
+		http.Error(w, "invalid format: "+query.Get("format"), http.StatusBadRequest)
+	}
+}
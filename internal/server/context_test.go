@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/llmcontext"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/sessions"
+)
+
+func setupContextServer(t *testing.T) (*http.ServeMux, func()) {
+	t.Helper()
+
+	server, cleanup := setupTestServer(t)
+	sessionizer, err := sessions.New(sqliteDB(server), 30*time.Minute)
+	if err != nil {
+		cleanup()
+		t.Fatalf("Failed to create sessionizer: %v", err)
+	}
+	WithContext(llmcontext.NewCompiler(sqliteDB(server), sessionizer, nil))(server)
+
+	now := time.Now().UnixMilli()
+	events := []models.Event{
+		{TSUTC: 1609459200000, TSISO: "2021-01-01T00:00:00.000Z", URL: "https://old.example/", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: now - 60000, TSISO: models.FormatTimestamp(now - 60000), URL: "https://recent.example/", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: now - 50000, TSISO: models.FormatTimestamp(now - 50000), URL: "https://recent.example/", Type: "visible_text", Data: map[string]any{"text": "Fresh news"}},
+	}
+	if err := sqliteDB(server).InsertEvents(events); err != nil {
+		cleanup()
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	return server.setupRoutes(), cleanup
+}
+
+func TestHandleContextRecentMinutes(t *testing.T) {
+	mux, cleanup := setupContextServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/context?minutes=10", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "Fresh news") || strings.Contains(body, "old.example") {
+		t.Errorf("Expected only recent activity:\n%s", body)
+	}
+	if w.Header().Get("X-Estimated-Tokens") == "" {
+		t.Error("Expected a token estimate header")
+	}
+}
+
+func TestHandleContextSessionJSON(t *testing.T) {
+	mux, cleanup := setupContextServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/context?session=1&format=json&budget=100", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var result llmcontext.Result
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	// Session 1 is the 2021 visit; the recent events form session 2.
+	if result.Pages != 1 || !strings.Contains(result.Text, "old.example") || result.EstimatedTokens > 100 {
+		t.Errorf("Unexpected session context: %+v", result)
+	}
+}
+
+func TestHandleContextInvalidParameters(t *testing.T) {
+	mux, cleanup := setupContextServer(t)
+	defer cleanup()
+
+	tests := map[string]int{
+		"/context?session=99":       http.StatusNotFound,
+		"/context?session=abc":      http.StatusBadRequest,
+		"/context?minutes=0":        http.StatusBadRequest,
+		"/context?budget=lots":      http.StatusBadRequest,
+		"/context?strategy=shuffle": http.StatusBadRequest,
+		"/context?format=xml":       http.StatusBadRequest,
+		"/context?since=not-a-date": http.StatusBadRequest,
+	}
+	for target, want := range tests {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("%s: expected status %d, got %d", target, want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/export"
+)
+
+// handleExport streams events as JSON Lines, CSV, Parquet or HAR. The format
+// comes from the format query parameter if present, otherwise from the Accept
+// header.
+func (s *Server) handleExport(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	query := req.URL.Query()
+	filter, err := filterFromQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var format export.Format
+	if value := query.Get("format"); value != "" {
+		if format, err = export.ParseFormat(value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		var ok bool
+		if format, ok = export.NegotiateFormat(req.Header.Get("Accept")); !ok {
+			http.Error(w, "Supported formats: application/x-ndjson, text/csv, application/vnd.apache.parquet, application/har+json", http.StatusNotAcceptable)
+			return
+		}
+	}
+
+	var fields []string
+	if value := query.Get("fields"); value != "" {
+		fields = strings.Split(value, ",")
+	}
+
+	// Exports can outlive the server-wide write timeout.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="events.%s"`, format.Extension()))
+	_, err = export.Export(req.Context(), s.db, w, export.Options{Format: format, Filter: filter, CSVFields: fields})
+	if err != nil {
+		// Headers are already sent, so the client sees a truncated body.
+		log.Printf("Export error: %v", err)
+	}
+}
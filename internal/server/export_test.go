@@ -0,0 +1,115 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func insertExportFixtures(t *testing.T, server *Server) {
+	t.Helper()
+
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://example.com", Type: "click", Data: map[string]any{"selector": "#go"}},
+	}
+	if err := sqliteDB(server).InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+}
+
+func TestHandleExportContentNegotiation(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	insertExportFixtures(t, server)
+
+	tests := []struct {
+		name        string
+		target      string
+		accept      string
+		status      int
+		contentType string
+	}{
+		{"default jsonl", "/export", "", http.StatusOK, "application/x-ndjson"},
+		{"accept csv", "/export", "text/csv", http.StatusOK, "text/csv"},
+		{"accept parquet", "/export", "application/vnd.apache.parquet", http.StatusOK, "application/vnd.apache.parquet"},
+		{"format overrides accept", "/export?format=csv", "application/x-ndjson", http.StatusOK, "text/csv"},
+		{"unacceptable", "/export", "image/png", http.StatusNotAcceptable, ""},
+		{"bad format", "/export?format=xml", "", http.StatusBadRequest, ""},
+		{"bad since", "/export?since=yesterday", "", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+
+			server.handleExport(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if tt.contentType != "" && w.Header().Get("Content-Type") != tt.contentType {
+				t.Errorf("Expected Content-Type %s, got %s", tt.contentType, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestHandleExportFilters(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	insertExportFixtures(t, server)
+
+	req := httptest.NewRequest(http.MethodGet, "/export?format=csv&type=click&fields=selector", nil)
+	w := httptest.NewRecorder()
+
+	server.handleExport(w, req)
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected header and 1 row, got %d lines: %q", len(lines), w.Body.String())
+	}
+	if !strings.HasSuffix(lines[0], "data.selector") || !strings.HasSuffix(lines[1], "#go") {
+		t.Errorf("Unexpected CSV output: %q", w.Body.String())
+	}
+}
+
+func TestHandleExportMethodNotAllowed(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodPost, "/export", nil)
+	w := httptest.NewRecorder()
+
+	server.handleExport(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
+
+func TestFilterFromQueryIdentity(t *testing.T) {
+	query := url.Values{"tab_id": {"4"}, "window_id": {"2"}, "profile": {"Work"}, "client_id": {"laptop"}}
+	filter, err := filterFromQuery(query)
+	if err != nil {
+		t.Fatalf("filterFromQuery() error = %v", err)
+	}
+	if filter.TabID == nil || *filter.TabID != 4 || filter.WindowID == nil || *filter.WindowID != 2 {
+		t.Errorf("Unexpected tab/window filter: %+v", filter)
+	}
+	if filter.Profile != "Work" || filter.ClientID != "laptop" {
+		t.Errorf("Unexpected profile/client filter: %+v", filter)
+	}
+
+	if _, err := filterFromQuery(url.Values{"tab_id": {"abc"}}); err == nil {
+		t.Error("Expected error for invalid tab_id")
+	}
+}
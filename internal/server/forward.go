@@ -0,0 +1,22 @@
+package server
+
+import (
+	"log"
+	"net/http"
+)
+
+// handleForward reports how many events wait to be forwarded to the
+// collector, and why the last attempt failed.
+func (s *Server) handleForward(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	status, err := s.forwarder.Status(req.Context())
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to read outbox", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, status)
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/forward"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func TestHandleForward(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	forwarder, err := forward.New(sqliteDB(server), forward.Config{URL: "http://127.0.0.1:0/events"})
+	if err != nil {
+		t.Fatalf("forward.New() error = %v", err)
+	}
+	WithForwarding(forwarder)(server)
+	mux := server.setupRoutes()
+
+	events := []models.Event{{TSUTC: 1000, URL: "https://example.com/", Type: "navigate", Data: map[string]any{}}}
+	if err := sqliteDB(server).InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/forward", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var status forward.Status
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if status.Pending != 1 {
+		t.Errorf("Expected 1 pending event, got %+v", status)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/forward", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/vincentbai/browsetrace-agent/internal/insights"
+)
+
+// handleWorkflows mines repeated workflows from the sessions in since/until.
+// min_support, min_length and max_length tune the mining; sort is frequency
+// or time.
+func (s *Server) handleWorkflows(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	query := req.URL.Query()
+	filter, err := filterFromQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := insights.WorkflowOptions{Since: filter.Since, Until: filter.Until, Limit: filter.Limit}
+	for name, target := range map[string]*int{
+		"min_support": &opts.MinSupport,
+		"min_length":  &opts.MinLength,
+		"max_length":  &opts.MaxLength,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		if *target, err = strconv.Atoi(value); err != nil || *target <= 0 {
+			http.Error(w, "invalid "+name+": "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	if opts.Sort, err = insights.ParseRanking(query.Get("sort")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	workflows, err := s.insights.Workflows(req.Context(), opts)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to mine workflows", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{"sort": opts.Sort, "workflows": workflows})
+}
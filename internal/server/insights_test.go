@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/insights"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/sessions"
+)
+
+func TestHandleWorkflows(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	sessionizer, err := sessions.New(sqliteDB(server), sessions.DefaultIdleGap)
+	if err != nil {
+		t.Fatalf("Failed to create sessionizer: %v", err)
+	}
+	WithInsights(insights.NewMiner(sessionizer))(server)
+	mux := server.setupRoutes()
+
+	var events []models.Event
+	for day := int64(0); day < 2; day++ {
+		start := int64(1609459200000) + day*86400000
+		for i, url := range []string{"https://a.example/", "https://b.example/1", "https://c.example/"} {
+			ts := start + int64(i)*60000
+			events = append(events, models.Event{TSUTC: ts, TSISO: models.FormatTimestamp(ts), URL: url, Type: "navigate", Data: map[string]any{}})
+		}
+	}
+	if err := sqliteDB(server).InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/insights/workflows?min_support=2&sort=time", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		Sort      string              `json:"sort"`
+		Workflows []insights.Workflow `json:"workflows"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if body.Sort != "time" || len(body.Workflows) != 1 {
+		t.Fatalf("Unexpected response: %+v", body)
+	}
+	if workflow := body.Workflows[0]; workflow.Sessions != 2 || workflow.Steps[1].URLTemplate != "b.example/{id}" {
+		t.Errorf("Unexpected workflow: %+v", workflow)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		want   int
+	}{
+		{"bad min_support", http.MethodGet, "?min_support=0", http.StatusBadRequest},
+		{"bad sort", http.MethodGet, "?sort=random", http.StatusBadRequest},
+		{"post", http.MethodPost, "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/insights/workflows"+tt.query, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
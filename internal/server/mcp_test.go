@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/mcp"
+)
+
+func TestMCPRoute(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+	w := httptest.NewRecorder()
+	server.setupRoutes().ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected /mcp to be absent without WithMCP, got %d", w.Code)
+	}
+
+	WithMCP(mcp.New(sqliteDB(server), nil, nil, nil))(server)
+	req = httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+	w = httptest.NewRecorder()
+	server.setupRoutes().ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "search_history") {
+		t.Errorf("Expected tools from /mcp, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// handleNotifications lists the notifications stored by rules, newest first.
+// ?unread=true leaves out those already read and ?limit= caps the list.
+func (s *Server) handleNotifications(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	query := req.URL.Query()
+	var unread bool
+	if value := query.Get("unread"); value != "" {
+		var err error
+		if unread, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "invalid unread: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			http.Error(w, "invalid limit: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	notifications, err := s.rules.Notifications(req.Context(), unread, limit)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to load notifications", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{"notifications": notifications})
+}
+
+// handleNotificationsRead marks the notification and every older one read.
+func (s *Server) handleNotificationsRead(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(req.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid notification ID", http.StatusBadRequest)
+		return
+	}
+	marked, err := s.rules.MarkRead(req.Context(), id)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to update notifications", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]int64{"marked": marked})
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/rules"
+)
+
+func TestHandleNotifications(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	tmpDir, err := os.MkdirTemp("", "browsetrace-rules-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "rules.json")
+	ruleFile := `{"rules": [{"name": "any", "types": ["navigate"], "actions": [{"type": "notify", "message": "Visited {{.Event.URL}}"}]}]}`
+	if err := os.WriteFile(path, []byte(ruleFile), 0o600); err != nil {
+		t.Fatalf("Failed to write rules: %v", err)
+	}
+	engine, err := rules.New(sqliteDB(server), nil, path)
+	if err != nil {
+		t.Fatalf("rules.New() error = %v", err)
+	}
+	WithRules(engine)(server)
+	mux := server.setupRoutes()
+
+	events := []models.Event{
+		{TSUTC: 1000, URL: "https://example.com/a", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, URL: "https://example.com/b", Type: "navigate", Data: map[string]any{}},
+	}
+	if err := sqliteDB(server).InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	list := func(query string) []rules.Notification {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/notifications"+query, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var response struct {
+			Notifications []rules.Notification `json:"notifications"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Invalid JSON response: %v", err)
+		}
+		return response.Notifications
+	}
+
+	all := list("")
+	if len(all) != 2 || all[0].Message != "Visited https://example.com/b" {
+		t.Fatalf("Expected 2 notifications, newest first, got %+v", all)
+	}
+	if limited := list("?limit=1"); len(limited) != 1 {
+		t.Errorf("Expected 1 notification with limit=1, got %d", len(limited))
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/notifications/"+strconv.FormatInt(all[1].ID, 10)+"/read", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() == "" {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if unread := list("?unread=true"); len(unread) != 1 || unread[0].ID != all[0].ID {
+		t.Errorf("Expected only the newest notification unread, got %+v", unread)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodPost, "/notifications", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/notifications?unread=maybe", http.StatusBadRequest},
+		{http.MethodGet, "/notifications?limit=-1", http.StatusBadRequest},
+		{http.MethodGet, "/notifications/1/read", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/notifications/abc/read", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s %s: Expected status %d, got %d", tt.method, tt.path, tt.status, w.Code)
+		}
+	}
+}
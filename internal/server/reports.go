@@ -0,0 +1,92 @@
+package server
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/report"
+)
+
+func (s *Server) handleReport(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	period, err := report.ParsePeriod(req.PathValue("period"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	query := req.URL.Query()
+	opts := report.Options{Period: period}
+	if opts.Location, err = locationFromQuery(query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if value := query.Get("date"); isDate(value) {
+		// A plain date names that day in tz, or the agent's zone, not UTC.
+		opts.Date, _ = time.ParseInLocation(time.DateOnly, value, opts.Location)
+	} else if value != "" {
+		ms, err := models.ParseTimestamp(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts.Date = time.UnixMilli(ms)
+	}
+	if value := query.Get("limit"); value != "" {
+		if opts.Limit, err = strconv.Atoi(value); err != nil || opts.Limit < 0 {
+			http.Error(w, "invalid limit: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	format := reportFormatFromAccept(req.Header.Get("Accept"))
+	if value := query.Get("format"); value != "" {
+		if format, err = report.ParseFormat(value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	digest, err := s.reports.Generate(req.Context(), opts)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to generate report", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	if err := report.Render(w, digest, format); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+func isDate(value string) bool {
+	_, err := time.Parse(time.DateOnly, value)
+	return err == nil
+}
+
+// reportFormatFromAccept serves HTML to browsers and JSON to API clients that
+// ask for it; everything else gets Markdown.
+func reportFormatFromAccept(accept string) report.Format {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "text/html":
+			return report.FormatHTML
+		case "application/json":
+			return report.FormatJSON
+		case "text/markdown":
+			return report.FormatMarkdown
+		}
+	}
+	return report.FormatMarkdown
+}
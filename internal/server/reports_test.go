@@ -0,0 +1,143 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/analytics"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/report"
+)
+
+func setupReportsServer(t *testing.T) (*http.ServeMux, func()) {
+	t.Helper()
+
+	server, cleanup := setupTestServer(t)
+	tracker, err := analytics.New(sqliteDB(server), analytics.DefaultIdleCutoff)
+	if err != nil {
+		cleanup()
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	WithReports(report.New(sqliteDB(server), tracker))(server)
+
+	events := []models.Event{
+		{TSUTC: 1609459200000, TSISO: "2021-01-01T00:00:00.000Z", URL: "https://www.google.com/search?q=browsetrace", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 1609459260000, TSISO: "2021-01-01T00:01:00.000Z", URL: "https://example.com/", Type: "navigate", Data: map[string]any{}},
+	}
+	if err := sqliteDB(server).InsertEvents(events); err != nil {
+		cleanup()
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	return server.setupRoutes(), cleanup
+}
+
+func TestHandleReportMarkdown(t *testing.T) {
+	mux, cleanup := setupReportsServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/reports/day?date=2021-01-01", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/markdown") {
+		t.Errorf("Expected Markdown, got %s", contentType)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "Google: browsetrace") || !strings.Contains(body, "[example.com]") {
+		t.Errorf("Unexpected report:\n%s", body)
+	}
+}
+
+func TestHandleReportNegotiatesFormat(t *testing.T) {
+	mux, cleanup := setupReportsServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/reports/week?date=2021-01-01", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<h1>Browsing digest: week of 2020-12-28</h1>") {
+		t.Errorf("Expected HTML week report, got %d:\n%s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/reports/day?date=2021-01-01&format=json", nil)
+	req.Header.Set("Accept", "text/html")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var body report.Report
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected format parameter to win, got %s", w.Body.String())
+	}
+	if body.EventCount != 2 || len(body.NewSites) != 2 {
+		t.Errorf("Unexpected report: %+v", body)
+	}
+
+	// A date is the day in tz; an instant falls on whatever day it is there,
+	// here the evening before in New York.
+	for target, want := range map[string]string{
+		"/reports/day?date=2021-01-01&format=json&tz=America/New_York":           "2021-01-01",
+		"/reports/day?date=2021-01-01T02:00:00Z&format=json&tz=America/New_York": "2020-12-31",
+	} {
+		req = httptest.NewRequest(http.MethodGet, target, nil)
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		body = report.Report{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to decode report: %s", w.Body.String())
+		}
+		if body.Start != want {
+			t.Errorf("%s: expected the report to start on %s, got %s", target, want, body.Start)
+		}
+	}
+}
+
+func TestHandleReportInvalidParameters(t *testing.T) {
+	mux, cleanup := setupReportsServer(t)
+	defer cleanup()
+
+	tests := map[string]int{
+		"/reports/month":              http.StatusNotFound,
+		"/reports/day?date=yesterday": http.StatusBadRequest,
+		"/reports/day?format=pdf":     http.StatusBadRequest,
+		"/reports/week?limit=-1":      http.StatusBadRequest,
+		"/reports/day?tz=Mars/Base":   http.StatusBadRequest,
+	}
+	for target, want := range tests {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("%s: expected status %d, got %d", target, want, w.Code)
+		}
+	}
+}
+
+func TestHandleReportDefaultsToAgentZone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+	models.DefaultLocation = newYork
+	defer func() { models.DefaultLocation = time.UTC }()
+
+	// The fixture's events, at midnight UTC, are on New Year's Eve in New
+	// York: both the events themselves and the time rolled up from them.
+	mux, cleanup := setupReportsServer(t)
+	defer cleanup()
+	req := httptest.NewRequest(http.MethodGet, "/reports/day?date=2020-12-31&format=json", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var body report.Report
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode report: %s", w.Body.String())
+	}
+	if body.Start != "2020-12-31" || body.EventCount != 2 || body.TotalMS == 0 {
+		t.Errorf("Expected the events and their time on 2020-12-31, got %+v", body)
+	}
+}
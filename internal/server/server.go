@@ -3,49 +3,255 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/vincentbai/browsetrace-agent/internal/analytics"
+	"github.com/vincentbai/browsetrace-agent/internal/backup"
+	"github.com/vincentbai/browsetrace-agent/internal/capture"
+	"github.com/vincentbai/browsetrace-agent/internal/clockskew"
 	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/devicesync"
+	"github.com/vincentbai/browsetrace-agent/internal/forward"
+	"github.com/vincentbai/browsetrace-agent/internal/insights"
+	"github.com/vincentbai/browsetrace-agent/internal/llmcontext"
+	"github.com/vincentbai/browsetrace-agent/internal/mcp"
 	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/pipeline"
+	"github.com/vincentbai/browsetrace-agent/internal/report"
+	"github.com/vincentbai/browsetrace-agent/internal/rules"
+	"github.com/vincentbai/browsetrace-agent/internal/sessions"
+	"github.com/vincentbai/browsetrace-agent/internal/storage"
+)
+
+const (
+	// writeTimeout bounds how long a handler has to answer.
+	writeTimeout = 5 * time.Second
+	// pipelineTimeout bounds the pipeline's stages over one batch, leaving
+	// time to store the events and answer within writeTimeout.
+	pipelineTimeout = writeTimeout - time.Second
 )
 
 type Server struct {
-	db      *database.Database
-	address string
-	server  *http.Server
+	db        storage.Store
+	address   string
+	server    *http.Server
+	sessions  *sessions.Sessionizer
+	analytics *analytics.Tracker
+	reports   *report.Generator
+	mcp       *mcp.Server
+	context   *llmcontext.Compiler
+	insights  *insights.Miner
+	backups   *backup.Manager
+	sync      *devicesync.Peer
+	forwarder *forward.Forwarder
+	rules     *rules.Engine
+	pipeline  *pipeline.Pipeline
+	capture   *capture.Switch
+	clock     *clockskew.Checker
+}
+
+// Option enables an optional subsystem on the server.
+type Option func(*Server)
+
+// WithSessions serves /sessions from sessionizer.
+func WithSessions(sessionizer *sessions.Sessionizer) Option {
+	return func(s *Server) {
+		s.sessions = sessionizer
+	}
+}
+
+// WithAnalytics serves /stats/time from tracker.
+func WithAnalytics(tracker *analytics.Tracker) Option {
+	return func(s *Server) {
+		s.analytics = tracker
+	}
+}
+
+// WithReports serves /reports/{period} from generator.
+func WithReports(generator *report.Generator) Option {
+	return func(s *Server) {
+		s.reports = generator
+	}
+}
+
+// WithMCP serves the Model Context Protocol's streamable HTTP transport at /mcp.
+func WithMCP(mcpServer *mcp.Server) Option {
+	return func(s *Server) {
+		s.mcp = mcpServer
+	}
+}
+
+// WithContext serves /context, LLM-ready summaries of recent activity.
+func WithContext(compiler *llmcontext.Compiler) Option {
+	return func(s *Server) {
+		s.context = compiler
+	}
+}
+
+// WithInsights serves /insights/workflows, routines mined from sessions.
+func WithInsights(miner *insights.Miner) Option {
+	return func(s *Server) {
+		s.insights = miner
+	}
+}
+
+// WithBackups serves /backups and runs manager's backup schedule while the
+// server is up.
+func WithBackups(manager *backup.Manager) Option {
+	return func(s *Server) {
+		s.backups = manager
+	}
+}
+
+// WithSync serves /sync, so that agents sharing peer's secret can sync with
+// this one.
+func WithSync(peer *devicesync.Peer) Option {
+	return func(s *Server) {
+		s.sync = peer
+	}
+}
+
+// WithForwarding serves /forward, the state of the outbox, and runs
+// forwarder while the server is up.
+func WithForwarding(forwarder *forward.Forwarder) Option {
+	return func(s *Server) {
+		s.forwarder = forwarder
+	}
+}
+
+// WithRules serves /notifications, the messages stored by notify actions,
+// and reloads engine's rules file while the server is up.
+func WithRules(engine *rules.Engine) Option {
+	return func(s *Server) {
+		s.rules = engine
+	}
+}
+
+// WithPipeline runs posted events through p's stages before they are stored.
+// What the stages return goes through the capture and clock checks like the
+// posted events do.
+func WithPipeline(p *pipeline.Pipeline) Option {
+	return func(s *Server) {
+		s.pipeline = p
+	}
+}
+
+// WithCapture drops posted events while sw is paused or they come from a
+// private window, and serves /capture to read and change its state.
+func WithCapture(sw *capture.Switch) Option {
+	return func(s *Server) {
+		s.capture = sw
+	}
 }
 
-func NewServer(db *database.Database, address string) *Server {
-	return &Server{
+// WithClockSkew checks the timestamps of posted events with checker and
+// serves its counters at /stats/clock.
+func WithClockSkew(checker *clockskew.Checker) Option {
+	return func(s *Server) {
+		s.clock = checker
+	}
+}
+
+// NewServer serves the events in db. The optional subsystems need the SQLite
+// backend, *database.Database.
+func NewServer(db storage.Store, address string, options ...Option) *Server {
+	s := &Server{
 		db:      db,
 		address: address,
 	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
 }
 
 func (s *Server) handleHealthz(w http.ResponseWriter, _ *http.Request) {
 	w.Write([]byte("ok"))
 }
 
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
 func (s *Server) handleEvents(w http.ResponseWriter, req *http.Request) {
-	if req.Method != http.MethodPost {
-		http.Error(w, "POST only", http.StatusMethodNotAllowed)
-		return
+	switch req.Method {
+	case http.MethodPost:
+		s.insertEvents(w, req)
+	case http.MethodDelete:
+		s.deleteEvents(w, req)
+	default:
+		http.Error(w, "POST or DELETE only", http.StatusMethodNotAllowed)
 	}
+}
+
+func (s *Server) insertEvents(w http.ResponseWriter, req *http.Request) {
 	var batch models.Batch
 	if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
 		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
 		return
 	}
-	if len(batch.Events) == 0 {
+	events := batch.Events
+	if s.capture != nil {
+		// Checked before the pipeline, so that no plugin sees what the user
+		// asked not to record.
+		var err error
+		if events, _, err = s.capture.Filter(req.Context(), events); err != nil {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "Failed to store events", http.StatusInternalServerError)
+			return
+		}
+	}
+	// A stage that overruns must fail the request while it can still be
+	// answered.
+	ctx, cancel := context.WithTimeout(req.Context(), pipelineTimeout)
+	defer cancel()
+	events, err := s.pipeline.Process(ctx, events)
+	if err != nil {
+		log.Printf("Pipeline error: %v", err)
+		http.Error(w, "Failed to process events", http.StatusInternalServerError)
+		return
+	}
+	// Stages may add events and rewrite them, so the capture switch is asked
+	// again and the clock is checked on what they return, which is what gets
+	// stored.
+	if s.capture != nil {
+		if events, _, err = s.capture.Filter(req.Context(), events); err != nil {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "Failed to store events", http.StatusInternalServerError)
+			return
+		}
+	}
+	if s.clock != nil {
+		if events, err = s.clock.Check(events); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Detect mode counts a ts_iso that disagrees with ts_utc but leaves it
+		// as sent; refuse it as the client's error rather than a failed insert.
+		for _, event := range events {
+			if err := models.CheckISOTimestamp(event.TSUTC, event.TSISO); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if len(events) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	if err := s.db.InsertEvents(batch.Events); err != nil {
+	if err := s.db.InsertEvents(events); err != nil {
 		log.Printf("Database error: %v", err)
 		http.Error(w, "Failed to store events", http.StatusInternalServerError)
 		return
@@ -53,10 +259,152 @@ func (s *Server) handleEvents(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(http.StatusNoContent) // success, no body
 }
 
+// deleteEvents forgets the events selected by the read endpoints' filter
+// parameters and url, an exact URL. At least one is required, so that a bare
+// DELETE cannot wipe the history.
+func (s *Server) deleteEvents(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	filter, err := filterFromQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.URL = query.Get("url")
+	// Unknown, empty or limit-only parameters would select everything.
+	if !selectsEvents(filter) {
+		http.Error(w, "a filter is required to delete events", http.StatusBadRequest)
+		return
+	}
+	deleted, err := s.db.DeleteEvents(req.Context(), filter)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to delete events", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Deleted %d events", deleted)
+	writeJSON(w, map[string]int64{"deleted": deleted})
+}
+
+// selectsEvents reports whether filter narrows down which events match, as
+// opposed to only limiting or ordering them.
+func selectsEvents(filter database.EventFilter) bool {
+	return filter.Since > 0 || filter.Until > 0 || len(filter.Types) > 0 || filter.URL != "" || filter.URLPrefix != "" ||
+		filter.Canonical != "" || filter.Text != "" || filter.TabID != nil || filter.WindowID != nil ||
+		filter.Profile != "" || filter.ClientID != ""
+}
+
+// filterFromQuery builds an EventFilter from the since, until, type,
+// url_prefix, canonical_url, tab_id, window_id, profile, client_id and limit
+// query parameters shared by the read endpoints.
+func filterFromQuery(query url.Values) (database.EventFilter, error) {
+	var filter database.EventFilter
+	var err error
+	if value := query.Get("since"); value != "" {
+		if filter.Since, err = models.ParseTimestamp(value); err != nil {
+			return filter, err
+		}
+	}
+	if value := query.Get("until"); value != "" {
+		if filter.Until, err = models.ParseTimestamp(value); err != nil {
+			return filter, err
+		}
+	}
+	for _, value := range query["type"] {
+		for _, eventType := range strings.Split(value, ",") {
+			if eventType != "" {
+				filter.Types = append(filter.Types, eventType)
+			}
+		}
+	}
+	filter.URLPrefix = query.Get("url_prefix")
+	filter.Canonical = query.Get("canonical_url")
+	if value := query.Get("tab_id"); value != "" {
+		tabID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid tab_id: %s", value)
+		}
+		filter.TabID = &tabID
+	}
+	if value := query.Get("window_id"); value != "" {
+		windowID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid window_id: %s", value)
+		}
+		filter.WindowID = &windowID
+	}
+	filter.Profile = query.Get("profile")
+	filter.ClientID = query.Get("client_id")
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit: %s", value)
+		}
+	}
+	return filter, nil
+}
+
+// locationFromQuery reads the tz query parameter, an IANA time zone whose
+// days reports and time stats are bucketed in. Without it they use the
+// agent's, as stored local dates do.
+func locationFromQuery(query url.Values) (*time.Location, error) {
+	value := query.Get("tz")
+	if value == "" {
+		return models.DefaultLocation, nil
+	}
+	location, err := time.LoadLocation(value)
+	if err != nil || value == "Local" {
+		return nil, fmt.Errorf("invalid tz: %s", value)
+	}
+	return location, nil
+}
+
 func (s *Server) setupRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", s.handleHealthz)
 	mux.HandleFunc("/events", s.handleEvents)
+	mux.HandleFunc("/export", s.handleExport)
+	mux.HandleFunc("/stats", s.handleStats)
+	if s.sessions != nil {
+		mux.HandleFunc("/sessions", s.handleSessions)
+		mux.HandleFunc("/sessions/{id}", s.handleSession)
+		mux.HandleFunc("/sessions/{id}/script", s.handleSessionScript)
+	}
+	if s.analytics != nil {
+		mux.HandleFunc("/stats/time", s.handleTimeStats)
+	}
+	if s.clock != nil {
+		mux.HandleFunc("/stats/clock", s.handleClockStats)
+	}
+	if s.reports != nil {
+		mux.HandleFunc("/reports/{period}", s.handleReport)
+	}
+	if s.mcp != nil {
+		mux.Handle("/mcp", s.mcp)
+	}
+	if s.context != nil {
+		mux.HandleFunc("/context", s.handleContext)
+	}
+	if s.insights != nil {
+		mux.HandleFunc("/insights/workflows", s.handleWorkflows)
+	}
+	if s.backups != nil {
+		mux.HandleFunc("/backups", s.handleBackups)
+	}
+	if s.sync != nil {
+		mux.HandleFunc("/sync", s.handleSync)
+	}
+	if s.forwarder != nil {
+		mux.HandleFunc("/forward", s.handleForward)
+	}
+	if s.capture != nil {
+		mux.HandleFunc("/capture", s.handleCapture)
+		mux.HandleFunc("/capture/pause", s.handleCapturePause)
+		mux.HandleFunc("/capture/resume", s.handleCaptureResume)
+		mux.HandleFunc("/capture/windows", s.handleCaptureWindows)
+	}
+	if s.rules != nil {
+		mux.HandleFunc("/notifications", s.handleNotifications)
+		mux.HandleFunc("/notifications/{id}/read", s.handleNotificationsRead)
+	}
 	return mux
 }
 
@@ -66,13 +414,25 @@ func (s *Server) Start() error {
 		Addr:         s.address,
 		Handler:      mux,
 		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 5 * time.Second,
+		WriteTimeout: writeTimeout,
 	}
 
 	// Graceful shutdown
 	shutdownChannel := make(chan os.Signal, 1)
 	signal.Notify(shutdownChannel, syscall.SIGINT, syscall.SIGTERM)
 
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	if s.backups != nil {
+		go s.backups.Run(background)
+	}
+	if s.forwarder != nil {
+		go s.forwarder.Run(background)
+	}
+	if s.rules != nil {
+		go s.rules.Watch(background, rules.DefaultReloadInterval)
+	}
+
 	go func() {
 		log.Printf("BrowserTrace agent listening on %s", s.address)
 		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -82,6 +442,7 @@ func (s *Server) Start() error {
 
 	<-shutdownChannel
 	log.Println("Shutting down server...")
+	stopBackground()
 
 	shutdownContext, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -2,17 +2,32 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/vincentbai/browsetrace-agent/internal/capture"
+	"github.com/vincentbai/browsetrace-agent/internal/clockskew"
 	"github.com/vincentbai/browsetrace-agent/internal/database"
 	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/pipeline"
+	"github.com/vincentbai/browsetrace-agent/internal/storage"
 )
 
+// Events without a time zone fall on the agent's days; pin them to UTC so
+// that the tests do not depend on where they run.
+func TestMain(m *testing.M) {
+	models.DefaultLocation = time.UTC
+	os.Exit(m.Run())
+}
+
 func setupTestServer(t *testing.T) (*Server, func()) {
 	t.Helper()
 
@@ -39,6 +54,12 @@ func setupTestServer(t *testing.T) (*Server, func()) {
 	return server, cleanup
 }
 
+// sqliteDB returns the database behind a server from setupTestServer, for
+// tests that need the subsystems built on it.
+func sqliteDB(server *Server) *database.Database {
+	return server.db.(*database.Database)
+}
+
 func TestNewServer(t *testing.T) {
 	server, cleanup := setupTestServer(t)
 	defer cleanup()
@@ -82,7 +103,7 @@ func TestHandleEventsSuccess(t *testing.T) {
 	batch := models.Batch{
 		Events: []models.Event{
 			{
-				TSUTC: 1234567890,
+				TSUTC: 1234567890000,
 				TSISO: "2009-02-13T23:31:30Z",
 				URL:   "https://example.com",
 				Title: &title,
@@ -162,7 +183,7 @@ func TestHandleEventsInvalidEvent(t *testing.T) {
 	batch := models.Batch{
 		Events: []models.Event{
 			{
-				TSUTC: 1234567890,
+				TSUTC: 1234567890000,
 				TSISO: "2009-02-13T23:31:30Z",
 				URL:   "", // Invalid: empty URL
 				Type:  "navigate",
@@ -193,7 +214,7 @@ func TestHandleEventsMultipleEvents(t *testing.T) {
 	batch := models.Batch{
 		Events: []models.Event{
 			{
-				TSUTC: 1234567890,
+				TSUTC: 1234567890000,
 				TSISO: "2009-02-13T23:31:30Z",
 				URL:   "https://example.com",
 				Title: &title1,
@@ -201,7 +222,7 @@ func TestHandleEventsMultipleEvents(t *testing.T) {
 				Data:  map[string]any{},
 			},
 			{
-				TSUTC: 1234567891,
+				TSUTC: 1234567891000,
 				TSISO: "2009-02-13T23:31:31Z",
 				URL:   "https://example.com/page2",
 				Title: &title2,
@@ -209,7 +230,7 @@ func TestHandleEventsMultipleEvents(t *testing.T) {
 				Data:  map[string]any{"x": 100, "y": 200},
 			},
 			{
-				TSUTC: 1234567892,
+				TSUTC: 1234567892000,
 				TSISO: "2009-02-13T23:31:32Z",
 				URL:   "https://example.com/page3",
 				Title: nil,
@@ -232,6 +253,33 @@ func TestHandleEventsMultipleEvents(t *testing.T) {
 	}
 }
 
+func TestHandleEventsDerivesTimestamps(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	// A client that sends only ts_utc, with its time zone.
+	body := `{"events":[{"ts_utc":1609471800000,"url":"https://example.com","title":null,"type":"navigate","data":{},` +
+		`"timezone":"America/New_York","utc_offset_minutes":-300}]}`
+	req := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.handleEvents(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	events, err := server.db.QueryEvents(context.Background(), database.EventFilter{})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	if events[0].TSISO != "2021-01-01T03:30:00.000Z" || events[0].LocalDate != "2020-12-31" {
+		t.Errorf("Expected derived ts_iso and New York day, got %s and %s", events[0].TSISO, events[0].LocalDate)
+	}
+}
+
 func TestSetupRoutes(t *testing.T) {
 	server, cleanup := setupTestServer(t)
 	defer cleanup()
@@ -248,7 +296,7 @@ func TestSetupRoutes(t *testing.T) {
 		status int
 	}{
 		{"/healthz", http.MethodGet, http.StatusOK},
-		{"/events", http.MethodGet, http.StatusMethodNotAllowed}, // Only POST allowed
+		{"/events", http.MethodGet, http.StatusMethodNotAllowed}, // Only POST and DELETE allowed
 	}
 
 	for _, tt := range tests {
@@ -273,7 +321,7 @@ func TestHandleEventsContentType(t *testing.T) {
 	batch := models.Batch{
 		Events: []models.Event{
 			{
-				TSUTC: 1234567890,
+				TSUTC: 1234567890000,
 				TSISO: "2009-02-13T23:31:30Z",
 				URL:   "https://example.com",
 				Title: &title,
@@ -296,3 +344,180 @@ func TestHandleEventsContentType(t *testing.T) {
 		t.Errorf("Expected status 204, got %d", resp.StatusCode)
 	}
 }
+
+func TestHandleEventsPipeline(t *testing.T) {
+	drop, err := pipeline.NewDrop(pipeline.DropConfig{Domains: []string{"bank.example"}})
+	if err != nil {
+		t.Fatalf("NewDrop() error = %v", err)
+	}
+	set, err := pipeline.NewSet(pipeline.SetConfig{Data: map[string]any{"team": "research"}})
+	if err != nil {
+		t.Fatalf("NewSet() error = %v", err)
+	}
+	refuse := pipeline.EventFunc(func(_ context.Context, event models.Event) ([]models.Event, error) {
+		if event.Type == "input" {
+			return nil, errors.New("inputs are not allowed")
+		}
+		return []models.Event{event}, nil
+	})
+	store := storage.NewMemory()
+	server := NewServer(store, "127.0.0.1:0", WithPipeline(pipeline.New(drop, set, refuse)))
+
+	tests := []struct {
+		name     string
+		events   []models.Event
+		status   int
+		wantLeft int
+	}{
+		{"all dropped", []models.Event{{TSUTC: 1000, URL: "https://bank.example/", Type: "navigate", Data: map[string]any{}}}, http.StatusNoContent, 0},
+		{"some dropped", []models.Event{
+			{TSUTC: 2000, URL: "https://bank.example/", Type: "navigate", Data: map[string]any{}},
+			{TSUTC: 3000, URL: "https://go.dev/", Type: "navigate", Data: map[string]any{}},
+		}, http.StatusNoContent, 1},
+		{"stage fails", []models.Event{{TSUTC: 4000, URL: "https://go.dev/", Type: "input", Data: map[string]any{}}}, http.StatusInternalServerError, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonData, _ := json.Marshal(models.Batch{Events: tt.events})
+			req := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(jsonData))
+			w := httptest.NewRecorder()
+			server.handleEvents(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			stored, err := store.QueryEvents(req.Context(), database.EventFilter{})
+			if err != nil {
+				t.Fatalf("QueryEvents() error = %v", err)
+			}
+			if len(stored) != tt.wantLeft {
+				t.Fatalf("Expected %d events stored, got %d", tt.wantLeft, len(stored))
+			}
+			for _, event := range stored {
+				if event.Data["team"] != "research" {
+					t.Errorf("Expected events to be enriched before storing, got %v", event.Data)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleEventsChecksPipelineOutput(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	sw, err := capture.New(sqliteDB(server))
+	if err != nil {
+		t.Fatalf("capture.New() error = %v", err)
+	}
+	if _, err := sw.SetPrivate(t.Context(), "", 7, true); err != nil {
+		t.Fatalf("SetPrivate() error = %v", err)
+	}
+	checker, err := clockskew.New(clockskew.Config{Mode: clockskew.ModeReject})
+	if err != nil {
+		t.Fatalf("clockskew.New() error = %v", err)
+	}
+	// A stage that moves tabs into the private window, and one that stamps
+	// clicks an hour ahead.
+	private := int64(7)
+	rewrite := pipeline.EventFunc(func(_ context.Context, event models.Event) ([]models.Event, error) {
+		switch event.Type {
+		case "navigate":
+			event.WindowID = &private
+		case "click":
+			event.TSUTC = time.Now().Add(time.Hour).UnixMilli()
+		}
+		return []models.Event{event}, nil
+	})
+	WithCapture(sw)(server)
+	WithClockSkew(checker)(server)
+	WithPipeline(pipeline.New(rewrite))(server)
+
+	tests := []struct {
+		name   string
+		event  models.Event
+		status int
+	}{
+		{"moved into a private window", models.Event{TSUTC: 1000, URL: "https://go.dev/", Type: "navigate", Data: map[string]any{}}, http.StatusNoContent},
+		{"moved ahead of the clock", models.Event{TSUTC: 2000, URL: "https://go.dev/", Type: "click", Data: map[string]any{}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonData, _ := json.Marshal(models.Batch{Events: []models.Event{tt.event}})
+			req := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(jsonData))
+			w := httptest.NewRecorder()
+			server.handleEvents(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			stored, err := sqliteDB(server).QueryEvents(req.Context(), database.EventFilter{})
+			if err != nil {
+				t.Fatalf("QueryEvents() error = %v", err)
+			}
+			if len(stored) != 0 {
+				t.Errorf("Expected nothing stored, got %d events", len(stored))
+			}
+		})
+	}
+	if counters := checker.Counters(); counters.Events != 1 || counters.Rejected != 1 {
+		t.Errorf("Expected the clock to see only what the pipeline kept, got %+v", counters)
+	}
+}
+
+func TestHandleEventsDelete(t *testing.T) {
+	store := storage.NewMemory()
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: models.FormatTimestamp(1000), URL: "https://bank.example/", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, TSISO: models.FormatTimestamp(2000), URL: "https://bank.example/", Type: "click", Data: map[string]any{}},
+		{TSUTC: 3000, TSISO: models.FormatTimestamp(3000), URL: "https://go.dev/", Type: "navigate", Data: map[string]any{}},
+	}
+	if err := store.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	server := NewServer(store, "127.0.0.1:0")
+
+	tests := []struct {
+		name        string
+		query       string
+		status      int
+		wantDeleted int64
+		wantLeft    int
+	}{
+		{"no filter", "", http.StatusBadRequest, 0, 3},
+		{"invalid filter", "?since=yesterday", http.StatusBadRequest, 0, 3},
+		{"unknown parameter", "?foo=1", http.StatusBadRequest, 0, 3},
+		{"empty parameter", "?url=", http.StatusBadRequest, 0, 3},
+		{"limit only", "?limit=0", http.StatusBadRequest, 0, 3},
+		{"by url", "?url=https://bank.example/", http.StatusOK, 2, 1},
+		{"nothing left to match", "?url=https://bank.example/", http.StatusOK, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/events"+tt.query, nil)
+			w := httptest.NewRecorder()
+			server.handleEvents(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if tt.status == http.StatusOK {
+				var body struct {
+					Deleted int64 `json:"deleted"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+					t.Fatalf("Invalid JSON response: %v", err)
+				}
+				if body.Deleted != tt.wantDeleted {
+					t.Errorf("Expected %d deleted, got %d", tt.wantDeleted, body.Deleted)
+				}
+			}
+			left, err := store.QueryEvents(req.Context(), database.EventFilter{})
+			if err != nil {
+				t.Fatalf("QueryEvents() error = %v", err)
+			}
+			if len(left) != tt.wantLeft {
+				t.Errorf("Expected %d events left, got %d", tt.wantLeft, len(left))
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/vincentbai/browsetrace-agent/internal/automation"
+	"github.com/vincentbai/browsetrace-agent/internal/sessions"
+)
+
+func (s *Server) handleSessions(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := filterFromQuery(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	list, err := s.sessions.List(req.Context(), sessions.ListOptions{
+		Since:    filter.Since,
+		Until:    filter.Until,
+		ClientID: filter.ClientID,
+		Profile:  filter.Profile,
+		Limit:    filter.Limit,
+	})
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{"sessions": list})
+}
+
+func (s *Server) handleSession(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(req.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+	detail, err := s.sessions.Get(req.Context(), id)
+	if errors.Is(err, sessions.ErrNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to load session", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, detail)
+}
+
+// handleSessionScript replays a session as a Playwright or chromedp script,
+// selected with ?target=; ?parameterize=true turns typed values into
+// environment parameters.
+func (s *Server) handleSessionScript(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(req.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+	query := req.URL.Query()
+	target, err := automation.ParseTarget(query.Get("target"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	parameterize := false
+	if value := query.Get("parameterize"); value != "" {
+		if parameterize, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "invalid parameterize: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+
+	detail, err := s.sessions.Get(req.Context(), id)
+	if errors.Is(err, sessions.ErrNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to load session", http.StatusInternalServerError)
+		return
+	}
+	script, err := automation.Generate(detail.Events, automation.Options{
+		Target:       target,
+		Parameterize: parameterize,
+		Description:  fmt.Sprintf("session %d", id),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="session-%d.%s"`, id, target.Extension()))
+	w.Write([]byte(script))
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/sessions"
+)
+
+func setupSessionsServer(t *testing.T) (*http.ServeMux, *Server, func()) {
+	t.Helper()
+
+	server, cleanup := setupTestServer(t)
+	sessionizer, err := sessions.New(sqliteDB(server), 30*time.Minute)
+	if err != nil {
+		cleanup()
+		t.Fatalf("Failed to create sessionizer: %v", err)
+	}
+	WithSessions(sessionizer)(server)
+
+	events := []models.Event{
+		{TSUTC: 1609459200000, TSISO: "2021-01-01T00:00:00.000Z", URL: "https://example.com", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 1609459260000, TSISO: "2021-01-01T00:01:00.000Z", URL: "https://example.com", Type: "click", Data: map[string]any{}},
+	}
+	if err := sqliteDB(server).InsertEvents(events); err != nil {
+		cleanup()
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	return server.setupRoutes(), server, cleanup
+}
+
+func TestHandleSessionsList(t *testing.T) {
+	mux, _, cleanup := setupSessionsServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/sessions?since=2021-01-01", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var body struct {
+		Sessions []sessions.Session `json:"sessions"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if len(body.Sessions) != 1 || body.Sessions[0].EventCount != 2 {
+		t.Errorf("Unexpected sessions: %+v", body.Sessions)
+	}
+}
+
+func TestHandleSessionDetail(t *testing.T) {
+	mux, _, cleanup := setupSessionsServer(t)
+	defer cleanup()
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/sessions/1", http.StatusOK},
+		{"/sessions/42", http.StatusNotFound},
+		{"/sessions/abc", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var detail sessions.Detail
+			if err := json.Unmarshal(w.Body.Bytes(), &detail); err != nil {
+				t.Fatalf("Invalid JSON response: %v", err)
+			}
+			if len(detail.Events) != 2 || detail.Events[0].Type != "navigate" {
+				t.Errorf("Unexpected events: %+v", detail.Events)
+			}
+		})
+	}
+}
+
+func TestSessionsRoutesDisabledByDefault(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/sessions", nil)
+	w := httptest.NewRecorder()
+	server.setupRoutes().ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without a sessionizer, got %d", w.Code)
+	}
+}
+
+func TestHandleSessionScript(t *testing.T) {
+	mux, _, cleanup := setupSessionsServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/sessions/1/script?target=chromedp&parameterize=true", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if disposition := w.Header().Get("Content-Disposition"); !strings.Contains(disposition, `filename="session-1.go"`) {
+		t.Errorf("Unexpected Content-Disposition: %s", disposition)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `chromedp.Navigate("https://example.com")`) {
+		t.Errorf("Unexpected script:\n%s", body)
+	}
+
+	for target, want := range map[string]int{
+		"/sessions/1/script?target=selenium":    http.StatusBadRequest,
+		"/sessions/1/script?parameterize=maybe": http.StatusBadRequest,
+		"/sessions/42/script":                   http.StatusNotFound,
+	} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("%s: expected status %d, got %d", target, want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/vincentbai/browsetrace-agent/internal/analytics"
+)
+
+func (s *Server) handleStats(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	stats, err := s.db.Stats(req.Context())
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to compute stats", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, stats)
+}
+
+func (s *Server) handleTimeStats(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	query := req.URL.Query()
+	filter, err := filterFromQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group, err := analytics.ParseGrouping(query.Get("group"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	by, err := analytics.ParseDimension(query.Get("by"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	location, err := locationFromQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	buckets, err := s.analytics.TimeSpent(req.Context(), analytics.Query{
+		Group:    group,
+		By:       by,
+		Since:    filter.Since,
+		Until:    filter.Until,
+		Limit:    filter.Limit,
+		Location: location,
+	})
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to compute time stats", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{"group": group, "by": by, "buckets": buckets})
+}
+
+// handleClockStats reports how the timestamps of posted events compared with
+// the agent's clock since it started, per client.
+func (s *Server) handleClockStats(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, s.clock.Counters())
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/analytics"
+	"github.com/vincentbai/browsetrace-agent/internal/clockskew"
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+	"github.com/vincentbai/browsetrace-agent/internal/storage"
+)
+
+func setupStatsServer(t *testing.T) (*http.ServeMux, func()) {
+	t.Helper()
+
+	server, cleanup := setupTestServer(t)
+	tracker, err := analytics.New(sqliteDB(server), analytics.DefaultIdleCutoff)
+	if err != nil {
+		cleanup()
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	WithAnalytics(tracker)(server)
+
+	// A client in UTC, so that days do not depend on where the tests run.
+	utc := "UTC"
+	events := []models.Event{
+		{TSUTC: 1609459200000, TSISO: "2021-01-01T00:00:00.000Z", URL: "https://news.bbc.co.uk/a", Type: "navigate", Data: map[string]any{}, Timezone: &utc},
+		{TSUTC: 1609459260000, TSISO: "2021-01-01T00:01:00.000Z", URL: "https://go.dev/", Type: "navigate", Data: map[string]any{}, Timezone: &utc},
+		{TSUTC: 1609459380000, TSISO: "2021-01-01T00:03:00.000Z", URL: "https://go.dev/", Type: "scroll", Data: map[string]any{}, Timezone: &utc},
+	}
+	if err := sqliteDB(server).InsertEvents(events); err != nil {
+		cleanup()
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	return server.setupRoutes(), cleanup
+}
+
+func TestHandleTimeStats(t *testing.T) {
+	mux, cleanup := setupStatsServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/stats/time?group=week&since=2021-01-01", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		Group   string             `json:"group"`
+		By      string             `json:"by"`
+		Buckets []analytics.Bucket `json:"buckets"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if body.Group != "week" || body.By != "domain" {
+		t.Errorf("Unexpected grouping: %s by %s", body.Group, body.By)
+	}
+	if len(body.Buckets) != 1 || body.Buckets[0].Period != "2020-12-28" || body.Buckets[0].TotalMS != 180000 {
+		t.Fatalf("Unexpected buckets: %+v", body.Buckets)
+	}
+	if items := body.Buckets[0].Items; len(items) != 2 || items[0].Key != "go.dev" || items[0].MS != 120000 {
+		t.Errorf("Unexpected items: %+v", items)
+	}
+}
+
+func TestHandleTimeStatsInvalidParameters(t *testing.T) {
+	mux, cleanup := setupStatsServer(t)
+	defer cleanup()
+
+	for _, target := range []string{"/stats/time?group=year", "/stats/time?by=title", "/stats/time?since=yesterday"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", target, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/stats/time", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
+
+func TestHandleStats(t *testing.T) {
+	store := storage.NewMemory()
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: models.FormatTimestamp(1000), URL: "https://go.dev/", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, TSISO: models.FormatTimestamp(2000), URL: "https://go.dev/", Type: "click", Data: map[string]any{}},
+	}
+	if err := store.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	mux := NewServer(store, "127.0.0.1:0").setupRoutes()
+
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var stats database.Stats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if stats.Events != 2 || stats.OldestTSUTC != 1000 || stats.NewestTSUTC != 2000 || stats.Types["click"] != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestHandleClockStats(t *testing.T) {
+	store := storage.NewMemory()
+	checker, err := clockskew.New(clockskew.Config{Mode: clockskew.ModeCorrect})
+	if err != nil {
+		t.Fatalf("clockskew.New() error = %v", err)
+	}
+	server := NewServer(store, "127.0.0.1:0", WithClockSkew(checker))
+	mux := server.setupRoutes()
+
+	// A client whose clock is an hour ahead, with a ts_iso in seconds.
+	ahead := time.Now().Add(time.Hour).UnixMilli()
+	client := "laptop"
+	batch := models.Batch{Events: []models.Event{{
+		TSUTC: ahead, TSISO: "2001-01-01T00:00:00Z", URL: "https://example.com/", Type: "navigate",
+		Data: map[string]any{}, ClientID: &client,
+	}}}
+	jsonData, _ := json.Marshal(batch)
+	req := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(jsonData))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	stored, err := store.QueryEvents(req.Context(), database.EventFilter{})
+	if err != nil || len(stored) != 1 {
+		t.Fatalf("Expected 1 stored event, got %d, %v", len(stored), err)
+	}
+	if stored[0].TSUTC > stored[0].ReceivedTSUTC || stored[0].TSUTC < ahead-time.Hour.Milliseconds()-time.Minute.Milliseconds() {
+		t.Errorf("Expected ts_utc moved onto the receive time %d, got %d", stored[0].ReceivedTSUTC, stored[0].TSUTC)
+	}
+	if stored[0].TSISO != models.FormatTimestamp(stored[0].TSUTC) {
+		t.Errorf("Expected ts_iso to follow ts_utc, got %s", stored[0].TSISO)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/stats/clock", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var counters clockskew.Counters
+	if err := json.Unmarshal(w.Body.Bytes(), &counters); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if counters.Events != 1 || counters.Corrected != 1 || len(counters.Clients) != 1 || !counters.Clients[0].Skewed {
+		t.Errorf("Expected one corrected event from a skewed client, got %+v", counters)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/stats/clock", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
+
+func TestHandleEventsRejectsSkew(t *testing.T) {
+	checker, err := clockskew.New(clockskew.Config{Mode: clockskew.ModeReject})
+	if err != nil {
+		t.Fatalf("clockskew.New() error = %v", err)
+	}
+	store := storage.NewMemory()
+	server := NewServer(store, "127.0.0.1:0", WithClockSkew(checker))
+
+	ahead := time.Now().Add(time.Hour).UnixMilli()
+	jsonData, _ := json.Marshal(models.Batch{Events: []models.Event{
+		{TSUTC: ahead, URL: "https://example.com/", Type: "navigate", Data: map[string]any{}},
+	}})
+	req := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(jsonData))
+	w := httptest.NewRecorder()
+	server.handleEvents(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+	if stored, _ := store.QueryEvents(req.Context(), database.EventFilter{}); len(stored) != 0 {
+		t.Errorf("Expected nothing stored, got %d events", len(stored))
+	}
+}
+
+func TestHandleEventsDetectsISOMismatch(t *testing.T) {
+	checker, err := clockskew.New(clockskew.Config{Mode: clockskew.ModeDetect})
+	if err != nil {
+		t.Fatalf("clockskew.New() error = %v", err)
+	}
+	store := storage.NewMemory()
+	server := NewServer(store, "127.0.0.1:0", WithClockSkew(checker))
+
+	// Detect mode counts the mismatch and leaves ts_iso as sent, so the batch
+	// is refused as the client's error rather than failing to store.
+	jsonData, _ := json.Marshal(models.Batch{Events: []models.Event{
+		{TSUTC: 1000, TSISO: models.FormatTimestamp(1000), URL: "https://example.com/", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, TSISO: "2001-01-01T00:00:00Z", URL: "https://example.com/", Type: "click", Data: map[string]any{}},
+	}})
+	req := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(jsonData))
+	w := httptest.NewRecorder()
+	server.handleEvents(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "2001-01-01T00:00:00Z") {
+		t.Errorf("Expected status 400 naming the bad ts_iso, got %d: %s", w.Code, w.Body.String())
+	}
+	if stored, _ := store.QueryEvents(req.Context(), database.EventFilter{}); len(stored) != 0 {
+		t.Errorf("Expected nothing stored, got %d events", len(stored))
+	}
+	if counters := checker.Counters(); counters.ISOMismatched != 1 || counters.ISOReconciled != 0 {
+		t.Errorf("Expected one mismatch counted and none reconciled, got %+v", counters)
+	}
+}
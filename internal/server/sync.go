@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/devicesync"
+)
+
+// maxSyncRequestSize bounds a /sync request body; a full page of events with
+// their page text stays well below it.
+const maxSyncRequestSize = 64 << 20
+
+// handleSync answers another agent syncing with this one. Requests must be
+// signed with the sync secret, and responses are signed with it too.
+func (s *Server) handleSync(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxSyncRequestSize))
+	if err != nil {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	err = s.sync.Verify(body, req.Header.Get(devicesync.TimestampHeader), req.Header.Get(devicesync.NonceHeader),
+		req.Header.Get(devicesync.SignatureHeader), time.Now())
+	if err != nil {
+		log.Printf("Rejected sync request from %s: %v", req.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var request devicesync.Request
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	response, err := s.sync.Handle(req.Context(), request)
+	if err != nil {
+		log.Printf("Sync error: %v", err)
+		http.Error(w, "Failed to sync", http.StatusInternalServerError)
+		return
+	}
+	if response.Merged.Inserted > 0 || response.Merged.Deleted > 0 {
+		log.Printf("Merged %d events and %d deletes from %s", response.Merged.Inserted, response.Merged.Deleted, request.DeviceID)
+	}
+	if response.Merged.Inserted > 0 && s.analytics != nil {
+		// Merged events interleave with local ones, which the incremental
+		// dwell tracker skips.
+		if err := s.analytics.Rebuild(req.Context()); err != nil {
+			log.Printf("Analytics error: %v", err)
+		}
+	}
+	payload, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Failed to write response: %v", err)
+		http.Error(w, "Failed to sync", http.StatusInternalServerError)
+		return
+	}
+	timestamp, nonce, signature := s.sync.Sign(payload, time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(devicesync.TimestampHeader, timestamp)
+	w.Header().Set(devicesync.NonceHeader, nonce)
+	w.Header().Set(devicesync.SignatureHeader, signature)
+	w.Write(payload)
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/devicesync"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+var syncSecret = []byte("shared by every device")
+
+// startAgent runs an agent with its own database and /sync endpoint, as a
+// peer on another machine would.
+func startAgent(t *testing.T) (*database.Database, *devicesync.Peer, string) {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "browsetrace-server-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	db, err := database.NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	peer, err := devicesync.NewPeer(db, syncSecret)
+	if err != nil {
+		t.Fatalf("NewPeer() error = %v", err)
+	}
+	httpServer := httptest.NewServer(NewServer(db, "127.0.0.1:0", WithSync(peer)).setupRoutes())
+	t.Cleanup(func() {
+		httpServer.Close()
+		db.Close()
+		os.RemoveAll(tmpDir)
+	})
+	return db, peer, httpServer.URL
+}
+
+func eventUIDs(t *testing.T, db *database.Database) map[string]bool {
+	t.Helper()
+
+	events, err := db.QueryEvents(context.Background(), database.EventFilter{})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	uids := make(map[string]bool)
+	for _, event := range events {
+		uids[event.UID] = true
+	}
+	return uids
+}
+
+func TestSyncBetweenAgents(t *testing.T) {
+	laptop, laptopPeer, _ := startAgent(t)
+	desktop, _, desktopURL := startAgent(t)
+	phone, phonePeer, _ := startAgent(t)
+	ctx := context.Background()
+	laptopPeer.PageSize = 2
+
+	for i := int64(0); i < 5; i++ {
+		event := models.Event{TSUTC: 1000 + i, URL: "https://example.com/", Type: "navigate", Data: map[string]any{}}
+		if err := laptop.InsertEvents([]models.Event{event}); err != nil {
+			t.Fatalf("InsertEvents() error = %v", err)
+		}
+	}
+	events := []models.Event{
+		{TSUTC: 3000, URL: "https://go.dev/", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 3100, URL: "https://go.dev/doc", Type: "navigate", Data: map[string]any{}},
+	}
+	if err := desktop.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	result, err := laptopPeer.Sync(ctx, desktopURL)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if result.Received.Inserted != 2 || result.Sent.Inserted != 5 {
+		t.Errorf("Expected 2 events received and 5 sent, got %+v", result)
+	}
+	// The phone gets the laptop's events from the desktop.
+	if result, err = phonePeer.Sync(ctx, desktopURL); err != nil || result.Received.Inserted != 7 {
+		t.Errorf("Expected 7 events received, got %+v, %v", result, err)
+	}
+
+	// A delete on the laptop reaches the phone, and the phone's stale copies
+	// do not come back to the laptop.
+	if _, err := laptop.DeleteEvents(ctx, database.EventFilter{URL: "https://go.dev/doc"}); err != nil {
+		t.Fatalf("DeleteEvents() error = %v", err)
+	}
+	if result, err = laptopPeer.Sync(ctx, desktopURL); err != nil || result.Sent.Deleted != 1 {
+		t.Errorf("Expected 1 deletion sent, got %+v, %v", result, err)
+	}
+	if result, err = phonePeer.Sync(ctx, desktopURL); err != nil || result.Received.Deleted != 1 {
+		t.Errorf("Expected 1 deletion received, got %+v, %v", result, err)
+	}
+	want := eventUIDs(t, laptop)
+	for name, db := range map[string]*database.Database{"desktop": desktop, "phone": phone} {
+		got := eventUIDs(t, db)
+		if len(got) != 6 || len(got) != len(want) {
+			t.Errorf("Expected 6 events on the %s, got %d", name, len(got))
+		}
+		for uid := range want {
+			if !got[uid] {
+				t.Errorf("Expected %s on the %s", uid, name)
+			}
+		}
+	}
+
+	// Once in step, syncing again exchanges nothing.
+	if result, err = laptopPeer.Sync(ctx, desktopURL); err != nil || result != (devicesync.Result{}) {
+		t.Errorf("Expected nothing to sync, got %+v, %v", result, err)
+	}
+}
+
+func TestHandleSyncRejectsUnsigned(t *testing.T) {
+	_, _, url := startAgent(t)
+	body := []byte(`{"device_id":"intruder","vector":{}}`)
+
+	response, err := http.Post(url+"/sync", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, response.StatusCode)
+	}
+
+	outsider, cleanup := setupTestServer(t)
+	defer cleanup()
+	peer, err := devicesync.NewPeer(sqliteDB(outsider), []byte("not the shared secret"))
+	if err != nil {
+		t.Fatalf("NewPeer() error = %v", err)
+	}
+	if _, err := peer.Sync(context.Background(), url); err == nil {
+		t.Error("Expected a peer with another secret to be refused")
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/sync", nil)
+	timestamp, nonce, signature := peer.Sign(nil, time.Now())
+	request.Header.Set(devicesync.TimestampHeader, timestamp)
+	request.Header.Set(devicesync.NonceHeader, nonce)
+	request.Header.Set(devicesync.SignatureHeader, signature)
+	w := httptest.NewRecorder()
+	NewServer(sqliteDB(outsider), "127.0.0.1:0", WithSync(peer)).setupRoutes().ServeHTTP(w, request)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
@@ -0,0 +1,77 @@
+package sessions
+
+import "github.com/vincentbai/browsetrace-agent/internal/models"
+
+// Navigation is one navigate event within a tab's chain.
+type Navigation struct {
+	EventID  int64   `json:"event_id"`
+	TSUTC    int64   `json:"ts_utc"`
+	URL      string  `json:"url"`
+	Title    *string `json:"title"`
+	Referrer string  `json:"referrer,omitempty"`
+	// PreviousEventID is the navigation before this one in the same tab.
+	PreviousEventID *int64 `json:"previous_event_id,omitempty"`
+	// ReferrerEventID is the latest earlier navigation, in any tab, to the
+	// referrer URL; it links tabs opened from a link back to their origin.
+	ReferrerEventID *int64 `json:"referrer_event_id,omitempty"`
+}
+
+// TabChain is the ordered navigations of one tab. Events without a tab ID
+// share a chain with a nil TabID.
+type TabChain struct {
+	TabID       *int64       `json:"tab_id"`
+	Navigations []Navigation `json:"navigations"`
+}
+
+// NavigationChains groups the navigate events of a timestamp-ordered event
+// list by tab, in order of each tab's first navigation.
+func NavigationChains(events []models.StoredEvent) []TabChain {
+	chains := []TabChain{}
+	chainIndex := make(map[int64]int)
+	untabbed := -1
+	latestByURL := make(map[string]int64)
+
+	for _, event := range events {
+		if event.Type != "navigate" {
+			continue
+		}
+
+		var index int
+		var ok bool
+		if event.TabID == nil {
+			index, ok = untabbed, untabbed >= 0
+		} else {
+			index, ok = chainIndex[*event.TabID]
+		}
+		if !ok {
+			chains = append(chains, TabChain{TabID: event.TabID})
+			index = len(chains) - 1
+			if event.TabID == nil {
+				untabbed = index
+			} else {
+				chainIndex[*event.TabID] = index
+			}
+		}
+
+		navigation := Navigation{
+			EventID: event.ID,
+			TSUTC:   event.TSUTC,
+			URL:     event.URL,
+			Title:   event.Title,
+		}
+		if chain := chains[index].Navigations; len(chain) > 0 {
+			previous := chain[len(chain)-1].EventID
+			navigation.PreviousEventID = &previous
+		}
+		if referrer, ok := event.Data["referrer"].(string); ok && referrer != "" {
+			navigation.Referrer = referrer
+			if referrerID, ok := latestByURL[referrer]; ok {
+				navigation.ReferrerEventID = &referrerID
+			}
+		}
+
+		chains[index].Navigations = append(chains[index].Navigations, navigation)
+		latestByURL[event.URL] = event.ID
+	}
+	return chains
+}
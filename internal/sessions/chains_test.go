@@ -0,0 +1,74 @@
+package sessions
+
+import (
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func navigate(id int64, tabID *int64, url, referrer string) models.StoredEvent {
+	data := map[string]any{}
+	if referrer != "" {
+		data["referrer"] = referrer
+	}
+	return models.StoredEvent{
+		ID:    id,
+		Event: models.Event{TSUTC: id * 1000, URL: url, Type: "navigate", Data: data, TabID: tabID},
+	}
+}
+
+func TestNavigationChainsPerTab(t *testing.T) {
+	firstTab, secondTab := int64(1), int64(2)
+	events := []models.StoredEvent{
+		navigate(1, &firstTab, "https://search.example/?q=go", ""),
+		navigate(2, &firstTab, "https://go.dev/", "https://search.example/?q=go"),
+		{ID: 3, Event: models.Event{TSUTC: 3000, URL: "https://go.dev/", Type: "click", Data: map[string]any{}, TabID: &firstTab}},
+		// Opened in a new tab from the Go homepage.
+		navigate(4, &secondTab, "https://go.dev/doc/", "https://go.dev/"),
+		navigate(5, &firstTab, "https://go.dev/blog/", "https://go.dev/"),
+	}
+
+	chains := NavigationChains(events)
+	if len(chains) != 2 {
+		t.Fatalf("Expected 2 chains, got %d", len(chains))
+	}
+
+	first := chains[0]
+	if *first.TabID != 1 || len(first.Navigations) != 3 {
+		t.Fatalf("Unexpected first chain: %+v", first)
+	}
+	if first.Navigations[0].PreviousEventID != nil {
+		t.Errorf("Expected chain start to have no previous navigation")
+	}
+	if *first.Navigations[1].PreviousEventID != 1 || *first.Navigations[1].ReferrerEventID != 1 {
+		t.Errorf("Unexpected links on second navigation: %+v", first.Navigations[1])
+	}
+	if *first.Navigations[2].PreviousEventID != 2 {
+		t.Errorf("Expected previous navigation 2, got %d", *first.Navigations[2].PreviousEventID)
+	}
+
+	second := chains[1]
+	if *second.TabID != 2 || len(second.Navigations) != 1 {
+		t.Fatalf("Unexpected second chain: %+v", second)
+	}
+	opened := second.Navigations[0]
+	if opened.PreviousEventID != nil || opened.ReferrerEventID == nil || *opened.ReferrerEventID != 2 {
+		t.Errorf("Expected new tab linked to its opener, got %+v", opened)
+	}
+}
+
+func TestNavigationChainsWithoutTabs(t *testing.T) {
+	events := []models.StoredEvent{
+		navigate(1, nil, "https://a.example", ""),
+		navigate(2, nil, "https://b.example", "https://unknown.example"),
+	}
+
+	chains := NavigationChains(events)
+	if len(chains) != 1 || chains[0].TabID != nil || len(chains[0].Navigations) != 2 {
+		t.Fatalf("Expected one untabbed chain, got %+v", chains)
+	}
+	second := chains[0].Navigations[1]
+	if second.Referrer != "https://unknown.example" || second.ReferrerEventID != nil {
+		t.Errorf("Expected unresolved referrer, got %+v", second)
+	}
+}
@@ -0,0 +1,321 @@
+// Package sessions derives browsing sessions from the raw event stream.
+//
+// A session is a run of activity from one client and browser profile with no
+// idle gap longer than the configured threshold. Sessions are kept up to date
+// by an insert hook and stored as time ranges in their own table; the events of
+// a session are the events of that client and profile inside its range.
+package sessions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// DefaultIdleGap is how long the user may be inactive before a new session starts.
+const DefaultIdleGap = 30 * time.Minute
+
+var ErrNotFound = errors.New("session not found")
+
+type Session struct {
+	ID         int64  `json:"id"`
+	ClientID   string `json:"client_id,omitempty"`
+	Profile    string `json:"profile,omitempty"`
+	StartTSUTC int64  `json:"start_ts_utc"`
+	StartTSISO string `json:"start_ts_iso"`
+	EndTSUTC   int64  `json:"end_ts_utc"`
+	EndTSISO   string `json:"end_ts_iso"`
+	EventCount int    `json:"event_count"`
+}
+
+// Detail is a session together with its ordered events and navigation chains.
+type Detail struct {
+	Session Session              `json:"session"`
+	Events  []models.StoredEvent `json:"events"`
+	Tabs    []TabChain           `json:"tabs"`
+}
+
+type ListOptions struct {
+	Since    int64  // sessions ending at or after this time (ms)
+	Until    int64  // sessions starting before this time (ms)
+	ClientID string // only sessions from this client
+	Profile  string // only sessions from this browser profile
+	Limit    int    // maximum number of sessions, 0 for no limit
+}
+
+type Sessionizer struct {
+	db      *database.Database
+	idleGap int64 // milliseconds
+}
+
+// New creates the sessions table, backfills it from existing events when it is
+// empty, and registers the sessionizer as an insert and delete hook on db.
+func New(db *database.Database, idleGap time.Duration) (*Sessionizer, error) {
+	s := &Sessionizer{db: db, idleGap: idleGap.Milliseconds()}
+	_, err := db.DB().Exec(`
+	CREATE TABLE IF NOT EXISTS sessions(
+	  id          INTEGER PRIMARY KEY,
+	  client_id   TEXT    NOT NULL DEFAULT '',
+	  profile     TEXT    NOT NULL DEFAULT '',
+	  start_ts    INTEGER NOT NULL,
+	  end_ts      INTEGER NOT NULL,
+	  event_count INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_sessions_source ON sessions(client_id, profile, end_ts);
+	CREATE INDEX IF NOT EXISTS idx_sessions_start  ON sessions(start_ts);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sessions table: %w", err)
+	}
+
+	var hasSessions, hasEvents bool
+	if err := db.DB().QueryRow(`SELECT EXISTS(SELECT 1 FROM sessions), EXISTS(SELECT 1 FROM events)`).Scan(&hasSessions, &hasEvents); err != nil {
+		return nil, fmt.Errorf("failed to inspect sessions table: %w", err)
+	}
+	if !hasSessions && hasEvents {
+		if err := s.Rebuild(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+
+	db.AddInsertHook(s.observe)
+	db.AddDeleteHook(s.rebuild)
+	return s, nil
+}
+
+func (s *Sessionizer) observe(transaction *sql.Tx, events []models.StoredEvent) error {
+	sorted := make([]models.StoredEvent, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].TSUTC < sorted[j].TSUTC })
+
+	for _, event := range sorted {
+		if err := s.assign(transaction, stringValue(event.ClientID), stringValue(event.Profile), event.TSUTC); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assign adds one event to the session it falls into, extending that session,
+// starting a new one, or merging the sessions it bridges.
+func (s *Sessionizer) assign(transaction *sql.Tx, clientID, profile string, ts int64) error {
+	rows, err := transaction.Query(`
+	SELECT id, start_ts, end_ts, event_count FROM sessions
+	WHERE client_id = ? AND profile = ? AND start_ts <= ? AND end_ts >= ?
+	ORDER BY start_ts`, clientID, profile, ts+s.idleGap, ts-s.idleGap)
+	if err != nil {
+		return fmt.Errorf("failed to find session: %w", err)
+	}
+	var matches []Session
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(&session.ID, &session.StartTSUTC, &session.EndTSUTC, &session.EventCount); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan session: %w", err)
+		}
+		matches = append(matches, session)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read sessions: %w", err)
+	}
+
+	if len(matches) == 0 {
+		_, err := transaction.Exec(`INSERT INTO sessions(client_id, profile, start_ts, end_ts, event_count) VALUES(?,?,?,?,1)`,
+			clientID, profile, ts, ts)
+		if err != nil {
+			return fmt.Errorf("failed to create session: %w", err)
+		}
+		return nil
+	}
+
+	merged := matches[0]
+	merged.StartTSUTC = min(merged.StartTSUTC, ts)
+	merged.EndTSUTC = max(merged.EndTSUTC, ts)
+	merged.EventCount++
+	for _, other := range matches[1:] {
+		merged.StartTSUTC = min(merged.StartTSUTC, other.StartTSUTC)
+		merged.EndTSUTC = max(merged.EndTSUTC, other.EndTSUTC)
+		merged.EventCount += other.EventCount
+		if _, err := transaction.Exec(`DELETE FROM sessions WHERE id = ?`, other.ID); err != nil {
+			return fmt.Errorf("failed to merge session %d: %w", other.ID, err)
+		}
+	}
+	_, err = transaction.Exec(`UPDATE sessions SET start_ts = ?, end_ts = ?, event_count = ? WHERE id = ?`,
+		merged.StartTSUTC, merged.EndTSUTC, merged.EventCount, merged.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update session %d: %w", merged.ID, err)
+	}
+	return nil
+}
+
+// Rebuild recomputes all sessions from the events table in a single pass.
+func (s *Sessionizer) Rebuild(ctx context.Context) error {
+	transaction, err := s.db.DB().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+	if err := s.rebuild(ctx, transaction); err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// rebuild recomputes the sessions within transaction. It is also the delete
+// hook, since removing events can split or shrink any session.
+func (s *Sessionizer) rebuild(ctx context.Context, transaction *sql.Tx) error {
+	rows, err := transaction.QueryContext(ctx, `SELECT ts_utc, COALESCE(client_id, ''), COALESCE(profile, '') FROM events ORDER BY ts_utc`)
+	if err != nil {
+		return fmt.Errorf("failed to query events: %w", err)
+	}
+	type source struct{ clientID, profile string }
+	open := make(map[source]*Session)
+	var finished []Session
+	for rows.Next() {
+		var ts int64
+		var key source
+		if err := rows.Scan(&ts, &key.clientID, &key.profile); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan event: %w", err)
+		}
+		current, ok := open[key]
+		if ok && ts-current.EndTSUTC <= s.idleGap {
+			current.EndTSUTC = ts
+			current.EventCount++
+			continue
+		}
+		if ok {
+			finished = append(finished, *current)
+		}
+		open[key] = &Session{ClientID: key.clientID, Profile: key.profile, StartTSUTC: ts, EndTSUTC: ts, EventCount: 1}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read events: %w", err)
+	}
+	for _, session := range open {
+		finished = append(finished, *session)
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].StartTSUTC < finished[j].StartTSUTC })
+
+	if _, err := transaction.ExecContext(ctx, `DELETE FROM sessions`); err != nil {
+		return fmt.Errorf("failed to clear sessions: %w", err)
+	}
+	for _, session := range finished {
+		_, err := transaction.ExecContext(ctx, `INSERT INTO sessions(client_id, profile, start_ts, end_ts, event_count) VALUES(?,?,?,?,?)`,
+			session.ClientID, session.Profile, session.StartTSUTC, session.EndTSUTC, session.EventCount)
+		if err != nil {
+			return fmt.Errorf("failed to store session: %w", err)
+		}
+	}
+	return nil
+}
+
+// List returns sessions overlapping the requested range, most recent first.
+func (s *Sessionizer) List(ctx context.Context, opts ListOptions) ([]Session, error) {
+	query := `SELECT id, client_id, profile, start_ts, end_ts, event_count FROM sessions WHERE 1 = 1`
+	var args []any
+	if opts.Since > 0 {
+		query += ` AND end_ts >= ?`
+		args = append(args, opts.Since)
+	}
+	if opts.Until > 0 {
+		query += ` AND start_ts < ?`
+		args = append(args, opts.Until)
+	}
+	if opts.ClientID != "" {
+		query += ` AND client_id = ?`
+		args = append(args, opts.ClientID)
+	}
+	if opts.Profile != "" {
+		query += ` AND profile = ?`
+		args = append(args, opts.Profile)
+	}
+	query += ` ORDER BY start_ts DESC`
+	if opts.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, opts.Limit)
+	}
+
+	rows, err := s.db.DB().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// Get returns a session with its events in timestamp order.
+func (s *Sessionizer) Get(ctx context.Context, id int64) (Detail, error) {
+	var detail Detail
+	rows, err := s.db.DB().QueryContext(ctx, `SELECT id, client_id, profile, start_ts, end_ts, event_count FROM sessions WHERE id = ?`, id)
+	if err != nil {
+		return detail, fmt.Errorf("failed to query session: %w", err)
+	}
+	found := rows.Next()
+	if found {
+		detail.Session, err = scanSession(rows)
+	}
+	rows.Close()
+	if err != nil {
+		return detail, err
+	}
+	if !found {
+		return detail, ErrNotFound
+	}
+
+	session := detail.Session
+	filter := database.EventFilter{Since: session.StartTSUTC, Until: session.EndTSUTC + 1, ClientID: session.ClientID, Profile: session.Profile}
+	detail.Events = []models.StoredEvent{}
+	err = s.db.StreamEvents(ctx, filter, func(event models.StoredEvent) error {
+		// An empty client or profile on the session means the field was absent.
+		if stringValue(event.ClientID) == session.ClientID && stringValue(event.Profile) == session.Profile {
+			detail.Events = append(detail.Events, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return detail, err
+	}
+	detail.Tabs = NavigationChains(detail.Events)
+	return detail, nil
+}
+
+func scanSession(rows *sql.Rows) (Session, error) {
+	var session Session
+	if err := rows.Scan(&session.ID, &session.ClientID, &session.Profile, &session.StartTSUTC, &session.EndTSUTC, &session.EventCount); err != nil {
+		return session, fmt.Errorf("failed to scan session: %w", err)
+	}
+	session.StartTSISO = models.FormatTimestamp(session.StartTSUTC)
+	session.EndTSISO = models.FormatTimestamp(session.EndTSUTC)
+	return session, nil
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -0,0 +1,312 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+const minute = int64(60 * 1000)
+
+func setupTestDB(t *testing.T) (*database.Database, func()) {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "browsetrace-sessions-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	db, err := database.NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+	return db, cleanup
+}
+
+func event(ts int64, url, eventType string) models.Event {
+	return models.Event{TSUTC: ts, TSISO: models.FormatTimestamp(ts), URL: url, Type: eventType, Data: map[string]any{}}
+}
+
+func listAll(t *testing.T, sessionizer *Sessionizer) []Session {
+	t.Helper()
+
+	sessions, err := sessionizer.List(context.Background(), ListOptions{})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	return sessions
+}
+
+func TestSessionsSplitOnIdleGap(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	sessionizer, err := New(db, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	base := int64(1609459200000)
+	batch := []models.Event{
+		event(base, "https://a.example", "navigate"),
+		event(base+10*minute, "https://a.example", "click"),
+		event(base+35*minute, "https://a.example", "scroll"),
+		// More than 30 minutes idle: new session.
+		event(base+90*minute, "https://b.example", "navigate"),
+	}
+	if err := db.InsertEvents(batch); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	sessions := listAll(t, sessionizer)
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d: %+v", len(sessions), sessions)
+	}
+	// Most recent first.
+	if sessions[0].StartTSUTC != base+90*minute || sessions[0].EventCount != 1 {
+		t.Errorf("Unexpected latest session: %+v", sessions[0])
+	}
+	if sessions[1].StartTSUTC != base || sessions[1].EndTSUTC != base+35*minute || sessions[1].EventCount != 3 {
+		t.Errorf("Unexpected first session: %+v", sessions[1])
+	}
+}
+
+func TestSessionsIncrementalAcrossBatches(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	sessionizer, err := New(db, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	base := int64(1609459200000)
+	for i := int64(0); i < 5; i++ {
+		if err := db.InsertEvents([]models.Event{event(base+i*5*minute, "https://a.example", "scroll")}); err != nil {
+			t.Fatalf("InsertEvents() error = %v", err)
+		}
+	}
+
+	sessions := listAll(t, sessionizer)
+	if len(sessions) != 1 || sessions[0].EventCount != 5 || sessions[0].EndTSUTC != base+20*minute {
+		t.Errorf("Expected one 5-event session, got %+v", sessions)
+	}
+}
+
+func TestSessionsMergeWhenLateEventBridgesGap(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	sessionizer, err := New(db, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	base := int64(1609459200000)
+	if err := db.InsertEvents([]models.Event{event(base, "https://a.example", "navigate"), event(base+50*minute, "https://a.example", "click")}); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+	if got := len(listAll(t, sessionizer)); got != 2 {
+		t.Fatalf("Expected 2 sessions before the late event, got %d", got)
+	}
+
+	if err := db.InsertEvents([]models.Event{event(base+25*minute, "https://a.example", "scroll")}); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+	sessions := listAll(t, sessionizer)
+	if len(sessions) != 1 || sessions[0].EventCount != 3 || sessions[0].StartTSUTC != base || sessions[0].EndTSUTC != base+50*minute {
+		t.Errorf("Expected sessions merged into one, got %+v", sessions)
+	}
+}
+
+func TestSessionsSeparateClients(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	sessionizer, err := New(db, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	laptop := "laptop"
+	withClient := event(1609459200000+minute, "https://b.example", "navigate")
+	withClient.ClientID = &laptop
+	if err := db.InsertEvents([]models.Event{event(1609459200000, "https://a.example", "navigate"), withClient}); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	if got := len(listAll(t, sessionizer)); got != 2 {
+		t.Errorf("Expected a session per client, got %d", got)
+	}
+	sessions, err := sessionizer.List(context.Background(), ListOptions{ClientID: "laptop"})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ClientID != "laptop" {
+		t.Errorf("Expected the laptop session, got %+v", sessions)
+	}
+
+	detail, err := sessionizer.Get(context.Background(), sessions[0].ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(detail.Events) != 1 || detail.Events[0].URL != "https://b.example" {
+		t.Errorf("Expected only the laptop event, got %+v", detail.Events)
+	}
+}
+
+func TestNewBackfillsExistingEvents(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	base := int64(1609459200000)
+	if err := db.InsertEvents([]models.Event{
+		event(base, "https://a.example", "navigate"),
+		event(base+minute, "https://a.example", "click"),
+		event(base+2*60*minute, "https://b.example", "navigate"),
+	}); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	sessionizer, err := New(db, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	sessions := listAll(t, sessionizer)
+	if len(sessions) != 2 || sessions[1].EventCount != 2 {
+		t.Errorf("Expected 2 backfilled sessions, got %+v", sessions)
+	}
+}
+
+func TestGetReturnsOrderedEventsAndChains(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	sessionizer, err := New(db, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	base := int64(1609459200000)
+	// Inserted out of order on purpose.
+	if err := db.InsertEvents([]models.Event{
+		event(base+2*minute, "https://a.example/next", "navigate"),
+		event(base, "https://a.example", "navigate"),
+		event(base+minute, "https://a.example", "click"),
+	}); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	sessions := listAll(t, sessionizer)
+	detail, err := sessionizer.Get(context.Background(), sessions[0].ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(detail.Events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(detail.Events))
+	}
+	for i := 1; i < len(detail.Events); i++ {
+		if detail.Events[i].TSUTC < detail.Events[i-1].TSUTC {
+			t.Errorf("Events not ordered: %+v", detail.Events)
+		}
+	}
+	if len(detail.Tabs) != 1 || len(detail.Tabs[0].Navigations) != 2 {
+		t.Errorf("Expected one chain of 2 navigations, got %+v", detail.Tabs)
+	}
+}
+
+func TestGetUnknownSession(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	sessionizer, err := New(db, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := sessionizer.Get(context.Background(), 99); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestListTimeRange(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	sessionizer, err := New(db, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	base := int64(1609459200000)
+	if err := db.InsertEvents([]models.Event{
+		event(base, "https://a.example", "navigate"),
+		event(base+120*minute, "https://b.example", "navigate"),
+		event(base+240*minute, "https://c.example", "navigate"),
+	}); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	sessions, err := sessionizer.List(context.Background(), ListOptions{Since: base + 60*minute, Until: base + 180*minute})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].StartTSUTC != base+120*minute {
+		t.Errorf("Expected only the middle session, got %+v", sessions)
+	}
+
+	limited, err := sessionizer.List(context.Background(), ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(limited) != 2 {
+		t.Errorf("Expected 2 sessions with limit, got %d", len(limited))
+	}
+}
+
+func TestDeleteSplitsSessions(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	sessionizer, err := New(db, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	base := int64(1609459200000)
+	batch := []models.Event{
+		event(base, "https://a.example", "navigate"),
+		event(base+20*minute, "https://bridge.example", "navigate"),
+		event(base+40*minute, "https://a.example", "click"),
+	}
+	if err := db.InsertEvents(batch); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+	if sessions := listAll(t, sessionizer); len(sessions) != 1 {
+		t.Fatalf("Expected 1 session, got %d", len(sessions))
+	}
+
+	// Without the event in the middle, the other two are 40 minutes apart.
+	if _, err := db.DeleteEvents(context.Background(), database.EventFilter{URL: "https://bridge.example"}); err != nil {
+		t.Fatalf("DeleteEvents() error = %v", err)
+	}
+	sessions := listAll(t, sessionizer)
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d: %+v", len(sessions), sessions)
+	}
+	for _, session := range sessions {
+		if session.EventCount != 1 {
+			t.Errorf("Expected single-event sessions, got %+v", session)
+		}
+	}
+}
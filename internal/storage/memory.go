@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// Memory keeps events in memory, for tests that should not touch disk.
+type Memory struct {
+	mu           sync.RWMutex
+	events       []models.StoredEvent // in timestamp order
+	nextID       int64
+	normalizeURL database.URLNormalizer
+}
+
+func NewMemory() *Memory {
+	return &Memory{nextID: 1}
+}
+
+// SetURLNormalizer sets how canonical URLs are derived for new events.
+func (m *Memory) SetURLNormalizer(normalize database.URLNormalizer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.normalizeURL = normalize
+}
+
+func (m *Memory) InsertEvents(events []models.Event) error {
+	for _, event := range events {
+		if err := database.ValidateEvent(event); err != nil {
+			return fmt.Errorf("invalid event: %w", err)
+		}
+	}
+	received := time.Now().UnixMilli()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, event := range events {
+		event.Data = maps.Clone(event.Data)
+		event.TSISO = event.ISOTimestamp()
+		m.events = append(m.events, models.StoredEvent{
+			ID:            m.nextID,
+			Event:         event,
+			CanonicalURL:  canonicalURL(m.normalizeURL, event.URL),
+			ReceivedTSUTC: received,
+			LocalDate:     event.LocalDate(models.DefaultLocation),
+		})
+		m.nextID++
+	}
+	sort.SliceStable(m.events, func(i, j int) bool { return m.events[i].TSUTC < m.events[j].TSUTC })
+	return nil
+}
+
+// matching returns the events filter selects, in the order it asks for.
+func (m *Memory) matching(filter database.EventFilter) []models.StoredEvent {
+	var matched []models.StoredEvent
+	for _, event := range m.events {
+		if filter.Matches(event) {
+			event.Data = maps.Clone(event.Data)
+			matched = append(matched, event)
+		}
+	}
+	if filter.Newest {
+		slices.Reverse(matched)
+	}
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[:filter.Limit]
+	}
+	return matched
+}
+
+// StreamEvents calls fn on a snapshot of the matching events, so fn may use
+// the store.
+func (m *Memory) StreamEvents(ctx context.Context, filter database.EventFilter, fn func(models.StoredEvent) error) error {
+	m.mu.RLock()
+	matched := m.matching(filter)
+	m.mu.RUnlock()
+	for _, event := range matched {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) QueryEvents(ctx context.Context, filter database.EventFilter) ([]models.StoredEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.matching(filter), nil
+}
+
+func (m *Memory) DeleteEvents(ctx context.Context, filter database.EventFilter) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := make(map[int64]bool)
+	for _, event := range m.matching(filter) {
+		deleted[event.ID] = true
+	}
+	m.events = slices.DeleteFunc(m.events, func(event models.StoredEvent) bool { return deleted[event.ID] })
+	return int64(len(deleted)), nil
+}
+
+func (m *Memory) Stats(ctx context.Context) (database.Stats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stats := database.Stats{Events: int64(len(m.events)), Types: make(map[string]int64)}
+	if len(m.events) > 0 {
+		stats.OldestTSUTC = m.events[0].TSUTC
+		stats.NewestTSUTC = m.events[len(m.events)-1].TSUTC
+	}
+	for _, event := range m.events {
+		stats.Types[event.Type]++
+	}
+	return stats, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
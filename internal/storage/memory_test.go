@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestMemoryDoesNotShareData(t *testing.T) {
+	store := NewMemory()
+	events := []models.Event{{TSUTC: 1000, TSISO: models.FormatTimestamp(1000), URL: "https://go.dev/", Type: "click",
+		Data: map[string]any{"selector": "a"}}}
+	if err := store.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+	events[0].Data["selector"] = "changed by caller"
+
+	stored, err := store.QueryEvents(context.Background(), database.EventFilter{})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	stored[0].Data["selector"] = "changed by reader"
+	again, err := store.QueryEvents(context.Background(), database.EventFilter{})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if again[0].Data["selector"] != "a" {
+		t.Errorf("Expected stored data untouched, got %v", again[0].Data)
+	}
+}
+
+func TestMemoryURLNormalizer(t *testing.T) {
+	store := NewMemory()
+	store.SetURLNormalizer(func(rawURL string) string { return rawURL + "#canonical" })
+	events := []models.Event{{TSUTC: 1000, TSISO: models.FormatTimestamp(1000), URL: "https://go.dev/", Type: "navigate"}}
+	if err := store.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+	stored, err := store.QueryEvents(context.Background(), database.EventFilter{Canonical: "https://go.dev/#canonical"})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if len(stored) != 1 {
+		t.Errorf("Expected the event found by canonical URL, got %d events", len(stored))
+	}
+}
@@ -0,0 +1,358 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq" // PostgreSQL driver
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// Postgres keeps events in a PostgreSQL database, so that several agents can
+// write to one place. Page text stays inside data_json rather than in
+// deduplicated blobs.
+type Postgres struct {
+	db           *sql.DB
+	normalizeURL database.URLNormalizer
+}
+
+// NewPostgres connects to the database at dsn, a postgres:// URL or key=value
+// connection string, and creates the events table if needed.
+func NewPostgres(ctx context.Context, dsn string) (*Postgres, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	if err := migratePostgres(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Postgres{db: db}, nil
+}
+
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS events(
+  id            BIGSERIAL PRIMARY KEY,
+  ts_utc        BIGINT    NOT NULL,
+  ts_iso        TEXT      NOT NULL,
+  url           TEXT      NOT NULL,
+  title         TEXT,
+  type          TEXT      NOT NULL,
+  data_json     JSONB     NOT NULL,
+  tab_id        BIGINT,
+  window_id     BIGINT,
+  frame_id      BIGINT,
+  profile       TEXT,
+  client_id     TEXT,
+  canonical_url TEXT
+);
+ALTER TABLE events ADD COLUMN IF NOT EXISTS received_ts_utc    BIGINT;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS timezone           TEXT;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS utc_offset_minutes INTEGER;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS local_date         TEXT;
+CREATE INDEX IF NOT EXISTS idx_events_ts            ON events(ts_utc);
+CREATE INDEX IF NOT EXISTS idx_events_type          ON events(type);
+CREATE INDEX IF NOT EXISTS idx_events_url           ON events(url);
+CREATE INDEX IF NOT EXISTS idx_events_canonical_url ON events(canonical_url);
+CREATE INDEX IF NOT EXISTS idx_events_client        ON events(client_id);
+CREATE INDEX IF NOT EXISTS idx_events_local_date    ON events(local_date);
+`
+
+// localDateBatch is how many rows the local date backfill reads at a time.
+const localDateBatch = 1000
+
+// migratePostgres creates or updates the schema in one transaction. Rows
+// stored before local_date existed are given one when the column is added,
+// and only then.
+func migratePostgres(ctx context.Context, db *sql.DB) error {
+	transaction, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration: %w", err)
+	}
+	defer transaction.Rollback()
+
+	var hadLocalDate bool
+	err = transaction.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM information_schema.columns
+	WHERE table_schema = current_schema() AND table_name = 'events' AND column_name = 'local_date')`).Scan(&hadLocalDate)
+	if err != nil {
+		return fmt.Errorf("failed to read schema: %w", err)
+	}
+	if _, err := transaction.ExecContext(ctx, postgresSchema); err != nil {
+		return fmt.Errorf("failed to create database tables: %w", err)
+	}
+	if !hadLocalDate {
+		if err := backfillLocalDates(ctx, transaction); err != nil {
+			return err
+		}
+	}
+	if err := transaction.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration: %w", err)
+	}
+	return nil
+}
+
+// backfillLocalDates fills in local_date for rows stored before the column
+// existed. Those rows carry no time zone, so their day is taken in
+// models.DefaultLocation, as for new events that carry none, rather than in
+// whatever zone the database session happens to use.
+func backfillLocalDates(ctx context.Context, transaction *sql.Tx) error {
+	statement, err := transaction.PrepareContext(ctx, `UPDATE events SET local_date = $1 WHERE id = $2`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer statement.Close()
+	for {
+		rows, err := transaction.QueryContext(ctx,
+			`SELECT id, ts_utc, timezone, utc_offset_minutes FROM events WHERE local_date IS NULL LIMIT $1`, localDateBatch)
+		if err != nil {
+			return fmt.Errorf("failed to query events without a local date: %w", err)
+		}
+		dates := make(map[int64]string)
+		for rows.Next() {
+			var id int64
+			var event models.Event
+			if err := rows.Scan(&id, &event.TSUTC, &event.Timezone, &event.UTCOffsetMinutes); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan event: %w", err)
+			}
+			dates[id] = event.LocalDate(models.DefaultLocation)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to read events: %w", err)
+		}
+		for id, date := range dates {
+			if _, err := statement.ExecContext(ctx, date, id); err != nil {
+				return fmt.Errorf("failed to set local date: %w", err)
+			}
+		}
+		if len(dates) < localDateBatch {
+			return nil
+		}
+	}
+}
+
+// SetURLNormalizer sets how canonical URLs are derived for new events.
+// Register it before the store is shared between goroutines.
+func (p *Postgres) SetURLNormalizer(normalize database.URLNormalizer) {
+	p.normalizeURL = normalize
+}
+
+func (p *Postgres) InsertEvents(events []models.Event) error {
+	transaction, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+	statement, err := transaction.Prepare(`INSERT INTO events(ts_utc, ts_iso, url, title, type, data_json, tab_id, window_id, frame_id, profile, client_id, canonical_url, received_ts_utc,
+	timezone, utc_offset_minutes, local_date)
+	VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer statement.Close()
+
+	received := time.Now().UnixMilli()
+	for _, event := range events {
+		if err := database.ValidateEvent(event); err != nil {
+			return fmt.Errorf("invalid event: %w", err)
+		}
+		jsonData, err := json.Marshal(event.Data)
+		if err != nil {
+			return fmt.Errorf("failed to marshal event data: %w", err)
+		}
+		_, err = statement.Exec(event.TSUTC, event.ISOTimestamp(), event.URL, event.Title, event.Type, string(jsonData),
+			event.TabID, event.WindowID, event.FrameID, event.Profile, event.ClientID, canonicalURL(p.normalizeURL, event.URL), received,
+			event.Timezone, event.UTCOffsetMinutes, event.LocalDate(models.DefaultLocation))
+		if err != nil {
+			return fmt.Errorf("failed to execute statement: %w", err)
+		}
+	}
+	if err := transaction.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// postgresLikeEscaper escapes LIKE wildcards for the default escape character.
+var postgresLikeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// selection renders the filter as a WHERE clause with numbered parameters.
+// The text filter uses ILIKE, which unlike SQLite's LIKE also folds the case
+// of non-ASCII letters.
+func selection(f database.EventFilter) (string, []any) {
+	var conditions []string
+	var args []any
+	add := func(condition string, values ...any) {
+		for _, value := range values {
+			args = append(args, value)
+			condition = strings.Replace(condition, "?", fmt.Sprintf("$%d", len(args)), 1)
+		}
+		conditions = append(conditions, condition)
+	}
+	if f.Since > 0 {
+		add("ts_utc >= ?", f.Since)
+	}
+	if f.Until > 0 {
+		add("ts_utc < ?", f.Until)
+	}
+	if f.SinceDate != "" {
+		add("local_date >= ?", f.SinceDate)
+	}
+	if f.UntilDate != "" {
+		add("local_date < ?", f.UntilDate)
+	}
+	if len(f.Types) > 0 {
+		values := make([]any, len(f.Types))
+		for i, eventType := range f.Types {
+			values[i] = eventType
+		}
+		add("type IN ("+strings.TrimSuffix(strings.Repeat("?,", len(f.Types)), ",")+")", values...)
+	}
+	if f.URLPrefix != "" {
+		add("left(url, ?) = ?", len([]rune(f.URLPrefix)), f.URLPrefix)
+	}
+	if f.URL != "" {
+		add("url = ?", f.URL)
+	}
+	if f.Canonical != "" {
+		add("canonical_url = ?", f.Canonical)
+	}
+	if f.Text != "" {
+		pattern := "%" + postgresLikeEscaper.Replace(f.Text) + "%"
+		add("(url ILIKE ? OR title ILIKE ? OR data_json->>'text' ILIKE ?)", pattern, pattern, pattern)
+	}
+	if f.TabID != nil {
+		add("tab_id = ?", *f.TabID)
+	}
+	if f.WindowID != nil {
+		add("window_id = ?", *f.WindowID)
+	}
+	if f.Profile != "" {
+		add("profile = ?", f.Profile)
+	}
+	if f.ClientID != "" {
+		add("client_id = ?", f.ClientID)
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// orderAndLimit renders the ORDER BY and LIMIT clauses of a selection that
+// already has the parameters args.
+func orderAndLimit(f database.EventFilter, args []any) (string, []any) {
+	clause := ` ORDER BY ts_utc, id`
+	if f.Newest {
+		clause = ` ORDER BY ts_utc DESC, id DESC`
+	}
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		clause += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	return clause, args
+}
+
+func (p *Postgres) StreamEvents(ctx context.Context, filter database.EventFilter, fn func(models.StoredEvent) error) error {
+	where, args := selection(filter)
+	order, args := orderAndLimit(filter, args)
+	rows, err := p.db.QueryContext(ctx, `
+	SELECT id, ts_utc, ts_iso, url, title, type, data_json, tab_id, window_id, frame_id, profile, client_id, canonical_url, received_ts_utc,
+	timezone, utc_offset_minutes, local_date
+	FROM events`+where+order, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event models.StoredEvent
+		var canonicalURL, localDate sql.NullString
+		var received sql.NullInt64
+		var dataJSON []byte
+		if err := rows.Scan(&event.ID, &event.TSUTC, &event.TSISO, &event.URL, &event.Title, &event.Type, &dataJSON,
+			&event.TabID, &event.WindowID, &event.FrameID, &event.Profile, &event.ClientID, &canonicalURL, &received,
+			&event.Timezone, &event.UTCOffsetMinutes, &localDate); err != nil {
+			return fmt.Errorf("failed to scan event: %w", err)
+		}
+		event.CanonicalURL, event.ReceivedTSUTC, event.LocalDate = canonicalURL.String, received.Int64, localDate.String
+		if err := json.Unmarshal(dataJSON, &event.Data); err != nil {
+			return fmt.Errorf("failed to unmarshal data for event %d: %w", event.ID, err)
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read events: %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) QueryEvents(ctx context.Context, filter database.EventFilter) ([]models.StoredEvent, error) {
+	var events []models.StoredEvent
+	err := p.StreamEvents(ctx, filter, func(event models.StoredEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	return events, err
+}
+
+func (p *Postgres) DeleteEvents(ctx context.Context, filter database.EventFilter) (int64, error) {
+	where, args := selection(filter)
+	query := `DELETE FROM events` + where
+	if filter.Limit > 0 {
+		var order string
+		order, args = orderAndLimit(filter, args)
+		query = `DELETE FROM events WHERE id IN (SELECT id FROM events` + where + order + `)`
+	}
+	result, err := p.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete events: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted events: %w", err)
+	}
+	return deleted, nil
+}
+
+func (p *Postgres) Stats(ctx context.Context) (database.Stats, error) {
+	stats := database.Stats{Types: make(map[string]int64)}
+	err := p.db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(MIN(ts_utc), 0), COALESCE(MAX(ts_utc), 0) FROM events`).
+		Scan(&stats.Events, &stats.OldestTSUTC, &stats.NewestTSUTC)
+	if err != nil {
+		return stats, fmt.Errorf("failed to count events: %w", err)
+	}
+	rows, err := p.db.QueryContext(ctx, `SELECT type, COUNT(*) FROM events GROUP BY type`)
+	if err != nil {
+		return stats, fmt.Errorf("failed to count event types: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var eventType string
+		var count int64
+		if err := rows.Scan(&eventType, &count); err != nil {
+			return stats, fmt.Errorf("failed to scan event type count: %w", err)
+		}
+		stats.Types[eventType] = count
+	}
+	if err := rows.Err(); err != nil {
+		return stats, fmt.Errorf("failed to read event type counts: %w", err)
+	}
+	return stats, nil
+}
+
+func (p *Postgres) Close() error {
+	return p.db.Close()
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+)
+
+// TestPostgresStore runs against the database named by
+// BROWSETRACE_TEST_POSTGRES, e.g.
+// postgres://postgres@localhost/browsetrace_test?sslmode=disable. Its events
+// table is emptied first.
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("BROWSETRACE_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("BROWSETRACE_TEST_POSTGRES not set")
+	}
+	store, err := NewPostgres(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("NewPostgres() error = %v", err)
+	}
+	defer store.Close()
+	if _, err := store.db.Exec(`TRUNCATE events RESTART IDENTITY`); err != nil {
+		t.Fatalf("Failed to empty events: %v", err)
+	}
+	testStore(t, store)
+}
+
+func TestSelectionNumbersParameters(t *testing.T) {
+	tabID := int64(3)
+	where, args := selection(database.EventFilter{
+		Since:     1000,
+		Types:     []string{"navigate", "click"},
+		URLPrefix: "https://ünï.example/",
+		Text:      "50%_off",
+		TabID:     &tabID,
+	})
+	wantWhere := ` WHERE ts_utc >= $1 AND type IN ($2,$3) AND left(url, $4) = $5` +
+		` AND (url ILIKE $6 OR title ILIKE $7 OR data_json->>'text' ILIKE $8) AND tab_id = $9`
+	if where != wantWhere {
+		t.Errorf("Expected %s, got %s", wantWhere, where)
+	}
+	wantArgs := []any{int64(1000), "navigate", "click", 20, "https://ünï.example/",
+		`%50\%\_off%`, `%50\%\_off%`, `%50\%\_off%`, int64(3)}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("Expected args %v, got %v", wantArgs, args)
+	}
+
+	order, args := orderAndLimit(database.EventFilter{Newest: true, Limit: 5}, args)
+	if order != ` ORDER BY ts_utc DESC, id DESC LIMIT $10` || args[len(args)-1] != 5 {
+		t.Errorf("Unexpected order clause %q with args %v", order, args)
+	}
+}
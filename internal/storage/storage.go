@@ -0,0 +1,47 @@
+// Package storage defines what the HTTP server needs from wherever events are
+// kept, so that the local SQLite database can be swapped for an in-memory
+// store in tests or a shared PostgreSQL database for teams that centralize
+// traces.
+//
+// Every backend validates events with database.ValidateEvent and selects them
+// with database.EventFilter, so the read and delete APIs behave the same on
+// all of them. Derived data (sessions, time rollups, reports, encryption,
+// text compression) lives in SQLite tables next to the events and is only
+// available with the SQLite backend.
+package storage
+
+import (
+	"context"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// Store keeps events.
+type Store interface {
+	// InsertEvents validates and stores a batch atomically.
+	InsertEvents(events []models.Event) error
+	// StreamEvents calls fn for every event matching filter in timestamp
+	// order, newest first when filter.Newest is set.
+	StreamEvents(ctx context.Context, filter database.EventFilter, fn func(models.StoredEvent) error) error
+	// QueryEvents returns all events matching filter.
+	QueryEvents(ctx context.Context, filter database.EventFilter) ([]models.StoredEvent, error)
+	// DeleteEvents deletes the events matching filter, honoring its limit and
+	// order, and returns how many there were.
+	DeleteEvents(ctx context.Context, filter database.EventFilter) (int64, error)
+	// Stats summarizes the stored events.
+	Stats(ctx context.Context) (database.Stats, error)
+	Close() error
+}
+
+// The SQLite database is the default backend.
+var _ Store = (*database.Database)(nil)
+
+// canonicalURL fills canonical_url the way the SQLite backend does: without a
+// normalizer it is a copy of the raw URL.
+func canonicalURL(normalize database.URLNormalizer, rawURL string) string {
+	if normalize == nil {
+		return rawURL
+	}
+	return normalize(rawURL)
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-agent/internal/database"
+	"github.com/vincentbai/browsetrace-agent/internal/models"
+)
+
+// Events without a time zone fall on the agent's days; pin them to UTC so
+// that the tests do not depend on where they run.
+func TestMain(m *testing.M) {
+	models.DefaultLocation = time.UTC
+	os.Exit(m.Run())
+}
+
+func setupTestDB(t *testing.T) (*database.Database, func()) {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "browsetrace-storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	db, err := database.NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+	return db, cleanup
+}
+
+func fixtures() []models.Event {
+	title := "Release Notes"
+	tabID := int64(7)
+	client := "laptop"
+	timezone := "America/New_York"
+	return []models.Event{
+		{TSUTC: 1000, TSISO: models.FormatTimestamp(1000), URL: "https://go.dev/doc/", Title: &title, Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, URL: "https://go.dev/doc/", Type: "visible_text",
+			Data: map[string]any{"text": "Generic Type Parameters", "trigger": "load"}, TabID: &tabID, Timezone: &timezone},
+		{TSUTC: 3000, TSISO: models.FormatTimestamp(3000), URL: "https://example.com/", Type: "click", Data: map[string]any{"selector": "a"}, ClientID: &client},
+		{TSUTC: 4000, TSISO: models.FormatTimestamp(4000), URL: "https://go.dev/blog/", Type: "navigate", Data: map[string]any{}, ClientID: &client},
+	}
+}
+
+// timestamps identifies events by timestamp, which is the same on every
+// backend while IDs need not be.
+func timestamps(events []models.StoredEvent) string {
+	var values []string
+	for _, event := range events {
+		values = append(values, models.FormatTimestamp(event.TSUTC)[17:19])
+	}
+	return strings.Join(values, ",")
+}
+
+// testStore checks the behavior every backend must share. store must be empty.
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	invalid := append(fixtures(), models.Event{TSUTC: 5000, URL: "https://go.dev/", Type: "hover"})
+	if err := store.InsertEvents(invalid); err == nil {
+		t.Fatal("Expected an invalid event to be rejected")
+	}
+	if stats, err := store.Stats(ctx); err != nil || stats.Events != 0 {
+		t.Fatalf("Expected a rejected batch to store nothing, got %+v, %v", stats, err)
+	}
+	if err := store.InsertEvents(fixtures()); err != nil {
+		t.Fatalf("InsertEvents() error = %v", err)
+	}
+
+	tabID := int64(7)
+	queries := []struct {
+		name   string
+		filter database.EventFilter
+		want   string
+	}{
+		{"all", database.EventFilter{}, "01,02,03,04"},
+		{"time range", database.EventFilter{Since: 2000, Until: 4000}, "02,03"},
+		{"local days", database.EventFilter{SinceDate: "1970-01-01", UntilDate: "1970-01-02"}, "01,03,04"},
+		{"local day before", database.EventFilter{UntilDate: "1970-01-01"}, "02"},
+		{"types", database.EventFilter{Types: []string{"navigate"}}, "01,04"},
+		{"url prefix", database.EventFilter{URLPrefix: "https://go.dev/"}, "01,02,04"},
+		{"url", database.EventFilter{URL: "https://go.dev/doc/"}, "01,02"},
+		{"canonical url", database.EventFilter{Canonical: "https://example.com/"}, "03"},
+		{"text in title", database.EventFilter{Text: "release"}, "01"},
+		{"text in page", database.EventFilter{Text: "type param"}, "02"},
+		{"text wildcard is literal", database.EventFilter{Text: "go_dev"}, ""},
+		{"tab", database.EventFilter{TabID: &tabID}, "02"},
+		{"client", database.EventFilter{ClientID: "laptop"}, "03,04"},
+		{"limit", database.EventFilter{Limit: 2}, "01,02"},
+		{"newest", database.EventFilter{Newest: true, Limit: 3}, "04,03,02"},
+	}
+	for _, tt := range queries {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := store.QueryEvents(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("QueryEvents() error = %v", err)
+			}
+			if got := timestamps(events); got != tt.want {
+				t.Errorf("Expected events %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	events, err := store.QueryEvents(ctx, database.EventFilter{Types: []string{"visible_text"}})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if len(events) != 1 || events[0].Data["text"] != "Generic Type Parameters" || events[0].Data["trigger"] != "load" ||
+		events[0].CanonicalURL != "https://go.dev/doc/" || events[0].TabID == nil || *events[0].TabID != 7 {
+		t.Errorf("Expected fields to round-trip, got %+v", events)
+	}
+	if len(events) == 1 && (events[0].TSISO != "1970-01-01T00:00:02.000Z" || events[0].Timezone == nil ||
+		*events[0].Timezone != "America/New_York" || events[0].LocalDate != "1969-12-31") {
+		t.Errorf("Expected ts_iso derived and the day taken in the client's time zone, got %+v", events[0])
+	}
+
+	stats, err := store.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Events != 4 || stats.OldestTSUTC != 1000 || stats.NewestTSUTC != 4000 || stats.Types["navigate"] != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	deleted, err := store.DeleteEvents(ctx, database.EventFilter{Newest: true, Limit: 1})
+	if err != nil {
+		t.Fatalf("DeleteEvents() error = %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 deleted, got %d", deleted)
+	}
+	if deleted, err = store.DeleteEvents(ctx, database.EventFilter{URLPrefix: "https://go.dev/"}); err != nil {
+		t.Fatalf("DeleteEvents() error = %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("Expected 2 deleted, got %d", deleted)
+	}
+	remaining, err := store.QueryEvents(ctx, database.EventFilter{})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if got := timestamps(remaining); got != "03" {
+		t.Errorf("Expected only event 03 left, got %q", got)
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	testStore(t, db)
+}
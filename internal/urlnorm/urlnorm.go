@@ -0,0 +1,291 @@
+// Package urlnorm canonicalizes URLs so that the same page is recognized
+// however it was linked to: tracking parameters are stripped, the scheme and
+// host are lowercased, default ports and in-page anchors are dropped, and the
+// remaining query parameters are sorted.
+package urlnorm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// FragmentMode decides what happens to the part of a URL after "#".
+type FragmentMode string
+
+const (
+	// FragmentDrop removes every fragment.
+	FragmentDrop FragmentMode = "drop"
+	// FragmentKeep leaves fragments alone.
+	FragmentKeep FragmentMode = "keep"
+	// FragmentRoutes keeps fragments that are client-side routes ("#/inbox",
+	// "#!/settings") and drops in-page anchors ("#section-2").
+	FragmentRoutes FragmentMode = "routes"
+)
+
+func ParseFragmentMode(value string) (FragmentMode, error) {
+	switch FragmentMode(strings.ToLower(value)) {
+	case FragmentDrop:
+		return FragmentDrop, nil
+	case FragmentKeep:
+		return FragmentKeep, nil
+	case FragmentRoutes:
+		return FragmentRoutes, nil
+	}
+	return "", fmt.Errorf("invalid fragment mode %q (want drop, keep or routes)", value)
+}
+
+// DefaultStrip lists the tracking parameters removed unless a rule keeps them.
+var DefaultStrip = []string{
+	"utm_*", "fbclid", "gclid", "gclsrc", "dclid", "gbraid", "wbraid", "msclkid",
+	"yclid", "twclid", "ttclid", "li_fat_id", "igshid", "mc_cid", "mc_eid",
+	"_ga", "_gl", "_hsenc", "_hsmi", "__hssc", "__hstc", "__hsfp", "hsCtaTracking",
+	"mkt_tok", "oly_anon_id", "oly_enc_id", "vero_id", "wickedid", "rb_clickid",
+	"s_cid", "spm", "ref_src", "ref_url",
+}
+
+// Rules configure a Normalizer. A parameter rule is a glob on the parameter
+// name ("utm_*"), optionally limited to a domain and its subdomains with a
+// "domain:" prefix ("amazon.com:ref"). Keep rules win over strip rules.
+type Rules struct {
+	Strip     []string
+	Keep      []string
+	Fragment  FragmentMode
+	SortQuery bool
+}
+
+// DefaultRules strip DefaultStrip, keep client-side routes and sort queries.
+func DefaultRules() Rules {
+	return Rules{
+		Strip:     append([]string(nil), DefaultStrip...),
+		Fragment:  FragmentRoutes,
+		SortQuery: true,
+	}
+}
+
+// Parse reads rules on top of DefaultRules, one per line:
+//
+//	ref                  strip this parameter as well
+//	amazon.com:ref_*     strip it on this domain only
+//	!si                  keep this parameter even if a default strips it
+//	fragment keep        fragment handling: drop, keep or routes
+//	sort off             leave query parameters in their original order
+//
+// Blank lines and lines starting with # are ignored.
+func Parse(r io.Reader) (Rules, error) {
+	rules := DefaultRules()
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if directive, value, ok := strings.Cut(line, " "); ok {
+			value = strings.TrimSpace(value)
+			switch directive {
+			case "fragment":
+				mode, err := ParseFragmentMode(value)
+				if err != nil {
+					return rules, fmt.Errorf("line %d: %w", number, err)
+				}
+				rules.Fragment = mode
+			case "sort":
+				if value != "on" && value != "off" {
+					return rules, fmt.Errorf("line %d: sort must be on or off", number)
+				}
+				rules.SortQuery = value == "on"
+			default:
+				return rules, fmt.Errorf("line %d: unknown directive %q", number, directive)
+			}
+			continue
+		}
+		if keep, ok := strings.CutPrefix(line, "!"); ok {
+			rules.Keep = append(rules.Keep, keep)
+		} else {
+			rules.Strip = append(rules.Strip, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return rules, fmt.Errorf("failed to read URL rules: %w", err)
+	}
+	return rules, nil
+}
+
+// Load reads a rules file. A missing file means DefaultRules.
+func Load(path string) (Rules, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultRules(), nil
+	}
+	if err != nil {
+		return Rules{}, fmt.Errorf("failed to open URL rules: %w", err)
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+type parameterRule struct {
+	domain  string // empty for every domain
+	pattern string
+}
+
+func compileRules(entries []string) ([]parameterRule, error) {
+	var rules []parameterRule
+	for _, entry := range entries {
+		rule := parameterRule{pattern: entry}
+		if domain, pattern, ok := strings.Cut(entry, ":"); ok {
+			rule = parameterRule{domain: strings.Trim(strings.ToLower(domain), "."), pattern: pattern}
+		}
+		if _, err := path.Match(rule.pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid parameter rule %q: %w", entry, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (r parameterRule) matches(host, name string) bool {
+	if r.domain != "" && host != r.domain && !strings.HasSuffix(host, "."+r.domain) {
+		return false
+	}
+	matched, _ := path.Match(r.pattern, name)
+	return matched
+}
+
+type Normalizer struct {
+	strip     []parameterRule
+	keep      []parameterRule
+	fragment  FragmentMode
+	sortQuery bool
+}
+
+func New(rules Rules) (*Normalizer, error) {
+	strip, err := compileRules(rules.Strip)
+	if err != nil {
+		return nil, err
+	}
+	keep, err := compileRules(rules.Keep)
+	if err != nil {
+		return nil, err
+	}
+	fragment := rules.Fragment
+	if fragment == "" {
+		fragment = FragmentRoutes
+	}
+	return &Normalizer{strip: strip, keep: keep, fragment: fragment, sortQuery: rules.SortQuery}, nil
+}
+
+// Normalize returns the canonical form of rawURL. Strings that do not parse
+// as URLs are returned unchanged.
+func (n *Normalizer) Normalize(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Opaque != "" {
+		return rawURL
+	}
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	if parsed.Host != "" {
+		port := parsed.Port()
+		if (parsed.Scheme == "http" && port == "80") || (parsed.Scheme == "https" && port == "443") {
+			port = ""
+		}
+		parsed.Host = host
+		if strings.Contains(host, ":") {
+			parsed.Host = "[" + host + "]"
+		}
+		if port != "" {
+			parsed.Host += ":" + port
+		}
+		if parsed.Path == "" {
+			parsed.Path = "/"
+		}
+	}
+
+	parsed.RawQuery = n.query(host, parsed.RawQuery)
+	parsed.ForceQuery = false
+
+	switch n.fragment {
+	case FragmentDrop:
+		parsed.Fragment, parsed.RawFragment = "", ""
+	case FragmentRoutes:
+		if !strings.HasPrefix(parsed.Fragment, "/") && !strings.HasPrefix(parsed.Fragment, "!") {
+			parsed.Fragment, parsed.RawFragment = "", ""
+		}
+	}
+	return parsed.String()
+}
+
+// query filters a raw query string, keeping each parameter's original
+// encoding so that only removals and reordering change it.
+func (n *Normalizer) query(host, rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	var kept []string
+	for _, parameter := range strings.Split(rawQuery, "&") {
+		if parameter == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(parameter, "=")
+		if decoded, err := url.QueryUnescape(name); err == nil {
+			name = decoded
+		}
+		if n.stripped(host, name) {
+			continue
+		}
+		kept = append(kept, parameter)
+	}
+	if n.sortQuery {
+		sort.SliceStable(kept, func(i, j int) bool {
+			a, _, _ := strings.Cut(kept[i], "=")
+			b, _, _ := strings.Cut(kept[j], "=")
+			return a < b
+		})
+	}
+	return strings.Join(kept, "&")
+}
+
+func (n *Normalizer) stripped(host, name string) bool {
+	for _, rule := range n.keep {
+		if rule.matches(host, name) {
+			return false
+		}
+	}
+	for _, rule := range n.strip {
+		if rule.matches(host, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// RegistrableDomain returns the eTLD+1 of a URL's host ("news.bbc.co.uk" →
+// "bbc.co.uk") according to the public suffix list. IP addresses and hosts
+// without a public suffix are returned as-is; URLs without a host are keyed
+// by scheme, e.g. "file:".
+func RegistrableDomain(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	if host == "" {
+		return parsed.Scheme + ":"
+	}
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
@@ -0,0 +1,152 @@
+package urlnorm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	normalizer, err := New(DefaultRules())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"tracking parameters", "https://example.com/post?utm_source=x&id=4&utm_medium=y&fbclid=z", "https://example.com/post?id=4"},
+		{"only tracking", "https://example.com/post?utm_campaign=spring", "https://example.com/post"},
+		{"host and scheme case", "HTTPS://News.Example.COM/Path", "https://news.example.com/Path"},
+		{"default port", "http://example.com:80/a", "http://example.com/a"},
+		{"other port", "http://localhost:8080/a", "http://localhost:8080/a"},
+		{"empty path", "https://example.com", "https://example.com/"},
+		{"sorted query", "https://example.com/s?q=go&lang=en", "https://example.com/s?lang=en&q=go"},
+		{"encoding preserved", "https://example.com/s?q=a%20b&utm_term=x", "https://example.com/s?q=a%20b"},
+		{"anchor dropped", "https://example.com/doc#section-2", "https://example.com/doc"},
+		{"route kept", "https://app.example/#/inbox/3", "https://app.example/#/inbox/3"},
+		{"hashbang kept", "https://app.example/#!/settings", "https://app.example/#!/settings"},
+		{"trailing dot", "https://example.com./a", "https://example.com/a"},
+		{"no host", "file:///home/user/notes.html?utm_source=x", "file:///home/user/notes.html"},
+		{"opaque", "mailto:someone@example.com", "mailto:someone@example.com"},
+		{"unparseable", "http://[::1", "http://[::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizer.Normalize(tt.url); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	input := `
+# shop referral codes
+amazon.com:ref*
+!fbclid
+fragment keep
+sort off
+`
+	rules, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	normalizer, err := New(rules)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://www.amazon.com/dp/X?th=1&ref_=nav&utm_source=y", "https://www.amazon.com/dp/X?th=1"},
+		{"https://example.com/?ref=home", "https://example.com/?ref=home"},
+		{"https://example.com/?z=1&fbclid=abc&a=2", "https://example.com/?z=1&fbclid=abc&a=2"},
+		{"https://example.com/doc#section-2", "https://example.com/doc#section-2"},
+	}
+	for _, tt := range tests {
+		if got := normalizer.Normalize(tt.url); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	tests := []string{
+		"fragment sometimes",
+		"sort maybe",
+		"strip everything",
+	}
+	for _, input := range tests {
+		if _, err := Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("Expected an error for %q", input)
+		}
+	}
+	if _, err := New(Rules{Strip: []string{"[utm"}}); err == nil {
+		t.Error("Expected an error for a malformed pattern")
+	}
+}
+
+func TestFragmentDrop(t *testing.T) {
+	normalizer, err := New(Rules{Fragment: FragmentDrop})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got := normalizer.Normalize("https://app.example/#/inbox?b=1&a=2"); got != "https://app.example/" {
+		t.Errorf("Expected fragment dropped, got %q", got)
+	}
+	// Without SortQuery or strip rules the query is left alone.
+	if got := normalizer.Normalize("https://example.com/?b=1&a=2"); got != "https://example.com/?b=1&a=2" {
+		t.Errorf("Expected query untouched, got %q", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "browsetrace-urlnorm-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	rules, err := Load(filepath.Join(tmpDir, "missing.txt"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if rules.Fragment != FragmentRoutes || len(rules.Strip) != len(DefaultStrip) {
+		t.Errorf("Expected default rules for a missing file, got %+v", rules)
+	}
+
+	path := filepath.Join(tmpDir, "url-rules.txt")
+	if err := os.WriteFile(path, []byte("sid\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write rules: %v", err)
+	}
+	rules, err = Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if rules.Strip[len(rules.Strip)-1] != "sid" {
+		t.Errorf("Expected sid appended to the strip rules, got %v", rules.Strip)
+	}
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	tests := map[string]string{
+		"https://news.bbc.co.uk/article":  "bbc.co.uk",
+		"https://WWW.Example.COM/":        "example.com",
+		"http://127.0.0.1:8123/healthz":   "127.0.0.1",
+		"http://localhost:3000/":          "localhost",
+		"file:///home/user/notes.html":    "file:",
+		"https://user.github.io/project/": "user.github.io",
+	}
+	for input, want := range tests {
+		if got := RegistrableDomain(input); got != want {
+			t.Errorf("RegistrableDomain(%q) = %q, want %q", input, got, want)
+		}
+	}
+}